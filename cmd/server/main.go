@@ -15,15 +15,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"uber/internal/api"
 	"uber/internal/api/handlers"
+	"uber/internal/api/middleware"
 	"uber/internal/config"
+	"uber/internal/domain/entities"
 	"uber/internal/geo"
 	"uber/internal/repository/memory"
 	"uber/internal/services"
+	"uber/pkg/utils"
 )
 
 func main() {
@@ -35,6 +47,12 @@ func main() {
 	// are popular for production config management.
 	cfg := config.NewDefaultConfig()
 
+	// Apply any market-specific ride state machine overrides before the first
+	// ride is created. A bad override is a configuration bug, so fail fast.
+	if err := entities.ApplyTransitionOverrides(cfg.Ride.TransitionOverrides); err != nil {
+		log.Fatalf("Invalid ride transition overrides: %v", err)
+	}
+
 	// Initialize repositories (data access layer).
 	// Go Learning Note — The Repository Pattern:
 	// Each repository encapsulates data access for one domain entity. Using
@@ -44,13 +62,25 @@ func main() {
 	riderRepo := memory.NewRiderRepository()
 	driverRepo := memory.NewDriverRepository()
 	rideRepo := memory.NewRideRepository()
-	locationRepo := memory.NewLocationRepository()
+	promoRepo := memory.NewPromoCodeRepository()
+	locationRepo := memory.NewLocationRepositoryWithHistory(cfg.Geo.LocationHistorySize)
 	lockManager := memory.NewLockManager()
 
+	// There is no admin API for promo codes yet, so the MVP ships with one
+	// welcome code for trying the discount flow.
+	promoRepo.Create(context.Background(), &entities.PromoCode{
+		Code:        "WELCOME20",
+		Type:        entities.PromoTypePercentage,
+		Value:       20,
+		MaxDiscount: utils.NewMoney(10),
+	})
+
 	// Initialize spatial index for fast geolocation queries.
 	// The precision parameter (6) means geohash cells of ~1.2 km — a good
-	// tradeoff between search accuracy and the number of cells to scan.
-	spatialIndex := geo.NewSpatialIndex(cfg.Geo.GeohashPrecision)
+	// tradeoff between search accuracy and the number of cells to scan. A
+	// precision of 0 in the config is derived from the search radius instead.
+	precision := geo.IndexPrecision(cfg.Geo.GeohashPrecision, cfg.Matching.SearchRadiusKm)
+	spatialIndex := geo.NewSpatialIndex(precision)
 
 	// Initialize services (business logic layer).
 	// Go Learning Note — Layered Architecture:
@@ -59,8 +89,27 @@ func main() {
 	// services by providing mock repositories, and test handlers by providing
 	// mock services.
 	notificationService := services.NewNotificationService()
+	notificationService.SetCurrency(cfg.Pricing.CurrencyCode)
 	locationService := services.NewLocationService(spatialIndex, driverRepo, locationRepo)
-	rideService := services.NewRideService(rideRepo, riderRepo, driverRepo, cfg)
+	locationService.SetAvailabilityBatchSize(cfg.Matching.AvailabilityBatchSize)
+	locationService.SetBarriers(barriersFromConfig(cfg.Geo.Barriers))
+	locationService.SetCoalesceWindow(cfg.Geo.IndexCoalesceWindow)
+	locationService.SetReadyRequiresDriver(cfg.Server.ReadyRequiresDriver)
+	locationService.StartLocationSweeper(cfg.Geo.LocationTTL)
+	demandTracker := services.NewDemandTracker(spatialIndex, precision)
+	rideService := services.NewRideService(rideRepo, riderRepo, driverRepo, promoRepo, locationService, demandTracker, cfg)
+
+	// Surge pricing reads pending requests vs. supply around the pickup cell.
+	surgeService := services.NewSurgeService(demandTracker, cfg.Pricing.SurgePriceMax)
+	rideService.SetSurgeFunc(surgeService.MultiplierAt)
+	rideService.SetCompletionFunc(func(ride *entities.Ride) {
+		notificationService.NotifyRiderOfTripCompleted(ride.RiderID, ride.ID, ride.FinalFare())
+	})
+	rideService.SetArrivingSoonFunc(func(ride *entities.Ride) {
+		notificationService.NotifyRiderOfDriverArrivingSoon(ride.RiderID, ride.AssignedDriver(), ride.ID)
+	})
+	locationService.SetRidePingFunc(rideService.ObserveDriverLocation)
+
 	matchingService := services.NewMatchingService(
 		cfg,
 		rideService,
@@ -78,24 +127,141 @@ func main() {
 	locationHandler := handlers.NewLocationHandler(locationService)
 
 	// Setup router — wires handlers to URL paths with middleware.
-	router := api.NewRouter(rideHandler, driverHandler, locationHandler)
+	router := api.NewRouter(cfg, rideHandler, driverHandler, locationHandler)
+	router.SetLanguagePreferences(notificationService.SetLanguage)
+	router.SetMetrics(api.NewMetricsRegistry(matchingService, spatialIndex, lockManager))
 
-	// Create Gin engine with default middleware (logger + recovery).
+	// Create Gin engine with a structured access log and panic recovery.
 	// Go Learning Note — gin.Default() vs gin.New():
 	// gin.Default() includes Logger and Recovery middleware automatically.
 	// gin.New() gives you a bare engine. Recovery middleware catches panics in
 	// handlers and returns a 500 instead of crashing the server — essential for
-	// production.
-	engine := gin.Default()
+	// production. We start bare so Gin's text Logger can be swapped for JSON
+	// access logs. AccessLog goes first so it still logs the 500 that Recovery
+	// writes for a panicking handler.
+	engine := gin.New()
+	accessLogger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	engine.Use(middleware.AccessLog(accessLogger), gin.Recovery())
 	router.Setup(engine)
 
 	// Start server.
-	// Go Learning Note — log.Fatalf:
-	// log.Fatalf calls os.Exit(1) after logging, so deferred functions won't run.
-	// For graceful shutdown in production, use http.Server with signal handling
-	// and server.Shutdown(ctx) instead of engine.Run().
-	log.Printf("Starting Uber Clone server on %s", cfg.Server.Port)
-	if err := engine.Run(cfg.Server.Port); err != nil {
+	// Go Learning Note — http.Server Instead of engine.Run:
+	// engine.Run is a one-line wrapper around http.ListenAndServe that never
+	// returns on success, so there is nothing to call on SIGTERM. Building the
+	// http.Server ourselves gives us its Shutdown method, which stops
+	// accepting connections and waits for requests already in progress.
+	server := newHTTPServer(cfg.Server, engine)
+	// Ride status streams only end with their ride, so Shutdown would wait
+	// on them for its whole budget; end them as soon as it starts.
+	server.RegisterOnShutdown(rideHandler.CloseStreams)
+
+	// Listen before serving so a bad address (port in use) fails here, with
+	// nothing running yet to shut down.
+	listener, err := net.Listen("tcp", cfg.Server.Port)
+	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+
+	// Go Learning Note — signal.NotifyContext:
+	// signal.NotifyContext returns a context that is cancelled when the
+	// process receives one of the listed signals. Ctrl-C sends SIGINT;
+	// Kubernetes and systemd send SIGTERM before killing a process. Calling
+	// stop restores default handling, so a second Ctrl-C kills at once; it
+	// has to run as soon as the first signal arrives, not when main returns
+	// after the graceful shutdown it is meant to cut short. context.AfterFunc
+	// runs it on its own goroutine once ctx is cancelled.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	// Shut down from the outside in: serve stops taking HTTP requests first,
+	// so no new rides start matching, then the matches in flight are let
+	// finish, and the sweepers stop last since matching still releases
+	// locks.
+	log.Printf("Starting Uber Clone server on %s", listener.Addr())
+	err = serve(ctx, server, listener, cfg.Server.ShutdownTimeout, cfg.Server.DrainTimeout, func(drainCtx context.Context) {
+		if err := matchingService.Shutdown(drainCtx); err != nil {
+			log.Printf("Matching shutdown: %v", err)
+		}
+		locationService.Stop()
+		lockManager.Stop()
+	})
+	if err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	log.Printf("Shutdown complete")
+}
+
+// newHTTPServer builds the http.Server for handler with the configured
+// timeouts. Addr is informational: serve is handed its listener.
+//
+// Go Learning Note — Server Timeouts:
+// A zero http.Server has no timeouts, so a client that opens a connection
+// and sends one byte a minute holds a goroutine and a socket forever.
+// ReadTimeout bounds reading the whole request, body included; WriteTimeout
+// bounds the time from the end of reading the headers to the end of writing
+// the response. Handlers that stream for longer (the ride event stream)
+// lift the write deadline for their own response with http.ResponseController.
+func newHTTPServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         cfg.Port,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+}
+
+// serve runs server on listener until ctx is cancelled, then shuts it down
+// gracefully: new connections are refused, requests in progress get up to
+// shutdownTimeout to finish, and those still running after that are cut off.
+// afterShutdown then stops everything behind the HTTP layer with a budget of
+// its own, drainTimeout, so a request that hangs on doesn't use up the time
+// the services need. It returns an error only if the server itself failed; a
+// shutdown that runs out of time is logged, since stopping was the goal
+// anyway.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, shutdownTimeout, drainTimeout time.Duration, afterShutdown func(context.Context)) error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serverErr:
+		// Serve only returns before Shutdown on failure.
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+		server.Close()
+	}
+	if afterShutdown != nil {
+		drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		afterShutdown(drainCtx)
+	}
+	if err := <-serverErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// barriersFromConfig converts configured barriers into the geo package's type.
+// config stays free of geo imports, so the translation happens here at wiring.
+func barriersFromConfig(configured []config.BarrierConfig) []geo.Barrier {
+	barriers := make([]geo.Barrier, 0, len(configured))
+	for _, b := range configured {
+		barriers = append(barriers, geo.Barrier{
+			Name:     b.Name,
+			SideA:    b.SideA,
+			SideB:    b.SideB,
+			DetourKm: b.DetourKm,
+			Exclude:  b.Exclude,
+		})
+	}
+	return barriers
 }
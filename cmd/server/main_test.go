@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"uber/internal/config"
+)
+
+// startServing runs serve for handler on a free local port, giving requests
+// shutdownTimeout to finish, and returns its base URL, the cancel that
+// triggers shutdown, and serve's result.
+func startServing(t *testing.T, handler http.Handler, shutdownTimeout time.Duration, afterShutdown func(context.Context)) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server := newHTTPServer(config.NewDefaultConfig().Server, handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, server, listener, shutdownTimeout, 5*time.Second, afterShutdown)
+	}()
+	return "http://" + listener.Addr().String(), cancel, done
+}
+
+// waitServe waits for serve to return.
+func waitServe(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after shutdown")
+		return nil
+	}
+}
+
+func TestServe_ServesUntilCancelledThenShutsDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	cleanedUp := false
+	url, cancel, done := startServing(t, engine, 5*time.Second, func(ctx context.Context) {
+		if ctx.Err() != nil {
+			t.Error("Expected the shutdown budget to be left for the services")
+		}
+		cleanedUp = true
+	})
+
+	resp, err := http.Get(url + "/health")
+	if err != nil {
+		t.Fatalf("GET /health failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	cancel()
+	if err := waitServe(t, done); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+	if !cleanedUp {
+		t.Error("Expected the services to be shut down after the server")
+	}
+	if _, err := http.Get(url + "/health"); err == nil {
+		t.Error("Expected the server to refuse connections after shutdown")
+	}
+}
+
+func TestServe_FinishesRequestsInProgress(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	})
+	url, cancel, done := startServing(t, handler, 5*time.Second, nil)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-entered
+
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("Expected serve to wait for the request in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-status; got != http.StatusAccepted {
+		t.Errorf("Expected the in-flight request to complete with 202, got %d", got)
+	}
+	if err := waitServe(t, done); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestServe_ReturnsServerFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	listener.Close()
+
+	server := newHTTPServer(config.NewDefaultConfig().Server, http.NotFoundHandler())
+	err = serve(context.Background(), server, listener, time.Second, time.Second, nil)
+	if err == nil {
+		t.Error("Expected an error from serving on a closed listener")
+	}
+}
+
+func TestServe_LongRequestDoesNotUseUpTheDrainBudget(t *testing.T) {
+	// A stream that never ends by itself, like a ride event stream whose
+	// handler wasn't told about the shutdown.
+	entered := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		close(entered)
+		<-r.Context().Done()
+	})
+
+	var drainErr error
+	var drainLeft time.Duration
+	url, cancel, done := startServing(t, handler, 100*time.Millisecond, func(ctx context.Context) {
+		drainErr = ctx.Err()
+		deadline, _ := ctx.Deadline()
+		drainLeft = time.Until(deadline)
+	})
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	<-entered
+
+	start := time.Now()
+	cancel()
+	if err := waitServe(t, done); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the stream to be cut off after the shutdown timeout, took %v", elapsed)
+	}
+	if drainErr != nil || drainLeft < 4*time.Second {
+		t.Errorf("Expected the services to get their own 5s budget, got %v left (%v)", drainLeft, drainErr)
+	}
+
+	// The stream's connection was closed rather than left to the client.
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("Expected the cut-off stream to end with an error")
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"uber/internal/api/middleware"
+)
+
+// bindJSON decodes the request body into obj and runs binding validation,
+// like c.ShouldBindJSON. In strict mode (see middleware.StrictJSON) unknown
+// fields are rejected with an error naming the field, so a client sending
+// {"latitude": 37} learns about the typo instead of getting a puzzling
+// "required" error for "lat".
+//
+// Go Learning Note — json.Decoder.DisallowUnknownFields:
+// json.Unmarshal ignores keys that don't match any struct field. A Decoder
+// with DisallowUnknownFields() turns those into errors instead. The check
+// happens during decoding, before Gin's validator runs the binding tags, so
+// the unknown-field error takes precedence over "required" errors.
+func bindJSON(c *gin.Context, obj interface{}) error {
+	if !middleware.IsStrictJSON(c) {
+		return c.ShouldBindJSON(obj)
+	}
+
+	if c.Request == nil || c.Request.Body == nil {
+		return errors.New("invalid request")
+	}
+
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(obj); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("unknown field %s in request body", field)
+		}
+		return err
+	}
+
+	return binding.Validator.ValidateStruct(obj)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"uber/internal/domain/entities"
+)
+
+// coordinateScale rounds incoming coordinates to 6 decimal places (about
+// 11 cm), far finer than any GPS fix but coarse enough to absorb float noise.
+const coordinateScale = 1e6
+
+// normalizeCoordinate rounds a latitude or longitude from a client to
+// coordinateScale and turns -0 into 0. Without it, two requests for what is
+// effectively the same point (37.7749 and 37.7749000001, or -0.0 and 0.0) can
+// land on opposite sides of a geohash cell boundary, or miss a cache keyed on
+// the coordinates.
+//
+// Go Learning Note — Negative Zero:
+// IEEE 754 floats have a -0 that compares equal to 0 (-0.0 == 0.0 is true)
+// but formats as "-0" and keeps its sign through multiplication and rounding,
+// so it leaks into anything built from the printed value. Returning a literal
+// 0 for any zero result drops the sign.
+func normalizeCoordinate(v float64) float64 {
+	rounded := math.Round(v*coordinateScale) / coordinateScale
+	if rounded == 0 {
+		return 0
+	}
+	return rounded
+}
+
+// normalizedLocation builds a domain Location from client coordinates,
+// normalizing both (see normalizeCoordinate).
+func normalizedLocation(lat, long float64) entities.Location {
+	return entities.NewLocation(normalizeCoordinate(lat), normalizeCoordinate(long))
+}
+
+// checkCoordinates rejects a latitude outside [-90, 90] or a longitude
+// outside [-180, 180], naming the offending value, so a client sending lat 999
+// gets a 400 saying so instead of a fare built from a nonsense geohash.
+func checkCoordinates(lat, long float64) error {
+	if !(lat >= -90 && lat <= 90) {
+		return fmt.Errorf("lat %g is out of range: must be between -90 and 90", lat)
+	}
+	if !(long >= -180 && long <= 180) {
+		return fmt.Errorf("long %g is out of range: must be between -180 and 180", long)
+	}
+	return nil
+}
+
+// validate checks the request's coordinates are on the globe (see
+// checkCoordinates). Binding has already made sure both are present.
+func (r LocationRequest) validate() error {
+	return checkCoordinates(*r.Lat, *r.Long)
+}
+
+// toLocation converts the request's coordinates into a normalized domain
+// Location. It returns a fresh value, so nothing downstream shares the
+// request struct.
+func (r LocationRequest) toLocation() entities.Location {
+	return normalizedLocation(*r.Lat, *r.Long)
+}
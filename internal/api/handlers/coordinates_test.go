@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+	"uber/internal/geo"
+)
+
+func TestNormalizeCoordinate_SameCell(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   [2]float64 // lat, long
+		differ bool       // whether the raw points fall in different cells
+	}{
+		{"float noise", [2]float64{37.7749000001, -122.4194}, [2]float64{37.7749, -122.4194}, false},
+		// Latitude 0 is a cell boundary at every precision, so noise just
+		// below it flips the very first geohash bit.
+		{"noise across a boundary", [2]float64{-0.0000000001, 10}, [2]float64{0, 10}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawA := geo.Encode(tt.a[0], tt.a[1], geo.MaxPrecision)
+			rawB := geo.Encode(tt.b[0], tt.b[1], geo.MaxPrecision)
+			if (rawA != rawB) != tt.differ {
+				t.Fatalf("Test setup: raw cells %s and %s, expected differ=%v", rawA, rawB, tt.differ)
+			}
+
+			a := normalizedLocation(tt.a[0], tt.a[1])
+			b := normalizedLocation(tt.b[0], tt.b[1])
+			if a != b {
+				t.Errorf("Expected equal locations, got %+v and %+v", a, b)
+			}
+			cellA := geo.Encode(a.Latitude, a.Longitude, geo.MaxPrecision)
+			cellB := geo.Encode(b.Latitude, b.Longitude, geo.MaxPrecision)
+			if cellA != cellB {
+				t.Errorf("Expected the same cell, got %s and %s", cellA, cellB)
+			}
+		})
+	}
+}
+
+func TestNormalizeCoordinate_NegativeZero(t *testing.T) {
+	for _, v := range []float64{math.Copysign(0, -1), -0.0000001} {
+		if got := normalizeCoordinate(v); got != 0 || math.Signbit(got) {
+			t.Errorf("normalizeCoordinate(%g) = %g, expected +0", v, got)
+		}
+	}
+	if got := normalizeCoordinate(-122.41941949); got != -122.419419 {
+		t.Errorf("Expected rounding to 6 decimals, got %v", got)
+	}
+}
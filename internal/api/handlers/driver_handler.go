@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -43,10 +44,12 @@ type AcceptRideRequest struct {
 // The driver's response is submitted asynchronously to the matching service
 // via a channel, which is waiting for this driver's reply. The HTTP response
 // returns immediately — the actual ride state transition happens in the
-// matching goroutine.
+// matching goroutine. If no match is waiting for the ride, the driver gets
+// 404 for a ride that doesn't exist and 409 for one that isn't (or is no
+// longer) being matched, instead of a 200 for an answer that went nowhere.
 func (h *DriverHandler) AcceptRide(c *gin.Context) {
 	var req AcceptRideRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindJSON(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -54,7 +57,24 @@ func (h *DriverHandler) AcceptRide(c *gin.Context) {
 	driverID := middleware.GetUserID(c)
 
 	// Submit response to matching service via the driver response channel.
-	h.matchingService.SubmitDriverResponse(driverID, req.RideID, req.Accept)
+	waiting, err := h.matchingService.SubmitDriverResponse(driverID, req.RideID, req.Accept)
+	if err != nil {
+		switch err {
+		case services.ErrShuttingDown:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if !waiting {
+		if _, err := h.rideService.GetRide(c.Request.Context(), req.RideID); err != nil {
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
+		} else {
+			c.JSON(http.StatusConflict, localizedError(c, "error.ride_not_matching"))
+		}
+		return
+	}
 
 	if req.Accept {
 		c.JSON(http.StatusOK, gin.H{
@@ -69,17 +89,71 @@ func (h *DriverHandler) AcceptRide(c *gin.Context) {
 	}
 }
 
+// AcknowledgeOfferRequest is the JSON body a driver app sends as soon as it
+// receives a ride offer push, before the driver has decided.
+type AcknowledgeOfferRequest struct {
+	RideID string `json:"ride_id" binding:"required"`
+}
+
+// AcknowledgeOffer handles PATCH /ride/driver/ack.
+// It confirms delivery of an offer so the matching loop can start the
+// driver's decision window instead of skipping them as unreachable. An ack
+// for an unknown ride is 404, and one for a ride the driver has no open
+// offer of is 409, as for AcceptRide.
+func (h *DriverHandler) AcknowledgeOffer(c *gin.Context) {
+	var req AcknowledgeOfferRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	driverID := middleware.GetUserID(c)
+
+	open, err := h.matchingService.AcknowledgeOffer(driverID, req.RideID)
+	if err != nil {
+		switch err {
+		case services.ErrShuttingDown:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if !open {
+		if _, err := h.rideService.GetRide(c.Request.Context(), req.RideID); err != nil {
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
+		} else {
+			c.JSON(http.StatusConflict, localizedError(c, "error.no_open_offer"))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "offer acknowledged",
+		"ride_id": req.RideID,
+	})
+}
+
 // UpdateRideStatusRequest is the JSON body for advancing a ride through its
 // lifecycle. Drivers call this to signal pickup, trip start, and completion.
+//
+// When completing, the driver app may also send the trip's measured distance
+// and duration, together, to be charged for the trip as driven rather than as
+// quoted. They are pointers so an omitted field can be told apart from 0.
 type UpdateRideStatusRequest struct {
-	RideID string `json:"ride_id" binding:"required"`
-	Status string `json:"status" binding:"required"`
+	RideID             string   `json:"ride_id" binding:"required"`
+	Status             string   `json:"status" binding:"required"`
+	ActualDistanceKm   *float64 `json:"actual_distance_km"`
+	ActualDurationMins *float64 `json:"actual_duration_mins"`
 }
 
-// UpdateRideStatus handles PATCH /ride/driver/update.
-// It maps the API status string to the domain RideStatus type and delegates
-// to the service layer for the actual state transition. After a successful
-// transition, it triggers the appropriate rider notification.
+// ParseRideStatus maps a raw status string from the driver API to a typed
+// RideStatus. It only accepts the statuses a driver is allowed to set; the
+// rest (estimate, requested, matching, accepted, failed) are driven by the
+// rider or the matching engine and report ok=false here. Drivers finish a ride
+// with "completed" even when the rider must confirm it; the service decides
+// whether it lands in Completed or PendingConfirmation. This boundary
+// validation ensures only known statuses enter the domain layer.
 //
 // Go Learning Note — Switch Statements:
 // Go's switch is more powerful than C/Java's: cases don't fall through by
@@ -87,41 +161,65 @@ type UpdateRideStatusRequest struct {
 // complex expressions. The "default" case handles unexpected values, providing
 // a safety net. If you do want fallthrough, Go has an explicit `fallthrough`
 // keyword, but it's rarely used.
+func ParseRideStatus(raw string) (entities.RideStatus, bool) {
+	switch raw {
+	case "picking_up":
+		return entities.RideStatusPickingUp, true
+	case "in_progress":
+		return entities.RideStatusInProgress, true
+	case "completed":
+		return entities.RideStatusCompleted, true
+	case "cancelled":
+		return entities.RideStatusCancelled, true
+	default:
+		return "", false
+	}
+}
+
+// UpdateRideStatus handles PATCH /ride/driver/update.
+// It maps the API status string to the domain RideStatus type (ParseRideStatus)
+// and delegates to the service layer for the actual state transition. After a
+// successful transition, it triggers the appropriate rider notification.
 func (h *DriverHandler) UpdateRideStatus(c *gin.Context) {
 	var req UpdateRideStatusRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindJSON(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	driverID := middleware.GetUserID(c)
 
-	// Map the raw status string from the API to a typed RideStatus enum.
-	// This boundary validation ensures only known statuses enter the domain layer.
-	var newStatus entities.RideStatus
-	switch req.Status {
-	case "picking_up":
-		newStatus = entities.RideStatusPickingUp
-	case "in_progress":
-		newStatus = entities.RideStatusInProgress
-	case "completed":
-		newStatus = entities.RideStatusCompleted
-	case "cancelled":
-		newStatus = entities.RideStatusCancelled
-	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+	newStatus, ok := ParseRideStatus(req.Status)
+	if !ok {
+		c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_status"))
+		return
+	}
+
+	measured := req.ActualDistanceKm != nil || req.ActualDurationMins != nil
+	if measured && (newStatus != entities.RideStatusCompleted || req.ActualDistanceKm == nil || req.ActualDurationMins == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "actual_distance_km and actual_duration_mins go together, and only with status completed"})
 		return
 	}
 
-	ride, err := h.rideService.UpdateRideStatus(c.Request.Context(), driverID, req.RideID, newStatus)
+	var ride *entities.Ride
+	var err error
+	if measured {
+		ride, err = h.rideService.CompleteRide(c.Request.Context(), driverID, req.RideID, *req.ActualDistanceKm, *req.ActualDurationMins)
+	} else {
+		ride, err = h.rideService.UpdateRideStatus(c.Request.Context(), driverID, req.RideID, newStatus)
+	}
 	if err != nil {
 		switch err {
+		case services.ErrInvalidTripLength:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		case services.ErrRideNotFound:
-			c.JSON(http.StatusNotFound, gin.H{"error": "ride not found"})
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
 		case services.ErrNotAuthorized:
-			c.JSON(http.StatusForbidden, gin.H{"error": "not authorized"})
+			c.JSON(http.StatusForbidden, localizedError(c, "error.not_authorized"))
+		case services.ErrRideTerminal:
+			c.JSON(http.StatusConflict, localizedError(c, "error.ride_terminal"))
 		case services.ErrInvalidTransition:
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status transition"})
+			c.JSON(http.StatusUnprocessableEntity, localizedError(c, "error.invalid_status_transition"))
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -131,12 +229,73 @@ func (h *DriverHandler) UpdateRideStatus(c *gin.Context) {
 	// Send appropriate notifications based on the new ride state.
 	switch newStatus {
 	case entities.RideStatusPickingUp:
-		h.notificationService.NotifyRiderOfDriverArriving(ride.RiderID, driverID, ride.ID)
+		if ride.Contactless {
+			h.notificationService.NotifyRiderOfContactlessDropoff(ride.RiderID, driverID, ride.ID)
+		} else {
+			h.notificationService.NotifyRiderOfDriverArriving(ride.RiderID, driverID, ride.ID)
+		}
 	case entities.RideStatusInProgress:
 		h.notificationService.NotifyRiderOfTripStarted(ride.RiderID, ride.ID)
 	case entities.RideStatusCompleted:
-		h.notificationService.NotifyRiderOfTripCompleted(ride.RiderID, ride.ID, ride.ActualFare)
+		if ride.CurrentStatus() == entities.RideStatusPendingConfirmation {
+			h.notificationService.NotifyRiderToConfirmCompletion(ride.RiderID, ride.ID)
+		} else {
+			h.notificationService.NotifyRiderOfTripCompleted(ride.RiderID, ride.ID, ride.FinalFare())
+		}
+	case entities.RideStatusCancelled:
+		if ride.CurrentStatus() == entities.RideStatusMatching {
+			// The driver backed out before pickup and the ride went back to
+			// matching. Matching outlives this request, so, as in
+			// RideHandler.RequestRide, it gets a copy of the request's
+			// context that keeps the request ID but not the cancellation.
+			h.notificationService.NotifyRiderOfDriverReassignment(ride.RiderID, ride.ID)
+			h.matchingService.RecordCancelAfterAccept(driverID)
+			matchCtx := context.WithoutCancel(c.Request.Context())
+			go func() {
+				<-h.matchingService.RestartMatching(matchCtx, ride, driverID)
+			}()
+		}
+	}
+
+	c.JSON(http.StatusOK, ride)
+}
+
+// GetActiveRide handles GET /driver/active.
+// Returns the driver's current assigned ride, or 204 No Content when they
+// have none — an empty result is a normal state, not an error.
+func (h *DriverHandler) GetActiveRide(c *gin.Context) {
+	driverID := middleware.GetUserID(c)
+
+	ride, err := h.rideService.GetActiveRideForDriver(c.Request.Context(), driverID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ride == nil {
+		c.Status(http.StatusNoContent)
+		return
 	}
 
 	c.JSON(http.StatusOK, ride)
 }
+
+// GetTripFare handles GET /driver/active/fare.
+// Returns the running fare of the driver's in-progress ride, for the driver
+// app to show live earnings. 404 when no trip is in progress.
+func (h *DriverHandler) GetTripFare(c *gin.Context) {
+	driverID := middleware.GetUserID(c)
+
+	projection, err := h.rideService.ProjectTripFare(c.Request.Context(), driverID)
+	if err != nil {
+		switch err {
+		case services.ErrNoTripInProgress:
+			c.JSON(http.StatusNotFound, localizedError(c, "error.no_trip_in_progress"))
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, projection)
+}
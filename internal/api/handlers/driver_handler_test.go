@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"testing"
+	"uber/internal/domain/entities"
+)
+
+// TestParseRideStatus_CoversAllStatuses pins down exactly which statuses a
+// driver may set. Every RideStatus must appear in the table, so adding a new
+// status fails this test until someone decides whether drivers can set it
+// (and, if so, wires it into ParseRideStatus).
+func TestParseRideStatus_CoversAllStatuses(t *testing.T) {
+	driverSettable := map[entities.RideStatus]bool{
+		entities.RideStatusEstimate:            false,
+		entities.RideStatusRequested:           false,
+		entities.RideStatusMatching:            false,
+		entities.RideStatusAccepted:            false,
+		entities.RideStatusPickingUp:           true,
+		entities.RideStatusInProgress:          true,
+		entities.RideStatusPendingConfirmation: false, // drivers send "completed"
+		entities.RideStatusCompleted:           true,
+		entities.RideStatusCancelled:           true,
+		entities.RideStatusFailed:              false,
+	}
+
+	all := entities.AllRideStatuses()
+	if len(all) != len(driverSettable) {
+		t.Fatalf("RideStatus has %d values but the table lists %d; classify the new status", len(all), len(driverSettable))
+	}
+
+	for _, status := range all {
+		expected, listed := driverSettable[status]
+		if !listed {
+			t.Errorf("Status %q is missing from the driver-settable table", status)
+			continue
+		}
+
+		parsed, ok := ParseRideStatus(string(status))
+		if ok != expected {
+			t.Errorf("ParseRideStatus(%q) ok = %v, expected %v", status, ok, expected)
+		}
+		if ok && parsed != status {
+			t.Errorf("ParseRideStatus(%q) = %q", status, parsed)
+		}
+	}
+}
+
+func TestParseRideStatus_Unknown(t *testing.T) {
+	for _, raw := range []string{"", "teleporting", "COMPLETED"} {
+		if _, ok := ParseRideStatus(raw); ok {
+			t.Errorf("Expected ParseRideStatus(%q) to be rejected", raw)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"uber/internal/api/middleware"
+	"uber/internal/i18n"
+)
+
+// localizedError builds an error body whose message is catalog message id in
+// the request's negotiated language (see middleware.Language).
+func localizedError(c *gin.Context, id string) gin.H {
+	return gin.H{"error": i18n.Render(middleware.GetLanguage(c), id, nil)}
+}
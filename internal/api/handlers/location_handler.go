@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"uber/internal/api/middleware"
+	"uber/internal/geo"
 	"uber/internal/services"
 )
 
@@ -24,25 +27,33 @@ func NewLocationHandler(locationService *services.LocationService) *LocationHand
 }
 
 // UpdateLocationRequest is the JSON body for a driver location ping.
+// The coordinates are pointers so a ping from exactly 0.0 isn't mistaken for a
+// missing field (see LocationRequest).
 type UpdateLocationRequest struct {
-	Lat  float64 `json:"lat" binding:"required"`
-	Long float64 `json:"long" binding:"required"`
+	Lat  *float64 `json:"lat" binding:"required"`
+	Long *float64 `json:"long" binding:"required"`
 }
 
 // UpdateLocation handles PATCH /location/update.
 // Called frequently by driver apps to report their current GPS position.
 // The response includes the computed geohash, which is useful for debugging
-// spatial index behavior.
+// spatial index behavior, and "created", which is true only for the ping that
+// first made the driver trackable.
 func (h *LocationHandler) UpdateLocation(c *gin.Context) {
 	var req UpdateLocationRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindJSON(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkCoordinates(*req.Lat, *req.Long); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	driverID := middleware.GetUserID(c)
 
-	location, err := h.locationService.UpdateDriverLocation(c.Request.Context(), driverID, req.Lat, req.Long)
+	location, created, err := h.locationService.UpdateDriverLocation(c.Request.Context(), driverID,
+		normalizeCoordinate(*req.Lat), normalizeCoordinate(*req.Long))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -56,6 +67,54 @@ func (h *LocationHandler) UpdateLocation(c *gin.Context) {
 		},
 		"geohash":    location.Geohash,
 		"updated_at": location.UpdatedAt,
+		"created":    created,
+	})
+}
+
+// UpdateDriverStatusRequest is the JSON body for a driver going online or
+// offline.
+type UpdateDriverStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// UpdateDriverStatus handles PATCH /driver/status.
+// A location ping already brings an offline driver online; this endpoint is
+// the explicit switch, and the only way to go offline. "offline" also takes
+// the driver out of the spatial index so no further offers reach them.
+func (h *LocationHandler) UpdateDriverStatus(c *gin.Context) {
+	var req UpdateDriverStatusRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var online bool
+	switch req.Status {
+	case "online":
+		online = true
+	case "offline":
+		online = false
+	default:
+		c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_status"))
+		return
+	}
+
+	driverID := middleware.GetUserID(c)
+
+	driver, err := h.locationService.SetDriverOnline(c.Request.Context(), driverID, online)
+	if err != nil {
+		switch err {
+		case services.ErrDriverInRide:
+			c.JSON(http.StatusConflict, localizedError(c, "error.driver_in_ride"))
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"driver_id": driver.ID,
+		"status":    driver.Status,
 	})
 }
 
@@ -77,3 +136,110 @@ func (h *LocationHandler) GetLocation(c *gin.Context) {
 
 	c.JSON(http.StatusOK, location)
 }
+
+// GetLocationHistory handles GET /debug/location/:driver_id/history (debug
+// endpoint, no auth). Optional "from" and "to" query parameters (RFC 3339)
+// bound the time range; they default to the beginning of time and now. An
+// optional positive "limit" returns only the most recent pings in the range.
+func (h *LocationHandler) GetLocationHistory(c *gin.Context) {
+	driverID := c.Param("driver_id")
+
+	from := time.Time{}
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from: expected RFC 3339 timestamp"})
+			return
+		}
+		from = parsed
+	}
+
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to: expected RFC 3339 timestamp"})
+			return
+		}
+		to = parsed
+	}
+
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	limit := 0
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: expected a positive integer"})
+			return
+		}
+		limit = parsed
+	}
+
+	history, err := h.locationService.GetLocationHistory(c.Request.Context(), driverID, from, to, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"driver_id": driverID,
+		"from":      from,
+		"to":        to,
+		"locations": history,
+	})
+}
+
+// Ready handles GET /ready (no auth). Unlike /health, which only says the
+// process is up, this says whether ride requests can be served: 503 while the
+// service is still waiting for its first driver, 200 otherwise. A degraded
+// service (no drivers online) still answers 200 with the reason in the body.
+func (h *LocationHandler) Ready(c *gin.Context) {
+	readiness := h.locationService.Readiness(c.Request.Context())
+	code := http.StatusOK
+	if !readiness.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, readiness)
+}
+
+// GetDriverStats handles GET /debug/drivers/stats (debug endpoint, no auth).
+// Returns driver counts by status alongside the spatial index size.
+func (h *LocationHandler) GetDriverStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.locationService.GetDriverStats(c.Request.Context()))
+}
+
+// ReindexRequest is the JSON body for re-homing the spatial index.
+type ReindexRequest struct {
+	Precision int `json:"precision" binding:"required"`
+}
+
+// ReindexSpatial handles POST /debug/spatial/reindex (debug endpoint, no auth).
+// Rebuilds the spatial index at a new geohash precision so drivers indexed at
+// the old precision stay reachable after the precision changes. Only mounted
+// when Server.EnableSpatialReindex is set.
+func (h *LocationHandler) ReindexSpatial(c *gin.Context) {
+	var req ReindexRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.locationService.ReindexSpatialIndex(c.Request.Context(), req.Precision); err != nil {
+		switch err {
+		case geo.ErrInvalidPrecision:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"precision": h.locationService.SpatialIndexPrecision(),
+		"indexed":   h.locationService.GetDriverStats(c.Request.Context()).Indexed,
+	})
+}
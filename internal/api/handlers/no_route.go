@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"uber/internal/api/middleware"
+	"uber/internal/i18n"
+)
+
+// NoRoute answers requests for a path the API doesn't serve. Without it Gin
+// replies with a plain-text "404 page not found", which clients expecting the
+// API's JSON error body can't parse.
+func NoRoute(c *gin.Context) {
+	c.JSON(http.StatusNotFound, routingError(c, "error.route_not_found"))
+}
+
+// NoMethod answers requests for a path the API serves, but not with the
+// request's method. Gin only calls it when engine.HandleMethodNotAllowed is
+// set; otherwise such requests fall through to NoRoute.
+func NoMethod(c *gin.Context) {
+	c.JSON(http.StatusMethodNotAllowed, routingError(c, "error.method_not_allowed"))
+}
+
+// routingError is the standard error body naming the request that missed,
+// plus its request ID so a client report can be matched to server logs.
+func routingError(c *gin.Context, id string) gin.H {
+	data := map[string]string{
+		"Method": c.Request.Method,
+		"Path":   c.Request.URL.Path,
+	}
+	return gin.H{
+		"error":      i18n.Render(middleware.GetLanguage(c), id, data),
+		"request_id": middleware.GetRequestID(c),
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"uber/internal/services"
+)
+
+// sseHeartbeatInterval is how often an idle ride event stream gets a comment
+// line, so proxies and load balancers that close quiet connections (often
+// after 30–60 seconds) leave it open while a ride waits on matching.
+const sseHeartbeatInterval = 15 * time.Second
+
+// StreamRideEvents handles GET /ride/:id/events.
+// The Server-Sent Events counterpart of WatchRide, for clients that can't use
+// WebSockets: a text/event-stream response with a "status" event carrying a
+// services.RideStatusEvent for every transition, starting with the ride's
+// current status, and a ": heartbeat" comment every sseHeartbeatInterval. The
+// response ends after the ride's terminal status, or when the server shuts
+// down (CloseStreams). Access rules are the same as WatchRide's.
+//
+// Go Learning Note — Streaming Responses and Flush:
+// An HTTP handler's writes are buffered, and normally the client sees them
+// when the handler returns. A stream never returns until it is over, so each
+// event is followed by Flush to push it out immediately. The loop also selects
+// on c.Request.Context().Done(): the server cancels that context when the
+// client disconnects, which is the only way a handler that is waiting rather
+// than writing finds out — without it the goroutine would sit on an abandoned
+// subscription until the ride happened to finish.
+func (h *RideHandler) StreamRideEvents(c *gin.Context) {
+	ride, ok := h.watchableRide(c)
+	if !ok {
+		return
+	}
+
+	events := h.rideService.SubscribeStatus(ride)
+	defer h.rideService.UnsubscribeStatus(ride.ID, events)
+
+	// The stream lasts as long as the ride, far beyond the server's
+	// WriteTimeout, so lift the write deadline for this response. Test
+	// recorders don't support deadlines, hence the ignored error.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.SSEvent("status", services.CurrentStatusEvent(ride))
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			c.SSEvent("status", event)
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			io.WriteString(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		case <-h.closing:
+			// The server is shutting down; the client reconnects to
+			// another instance and gets the current status first.
+			return
+		}
+	}
+}
@@ -13,7 +13,11 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"uber/internal/api/middleware"
@@ -23,9 +27,14 @@ import (
 
 // RideHandler groups all ride-related HTTP endpoints. It depends on RideService
 // for business logic and MatchingService to trigger async driver matching.
+//
+// closing is closed by CloseStreams to end the ride status streams, which
+// otherwise last as long as their ride.
 type RideHandler struct {
 	rideService     *services.RideService
 	matchingService *services.MatchingService
+	closing         chan struct{}
+	closeOnce       sync.Once
 }
 
 // NewRideHandler creates a RideHandler with its required service dependencies.
@@ -33,9 +42,19 @@ func NewRideHandler(rideService *services.RideService, matchingService *services
 	return &RideHandler{
 		rideService:     rideService,
 		matchingService: matchingService,
+		closing:         make(chan struct{}),
 	}
 }
 
+// CloseStreams ends every open ride status stream (GET /ride/:id/events and
+// /ride/:id/ws), and any opened later ends after its first event. Register it
+// with http.Server.RegisterOnShutdown: Shutdown waits for requests in
+// progress, and a stream isn't done until its ride is. Calling it again does
+// nothing.
+func (h *RideHandler) CloseStreams() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
 // FareEstimateRequest is the expected JSON body for the fare estimate endpoint.
 //
 // Go Learning Note — Gin Binding Tags:
@@ -43,19 +62,33 @@ func NewRideHandler(rideService *services.RideService, matchingService *services
 // if the field is missing or has its zero value. Gin uses the "go-playground/validator"
 // library under the hood, so you can also use tags like `binding:"min=1,max=100"`
 // or `binding:"email"` for more complex validation. This keeps validation
-// declarative and out of your handler logic.
+// declarative and out of your handler logic. Slices need `dive` for the
+// elements' own tags to be checked.
 type FareEstimateRequest struct {
-	Source      LocationRequest `json:"source" binding:"required"`
-	Destination LocationRequest `json:"destination" binding:"required"`
+	Source      LocationRequest   `json:"source" binding:"required"`
+	Destination LocationRequest   `json:"destination" binding:"required"`
+	Waypoints   []LocationRequest `json:"waypoints" binding:"dive"` // stops in between, in order
+	Contactless bool              `json:"contactless"`
+	Category    string            `json:"category"`     // standard (default), premium, delivery
+	VehicleTier string            `json:"vehicle_tier"` // economy (default), comfort, xl
+	PromoCode   string            `json:"promo_code"`
 }
 
 // LocationRequest represents a lat/long pair in the API request.
 // Note: this is separate from entities.Location because API request types
 // and domain types should evolve independently (the API might use "lat/long"
-// while the domain uses "Latitude/Longitude").
+// while the domain uses "Latitude/Longitude"). Handlers convert it with
+// toLocation, which normalizes the coordinates.
+//
+// Go Learning Note — Pointers for Required Numbers:
+// `binding:"required"` fails on a field's zero value, and for a float64 an
+// omitted "lat" and a real "lat": 0 (the equator) both decode to 0. A
+// *float64 is nil only when the key is missing (or null), so required on a
+// pointer means "present" and 0.0 gets through. The range itself is checked
+// by validate, which can say which coordinate is wrong.
 type LocationRequest struct {
-	Lat  float64 `json:"lat" binding:"required"`
-	Long float64 `json:"long" binding:"required"`
+	Lat  *float64 `json:"lat" binding:"required"`
+	Long *float64 `json:"long" binding:"required"`
 }
 
 // FareEstimate handles POST /ride/fair-estimate.
@@ -66,7 +99,9 @@ type LocationRequest struct {
 // and runs validation. It returns an error if binding or validation fails.
 // The "Should" prefix means it doesn't automatically write a 400 response —
 // you handle the error yourself. The alternative c.BindJSON() auto-aborts with
-// 400 on failure, giving you less control over the error format.
+// 400 on failure, giving you less control over the error format. Handlers here
+// go through bindJSON, which wraps ShouldBindJSON and adds the optional strict
+// (unknown-field-rejecting) mode.
 //
 // Go Learning Note — c.Request.Context():
 // c.Request.Context() returns the standard library context.Context from the
@@ -75,29 +110,146 @@ type LocationRequest struct {
 // cancelled, and well-behaved code will stop work early.
 func (h *RideHandler) FareEstimate(c *gin.Context) {
 	var req FareEstimateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindJSON(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := req.Source.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source: " + err.Error()})
+		return
+	}
+	if err := req.Destination.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "destination: " + err.Error()})
+		return
+	}
+	waypoints := make([]entities.Location, len(req.Waypoints))
+	for i, stop := range req.Waypoints {
+		if err := stop.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("waypoints[%d]: %s", i, err)})
+			return
+		}
+		waypoints[i] = stop.toLocation()
+	}
+
+	category, ok := entities.ParseRideCategory(req.Category)
+	if !ok {
+		c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_ride_category"))
+		return
+	}
+	tier, ok := entities.ParseVehicleTier(req.VehicleTier)
+	if !ok {
+		c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_vehicle_tier"))
+		return
+	}
 
 	riderID := middleware.GetUserID(c)
 
 	estimate, err := h.rideService.CreateFareEstimate(c.Request.Context(), riderID, services.FareEstimateRequest{
-		Source: entities.Location{
-			Latitude:  req.Source.Lat,
-			Longitude: req.Source.Long,
-		},
-		Destination: entities.Location{
-			Latitude:  req.Destination.Lat,
-			Longitude: req.Destination.Long,
-		},
+		Source:      req.Source.toLocation(),
+		Destination: req.Destination.toLocation(),
+		Waypoints:   waypoints,
+		Contactless: req.Contactless,
+		Category:    category,
+		VehicleTier: tier,
+		PromoCode:   req.PromoCode,
 	})
 
+	if err != nil {
+		switch err {
+		case services.ErrSameLocation:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case services.ErrInvalidPromoCode:
+			c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_promo_code"))
+		case services.ErrPromoCodeExpired:
+			c.JSON(http.StatusBadRequest, localizedError(c, "error.promo_code_expired"))
+		case services.ErrInvalidLocation:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case services.ErrRouteTooLong, services.ErrTooManyWaypoints:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, estimate)
+}
+
+// GetActiveRide handles GET /ride/active.
+// Returns the rider's current ride, or 404 when they have none. Unlike the
+// driver's /driver/active, which answers 204, this is what a rider app polls
+// right after a 202 from /ride/request, so "no ride" there means the ride
+// has ended or was never created and the app should stop polling.
+func (h *RideHandler) GetActiveRide(c *gin.Context) {
+	riderID := middleware.GetUserID(c)
+
+	ride, err := h.rideService.GetActiveRide(c.Request.Context(), riderID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if ride == nil {
+		c.JSON(http.StatusNotFound, localizedError(c, "error.no_active_ride"))
+		return
+	}
+
+	c.JSON(http.StatusOK, ride)
+}
+
+// GetRideHistory handles GET /ride/history?limit=&offset=.
+// Returns a page of the rider's rides, newest first, with the total count.
+// limit defaults to services.DefaultHistoryLimit and offset to 0; values that
+// aren't integers, or are out of range, get 400.
+func (h *RideHandler) GetRideHistory(c *gin.Context) {
+	riderID := middleware.GetUserID(c)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(services.DefaultHistoryLimit)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_pagination"))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_pagination"))
+		return
+	}
+
+	history, err := h.rideService.RideHistory(c.Request.Context(), riderID, limit, offset)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidPagination:
+			c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_pagination"))
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// RepeatRide handles POST /ride/repeat/:id. It quotes the trip of one of the
+// rider's earlier rides again, returning a new estimate just like
+// FareEstimate does.
+func (h *RideHandler) RepeatRide(c *gin.Context) {
+	riderID := middleware.GetUserID(c)
+
+	estimate, err := h.rideService.RepeatRide(c.Request.Context(), riderID, c.Param("id"))
+	if err != nil {
+		switch err {
+		case services.ErrRideNotFound:
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
+		case services.ErrNotAuthorized:
+			c.JSON(http.StatusForbidden, localizedError(c, "error.not_authorized"))
+		case services.ErrRouteTooLong, services.ErrTooManyWaypoints:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
 	c.JSON(http.StatusOK, estimate)
 }
 
@@ -129,7 +281,7 @@ type RequestRideRequest struct {
 // so the HTTP response returns immediately.
 func (h *RideHandler) RequestRide(c *gin.Context) {
 	var req RequestRideRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := bindJSON(c, &req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -140,11 +292,21 @@ func (h *RideHandler) RequestRide(c *gin.Context) {
 	if err != nil {
 		switch err {
 		case services.ErrRideNotFound:
-			c.JSON(http.StatusNotFound, gin.H{"error": "ride not found"})
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
 		case services.ErrNotAuthorized:
-			c.JSON(http.StatusForbidden, gin.H{"error": "not authorized"})
+			c.JSON(http.StatusForbidden, localizedError(c, "error.not_authorized"))
 		case services.ErrActiveRideExists:
-			c.JSON(http.StatusConflict, gin.H{"error": "active ride already exists"})
+			c.JSON(http.StatusConflict, localizedError(c, "error.active_ride_exists"))
+		case services.ErrEstimateExpired:
+			c.JSON(http.StatusGone, localizedError(c, "error.estimate_expired"))
+		case services.ErrFareExpired:
+			// Return the re-quoted fare so the client can show it for
+			// confirmation; repeating the request accepts it.
+			c.JSON(http.StatusConflict, gin.H{
+				"error":                err.Error(),
+				"estimated_fare":       ride.EstimatedFare,
+				"fare_lock_expires_at": ride.FareLockExpiresAt,
+			})
 		default:
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		}
@@ -153,9 +315,17 @@ func (h *RideHandler) RequestRide(c *gin.Context) {
 
 	// Start async matching process in a separate goroutine.
 	// The HTTP response returns immediately with 202 Accepted while matching
-	// continues in the background.
+	// continues in the background. net/http cancels the request's context as
+	// soon as the handler returns, so matching gets a copy that keeps its
+	// values (the request ID its logs are tagged with) but not its
+	// cancellation.
+	//
+	// The response is built before matching starts: from then on the
+	// matching goroutine owns the ride and moves it along concurrently.
+	matchCtx := context.WithoutCancel(c.Request.Context())
+	status := ride.CurrentStatus()
 	go func() {
-		resultChan := h.matchingService.StartMatching(c.Request.Context(), ride)
+		resultChan := h.matchingService.StartMatching(matchCtx, ride)
 		result := <-resultChan
 		if result.Success {
 			// Matching succeeded - ride is now accepted
@@ -166,11 +336,177 @@ func (h *RideHandler) RequestRide(c *gin.Context) {
 
 	c.JSON(http.StatusAccepted, gin.H{
 		"ride_id": ride.ID,
-		"status":  ride.Status,
+		"status":  status,
 		"message": "matching in progress",
 	})
 }
 
+// UpdatePickup handles PATCH /ride/:id/pickup.
+// The rider can move their pickup point while the ride is still Requested or
+// Matching. If matching is in flight, the new point is passed to the matching
+// loop so the remaining candidate drivers are re-queried around it.
+func (h *RideHandler) UpdatePickup(c *gin.Context) {
+	var req LocationRequest
+	if err := bindJSON(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	riderID := middleware.GetUserID(c)
+	pickup := req.toLocation()
+
+	ride, err := h.rideService.UpdatePickupLocation(c.Request.Context(), riderID, c.Param("id"), pickup)
+	if err != nil {
+		switch err {
+		case services.ErrRideNotFound:
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
+		case services.ErrNotAuthorized:
+			c.JSON(http.StatusForbidden, localizedError(c, "error.not_authorized"))
+		case services.ErrPickupLocked:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case services.ErrSameLocation, services.ErrInvalidLocation:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case services.ErrRouteTooLong:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	h.matchingService.UpdatePickup(ride.ID, pickup)
+
+	c.JSON(http.StatusOK, ride)
+}
+
+// CancelRide handles POST /ride/:id/cancel.
+// The rider can cancel any time before the trip starts. Cancelling during
+// matching also stops the matching loop, and a driver who accepts at the same
+// moment is released again.
+func (h *RideHandler) CancelRide(c *gin.Context) {
+	riderID := middleware.GetUserID(c)
+
+	ride, err := h.matchingService.CancelRide(c.Request.Context(), riderID, c.Param("id"))
+	if err != nil {
+		switch err {
+		case services.ErrRideNotFound:
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
+		case services.ErrNotAuthorized:
+			c.JSON(http.StatusForbidden, localizedError(c, "error.not_authorized"))
+		case services.ErrRideTerminal:
+			c.JSON(http.StatusConflict, localizedError(c, "error.ride_terminal"))
+		case services.ErrInvalidTransition:
+			c.JSON(http.StatusUnprocessableEntity, localizedError(c, "error.invalid_status_transition"))
+		case services.ErrRideLockHeld:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ride)
+}
+
+// ConfirmCompletion handles POST /ride/:id/confirm.
+// The rider confirms a ride the driver has marked completed, which finalizes
+// the fare. Only used when rides need rider confirmation
+// (RideConfig.RiderConfirmsCompletion); a ride that already completed, by
+// confirmation or by timing out, reports 409.
+func (h *RideHandler) ConfirmCompletion(c *gin.Context) {
+	riderID := middleware.GetUserID(c)
+
+	ride, err := h.rideService.ConfirmCompletion(c.Request.Context(), riderID, c.Param("id"))
+	if err != nil {
+		switch err {
+		case services.ErrRideNotFound:
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
+		case services.ErrNotAuthorized:
+			c.JSON(http.StatusForbidden, localizedError(c, "error.not_authorized"))
+		case services.ErrRideTerminal:
+			c.JSON(http.StatusConflict, localizedError(c, "error.ride_terminal"))
+		case services.ErrInvalidTransition:
+			c.JSON(http.StatusUnprocessableEntity, localizedError(c, "error.invalid_status_transition"))
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ride)
+}
+
+// GetPickupETA handles GET /ride/:id/eta.
+// Once a driver has accepted, the rider can poll how many minutes the driver
+// is from the pickup. Before that (or once the trip has started) there is no
+// driver on the way and it reports 409; a driver whose location isn't known
+// yet reports 404.
+func (h *RideHandler) GetPickupETA(c *gin.Context) {
+	riderID := middleware.GetUserID(c)
+
+	eta, err := h.rideService.GetPickupETA(c.Request.Context(), riderID, c.Param("id"))
+	if err != nil {
+		switch err {
+		case services.ErrRideNotFound:
+			c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
+		case services.ErrNotAuthorized:
+			c.JSON(http.StatusForbidden, localizedError(c, "error.not_authorized"))
+		case services.ErrNoDriverEnRoute:
+			c.JSON(http.StatusConflict, localizedError(c, "error.no_driver_en_route"))
+		case services.ErrDriverLocationUnknown:
+			c.JSON(http.StatusNotFound, localizedError(c, "error.driver_location_unknown"))
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, eta)
+}
+
+// PreviewAvailability handles GET /ride/availability?lat=..&long=..[&category=..].
+// It shows the rider how many drivers are nearby and how soon the closest few
+// could arrive, without creating a ride.
+//
+// Go Learning Note — Query Parameters:
+// c.Query("lat") returns the raw string (or "" when absent); numeric values
+// still need strconv parsing and their own 400 on failure. Gin can also bind
+// query strings into a struct with c.ShouldBindQuery and `form:"lat"` tags,
+// but for two floats explicit parsing keeps the error messages specific.
+func (h *RideHandler) PreviewAvailability(c *gin.Context) {
+	lat, err := strconv.ParseFloat(c.Query("lat"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing lat"})
+		return
+	}
+	long, err := strconv.ParseFloat(c.Query("long"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or missing long"})
+		return
+	}
+	if err := checkCoordinates(lat, long); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	category, ok := entities.ParseRideCategory(c.Query("category"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, localizedError(c, "error.invalid_ride_category"))
+		return
+	}
+
+	preview, err := h.rideService.PreviewAvailability(c.Request.Context(), normalizedLocation(lat, long), category)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
 // GetRide handles GET /ride/:id.
 //
 // Go Learning Note — URL Path Parameters:
@@ -183,9 +519,33 @@ func (h *RideHandler) GetRide(c *gin.Context) {
 
 	ride, err := h.rideService.GetRide(c.Request.Context(), rideID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "ride not found"})
+		c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
 		return
 	}
 
 	c.JSON(http.StatusOK, ride)
 }
+
+// MatchingStats handles GET /debug/matching/stats (debug endpoint, no auth).
+// Reports how many rides are being matched right now, the matching engine's
+// current load, alongside its counters since startup: attempts, outcomes,
+// drivers offered, success rate and average time to match.
+func (h *RideHandler) MatchingStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"in_flight": h.matchingService.PendingCount(),
+		"metrics":   h.matchingService.Metrics(),
+	})
+}
+
+// SearchAreas handles GET /debug/rides/:id/search (debug endpoint, no auth).
+// Lists every driver search made while matching the ride: the center
+// geohash, the cells scanned, the radius and how many drivers were found.
+func (h *RideHandler) SearchAreas(c *gin.Context) {
+	rideID := c.Param("id")
+	searches := h.matchingService.SearchAreas(rideID)
+	if len(searches) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no searches recorded for ride"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ride_id": rideID, "searches": searches})
+}
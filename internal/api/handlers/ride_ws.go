@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"uber/internal/api/middleware"
+	"uber/internal/domain/entities"
+	"uber/internal/services"
+)
+
+// wsWriteTimeout bounds each write to a ride status socket, so a client that
+// has stopped reading can't hold its handler open indefinitely.
+const wsWriteTimeout = 10 * time.Second
+
+// upgrader turns a GET /ride/:id/ws request into a WebSocket. Its default
+// origin check accepts requests with no Origin header (mobile apps, servers)
+// and browsers on the API's own origin.
+var upgrader = websocket.Upgrader{}
+
+// watchableRide loads the ride named in the path for a status stream (the
+// WebSocket or SSE endpoint), which only the ride's rider or its assigned
+// driver may open. On failure it has already written the 404 or 403 and
+// returns false.
+func (h *RideHandler) watchableRide(c *gin.Context) (*entities.Ride, bool) {
+	userID := middleware.GetUserID(c)
+
+	ride, err := h.rideService.GetRide(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, localizedError(c, "error.ride_not_found"))
+		return nil, false
+	}
+	if userID != ride.RiderID && userID != ride.AssignedDriver() {
+		c.JSON(http.StatusForbidden, localizedError(c, "error.not_authorized"))
+		return nil, false
+	}
+	return ride, true
+}
+
+// WatchRide handles GET /ride/:id/ws.
+// Upgrades to a WebSocket and pushes a services.RideStatusEvent as JSON each
+// time the ride changes status, so a rider app waiting on matching after a 202
+// from /ride/request doesn't have to poll GET /ride/:id. The first message is
+// the ride's status at connection time (with no "from"); the last is its
+// terminal status, after which the server closes the socket normally; on
+// shutdown (CloseStreams) it closes it with "going away" instead. Only the
+// ride's rider or its assigned driver may watch it; the socket authenticates
+// with the same Authorization header as every other endpoint.
+//
+// Go Learning Note — Hijacking the Connection:
+// A WebSocket starts life as an HTTP request. Upgrade answers it with 101
+// Switching Protocols and takes over ("hijacks") the underlying TCP
+// connection, after which nothing may be written through c.Writer — so every
+// check that can fail with an ordinary HTTP error happens before Upgrade.
+// From then on the handler owns the connection until it returns.
+//
+// Go Learning Note — One Reader, One Writer:
+// A websocket.Conn supports one concurrent reader and one concurrent writer.
+// The handler goroutine is the only writer; a second goroutine is the only
+// reader. The client isn't expected to send anything, but reading is how a
+// disconnect shows up: ReadMessage fails, the reader closes gone, and the
+// handler unsubscribes and returns.
+func (h *RideHandler) WatchRide(c *gin.Context) {
+	ride, ok := h.watchableRide(c)
+	if !ok {
+		return
+	}
+
+	// Subscribe before reading the snapshot status, so a change landing in
+	// between is sent (possibly repeating the snapshot) rather than missed.
+	events := h.rideService.SubscribeStatus(ride)
+	defer h.rideService.UnsubscribeStatus(ride.ID, events)
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already answered with an HTTP error.
+		return
+	}
+	defer conn.Close()
+
+	write := func(event services.RideStatusEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(event)
+	}
+	if err := write(services.CurrentStatusEvent(ride)); err != nil {
+		return
+	}
+
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "ride finished")
+				conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(wsWriteTimeout))
+				return
+			}
+			if err := write(event); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		case <-h.closing:
+			closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(wsWriteTimeout))
+			return
+		}
+	}
+}
@@ -1,38 +1,68 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
 	"uber/internal/api/handlers"
+	"uber/internal/api/middleware"
 	"uber/internal/config"
 	"uber/internal/geo"
 	"uber/internal/repository/memory"
 	"uber/internal/services"
+	"uber/pkg/utils"
 )
 
 func setupTestServer() *gin.Engine {
+	return newTestServer(nil)
+}
+
+// newTestServer builds a fully wired engine; configure, if non-nil, can tweak
+// the config before anything is constructed from it.
+func newTestServer(configure func(*config.Config)) *gin.Engine {
+	engine, _ := newTestStack(configure)
+	return engine
+}
+
+// newTestStack is newTestServer that also returns the ride handler, for tests
+// that wire it into an http.Server the way main does.
+func newTestStack(configure func(*config.Config)) (*gin.Engine, *handlers.RideHandler) {
 	gin.SetMode(gin.TestMode)
 
 	cfg := config.NewDefaultConfig()
 	cfg.Matching.DriverResponseTimeout = 1 * time.Second
 	cfg.Matching.TotalMatchingTimeout = 3 * time.Second
+	if configure != nil {
+		configure(cfg)
+	}
 
 	riderRepo := memory.NewRiderRepository()
 	driverRepo := memory.NewDriverRepository()
 	rideRepo := memory.NewRideRepository()
+	promoRepo := memory.NewPromoCodeRepository()
 	locationRepo := memory.NewLocationRepository()
 	lockManager := memory.NewLockManager()
-	spatialIndex := geo.NewSpatialIndex(cfg.Geo.GeohashPrecision)
+	precision := geo.IndexPrecision(cfg.Geo.GeohashPrecision, cfg.Matching.SearchRadiusKm)
+	spatialIndex := geo.NewSpatialIndex(precision)
 
 	notificationService := services.NewNotificationService()
 	locationService := services.NewLocationService(spatialIndex, driverRepo, locationRepo)
-	rideService := services.NewRideService(rideRepo, riderRepo, driverRepo, cfg)
+	locationService.SetCoalesceWindow(cfg.Geo.IndexCoalesceWindow)
+	locationService.SetReadyRequiresDriver(cfg.Server.ReadyRequiresDriver)
+	demandTracker := services.NewDemandTracker(spatialIndex, precision)
+	rideService := services.NewRideService(rideRepo, riderRepo, driverRepo, promoRepo, locationService, demandTracker, cfg)
 	matchingService := services.NewMatchingService(
 		cfg,
 		rideService,
@@ -46,11 +76,13 @@ func setupTestServer() *gin.Engine {
 	driverHandler := handlers.NewDriverHandler(rideService, matchingService, notificationService)
 	locationHandler := handlers.NewLocationHandler(locationService)
 
-	router := NewRouter(rideHandler, driverHandler, locationHandler)
+	router := NewRouter(cfg, rideHandler, driverHandler, locationHandler)
+	router.SetLanguagePreferences(notificationService.SetLanguage)
+	router.SetMetrics(NewMetricsRegistry(matchingService, spatialIndex, lockManager))
 	engine := gin.New()
 	router.Setup(engine)
 
-	return engine
+	return engine, rideHandler
 }
 
 func TestHealthEndpoint(t *testing.T) {
@@ -65,6 +97,117 @@ func TestHealthEndpoint(t *testing.T) {
 	}
 }
 
+func TestReadyEndpoint_WaitsForFirstDriver(t *testing.T) {
+	engine := newTestServer(func(cfg *config.Config) {
+		cfg.Server.ReadyRequiresDriver = true
+	})
+
+	ready := func() (int, map[string]interface{}) {
+		req, _ := http.NewRequest("GET", "/ready", nil)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	if code, body := ready(); code != http.StatusServiceUnavailable || body["status"] != "not_ready" {
+		t.Fatalf("Expected 503 not_ready on a cold start, got %d %v", code, body)
+	}
+
+	req, _ := http.NewRequest("PATCH", "/location/update", bytes.NewBufferString(`{"lat":37.771,"long":-122.411}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer driver-1")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	if code, body := ready(); code != http.StatusOK || body["status"] != "ready" {
+		t.Errorf("Expected 200 ready after a driver pinged, got %d %v", code, body)
+	}
+}
+
+func TestUnknownRouteAndMethod(t *testing.T) {
+	engine := setupTestServer()
+
+	tests := []struct {
+		method, path string
+		wantCode     int
+		wantError    string
+	}{
+		{"GET", "/no/such/path", http.StatusNotFound, "no endpoint GET /no/such/path"},
+		{"DELETE", "/health", http.StatusMethodNotAllowed, "DELETE is not allowed on /health"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer rider-1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != tt.wantCode {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.wantCode, w.Code)
+		}
+		var body map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s %s: expected a JSON body, got %q", tt.method, tt.path, w.Body.String())
+		}
+		if body["error"] != tt.wantError {
+			t.Errorf("%s %s: expected error %q, got %q", tt.method, tt.path, tt.wantError, body["error"])
+		}
+		if body["request_id"] == "" || body["request_id"] != w.Header().Get("X-Request-ID") {
+			t.Errorf("%s %s: expected request_id %q to match the X-Request-ID header %q",
+				tt.method, tt.path, body["request_id"], w.Header().Get("X-Request-ID"))
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	req, _ := http.NewRequest("PATCH", "/location/update", bytes.NewBufferString(`{"lat":37.771,"long":-122.411}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer driver-1")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	// No Authorization header: the scraper doesn't log in.
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	// Counted under the route pattern, not the raw path.
+	metrics := w.Body.String()
+	for _, want := range []string{
+		`uber_http_requests_total{method="PATCH",route="/location/update",status="200"} 1`,
+		`uber_http_request_duration_seconds_count{method="PATCH",route="/location/update"} 1`,
+		"uber_spatial_index_drivers 1",
+		"uber_matching_active 0",
+		`uber_matching_results_total{outcome="succeeded"} 0`,
+		`uber_lock_contention_total{kind="driver"} 0`,
+		`uber_lock_contention_total{kind="ride"} 0`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("Expected %q in the scrape", want)
+		}
+	}
+}
+
+func TestRequestIDHeaderIsEchoed(t *testing.T) {
+	engine := setupTestServer()
+
+	req, _ := http.NewRequest("GET", "/no/such/path", nil)
+	req.Header.Set("X-Request-ID", "trace-123")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body["request_id"] != "trace-123" || w.Header().Get("X-Request-ID") != "trace-123" {
+		t.Errorf("Expected the caller's request ID to be kept, got body %q header %q",
+			body["request_id"], w.Header().Get("X-Request-ID"))
+	}
+}
+
 func TestFareEstimateEndpoint(t *testing.T) {
 	engine := setupTestServer()
 
@@ -89,6 +232,9 @@ func TestFareEstimateEndpoint(t *testing.T) {
 	if response["fare"] == nil {
 		t.Error("Expected fare in response")
 	}
+	if v, ok := response["estimated_pickup_mins"]; !ok || v != nil {
+		t.Errorf("Expected estimated_pickup_mins to be null with no drivers, got %v", v)
+	}
 }
 
 func TestLocationUpdateEndpoint(t *testing.T) {
@@ -115,6 +261,51 @@ func TestLocationUpdateEndpoint(t *testing.T) {
 	if response["geohash"] == nil {
 		t.Error("Expected geohash in response")
 	}
+	if response["created"] != true {
+		t.Errorf("Expected created true on first ping, got %v", response["created"])
+	}
+
+	// A second ping updates the existing location.
+	req, _ = http.NewRequest("PATCH", "/location/update", bytes.NewBufferString(`{"lat":37.772,"long":-122.412}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer driver-1")
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	response = nil
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response["created"] != false {
+		t.Errorf("Expected created false on second ping, got %v", response["created"])
+	}
+}
+
+func TestRideRequestEndpoint_ExpiredEstimate(t *testing.T) {
+	engine := newTestServer(func(cfg *config.Config) {
+		cfg.Pricing.FareLockWindow = time.Millisecond
+		cfg.Pricing.EstimateTTL = 20 * time.Millisecond
+	})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer rider-1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/ride/fair-estimate", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	if estimate["expires_at"] == nil {
+		t.Errorf("Expected the estimate to say when it expires, got %s", w.Body.String())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if w = do("PATCH", "/ride/request", `{"ride_id":"`+estimate["ride_id"].(string)+`"}`); w.Code != http.StatusGone {
+		t.Errorf("Expected 410 requesting an expired estimate, got %d. Body: %s", w.Code, w.Body.String())
+	}
 }
 
 func TestRideRequestEndpoint(t *testing.T) {
@@ -153,6 +344,57 @@ func TestRideRequestEndpoint(t *testing.T) {
 	}
 }
 
+func TestActiveRideEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer rider-1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/ride/active", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 before any ride is requested, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	w := do("POST", "/ride/fair-estimate", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	// An estimate alone isn't an active ride.
+	if w = do("GET", "/ride/active", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unrequested estimate, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	if w = do("PATCH", "/ride/request", `{"ride_id":"`+rideID+`"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("Ride request failed: %d - %s", w.Code, w.Body.String())
+	}
+
+	w = do("GET", "/ride/active", "")
+	var ride map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &ride)
+	if w.Code != http.StatusOK || ride["id"] != rideID {
+		t.Errorf("Expected 200 with ride %s, got %d. Body: %s", rideID, w.Code, w.Body.String())
+	}
+}
+
+func TestActiveRideEndpoint_DriverForbidden(t *testing.T) {
+	engine := setupTestServer()
+
+	req, _ := http.NewRequest("GET", "/ride/active", nil)
+	req.Header.Set("Authorization", "Bearer driver-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
 func TestDriverAcceptEndpoint(t *testing.T) {
 	engine := setupTestServer()
 
@@ -199,6 +441,117 @@ func TestDriverAcceptEndpoint(t *testing.T) {
 	}
 }
 
+// TestDriverAcceptEndpoint_OverRealServer runs the request → offer → accept
+// flow through a real HTTP server. Unlike ServeHTTP on a recorder, net/http
+// cancels each request's context once its handler returns, which used to
+// cancel the matching started by /ride/request along with it: the driver's
+// accept was dropped and the ride stayed matching.
+func TestDriverAcceptEndpoint_OverRealServer(t *testing.T) {
+	server := httptest.NewServer(setupTestServer())
+	defer server.Close()
+
+	send := func(method, path, user, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+user)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		var decoded map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp.StatusCode, decoded
+	}
+	waitForStatus := func(rideID, want string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			_, ride := send("GET", "/ride/"+rideID, "rider-1", "")
+			if ride["status"] == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the ride to reach %s, still %v", want, ride["status"])
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	send("PATCH", "/location/update", "driver-1", `{"lat":37.771,"long":-122.411}`)
+	_, estimate := send("POST", "/ride/fair-estimate", "rider-1", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	rideID := estimate["ride_id"].(string)
+
+	if code, body := send("PATCH", "/ride/request", "rider-1", `{"ride_id":"`+rideID+`"}`); code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %v", code, body)
+	}
+	// The request has been answered, so its context is gone; matching
+	// carries on regardless and offers the ride to driver-1.
+	waitForStatus(rideID, "matching")
+	time.Sleep(100 * time.Millisecond)
+
+	if code, body := send("PATCH", "/ride/driver/accept", "driver-1", `{"ride_id":"`+rideID+`","accept":true}`); code != http.StatusOK {
+		t.Fatalf("Expected the accept to reach the match, got %d: %v", code, body)
+	}
+	waitForStatus(rideID, "accepted")
+}
+
+func TestDriverAcceptEndpoint_NoMatchWaiting(t *testing.T) {
+	engine := setupTestServer()
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+user)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("PATCH", "/ride/driver/accept", "driver-1", `{"ride_id":"ride-missing","accept":true}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 accepting a nonexistent ride, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	// An estimate exists but was never requested, so nothing is matching it.
+	w := do("POST", "/ride/fair-estimate", "rider-1",
+		`{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	if w = do("PATCH", "/ride/driver/accept", "driver-1", `{"ride_id":"`+rideID+`","accept":true}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 accepting a ride that isn't being matched, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDriverAckEndpoint_NoOpenOffer(t *testing.T) {
+	engine := setupTestServer()
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+user)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("PATCH", "/ride/driver/ack", "driver-1", `{"ride_id":"ride-missing"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 acknowledging a nonexistent ride, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	w := do("POST", "/ride/fair-estimate", "rider-1",
+		`{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	if w = do("PATCH", "/ride/driver/ack", "driver-1", `{"ride_id":"`+rideID+`"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 acknowledging an offer that was never made, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
+
 func TestCompleteRideFlow(t *testing.T) {
 	engine := setupTestServer()
 
@@ -295,6 +648,215 @@ func TestCompleteRideFlow(t *testing.T) {
 	if completeResponse["status"] != "completed" {
 		t.Errorf("Expected status completed, got %v", completeResponse["status"])
 	}
+
+	// The finished ride still shows how it got there.
+	getReq, _ := http.NewRequest("GET", "/ride/"+rideID, nil)
+	getReq.Header.Set("Authorization", "Bearer rider-1")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, getReq)
+	var ride map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &ride)
+	if history, _ := ride["status_history"].([]interface{}); len(history) != 7 {
+		t.Errorf("Expected 7 status changes from GET /ride/:id, got %v", ride["status_history"])
+	}
+}
+
+func TestConfirmCompletionEndpoint(t *testing.T) {
+	engine := newTestServer(func(cfg *config.Config) {
+		cfg.Ride.RiderConfirmsCompletion = true
+	})
+
+	do := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+user)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	do("PATCH", "/location/update", "driver-1", `{"lat":37.771,"long":-122.411}`)
+	w := do("POST", "/ride/fair-estimate", "rider-1",
+		`{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	if w = do("PATCH", "/ride/request", "rider-1", `{"ride_id":"`+rideID+`"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("Ride request failed: %d - %s", w.Code, w.Body.String())
+	}
+	time.Sleep(100 * time.Millisecond)
+	if w = do("PATCH", "/ride/driver/accept", "driver-1", `{"ride_id":"`+rideID+`","accept":true}`); w.Code != http.StatusOK {
+		t.Fatalf("Driver accept failed: %d - %s", w.Code, w.Body.String())
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Confirming before the driver has finished is out of order.
+	if w = do("POST", "/ride/"+rideID+"/confirm", "rider-1", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 confirming an unfinished ride, got %d", w.Code)
+	}
+
+	var ride map[string]interface{}
+	for _, status := range []string{"picking_up", "in_progress", "completed"} {
+		w = do("PATCH", "/ride/driver/update", "driver-1", `{"ride_id":"`+rideID+`","status":"`+status+`"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s failed: %d - %s", status, w.Code, w.Body.String())
+		}
+	}
+	json.Unmarshal(w.Body.Bytes(), &ride)
+	if ride["status"] != "pending_confirmation" {
+		t.Fatalf("Expected pending_confirmation after the driver completes, got %v", ride["status"])
+	}
+
+	tests := []struct {
+		rider string
+		want  int
+	}{
+		{"rider-2", http.StatusForbidden},
+		{"rider-1", http.StatusOK},
+		{"rider-1", http.StatusConflict}, // already completed
+	}
+	for _, tt := range tests {
+		if w = do("POST", "/ride/"+rideID+"/confirm", tt.rider, ""); w.Code != tt.want {
+			t.Fatalf("%s: expected %d, got %d. Body: %s", tt.rider, tt.want, w.Code, w.Body.String())
+		}
+		if tt.want == http.StatusOK {
+			json.Unmarshal(w.Body.Bytes(), &ride)
+			if ride["status"] != "completed" || ride["actual_fare"] == nil {
+				t.Errorf("Expected completed with a fare, got %v (fare %v)", ride["status"], ride["actual_fare"])
+			}
+		}
+	}
+}
+
+func TestDriverUpdateEndpoint_TransitionErrors(t *testing.T) {
+	engine := setupTestServer()
+
+	send := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+user)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	update := func(rideID, status string) *httptest.ResponseRecorder {
+		return send("PATCH", "/ride/driver/update", "driver-1", `{"ride_id":"`+rideID+`","status":"`+status+`"}`)
+	}
+
+	send("PATCH", "/location/update", "driver-1", `{"lat":37.771,"long":-122.411}`)
+	w := send("POST", "/ride/fair-estimate", "rider-1", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	send("PATCH", "/ride/request", "rider-1", `{"ride_id":"`+rideID+`"}`)
+	time.Sleep(100 * time.Millisecond)
+	if w := send("PATCH", "/ride/driver/accept", "driver-1", `{"ride_id":"`+rideID+`","accept":true}`); w.Code != http.StatusOK {
+		t.Fatalf("Driver accept failed: %d - %s", w.Code, w.Body.String())
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Skipping picking_up and in_progress isn't allowed from accepted.
+	if w := update(rideID, "completed"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for accepted -> completed, got %d - %s", w.Code, w.Body.String())
+	}
+
+	for _, status := range []string{"picking_up", "in_progress", "completed"} {
+		if w := update(rideID, status); w.Code != http.StatusOK {
+			t.Fatalf("Update to %s failed: %d - %s", status, w.Code, w.Body.String())
+		}
+	}
+
+	// A completed ride accepts no transitions at all, including a retry.
+	w = update(rideID, "completed")
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for completing a completed ride, got %d - %s", w.Code, w.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response["error"] != "ride is already finished" {
+		t.Errorf("Unexpected error message %v", response["error"])
+	}
+}
+
+func TestDriverUpdateEndpoint_MeasuredCompletion(t *testing.T) {
+	engine := setupTestServer()
+
+	send := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+user)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	send("PATCH", "/location/update", "driver-1", `{"lat":37.771,"long":-122.411}`)
+	w := send("POST", "/ride/fair-estimate", "rider-1", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	send("PATCH", "/ride/request", "rider-1", `{"ride_id":"`+rideID+`"}`)
+	time.Sleep(100 * time.Millisecond)
+	if w := send("PATCH", "/ride/driver/accept", "driver-1", `{"ride_id":"`+rideID+`","accept":true}`); w.Code != http.StatusOK {
+		t.Fatalf("Driver accept failed: %d - %s", w.Code, w.Body.String())
+	}
+	time.Sleep(200 * time.Millisecond)
+	for _, status := range []string{"picking_up", "in_progress"} {
+		if w := send("PATCH", "/ride/driver/update", "driver-1", `{"ride_id":"`+rideID+`","status":"`+status+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("Update to %s failed: %d - %s", status, w.Code, w.Body.String())
+		}
+	}
+
+	// The measurements go together.
+	if w := send("PATCH", "/ride/driver/update", "driver-1", `{"ride_id":"`+rideID+`","status":"completed","actual_distance_km":12}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a distance without a duration, got %d - %s", w.Code, w.Body.String())
+	}
+
+	w = send("PATCH", "/ride/driver/update", "driver-1", `{"ride_id":"`+rideID+`","status":"completed","actual_distance_km":12,"actual_duration_mins":40}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Measured completion failed: %d - %s", w.Code, w.Body.String())
+	}
+	var ride struct {
+		EstimatedFare utils.Money `json:"estimated_fare"`
+		ActualFare    utils.Money `json:"actual_fare"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &ride)
+	if ride.ActualFare <= ride.EstimatedFare {
+		t.Errorf("Expected a 12 km trip to cost more than the %v quoted for about 1.4 km, got %v", ride.EstimatedFare, ride.ActualFare)
+	}
+}
+
+func TestJWTAuthReplacesMockAuth(t *testing.T) {
+	engine := newTestServer(func(cfg *config.Config) {
+		cfg.Server.JWTSecret = "integration-secret"
+	})
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.Claims{
+		Role: middleware.UserTypeRider,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "alice",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte("integration-secret"))
+
+	for _, tt := range []struct {
+		bearer string
+		want   int
+	}{
+		{"rider-1", http.StatusUnauthorized}, // the mock scheme no longer works
+		{token, http.StatusNotFound},         // authenticated; alice just has no ride
+	} {
+		req, _ := http.NewRequest("GET", "/ride/active", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.bearer)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("Bearer %.10s...: expected %d, got %d. Body: %s", tt.bearer, tt.want, w.Code, w.Body.String())
+		}
+	}
 }
 
 func TestUnauthorizedAccess(t *testing.T) {
@@ -344,3 +906,1112 @@ func TestRiderAccessingDriverEndpoint(t *testing.T) {
 		t.Errorf("Expected status 403, got %d", w.Code)
 	}
 }
+
+func TestDriverStatsEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	driverBody := `{"lat":37.771,"long":-122.411}`
+	driverReq, _ := http.NewRequest("PATCH", "/location/update", bytes.NewBufferString(driverBody))
+	driverReq.Header.Set("Content-Type", "application/json")
+	driverReq.Header.Set("Authorization", "Bearer driver-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, driverReq)
+
+	req, _ := http.NewRequest("GET", "/debug/drivers/stats", nil)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	if response["available"] != float64(1) {
+		t.Errorf("Expected 1 available, got %v", response["available"])
+	}
+	if response["indexed"] != float64(1) {
+		t.Errorf("Expected 1 indexed, got %v", response["indexed"])
+	}
+}
+
+func TestDriverStatusEndpoint_OfflineAndOnline(t *testing.T) {
+	engine := setupTestServer()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer driver-1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	stats := func() map[string]interface{} {
+		w := send("GET", "/debug/drivers/stats", "")
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	send("PATCH", "/location/update", `{"lat":37.771,"long":-122.411}`)
+
+	w := send("PATCH", "/driver/status", `{"status":"offline"}`)
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response["status"] != "offline" {
+		t.Fatalf("Expected 200 offline, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if s := stats(); s["indexed"] != float64(0) || s["available"] != float64(0) {
+		t.Errorf("Expected an offline driver to leave the index, got %v indexed, %v available", s["indexed"], s["available"])
+	}
+
+	w = send("PATCH", "/driver/status", `{"status":"online"}`)
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response["status"] != "available" {
+		t.Fatalf("Expected 200 available, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	if s := stats(); s["available"] != float64(1) {
+		t.Errorf("Expected the driver to be available again, got %v", s["available"])
+	}
+
+	if w = send("PATCH", "/driver/status", `{"status":"in_ride"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a status drivers can't set, got %d", w.Code)
+	}
+}
+
+func TestDriverStatusEndpoint_InRideCannotGoOnline(t *testing.T) {
+	engine := setupTestServer()
+
+	send := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+user)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	send("PATCH", "/location/update", "driver-1", `{"lat":37.771,"long":-122.411}`)
+	w := send("POST", "/ride/fair-estimate", "rider-1", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	send("PATCH", "/ride/request", "rider-1", `{"ride_id":"`+rideID+`"}`)
+	time.Sleep(100 * time.Millisecond)
+	if w := send("PATCH", "/ride/driver/accept", "driver-1", `{"ride_id":"`+rideID+`","accept":true}`); w.Code != http.StatusOK {
+		t.Fatalf("Driver accept failed: %d - %s", w.Code, w.Body.String())
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	for _, status := range []string{"online", "offline"} {
+		if w := send("PATCH", "/driver/status", "driver-1", `{"status":"`+status+`"}`); w.Code != http.StatusConflict {
+			t.Errorf("Expected 409 going %s mid-ride, got %d. Body: %s", status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestDriverStatusEndpoint_RiderForbidden(t *testing.T) {
+	engine := setupTestServer()
+
+	req, _ := http.NewRequest("PATCH", "/driver/status", bytes.NewBufferString(`{"status":"offline"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestDriverStatsEndpoint_LocationUpdates(t *testing.T) {
+	engine := newTestServer(func(cfg *config.Config) {
+		cfg.Geo.IndexCoalesceWindow = time.Minute
+	})
+
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest("PATCH", "/location/update", bytes.NewBufferString(`{"lat":37.771,"long":-122.411}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer driver-1")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("GET", "/debug/drivers/stats", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	var response struct {
+		LocationUpdates services.LocationUpdateStats `json:"location_updates"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	got := response.LocationUpdates
+	if got.Received != 5 || got.IndexWrites != 1 || got.Coalesced != 4 {
+		t.Errorf("Expected 5 received, 1 index write, 4 coalesced; got %+v", got)
+	}
+}
+
+func TestFareEstimateEndpoint_SameLocation(t *testing.T) {
+	engine := setupTestServer()
+
+	body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.77,"long":-122.41}}`
+	req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFareEstimateEndpoint_RouteLimits(t *testing.T) {
+	engine := newTestServer(func(cfg *config.Config) {
+		cfg.Ride.MaxRouteDistanceKm = 100
+		cfg.Ride.MaxWaypoints = 2
+	})
+
+	// Out-of-range coordinates are a malformed request (400, see
+	// TestCoordinateValidation); these are well-formed routes over a limit.
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{"within cap", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.80,"long":-122.27}}`, http.StatusOK},
+		{"San Francisco to Los Angeles", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":34.05,"long":-118.24}}`, http.StatusUnprocessableEntity},
+		{"multi-stop within both limits", `{"source":{"lat":37.77,"long":-122.41},"waypoints":[{"lat":37.78,"long":-122.40},{"lat":37.79,"long":-122.35}],"destination":{"lat":37.80,"long":-122.27}}`, http.StatusOK},
+		{"too many stops", `{"source":{"lat":37.77,"long":-122.41},"waypoints":[{"lat":37.78,"long":-122.40},{"lat":37.79,"long":-122.35},{"lat":37.79,"long":-122.30}],"destination":{"lat":37.80,"long":-122.27}}`, http.StatusUnprocessableEntity},
+		{"detour via San Jose over distance cap", `{"source":{"lat":37.77,"long":-122.41},"waypoints":[{"lat":37.34,"long":-121.89}],"destination":{"lat":37.80,"long":-122.27}}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer rider-1")
+
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d. Body: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCoordinateValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		user      string
+		body      string
+		wantCode  int
+		wantError string
+	}{
+		{"fare estimate: latitude too large", "POST", "/ride/fair-estimate", "rider-1",
+			`{"source":{"lat":999,"long":-122.41},"destination":{"lat":37.80,"long":-122.27}}`,
+			http.StatusBadRequest, "source: lat 999 is out of range"},
+		{"fare estimate: longitude too small", "POST", "/ride/fair-estimate", "rider-1",
+			`{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.80,"long":-500}}`,
+			http.StatusBadRequest, "destination: long -500 is out of range"},
+		{"fare estimate: missing latitude", "POST", "/ride/fair-estimate", "rider-1",
+			`{"source":{"long":-122.41},"destination":{"lat":37.80,"long":-122.27}}`,
+			http.StatusBadRequest, "required"},
+		{"fare estimate: stop out of range", "POST", "/ride/fair-estimate", "rider-1",
+			`{"source":{"lat":37.77,"long":-122.41},"waypoints":[{"lat":37.78,"long":-122.40},{"lat":95,"long":-122.35}],"destination":{"lat":37.80,"long":-122.27}}`,
+			http.StatusBadRequest, "waypoints[1]: lat 95 is out of range"},
+		{"fare estimate: stop missing longitude", "POST", "/ride/fair-estimate", "rider-1",
+			`{"source":{"lat":37.77,"long":-122.41},"waypoints":[{"lat":37.78}],"destination":{"lat":37.80,"long":-122.27}}`,
+			http.StatusBadRequest, "required"},
+		{"fare estimate: equator and prime meridian", "POST", "/ride/fair-estimate", "rider-1",
+			`{"source":{"lat":0,"long":0},"destination":{"lat":0.01,"long":0.01}}`,
+			http.StatusOK, ""},
+		{"fare estimate: on the range limits", "POST", "/ride/fair-estimate", "rider-1",
+			`{"source":{"lat":-90,"long":180},"destination":{"lat":-89.99,"long":180}}`,
+			http.StatusOK, ""},
+		{"location update: latitude too small", "PATCH", "/location/update", "driver-1",
+			`{"lat":-90.5,"long":10}`, http.StatusBadRequest, "lat -90.5 is out of range"},
+		{"location update: longitude too large", "PATCH", "/location/update", "driver-1",
+			`{"lat":10,"long":180.01}`, http.StatusBadRequest, "long 180.01 is out of range"},
+		{"location update: null longitude", "PATCH", "/location/update", "driver-1",
+			`{"lat":10,"long":null}`, http.StatusBadRequest, "required"},
+		{"location update: equator and prime meridian", "PATCH", "/location/update", "driver-2",
+			`{"lat":0,"long":0}`, http.StatusOK, ""},
+		{"location update: valid", "PATCH", "/location/update", "driver-3",
+			`{"lat":37.771,"long":-122.411}`, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := setupTestServer()
+			req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+tt.user)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Errorf("Expected the error to mention %q, got %s", tt.wantError, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestUpdatePickupEndpoint_OutOfRange(t *testing.T) {
+	engine := setupTestServer()
+
+	req, _ := http.NewRequest("PATCH", "/ride/ride-1/pickup", bytes.NewBufferString(`{"lat":91,"long":0}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	// Rejected before the ride is even looked up.
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "lat 91 is out of range") {
+		t.Errorf("Expected 400 naming the latitude, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDriverActiveRideEndpoint_None(t *testing.T) {
+	engine := setupTestServer()
+
+	req, _ := http.NewRequest("GET", "/driver/active", nil)
+	req.Header.Set("Authorization", "Bearer driver-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDriverActiveRideEndpoint_RiderForbidden(t *testing.T) {
+	engine := setupTestServer()
+
+	req, _ := http.NewRequest("GET", "/driver/active", nil)
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	engine := setupTestServer()
+
+	tests := []struct {
+		acceptLanguage string
+		expected       string
+	}{
+		{"es-MX,es;q=0.9", "viaje no encontrado"},
+		{"fr", "ride not found"},
+		{"", "ride not found"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/ride/ride-missing", nil)
+		req.Header.Set("Authorization", "Bearer rider-1")
+		if tt.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusNotFound || response["error"] != tt.expected {
+			t.Errorf("Accept-Language %q: expected 404 %q, got %d %v", tt.acceptLanguage, tt.expected, w.Code, response["error"])
+		}
+	}
+}
+
+func TestDriverTripFareEndpoint_NoTrip(t *testing.T) {
+	engine := setupTestServer()
+
+	req, _ := http.NewRequest("GET", "/driver/active/fare", nil)
+	req.Header.Set("Authorization", "Bearer driver-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUpdatePickupEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	// Create an estimate
+	body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.80,"long":-122.40}}`
+	req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	pickupBody := `{"lat":37.78,"long":-122.41}`
+
+	// Moving the pickup of an unrequested estimate is rejected.
+	req, _ = http.NewRequest("PATCH", "/ride/"+rideID+"/pickup", bytes.NewBufferString(pickupBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 before request, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	// Another rider may not move this ride's pickup.
+	req, _ = http.NewRequest("PATCH", "/ride/"+rideID+"/pickup", bytes.NewBufferString(pickupBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-2")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for another rider, got %d. Body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRepeatRideEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`
+	req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	req, _ = http.NewRequest("POST", "/ride/repeat/"+rideID, nil)
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	var repeated map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &repeated)
+	if repeated["ride_id"] == nil || repeated["ride_id"] == rideID {
+		t.Errorf("Expected a new ride_id, got %v", repeated["ride_id"])
+	}
+
+	// Another rider can't clone it.
+	req, _ = http.NewRequest("POST", "/ride/repeat/"+rideID, nil)
+	req.Header.Set("Authorization", "Bearer rider-2")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another rider's ride, got %d", w.Code)
+	}
+}
+
+func TestRideHistoryEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer rider-1")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	tests := []struct {
+		query     string
+		wantCode  int
+		wantRides int
+	}{
+		{"", http.StatusOK, 3},
+		{"?limit=2", http.StatusOK, 2},
+		{"?limit=2&offset=2", http.StatusOK, 1},
+		{"?offset=10", http.StatusOK, 0},
+		{"?limit=0", http.StatusBadRequest, 0},
+		{"?limit=101", http.StatusBadRequest, 0},
+		{"?offset=-1", http.StatusBadRequest, 0},
+		{"?limit=ten", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/ride/history"+tt.query, nil)
+		req.Header.Set("Authorization", "Bearer rider-1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("%q: expected %d, got %d. Body: %s", tt.query, tt.wantCode, w.Code, w.Body.String())
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var history struct {
+			Rides []map[string]interface{} `json:"rides"`
+			Total int                      `json:"total"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &history)
+		if len(history.Rides) != tt.wantRides || history.Total != 3 {
+			t.Errorf("%q: expected %d of 3 rides, got %d of %d", tt.query, tt.wantRides, len(history.Rides), history.Total)
+		}
+	}
+
+	// Other riders see only their own rides.
+	req, _ := http.NewRequest("GET", "/ride/history", nil)
+	req.Header.Set("Authorization", "Bearer rider-2")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"total":0`) {
+		t.Errorf("Expected an empty history for rider-2, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWatchRideEndpoint_PushesStatusChanges(t *testing.T) {
+	engine := setupTestServer()
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`
+	req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ride/" + rideID + "/ws"
+
+	// Only the ride's rider (or its driver) may watch it.
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer rider-2"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 for another rider, got %v (%v)", resp, err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer rider-1"}})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	var snapshot services.RideStatusEvent
+	if err := conn.ReadJSON(&snapshot); err != nil {
+		t.Fatalf("Expected the current status first, got %v", err)
+	}
+	if snapshot.Status != "estimate" || snapshot.RideID != rideID {
+		t.Errorf("Expected an estimate snapshot for %s, got %+v", rideID, snapshot)
+	}
+
+	// No drivers are online, so matching fails.
+	req, _ = http.NewRequest("PATCH", "/ride/request", bytes.NewBufferString(`{"ride_id":"`+rideID+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 from request, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var statuses []string
+	for {
+		var event services.RideStatusEvent
+		err := conn.ReadJSON(&event)
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Reading events failed after %v: %v", statuses, err)
+		}
+		statuses = append(statuses, string(event.From)+"->"+string(event.Status))
+	}
+
+	want := "[estimate->requested requested->matching matching->failed]"
+	if got := fmt.Sprint(statuses); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestStreamRideEventsEndpoint_EndsAfterCompletion(t *testing.T) {
+	engine := setupTestServer()
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	send := func(method, path, user, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+user)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	send("PATCH", "/location/update", "driver-1", `{"lat":37.771,"long":-122.411}`)
+	w := send("POST", "/ride/fair-estimate", "rider-1", `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	send("PATCH", "/ride/request", "rider-1", `{"ride_id":"`+rideID+`"}`)
+	time.Sleep(100 * time.Millisecond)
+	if w := send("PATCH", "/ride/driver/accept", "driver-1", `{"ride_id":"`+rideID+`","accept":true}`); w.Code != http.StatusOK {
+		t.Fatalf("Driver accept failed: %d - %s", w.Code, w.Body.String())
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// The assigned driver may follow the ride too.
+	req, _ := http.NewRequest("GET", server.URL+"/ride/"+rideID+"/events", nil)
+	req.Header.Set("Authorization", "Bearer driver-1")
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Opening the stream failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("Expected a 200 event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	for _, status := range []string{"picking_up", "in_progress", "completed"} {
+		if w := send("PATCH", "/ride/driver/update", "driver-1", `{"ride_id":"`+rideID+`","status":"`+status+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("Update to %s failed: %d - %s", status, w.Code, w.Body.String())
+		}
+	}
+
+	// The stream ends by itself once the ride completes, so reading to EOF
+	// returns rather than hanging until the client timeout.
+	stream, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Reading the stream failed: %v", err)
+	}
+	var statuses []string
+	for _, line := range strings.Split(string(stream), "\n") {
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			var event services.RideStatusEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatalf("Bad event data %q: %v", data, err)
+			}
+			statuses = append(statuses, string(event.Status))
+		}
+	}
+	if got := fmt.Sprint(statuses); got != "[accepted picking_up in_progress completed]" {
+		t.Errorf("Expected accepted through completed, got %s", got)
+	}
+	if n := strings.Count(string(stream), "event:status"); n != len(statuses) {
+		t.Errorf("Expected every event to be named status, got %d names for %d events", n, len(statuses))
+	}
+
+	// Anyone else is turned away before the stream starts.
+	req, _ = http.NewRequest("GET", server.URL+"/ride/"+rideID+"/events", nil)
+	req.Header.Set("Authorization", "Bearer rider-2")
+	if resp, err := client.Do(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for another rider, got %v (%v)", resp, err)
+	}
+}
+
+func TestStreamRideEventsEndpoint_EndsOnShutdown(t *testing.T) {
+	engine, rideHandler := newTestStack(nil)
+	server := httptest.NewUnstartedServer(engine)
+	server.Config.RegisterOnShutdown(rideHandler.CloseStreams)
+	server.Start()
+	defer server.Close()
+
+	estimateReq, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(`{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`))
+	estimateReq.Header.Set("Content-Type", "application/json")
+	estimateReq.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, estimateReq)
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	// An estimate nobody requests never finishes, so its stream would stay
+	// open for good.
+	req, _ := http.NewRequest("GET", server.URL+"/ride/"+rideID+"/events", nil)
+	req.Header.Set("Authorization", "Bearer rider-1")
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("Opening the stream failed: %v", err)
+	}
+	defer resp.Body.Close()
+	first, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(first, "event:") {
+		t.Fatalf("Expected the stream to start with an event, got %q (%v)", first, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := server.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected Shutdown to finish with the stream open, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the stream to end as shutdown began, took %v", elapsed)
+	}
+}
+
+func TestCancelRideEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`
+	req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	var estimate map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimate)
+	rideID := estimate["ride_id"].(string)
+
+	req, _ = http.NewRequest("PATCH", "/ride/request", bytes.NewBufferString(`{"ride_id":"`+rideID+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 from request, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		rider string
+		want  int
+	}{
+		{"rider-2", http.StatusForbidden},
+		{"rider-1", http.StatusOK},
+		{"rider-1", http.StatusConflict}, // already cancelled
+	}
+	for _, tt := range tests {
+		req, _ = http.NewRequest("POST", "/ride/"+rideID+"/cancel", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.rider)
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Fatalf("%s: expected %d, got %d. Body: %s", tt.rider, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSpatialReindexEndpoint(t *testing.T) {
+	// Not mounted by default.
+	req, _ := http.NewRequest("POST", "/debug/spatial/reindex", bytes.NewBufferString(`{"precision":7}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	setupTestServer().ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 while disabled, got %d", w.Code)
+	}
+
+	engine := newTestServer(func(cfg *config.Config) {
+		cfg.Server.EnableSpatialReindex = true
+	})
+
+	req, _ = http.NewRequest("POST", "/debug/spatial/reindex", bytes.NewBufferString(`{"precision":7}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["precision"] != float64(7) {
+		t.Errorf("Expected precision 7, got %v", resp["precision"])
+	}
+
+	req, _ = http.NewRequest("POST", "/debug/spatial/reindex", bytes.NewBufferString(`{"precision":13}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for out-of-range precision, got %d", w.Code)
+	}
+}
+
+func TestLocationHistoryEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	for _, body := range []string{`{"lat":37.7700,"long":-122.41}`, `{"lat":37.7710,"long":-122.41}`} {
+		req, _ := http.NewRequest("PATCH", "/location/update", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer driver-1")
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("GET", "/debug/location/driver-1/history", nil)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if locations, _ := resp["locations"].([]interface{}); len(locations) != 2 {
+		t.Errorf("Expected 2 locations in history, got %v", resp["locations"])
+	}
+
+	req, _ = http.NewRequest("GET", "/debug/location/driver-1/history?limit=1", nil)
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	resp = nil
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	locations, _ := resp["locations"].([]interface{})
+	if len(locations) != 1 {
+		t.Fatalf("Expected 1 location with limit=1, got %v", resp["locations"])
+	}
+	if latest, _ := locations[0].(map[string]interface{})["location"].(map[string]interface{}); latest["lat"] != 37.771 {
+		t.Errorf("Expected the most recent ping, got %v", locations[0])
+	}
+
+	for _, query := range []string{"from=yesterday", "limit=0", "limit=ten"} {
+		req, _ = http.NewRequest("GET", "/debug/location/driver-1/history?"+query, nil)
+		w = httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestFareEstimateEndpoint_UnknownFields(t *testing.T) {
+	body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40},"gift_card":"SAVE10"}`
+
+	tests := []struct {
+		name         string
+		strict       bool
+		expectedCode int
+	}{
+		{"Lenient mode ignores unknown fields", false, http.StatusOK},
+		{"Strict mode rejects unknown fields", true, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newTestServer(func(cfg *config.Config) {
+				cfg.Server.StrictJSON = tt.strict
+			})
+
+			req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer rider-1")
+
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+
+			if tt.strict {
+				var response map[string]interface{}
+				json.Unmarshal(w.Body.Bytes(), &response)
+				msg, _ := response["error"].(string)
+				if !strings.Contains(msg, `"gift_card"`) {
+					t.Errorf("Expected error to name the unknown field, got %q", msg)
+				}
+			}
+		})
+	}
+}
+
+func TestFareEstimateEndpoint_Category(t *testing.T) {
+	tests := []struct {
+		name             string
+		category         string
+		expectedCode     int
+		expectedCategory string
+	}{
+		{"Default is standard", "", http.StatusOK, "standard"},
+		{"Premium", "premium", http.StatusOK, "premium"},
+		{"Unknown category", "helicopter", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := setupTestServer()
+
+			body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40},"category":"` + tt.category + `"}`
+			req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer rider-1")
+
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+			if tt.expectedCategory == "" {
+				return
+			}
+
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if response["category"] != tt.expectedCategory {
+				t.Errorf("Expected category %s, got %v", tt.expectedCategory, response["category"])
+			}
+		})
+	}
+}
+
+func TestFareEstimateEndpoint_VehicleTier(t *testing.T) {
+	tests := []struct {
+		name         string
+		tier         string
+		expectedCode int
+		expectedTier string
+	}{
+		{"Default is economy", "", http.StatusOK, "economy"},
+		{"XL", "xl", http.StatusOK, "xl"},
+		{"Unknown tier", "limo", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := setupTestServer()
+
+			body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40},"vehicle_tier":"` + tt.tier + `"}`
+			req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer rider-1")
+
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Fatalf("Expected status %d, got %d. Body: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+			if tt.expectedTier == "" {
+				return
+			}
+
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			if response["vehicle_tier"] != tt.expectedTier {
+				t.Errorf("Expected vehicle tier %s, got %v", tt.expectedTier, response["vehicle_tier"])
+			}
+		})
+	}
+}
+
+func TestFareEstimateEndpoint_InvalidPromoCode(t *testing.T) {
+	engine := setupTestServer()
+
+	body := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40},"promo_code":"NOSUCHCODE"}`
+	req, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer rider-1")
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an unknown promo code, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response["error"] != "promo code is not valid" {
+		t.Errorf("Expected the invalid promo code error, got %v", response["error"])
+	}
+}
+
+func TestPprofEndpoints_Gated(t *testing.T) {
+	tests := []struct {
+		name         string
+		enabled      bool
+		expectedCode int
+	}{
+		{"Disabled by default", false, http.StatusNotFound},
+		{"Enabled", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := newTestServer(func(cfg *config.Config) {
+				cfg.Server.EnablePprof = tt.enabled
+			})
+
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/pprof/cmdline"} {
+				req, _ := http.NewRequest("GET", path, nil)
+				w := httptest.NewRecorder()
+				engine.ServeHTTP(w, req)
+
+				if w.Code != tt.expectedCode {
+					t.Errorf("GET %s: expected status %d, got %d", path, tt.expectedCode, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestAvailabilityPreviewEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	// Four drivers near the pickup; the preview caps ETAs at the default 3.
+	pings := map[string]string{
+		"driver-1": `{"lat":37.7790,"long":-122.4180}`,
+		"driver-2": `{"lat":37.7752,"long":-122.4180}`,
+		"driver-3": `{"lat":37.7770,"long":-122.4180}`,
+		"driver-4": `{"lat":37.7775,"long":-122.4180}`,
+	}
+	for driverID, body := range pings {
+		req, _ := http.NewRequest("PATCH", "/location/update", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+driverID)
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("GET", "/ride/availability?lat=37.7750&long=-122.4180", nil)
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Count       int       `json:"count"`
+		ETAsMins    []float64 `json:"etas_mins"`
+		ClosestMins *float64  `json:"closest_mins"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+
+	if response.Count != 4 {
+		t.Errorf("Expected count 4, got %d", response.Count)
+	}
+	if len(response.ETAsMins) != 3 {
+		t.Fatalf("Expected 3 ETAs, got %v", response.ETAsMins)
+	}
+	for i := 1; i < len(response.ETAsMins); i++ {
+		if response.ETAsMins[i] < response.ETAsMins[i-1] {
+			t.Errorf("Expected ETAs ascending, got %v", response.ETAsMins)
+		}
+	}
+	if response.ClosestMins == nil || *response.ClosestMins != response.ETAsMins[0] {
+		t.Errorf("Expected closest_mins to equal the first ETA, got %v", response.ClosestMins)
+	}
+}
+
+func TestAvailabilityPreviewEndpoint_Invalid(t *testing.T) {
+	engine := setupTestServer()
+
+	for _, query := range []string{
+		"", "?lat=37.77", "?lat=abc&long=-122.41", "?lat=37.77&long=-122.41&category=helicopter",
+		"?lat=91&long=-122.41", "?lat=37.77&long=-180.5", "?lat=NaN&long=-122.41",
+	} {
+		req, _ := http.NewRequest("GET", "/ride/availability"+query, nil)
+		req.Header.Set("Authorization", "Bearer rider-1")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET /ride/availability%s: expected 400, got %d", query, w.Code)
+		}
+	}
+
+	// Out-of-range coordinates are named, as on every other endpoint.
+	req, _ := http.NewRequest("GET", "/ride/availability?lat=91&long=-122.41", nil)
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "lat 91 is out of range") {
+		t.Errorf("Expected the error to name the latitude, got %s", w.Body.String())
+	}
+}
+
+func TestPickupETAEndpoint(t *testing.T) {
+	engine := setupTestServer()
+
+	driverReq, _ := http.NewRequest("PATCH", "/location/update", bytes.NewBufferString(`{"lat":37.78,"long":-122.41}`))
+	driverReq.Header.Set("Content-Type", "application/json")
+	driverReq.Header.Set("Authorization", "Bearer driver-1")
+	engine.ServeHTTP(httptest.NewRecorder(), driverReq)
+
+	estimateBody := `{"source":{"lat":37.77,"long":-122.41},"destination":{"lat":37.78,"long":-122.40}}`
+	estimateReq, _ := http.NewRequest("POST", "/ride/fair-estimate", bytes.NewBufferString(estimateBody))
+	estimateReq.Header.Set("Content-Type", "application/json")
+	estimateReq.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, estimateReq)
+
+	var estimateResponse map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &estimateResponse)
+	rideID := estimateResponse["ride_id"].(string)
+
+	getETA := func(userID, rideID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/ride/"+rideID+"/eta", nil)
+		req.Header.Set("Authorization", "Bearer "+userID)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+
+	// No driver has accepted yet.
+	if w := getETA("rider-1", rideID); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 before a driver accepts, got %d. Body: %s", w.Code, w.Body.String())
+	}
+
+	requestReq, _ := http.NewRequest("PATCH", "/ride/request", bytes.NewBufferString(`{"ride_id":"`+rideID+`"}`))
+	requestReq.Header.Set("Content-Type", "application/json")
+	requestReq.Header.Set("Authorization", "Bearer rider-1")
+	engine.ServeHTTP(httptest.NewRecorder(), requestReq)
+	time.Sleep(100 * time.Millisecond)
+
+	acceptReq, _ := http.NewRequest("PATCH", "/ride/driver/accept", bytes.NewBufferString(`{"ride_id":"`+rideID+`","accept":true}`))
+	acceptReq.Header.Set("Content-Type", "application/json")
+	acceptReq.Header.Set("Authorization", "Bearer driver-1")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, acceptReq)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Accept failed: %d. Body: %s", w.Code, w.Body.String())
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	w = getETA("rider-1", rideID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+	}
+	var eta map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &eta)
+	if eta["driver_id"] != "driver-1" {
+		t.Errorf("Expected driver-1, got %v", eta["driver_id"])
+	}
+	if mins, _ := eta["eta_mins"].(float64); mins <= 0 {
+		t.Errorf("Expected a positive eta_mins, got %v", eta["eta_mins"])
+	}
+
+	if w := getETA("rider-2", rideID); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another rider, got %d", w.Code)
+	}
+	if w := getETA("rider-1", "no-such-ride"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown ride, got %d", w.Code)
+	}
+}
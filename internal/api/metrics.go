@@ -0,0 +1,110 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"uber/internal/api/middleware"
+	"uber/internal/geo"
+	"uber/internal/repository/memory"
+	"uber/internal/services"
+)
+
+// NewMetricsRegistry builds the Prometheus registry served at /metrics: Go
+// runtime and process metrics, plus gauges and counters read from the
+// services at scrape time. The HTTP request metrics are added by Setup once
+// the registry is passed to SetMetrics.
+//
+//	uber_spatial_index_drivers     drivers in the spatial index
+//	uber_matching_active           rides being matched right now
+//	uber_matching_attempts_total   matches started
+//	uber_matching_offers_total     ride offers sent to drivers
+//	uber_matching_results_total    finished matches, by outcome
+//	uber_lock_contention_total     lock acquisitions that found it held, by kind
+//
+// Go Learning Note — Func Metrics:
+// The services already keep these numbers for /debug/drivers/stats and
+// /debug/matching/stats. GaugeFunc and CounterFunc call a function on every
+// scrape instead of being updated on every event, so the services don't
+// import Prometheus and there is one source of truth for each number. A
+// registry of our own, rather than prometheus.DefaultRegisterer, keeps tests
+// from colliding when they build several servers in one process.
+func NewMetricsRegistry(
+	matchingService *services.MatchingService,
+	spatialIndex *geo.SpatialIndex,
+	lockManager *memory.LockManager,
+) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "uber_spatial_index_drivers",
+			Help: "Drivers currently held in the spatial index.",
+		}, func() float64 { return float64(spatialIndex.Count()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "uber_matching_active",
+			Help: "Rides currently being matched.",
+		}, func() float64 { return float64(matchingService.ActiveMatches()) }),
+		&matchingCollector{matchingService: matchingService},
+	)
+	for _, kind := range lockKinds {
+		kind := kind
+		reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "uber_lock_contention_total",
+			Help:        "Lock acquisitions that failed because the lock was held, by kind: driver (matches racing for a driver) or ride (each retry of an accept or cancel waiting for the ride).",
+			ConstLabels: prometheus.Labels{"kind": kind},
+		}, func() float64 { return float64(lockManager.Contended(kind)) }))
+	}
+	return reg
+}
+
+// lockKinds are the kinds of lock the services take, as
+// memory.LockManager.Contended counts them.
+var lockKinds = []string{"driver", "ride"}
+
+var (
+	matchingAttemptsDesc = prometheus.NewDesc("uber_matching_attempts_total",
+		"Matches started.", nil, nil)
+	matchingOffersDesc = prometheus.NewDesc("uber_matching_offers_total",
+		"Ride offers sent to drivers.", nil, nil)
+	matchingResultsDesc = prometheus.NewDesc("uber_matching_results_total",
+		"Finished matches by outcome: succeeded, no_driver, timeout or other (cancelled, shut down, internal error).",
+		[]string{"outcome"}, nil)
+)
+
+// matchingCollector exports MatchingService.Metrics. It is a Collector
+// rather than a set of CounterFuncs so every series in a scrape comes from
+// the same snapshot, and the outcomes always add up to the finished count.
+type matchingCollector struct {
+	matchingService *services.MatchingService
+}
+
+func (m *matchingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- matchingAttemptsDesc
+	ch <- matchingOffersDesc
+	ch <- matchingResultsDesc
+}
+
+func (m *matchingCollector) Collect(ch chan<- prometheus.Metric) {
+	snap := m.matchingService.Metrics()
+	other := snap.Finished - snap.Succeeded - snap.FailedNoDriver - snap.FailedTimeout
+
+	ch <- prometheus.MustNewConstMetric(matchingAttemptsDesc, prometheus.CounterValue, float64(snap.Attempted))
+	ch <- prometheus.MustNewConstMetric(matchingOffersDesc, prometheus.CounterValue, float64(snap.DriversOffered))
+	for outcome, n := range map[string]int64{
+		"succeeded": snap.Succeeded,
+		"no_driver": snap.FailedNoDriver,
+		"timeout":   snap.FailedTimeout,
+		"other":     other,
+	} {
+		ch <- prometheus.MustNewConstMetric(matchingResultsDesc, prometheus.CounterValue, float64(n), outcome)
+	}
+}
+
+// registerMetrics counts every request in reg and serves reg at /metrics.
+func registerMetrics(engine *gin.Engine, reg *prometheus.Registry) {
+	engine.Use(middleware.HTTPMetrics(reg))
+	engine.GET("/metrics", gin.WrapH(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+}
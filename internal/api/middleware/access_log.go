@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog writes one structured log record per request once it has been
+// handled: method, path, status, latency and, when known, the request ID and
+// the authenticated user. It replaces Gin's text Logger so every line can be
+// parsed by a log pipeline and joined with the service logs of the same
+// request on request_id. Install it after RequestID() so the ID is set; the
+// user is only known for routes behind MockAuth() or JWTAuth().
+//
+// Go Learning Note — log/slog:
+// log/slog (Go 1.21) is the standard library's structured logger. A record is
+// a message plus typed key/value attributes, and the Handler decides the
+// encoding: slog.NewJSONHandler writes one JSON object per line, while
+// slog.NewTextHandler writes key=value pairs. Attributes like slog.Int and
+// slog.Duration avoid the reflection the loosely typed ("key", value) form
+// needs.
+func AccessLog(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		// Read the path before c.Next: a handler may rewrite c.Request.
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if id := GetRequestID(c); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		if userID := c.GetString(UserIDKey); userID != "" {
+			attrs = append(attrs, slog.String("user_id", userID))
+		}
+		logger.LogAttrs(c.Request.Context(), slog.LevelInfo, "request", attrs...)
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"uber/internal/requestid"
+)
+
+func TestAccessLog_WritesJSONWithRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var out bytes.Buffer
+	engine := gin.New()
+	engine.Use(AccessLog(slog.New(slog.NewJSONHandler(&out, nil))), RequestID())
+
+	// The handler sees the same ID in the request's context.Context.
+	var fromContext string
+	engine.GET("/ride/:id", MockAuth(), func(c *gin.Context) {
+		fromContext = requestid.FromContext(c.Request.Context())
+		c.Status(http.StatusTeapot)
+	})
+
+	req, _ := http.NewRequest("GET", "/ride/ride-1", nil)
+	req.Header.Set("Authorization", "Bearer rider-1")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+
+	id := w.Header().Get(RequestIDHeader)
+	if id == "" {
+		t.Fatal("Expected a generated X-Request-ID header")
+	}
+	if fromContext != id {
+		t.Errorf("Expected request ID %q in the request context, got %q", id, fromContext)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON log line, got %q: %v", out.String(), err)
+	}
+	want := map[string]any{
+		"msg":        "request",
+		"method":     "GET",
+		"path":       "/ride/ride-1",
+		"status":     float64(http.StatusTeapot),
+		"request_id": id,
+		"user_id":    "rider-1",
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("Expected %s=%v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["latency"].(float64); !ok {
+		t.Errorf("Expected a numeric latency, got %v", entry["latency"])
+	}
+}
+
+func TestAccessLog_OmitsUnknownUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var out bytes.Buffer
+	engine := gin.New()
+	engine.Use(AccessLog(slog.New(slog.NewJSONHandler(&out, nil))))
+	engine.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON log line, got %q: %v", out.String(), err)
+	}
+	if _, ok := entry["user_id"]; ok {
+		t.Errorf("Expected no user_id for an unauthenticated route, got %v", entry["user_id"])
+	}
+	if _, ok := entry["request_id"]; ok {
+		t.Errorf("Expected no request_id without the RequestID middleware, got %v", entry["request_id"])
+	}
+}
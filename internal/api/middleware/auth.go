@@ -22,6 +22,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // Context keys for storing authenticated user data.
@@ -42,16 +43,16 @@ const (
 // MockAuth extracts user info from the Authorization header.
 // Format: "Bearer <user-id>" where user-id starts with "rider-" or "driver-".
 //
-// This is a simplified mock for the MVP. In production, you'd validate a real
-// JWT token using a library like "github.com/golang-jwt/jwt/v5", verify the
-// signature against a secret or public key, and extract claims from the token.
+// This is a simplified mock for development: anyone can claim to be anyone.
+// The router uses it only when no JWT secret is configured; otherwise JWTAuth
+// verifies a signed token instead.
 //
 // Go Learning Note — Returning Functions (Closures):
 // MockAuth() returns a gin.HandlerFunc — a function that returns a function.
 // This pattern is common for middleware that needs configuration. The outer
-// function (MockAuth) could accept parameters (like a JWT secret), and the
-// inner function (the closure) captures those parameters. Here no config is
-// needed, but the pattern is preserved for consistency with Gin's middleware API.
+// function can accept parameters (JWTAuth takes its secret this way), and the
+// inner function (the closure) captures those parameters. MockAuth needs no
+// config, but the pattern is preserved for consistency with Gin's middleware API.
 //
 // Go Learning Note — c.Abort():
 // c.Abort() prevents subsequent handlers in the chain from running. Without it,
@@ -59,22 +60,10 @@ const (
 // Always pair error responses with c.Abort() in middleware.
 func MockAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
-			c.Abort()
-			return
-		}
-
-		// strings.SplitN splits into at most 2 parts, handling tokens with spaces.
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization format"})
-			c.Abort()
+		userID, ok := bearerToken(c)
+		if !ok {
 			return
 		}
-
-		userID := parts[1]
 		var userType string
 
 		// Determine user type from the ID prefix — this mock approach avoids
@@ -96,8 +85,92 @@ func MockAuth() gin.HandlerFunc {
 	}
 }
 
+// bearerToken returns the token from a "Bearer <token>" Authorization header.
+// If the header is missing or malformed it answers 401, aborts the chain and
+// returns false.
+func bearerToken(c *gin.Context) (string, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
+		c.Abort()
+		return "", false
+	}
+
+	// strings.SplitN splits into at most 2 parts, handling tokens with spaces.
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization format"})
+		c.Abort()
+		return "", false
+	}
+	return parts[1], true
+}
+
+// Claims are what JWTAuth reads from a token: the standard registered claims,
+// of which "sub" is the user ID and "exp" is required, plus the user's role
+// ("rider" or "driver").
+type Claims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// JWTAuth authenticates requests with an HS256-signed JWT in the
+// "Authorization: Bearer <token>" header, replacing MockAuth wherever a
+// signing secret is configured (ServerConfig.JWTSecret). A token that is
+// badly signed, signed with any other algorithm, expired or missing "exp"
+// gets 401, as does one without a subject or a known role. On success it sets
+// the same context keys as MockAuth, so GetUserID, GetUserType and the role
+// middleware work unchanged.
+//
+// Go Learning Note — Pinning the Algorithm:
+// A JWT names its own signing algorithm in its header, so a verifier that
+// trusts the header can be talked into checking an "alg: none" token, or an
+// HMAC token keyed with a public key. jwt.WithValidMethods makes the parser
+// accept HS256 and nothing else, whatever the token claims.
+//
+// Go Learning Note — Embedded Structs:
+// Claims embeds jwt.RegisteredClaims, promoting its fields (Subject,
+// ExpiresAt, ...) and methods onto Claims. That is how Claims satisfies the
+// jwt.Claims interface the parser needs without writing any methods itself.
+func JWTAuth(secret []byte) gin.HandlerFunc {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	keyFunc := func(*jwt.Token) (interface{}, error) { return secret, nil }
+
+	return func(c *gin.Context) {
+		raw, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+
+		var claims Claims
+		if _, err := parser.ParseWithClaims(raw, &claims, keyFunc); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
+			c.Abort()
+			return
+		}
+		if claims.Subject == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token has no subject"})
+			c.Abort()
+			return
+		}
+		if claims.Role != UserTypeRider && claims.Role != UserTypeDriver {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token has no valid role"})
+			c.Abort()
+			return
+		}
+
+		c.Set(UserIDKey, claims.Subject)
+		c.Set(UserTypeKey, claims.Role)
+		c.Next()
+	}
+}
+
 // RequireRider is a role-based authorization middleware. It ensures the
-// authenticated user is a rider. Must be used after MockAuth() in the chain.
+// authenticated user is a rider. Must be used after MockAuth() or JWTAuth()
+// in the chain.
 func RequireRider() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userType, exists := c.Get(UserTypeKey)
@@ -123,14 +196,14 @@ func RequireDriver() gin.HandlerFunc {
 	}
 }
 
-// GetUserID retrieves the user ID previously set by MockAuth middleware.
+// GetUserID retrieves the user ID previously set by MockAuth or JWTAuth.
 //
 // Go Learning Note — Type Assertion:
 // c.Get() returns (interface{}, bool). The .(string) is a type assertion that
 // converts the interface{} to a concrete string. If the value isn't a string,
 // this will panic at runtime. The safer form is `val, ok := x.(string)` which
 // returns ok=false instead of panicking. Here the panic form is acceptable
-// because this function should only be called after MockAuth middleware
+// because this function should only be called after MockAuth or JWTAuth
 // guarantees the value exists and is a string.
 func GetUserID(c *gin.Context) string {
 	userID, _ := c.Get(UserIDKey)
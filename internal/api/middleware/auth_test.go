@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testSecret = []byte("test-secret")
+
+// signToken signs claims with HS256 under key.
+func signToken(t *testing.T, key []byte, claims Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("Signing failed: %v", err)
+	}
+	return token
+}
+
+// whoAmI serves JWTAuth in front of a handler echoing the caller's identity.
+func whoAmI(token string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/whoami", JWTAuth(testSecret), func(c *gin.Context) {
+		c.String(http.StatusOK, GetUserType(c)+":"+GetUserID(c))
+	})
+
+	req, _ := http.NewRequest("GET", "/whoami", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestJWTAuth(t *testing.T) {
+	inAnHour := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	anHourAgo := jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name: "valid",
+			token: signToken(t, testSecret, Claims{Role: UserTypeDriver,
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "d-42", ExpiresAt: inAnHour}}),
+			wantCode: http.StatusOK,
+			wantBody: "driver:d-42",
+		},
+		{
+			name: "expired",
+			token: signToken(t, testSecret, Claims{Role: UserTypeRider,
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "r-1", ExpiresAt: anHourAgo}}),
+			wantCode: http.StatusUnauthorized,
+			wantBody: "expired",
+		},
+		{
+			name: "no expiry",
+			token: signToken(t, testSecret, Claims{Role: UserTypeRider,
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "r-1"}}),
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong signature",
+			token: signToken(t, []byte("someone-else"), Claims{Role: UserTypeRider,
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "r-1", ExpiresAt: inAnHour}}),
+			wantCode: http.StatusUnauthorized,
+			wantBody: "signature",
+		},
+		{
+			name: "missing role",
+			token: signToken(t, testSecret, Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "r-1", ExpiresAt: inAnHour}}),
+			wantCode: http.StatusUnauthorized,
+			wantBody: "role",
+		},
+		{
+			name: "missing subject",
+			token: signToken(t, testSecret, Claims{Role: UserTypeRider,
+				RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: inAnHour}}),
+			wantCode: http.StatusUnauthorized,
+			wantBody: "subject",
+		},
+		{name: "garbage", token: "not-a-jwt", wantCode: http.StatusUnauthorized},
+		{name: "mock-style token", token: "rider-1", wantCode: http.StatusUnauthorized},
+		{name: "no header", token: "", wantCode: http.StatusUnauthorized, wantBody: "missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := whoAmI(tt.token)
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("Expected the body to mention %q, got %s", tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWTAuth_RejectsOtherAlgorithms(t *testing.T) {
+	// An unsigned token must never be accepted, whatever its claims.
+	claims := Claims{Role: UserTypeRider,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "r-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("Signing failed: %v", err)
+	}
+	if w := whoAmI(token); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an alg=none token, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit caps how many requests may run the wrapped handlers at
+// once. Requests beyond the limit are rejected immediately with 503 instead
+// of queueing, so a flood of location pings can't pile up behind the spatial
+// index write lock and starve matching reads. A limit <= 0 disables the gate.
+//
+// Go Learning Note — Buffered Channels as Semaphores:
+// A buffered channel of capacity N is the idiomatic Go counting semaphore:
+// sending acquires a slot, receiving releases it. Wrapping the send in a
+// select with a default case makes the acquire non-blocking — if all N slots
+// are taken, the default branch runs instead of waiting.
+func ConcurrencyLimit(limit int) gin.HandlerFunc {
+	if limit <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	slots := make(chan struct{}, limit)
+	return func(c *gin.Context) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			c.Next()
+		default:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server busy, retry later"})
+			c.Abort()
+		}
+	}
+}
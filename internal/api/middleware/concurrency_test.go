@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimit_RejectsExcess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const limit = 2
+	const total = 5
+
+	// Handlers block until released so every admitted request holds its slot
+	// while the excess requests arrive.
+	entered := make(chan struct{}, total)
+	release := make(chan struct{})
+
+	engine := gin.New()
+	engine.PATCH("/location/update", ConcurrencyLimit(limit), func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	codes := make(chan int, total)
+	var wg sync.WaitGroup
+	for i := 0; i < total; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("PATCH", "/location/update", nil)
+			w := httptest.NewRecorder()
+			engine.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+
+	// Wait until the limit is saturated, then let the rejected requests
+	// finish before releasing the admitted ones.
+	for i := 0; i < limit; i++ {
+		<-entered
+	}
+	for i := 0; i < total-limit; i++ {
+		if code := <-codes; code != http.StatusServiceUnavailable {
+			t.Errorf("Expected excess request to get 503, got %d", code)
+		}
+	}
+	close(release)
+	wg.Wait()
+	close(codes)
+
+	ok := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			ok++
+		}
+	}
+	if ok != limit {
+		t.Errorf("Expected %d admitted requests, got %d", limit, ok)
+	}
+}
+
+func TestConcurrencyLimit_ReleasesSlots(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	engine := gin.New()
+	engine.GET("/ping", ConcurrencyLimit(1), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	// Sequential requests must all succeed — each one frees its slot.
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+}
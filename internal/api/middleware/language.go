@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"uber/internal/i18n"
+)
+
+// LanguageKey is the context key holding the language negotiated for the
+// current request.
+const LanguageKey = "language"
+
+// Language negotiates the response language from the Accept-Language header
+// and records it for handlers, which read it back through GetLanguage.
+func Language() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(LanguageKey, i18n.Negotiate(c.GetHeader("Accept-Language")))
+		c.Next()
+	}
+}
+
+// GetLanguage returns the negotiated language for the current request, or
+// i18n.DefaultLanguage when the Language middleware is not installed.
+func GetLanguage(c *gin.Context) string {
+	if lang := c.GetString(LanguageKey); lang != "" {
+		return lang
+	}
+	return i18n.DefaultLanguage
+}
+
+// RememberLanguage passes the authenticated user's negotiated language to
+// remember whenever a request states one, so that later notifications — sent
+// outside any request — reach the user in the language their app asked for.
+// It must run after MockAuth. A nil remember disables it.
+func RememberLanguage(remember func(userID, lang string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if remember != nil && c.GetHeader("Accept-Language") != "" {
+			remember(GetUserID(c), GetLanguage(c))
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute labels requests that matched no route, so scanners probing
+// random paths add one series instead of one per path.
+const unmatchedRoute = "unmatched"
+
+// HTTPMetrics counts requests and observes their latency in reg, labelled by
+// method, route and status:
+//
+//	uber_http_requests_total{method, route, status}
+//	uber_http_request_duration_seconds{method, route}
+//
+// The route label is the registered pattern (/ride/:id), not the request
+// path (/ride/ride-123), which would create a series per ride. It panics if
+// the metrics are already registered in reg, so use it once per registry.
+//
+// Go Learning Note — Label Cardinality:
+// Every distinct combination of label values is a separate time series that
+// Prometheus stores and indexes. Labels must come from small, fixed sets:
+// c.FullPath() is one of the routes in routes.go, the status one of a few
+// dozen codes. User IDs, ride IDs or raw paths would grow without bound and
+// eventually overwhelm the Prometheus server.
+func HTTPMetrics(reg prometheus.Registerer) gin.HandlerFunc {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uber_http_requests_total",
+		Help: "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "uber_http_request_duration_seconds",
+		Help:    "HTTP request latency, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	reg.MustRegister(requests, duration)
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		duration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
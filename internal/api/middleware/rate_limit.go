@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// limiterIdleTimeout is how long a user's limiter is kept after their last
+// request, and how often idle limiters are swept out. A limiter left idle
+// this long has refilled its whole burst anyway (for any burst/rps under ten
+// minutes), so dropping it and starting afresh on the next request changes
+// nothing for the user.
+const limiterIdleTimeout = 10 * time.Minute
+
+// userLimiter is one user's token bucket and when they last used it.
+type userLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// userLimiters holds a token bucket per user ID. Sweeping happens on the
+// request path, at most once per limiterIdleTimeout, rather than in a
+// background goroutine, so a middleware instance never outlives its router.
+type userLimiters struct {
+	mu        sync.Mutex
+	rps       rate.Limit
+	burst     int
+	users     map[string]*userLimiter
+	lastSweep time.Time
+}
+
+// get returns userID's limiter, creating it on first use, and drops the
+// limiters of users idle for limiterIdleTimeout when a sweep is due.
+func (l *userLimiters) get(userID string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= limiterIdleTimeout {
+		for id, user := range l.users {
+			if now.Sub(user.lastSeen) >= limiterIdleTimeout {
+				delete(l.users, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	user, ok := l.users[userID]
+	if !ok {
+		user = &userLimiter{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.users[userID] = user
+	}
+	user.lastSeen = now
+	return user.limiter
+}
+
+// RateLimit throttles each authenticated user to rps requests per second on
+// average, with bursts of up to burst requests, using a token bucket per user
+// ID. A request over the limit gets 429 with a Retry-After header saying how
+// many seconds until it would be allowed. Users don't share buckets, so one
+// flooding client can't use up anyone else's allowance. rps <= 0 disables
+// the limit. Must be used after MockAuth() or JWTAuth() in the chain.
+//
+// Go Learning Note — Token Buckets (golang.org/x/time/rate):
+// A rate.Limiter holds up to burst tokens and refills at rps tokens per
+// second; each request spends one. Reserve takes a token now, or books one
+// for later and reports the wait as Delay. A request that would have to wait
+// is rejected rather than delayed, and its reservation is cancelled so the
+// booked token goes back into the bucket instead of being charged to a
+// request that never ran.
+func RateLimit(rps float64, burst int) gin.HandlerFunc {
+	if rps <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	limiters := &userLimiters{
+		rps:   rate.Limit(rps),
+		burst: max(burst, 1),
+		users: make(map[string]*userLimiter),
+	}
+	return func(c *gin.Context) {
+		now := time.Now()
+		reservation := limiters.get(GetUserID(c), now).ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded, retry later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func rateLimitedEngine(rps float64, burst int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.PATCH("/location/update", MockAuth(), RateLimit(rps, burst), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return engine
+}
+
+func ping(engine *gin.Engine, userID string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("PATCH", "/location/update", nil)
+	req.Header.Set("Authorization", "Bearer "+userID)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit_BurstThenReject(t *testing.T) {
+	// One request every two seconds, bursts of three.
+	engine := rateLimitedEngine(0.5, 3)
+
+	for i := 0; i < 3; i++ {
+		if w := ping(engine, "driver-1"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200 within the burst, got %d", i+1, w.Code)
+		}
+	}
+
+	w := ping(engine, "driver-1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 once the burst is spent, got %d", w.Code)
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 2 {
+		t.Errorf("Expected Retry-After of 1-2 seconds, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// A rejected request doesn't cost a token: the wait doesn't grow.
+	w = ping(engine, "driver-1")
+	if again := w.Header().Get("Retry-After"); again != strconv.Itoa(retryAfter) {
+		t.Errorf("Expected the same Retry-After after a rejection, got %q then %q", strconv.Itoa(retryAfter), again)
+	}
+}
+
+func TestRateLimit_UsersHaveSeparateBuckets(t *testing.T) {
+	engine := rateLimitedEngine(0.5, 1)
+
+	if w := ping(engine, "driver-1"); w.Code != http.StatusOK {
+		t.Fatalf("Expected driver-1's first request to pass, got %d", w.Code)
+	}
+	if w := ping(engine, "driver-1"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected driver-1's second request to be limited, got %d", w.Code)
+	}
+	if w := ping(engine, "driver-2"); w.Code != http.StatusOK {
+		t.Errorf("Expected driver-2 to be unaffected by driver-1, got %d", w.Code)
+	}
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	engine := rateLimitedEngine(0, 1)
+	for i := 0; i < 20; i++ {
+		if w := ping(engine, "driver-1"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected no limit, got %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestUserLimiters_EvictsIdleUsers(t *testing.T) {
+	limiters := &userLimiters{rps: 1, burst: 1, users: make(map[string]*userLimiter)}
+	start := time.Now()
+
+	limiters.get("driver-1", start)
+	limiters.get("driver-2", start.Add(limiterIdleTimeout/2))
+
+	// The next sweep finds driver-1 idle for the full timeout, driver-2 not.
+	limiters.get("driver-3", start.Add(limiterIdleTimeout))
+	if _, ok := limiters.users["driver-1"]; ok {
+		t.Error("Expected driver-1's idle limiter to be evicted")
+	}
+	if len(limiters.users) != 2 {
+		t.Errorf("Expected driver-2 and driver-3 to remain, got %d limiters", len(limiters.users))
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"uber/internal/requestid"
+)
+
+// RequestIDHeader carries the request ID in both directions: a client or
+// upstream proxy may supply one, and every response echoes the ID in use.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey is the context key holding the current request's ID.
+const RequestIDKey = "request_id"
+
+// maxRequestIDLength bounds a client-supplied ID; anything longer is replaced
+// rather than copied into every log line and response.
+const maxRequestIDLength = 128
+
+// RequestID gives every request an ID, reusing the caller's X-Request-ID when
+// it has a usable one, so an error a client reports can be traced to the
+// request that produced it. The ID is also stored in the request's
+// context.Context (see requestid.FromContext), so services handed
+// c.Request.Context() can include it in their logs.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.NewString()
+		}
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the current request's ID, or "" when the RequestID
+// middleware is not installed.
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
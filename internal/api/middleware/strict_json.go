@@ -0,0 +1,23 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// StrictJSONKey is the context key recording whether request bodies must be
+// decoded strictly (unknown fields rejected).
+const StrictJSONKey = "strict_json"
+
+// StrictJSON marks every request with the configured JSON decoding mode.
+// Handlers read it back through IsStrictJSON when binding request bodies, so
+// the mode is per-router rather than a process-wide switch.
+func StrictJSON(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(StrictJSONKey, enabled)
+		c.Next()
+	}
+}
+
+// IsStrictJSON reports whether the current request should reject unknown
+// JSON fields. It is false when the StrictJSON middleware is not installed.
+func IsStrictJSON(c *gin.Context) bool {
+	return c.GetBool(StrictJSONKey)
+}
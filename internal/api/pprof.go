@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pprofProfiles are the runtime profiles served by name under /debug/pprof/.
+var pprofProfiles = []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"}
+
+// registerPprof mounts the net/http/pprof handlers under group + "/pprof",
+// e.g. `go tool pprof http://localhost:8080/debug/pprof/profile?seconds=10`
+// for a CPU profile of the matching and spatial code under live load.
+//
+// Go Learning Note — net/http/pprof Without the Default Mux:
+// Importing net/http/pprof registers its handlers on http.DefaultServeMux as a
+// side effect. This server never serves the default mux (Gin's engine is the
+// only handler), so that registration is inert; the exported handler funcs
+// are mounted on the Gin router explicitly instead, which keeps them behind
+// the config flag. gin.WrapF and gin.WrapH adapt plain net/http handlers to
+// gin.HandlerFunc.
+func registerPprof(group *gin.RouterGroup) {
+	pp := group.Group("/pprof")
+	pp.GET("/", gin.WrapF(pprof.Index))
+	pp.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	pp.GET("/profile", gin.WrapF(pprof.Profile))
+	pp.GET("/symbol", gin.WrapF(pprof.Symbol))
+	pp.POST("/symbol", gin.WrapF(pprof.Symbol))
+	pp.GET("/trace", gin.WrapF(pprof.Trace))
+	for _, name := range pprofProfiles {
+		pp.GET("/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+}
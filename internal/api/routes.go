@@ -9,31 +9,58 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"uber/internal/api/handlers"
 	"uber/internal/api/middleware"
+	"uber/internal/config"
 )
 
 // Router holds references to all HTTP handlers and configures URL routing.
-// It acts as the composition root for the HTTP layer.
+// It acts as the composition root for the HTTP layer. The config supplies
+// route-level settings such as middleware limits.
 type Router struct {
+	config          *config.Config
 	rideHandler     *handlers.RideHandler
 	driverHandler   *handlers.DriverHandler
 	locationHandler *handlers.LocationHandler
+
+	// rememberLanguage stores a user's language preference for later
+	// notifications; nil until SetLanguagePreferences wires it.
+	rememberLanguage func(userID, lang string)
+
+	// metrics is served at /metrics and records every request; nil (no
+	// endpoint) until SetMetrics wires it.
+	metrics *prometheus.Registry
 }
 
 // NewRouter creates a Router with all required handler dependencies.
 func NewRouter(
+	cfg *config.Config,
 	rideHandler *handlers.RideHandler,
 	driverHandler *handlers.DriverHandler,
 	locationHandler *handlers.LocationHandler,
 ) *Router {
 	return &Router{
+		config:          cfg,
 		rideHandler:     rideHandler,
 		driverHandler:   driverHandler,
 		locationHandler: locationHandler,
 	}
 }
 
+// SetLanguagePreferences sets where the language an authenticated user asks
+// for (via Accept-Language) is remembered, typically
+// NotificationService.SetLanguage. Call it before Setup.
+func (r *Router) SetLanguagePreferences(remember func(userID, lang string)) {
+	r.rememberLanguage = remember
+}
+
+// SetMetrics serves reg at GET /metrics and records HTTP request counts and
+// latencies in it (see NewMetricsRegistry). Call it before Setup.
+func (r *Router) SetMetrics(reg *prometheus.Registry) {
+	r.metrics = reg
+}
+
 // Setup registers all routes and middleware on the Gin engine.
 //
 // Go Learning Note — Route Groups in Gin:
@@ -54,6 +81,26 @@ func NewRouter(
 // for ride state transitions since they modify specific fields, not the full
 // resource. POST is used for fare estimates since they create a new ride entity.
 func (r *Router) Setup(engine *gin.Engine) {
+	// Tag every request (including unmatched ones) with an ID that error
+	// bodies and the X-Request-ID response header can report.
+	engine.Use(middleware.RequestID())
+
+	// Prometheus metrics — unauthenticated, like /health, so the scraper
+	// needs no credentials. They reveal traffic and fleet size, so in
+	// production /metrics belongs behind network policy (reachable from the
+	// Prometheus server only), not on the public listener. Installed before
+	// any route so every route is counted.
+	if r.metrics != nil {
+		registerMetrics(engine, r.metrics)
+	}
+
+	// Unknown paths and wrong methods get the same JSON error body as every
+	// other failure instead of Gin's plain-text defaults. Middleware added
+	// with engine.Use also runs for these handlers.
+	engine.HandleMethodNotAllowed = true
+	engine.NoRoute(handlers.NoRoute)
+	engine.NoMethod(handlers.NoMethod)
+
 	// Health check endpoint — no authentication required.
 	// Load balancers and orchestrators (Kubernetes, ECS) call this to verify
 	// the server is running before routing traffic to it.
@@ -61,32 +108,77 @@ func (r *Router) Setup(engine *gin.Engine) {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
-	// Protected routes — all routes in this group require authentication.
+	// Readiness — no authentication either. Orchestrators use it to decide
+	// whether to send traffic, so it reports on the driver fleet, not just
+	// the process.
+	engine.GET("/ready", r.locationHandler.Ready)
+
+	// Record the JSON decoding mode for every request; handlers consult it
+	// when binding request bodies.
+	engine.Use(middleware.StrictJSON(r.config.Server.StrictJSON))
+
+	// Negotiate the response language once per request; error messages and
+	// remembered notification preferences both use it.
+	engine.Use(middleware.Language())
+
+	// Protected routes — all routes in this group require authentication:
+	// signed JWTs once a secret is configured, otherwise the development
+	// MockAuth.
+	auth := middleware.MockAuth()
+	if r.config.Server.JWTSecret != "" {
+		auth = middleware.JWTAuth([]byte(r.config.Server.JWTSecret))
+	}
 	api := engine.Group("/")
-	api.Use(middleware.MockAuth())
+	api.Use(auth, middleware.RememberLanguage(r.rememberLanguage))
 	{
 		// Rider endpoints — only authenticated riders can access these.
-		// Middleware is applied in order: MockAuth runs first (set by the
-		// parent group), then RequireRider checks the user type.
+		// Middleware is applied in order: authentication runs first (set by
+		// the parent group), then RequireRider checks the user type.
 		riderRoutes := api.Group("/ride")
 		riderRoutes.Use(middleware.RequireRider())
 		{
+			riderRoutes.GET("/availability", r.rideHandler.PreviewAvailability)
+			riderRoutes.GET("/active", r.rideHandler.GetActiveRide)
+			riderRoutes.GET("/history", r.rideHandler.GetRideHistory)
 			riderRoutes.POST("/fair-estimate", r.rideHandler.FareEstimate)
-			riderRoutes.PATCH("/request", r.rideHandler.RequestRide)
+			riderRoutes.POST("/repeat/:id", r.rideHandler.RepeatRide)
+			riderRoutes.PATCH("/request",
+				middleware.RateLimit(r.config.Server.RideRequestRateLimit, r.config.Server.RideRequestBurst),
+				r.rideHandler.RequestRide,
+			)
+			riderRoutes.PATCH("/:id/pickup", r.rideHandler.UpdatePickup)
+			riderRoutes.POST("/:id/cancel", r.rideHandler.CancelRide)
+			riderRoutes.POST("/:id/confirm", r.rideHandler.ConfirmCompletion)
+			riderRoutes.GET("/:id/eta", r.rideHandler.GetPickupETA)
 		}
 
 		// Driver endpoints — only authenticated drivers can access these.
 		driverRoutes := api.Group("/")
 		driverRoutes.Use(middleware.RequireDriver())
 		{
-			driverRoutes.PATCH("/location/update", r.locationHandler.UpdateLocation)
+			// Location pings are the highest-volume write path; the concurrency
+			// gate sheds load with 503s before it can starve matching reads.
+			// The rate limit goes first, so a driver flooding pings is turned
+			// away before taking a slot from everyone else.
+			driverRoutes.PATCH("/location/update",
+				middleware.RateLimit(r.config.Server.LocationUpdateRateLimit, r.config.Server.LocationUpdateBurst),
+				middleware.ConcurrencyLimit(r.config.Server.MaxConcurrentLocationUpdates),
+				r.locationHandler.UpdateLocation,
+			)
+			driverRoutes.PATCH("/ride/driver/ack", r.driverHandler.AcknowledgeOffer)
 			driverRoutes.PATCH("/ride/driver/accept", r.driverHandler.AcceptRide)
 			driverRoutes.PATCH("/ride/driver/update", r.driverHandler.UpdateRideStatus)
+			driverRoutes.GET("/driver/active", r.driverHandler.GetActiveRide)
+			driverRoutes.GET("/driver/active/fare", r.driverHandler.GetTripFare)
+			driverRoutes.PATCH("/driver/status", r.locationHandler.UpdateDriverStatus)
 		}
 
 		// Shared endpoints — both rider and driver can access.
-		// No additional role middleware is applied here; MockAuth alone suffices.
+		// No additional role middleware is applied here; authentication alone
+		// suffices.
 		api.GET("/ride/:id", r.rideHandler.GetRide)
+		api.GET("/ride/:id/ws", r.rideHandler.WatchRide)
+		api.GET("/ride/:id/events", r.rideHandler.StreamRideEvents)
 	}
 
 	// Debug endpoints — no authentication, only for testing and development.
@@ -94,5 +186,21 @@ func (r *Router) Setup(engine *gin.Engine) {
 	debug := engine.Group("/debug")
 	{
 		debug.GET("/location/:driver_id", r.locationHandler.GetLocation)
+		debug.GET("/location/:driver_id/history", r.locationHandler.GetLocationHistory)
+		debug.GET("/drivers/stats", r.locationHandler.GetDriverStats)
+		debug.GET("/matching/stats", r.rideHandler.MatchingStats)
+		debug.GET("/rides/:id/search", r.rideHandler.SearchAreas)
+
+		// Reindexing changes state for every rider and driver, not just what
+		// an operator gets to see, so it is opt-in too.
+		if r.config.Server.EnableSpatialReindex {
+			debug.POST("/spatial/reindex", r.locationHandler.ReindexSpatial)
+		}
+
+		// Profiling endpoints expose stack traces and command-line flags, so
+		// they are only mounted when explicitly enabled.
+		if r.config.Server.EnablePprof {
+			registerPprof(debug)
+		}
 	}
 }
@@ -22,11 +22,52 @@ import (
 // Go doesn't have classes or inheritance. Instead, you compose structs by
 // embedding or nesting them. Here Config "has a" ServerConfig, MatchingConfig,
 // etc. This is composition over inheritance — a core Go design principle.
+//
+// MatchingByCategory tunes matching per ride category ("standard", "premium",
+// "delivery"); see MatchingFor for how it falls back to Matching.
 type Config struct {
-	Server   ServerConfig
-	Matching MatchingConfig
-	Geo      GeoConfig
-	Pricing  PricingConfig
+	Server             ServerConfig
+	Matching           MatchingConfig
+	MatchingByCategory map[string]MatchingConfig
+	Geo                GeoConfig
+	Pricing            PricingConfig
+	Ride               RideConfig
+	Cancellation       CancellationConfig
+}
+
+// MatchingFor returns the matching settings for a ride category. Fields left
+// zero in the category's entry inherit from Matching, so an override only
+// needs to name what differs; unknown or empty categories get Matching as-is.
+// Only the search radii and timeouts can be overridden — the boolean
+// policies, batch size, panic recovery, reliability weighting, matching
+// strategy and radius expansion factor are process-wide. Categories are
+// plain strings for the same reason as RideConfig's statuses.
+//
+// Go Learning Note — Returning Structs by Value:
+// MatchingConfig is returned by value, so the caller gets its own merged copy
+// and can't accidentally modify the shared defaults through it.
+func (c *Config) MatchingFor(category string) MatchingConfig {
+	merged := c.Matching
+	override, ok := c.MatchingByCategory[category]
+	if !ok {
+		return merged
+	}
+	if override.DriverResponseTimeout > 0 {
+		merged.DriverResponseTimeout = override.DriverResponseTimeout
+	}
+	if override.OfferAckTimeout > 0 {
+		merged.OfferAckTimeout = override.OfferAckTimeout
+	}
+	if override.TotalMatchingTimeout > 0 {
+		merged.TotalMatchingTimeout = override.TotalMatchingTimeout
+	}
+	if override.SearchRadiusKm > 0 {
+		merged.SearchRadiusKm = override.SearchRadiusKm
+	}
+	if override.MaxSearchRadiusKm > 0 {
+		merged.MaxSearchRadiusKm = override.MaxSearchRadiusKm
+	}
+	return merged
 }
 
 // ServerConfig holds HTTP server settings.
@@ -36,35 +77,332 @@ type Config struct {
 // timeouts and intervals. This prevents unit confusion — you write
 // "10 * time.Second" which is self-documenting, rather than guessing whether
 // "10" means seconds, milliseconds, or something else.
+//
+// MaxConcurrentLocationUpdates bounds how many driver location pings are
+// processed at once per instance; excess pings get 503. 0 means unlimited.
+//
+// StrictJSON rejects request bodies containing fields the endpoint doesn't
+// know, naming the offending field, instead of silently ignoring them.
+//
+// EnablePprof mounts the net/http/pprof profiling endpoints under
+// /debug/pprof. Leave it off outside development: profiles reveal internals.
+//
+// EnableSpatialReindex mounts POST /debug/spatial/reindex, which rebuilds the
+// spatial index at a new geohash precision. Like the other debug endpoints it
+// has no authentication, and a rebuild stalls every search and location ping
+// while it runs, so it is off unless asked for.
+//
+// ReadyRequiresDriver keeps GET /ready answering 503 until at least one driver
+// has sent a location since startup. On a cold start every ride request would
+// fail for lack of drivers, so a load balancer polling /ready holds traffic
+// back until the instance has warmed up.
+//
+// ShutdownTimeout and DrainTimeout bound a graceful shutdown on SIGINT or
+// SIGTERM, one stage each: requests in progress get ShutdownTimeout to finish
+// before their connections are closed, then rides still being matched get
+// DrainTimeout before the remaining matches are stopped. A slow request can't
+// eat into the matches' time. Together the defaults fit inside the 30 seconds
+// Kubernetes allows a pod between SIGTERM and SIGKILL.
+//
+// LocationUpdateRateLimit and RideRequestRateLimit throttle each driver's
+// location pings and each rider's ride requests to that many per second on
+// average, allowing bursts of LocationUpdateBurst and RideRequestBurst; a
+// user over their limit gets 429. A rate of 0 disables the limit. Unlike
+// MaxConcurrentLocationUpdates, which protects the server as a whole, these
+// stop one misbehaving app from hogging it.
+//
+// JWTSecret is the HS256 key that bearer tokens must be signed with; tokens
+// carry the user ID as "sub" and "rider" or "driver" as "role". Left empty,
+// the server falls back to the development MockAuth, which trusts any
+// "Bearer rider-…" or "Bearer driver-…" token — never run it that way in
+// production.
 type ServerConfig struct {
-	Port         string
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
+	Port                         string
+	ReadTimeout                  time.Duration
+	WriteTimeout                 time.Duration
+	MaxConcurrentLocationUpdates int
+	StrictJSON                   bool
+	EnablePprof                  bool
+	EnableSpatialReindex         bool
+	ReadyRequiresDriver          bool
+	ShutdownTimeout              time.Duration
+	DrainTimeout                 time.Duration
+	JWTSecret                    string
+	LocationUpdateRateLimit      float64
+	LocationUpdateBurst          int
+	RideRequestRateLimit         float64
+	RideRequestBurst             int
 }
 
 // MatchingConfig controls the async ride-driver matching engine.
+//
+// The per-driver wait is split into two phases: OfferAckTimeout bounds how
+// long the driver app has to confirm it received the offer (network delivery),
+// and DriverResponseTimeout is the human decision window that starts once the
+// offer is acknowledged. Drivers whose phone never got the push are skipped
+// after the short ack window instead of burning the full decision window.
+// OfferAckTimeout is 0 by default, which disables the ack phase: driver apps
+// that never send PATCH /ride/driver/ack would otherwise be skipped after the
+// ack window on every offer. Turn it on once the apps acknowledge offers.
+//
+// DeclineCountsAsTimeout selects the fairness rule for declines: when false
+// (the default) a decline is recorded as a plain decline and does not hurt the
+// driver's timeout count; when true, markets that want to discourage declines
+// treat them exactly like a missed offer.
+//
+// ReliabilityWeight orders candidates by distance scaled up for unreliable
+// drivers: a driver is ranked as if they were (1 + weight*(1-score)) times
+// farther away, where score is their reliability from 0 to 1. 0 keeps plain
+// nearest-first ordering.
+//
+// PrecheckDrivers makes StartMatching look for at least one available driver
+// in range before the ride enters Matching; with none, the ride fails at once
+// without starting a matching goroutine.
+//
+// Location freshness: a driver whose last ping is older than
+// StaleLocationAfter may have moved, so they are ranked as if
+// StalePenaltyKmPerMin farther away for every minute past it (0.5 km/min is
+// average urban speed). Past MaxLocationAge the location is not trusted at
+// all and the driver is left out of the search. Neither takes the driver
+// offline. 0 disables either rule.
+//
+// MaxDriversContacted stops a match after that many offers, even with time
+// and candidates left: past a handful of declines the ride is unlikely to be
+// taken, and every further offer is a push notification some driver has to
+// dismiss. Candidates skipped without an offer (busy, locked, outside their
+// preferred zone) don't count.
+//
+// MatchingStrategy picks how offers go out. MatchingStrategySequential offers
+// the ride to one driver at a time, nearest first. MatchingStrategyBroadcast
+// offers it to the nearest BroadcastSize drivers at once and gives it to the
+// first to accept, so one slow driver can't burn most of the total timeout;
+// if the whole batch declines or times out, the next batch is tried. An
+// unknown strategy is treated as sequential.
+//
+// Expanding search: when every candidate within SearchRadiusKm has declined,
+// timed out or been skipped, the search is widened to RadiusExpansionFactor
+// times the radius, then again, up to MaxSearchRadiusKm, looking for drivers
+// not yet offered the ride. TotalMatchingTimeout still bounds the whole match.
+// A MaxSearchRadiusKm no larger than SearchRadiusKm (the default 0) disables
+// it; a factor of 1 or less jumps straight to MaxSearchRadiusKm.
+//
+// OfferDemandContext adds how busy the pickup area is to each ride offer: the
+// surge multiplier and the number of pending requests in the pickup's demand
+// cell. Drivers can weigh an offer against what else is likely nearby.
+//
+// ReofferDecliners is for markets where a decline is soft ("not right now"
+// rather than "never"). Once every other candidate has been tried, a driver
+// who declined earlier in the same match gets one more offer, provided they
+// are still available and in range and at least ReofferCooldown has passed
+// since they declined. The match waits out the cooldown if nobody else is
+// left, within TotalMatchingTimeout. It applies to the sequential strategy.
 type MatchingConfig struct {
-	DriverResponseTimeout time.Duration // How long to wait for one driver to respond
-	TotalMatchingTimeout  time.Duration // Max total time to find any driver
-	SearchRadiusKm        float64       // Geospatial search radius in kilometers
+	DriverResponseTimeout  time.Duration // How long to wait for one driver to respond
+	OfferAckTimeout        time.Duration // How long to wait for the driver app to acknowledge an offer
+	TotalMatchingTimeout   time.Duration // Max total time to find any driver
+	SearchRadiusKm         float64       // Geospatial search radius in kilometers
+	DeclineCountsAsTimeout bool          // Penalize declines like timeouts
+	AvailabilityBatchSize  int           // Drivers per bulk availability lookup (0 = all at once)
+	RecoverPanics          bool          // Recover matching goroutine panics instead of crashing
+	ReliabilityWeight      float64       // How much unreliability pushes a driver down the order
+	PrecheckDrivers        bool          // Fail fast when no driver is in range
+	AvailabilityPreviewMax int           // Max driver ETAs in the availability preview (0 = all)
+	StaleLocationAfter     time.Duration // Location age at which ranking penalties start
+	StalePenaltyKmPerMin   float64       // Distance penalty per minute past StaleLocationAfter
+	MaxLocationAge         time.Duration // Location age beyond which drivers are skipped
+	MaxDriversContacted    int           // Offers per match before giving up (0 = no cap)
+	MatchingStrategy       string        // MatchingStrategySequential or MatchingStrategyBroadcast
+	BroadcastSize          int           // Drivers offered the ride at once by the broadcast strategy
+	OfferDemandContext     bool          // Include surge and pending demand at the pickup in offers
+	RadiusExpansionFactor  float64       // Multiplier applied to the radius per search expansion
+	MaxSearchRadiusKm      float64       // Widest radius the search expands to (0 = no expansion)
+	ReofferDecliners       bool          // Give earlier decliners a second offer once others are exhausted
+	ReofferCooldown        time.Duration // Minimum time between a decline and its re-offer
 }
 
+// Matching strategies for MatchingConfig.MatchingStrategy.
+const (
+	MatchingStrategySequential = "sequential"
+	MatchingStrategyBroadcast  = "broadcast"
+)
+
 // GeoConfig controls geohash encoding precision. Precision 6 ≈ 1.2 km cells,
 // precision 7 ≈ 150 m cells. Higher precision means smaller cells and more
 // accurate proximity queries, but requires scanning more neighboring cells.
+// Setting GeohashPrecision to 0 derives it from Matching.SearchRadiusKm at
+// startup (geo.IndexPrecision), so changing the radius also changes the cells.
+//
+// Barriers describe rivers, bays and similar divides for markets where the
+// straight-line distance to a driver on the far bank badly understates the
+// drive. See BarrierConfig.
+//
+// IndexCoalesceWindow thins out spatial index writes under heavy ping traffic:
+// a ping arriving within this long of the driver's last index write, and
+// still in the same geohash cell, is recorded in location history but not
+// written to the index. The indexed position then lags by at most one cell's
+// worth of movement and one window of time. 0 writes every ping.
+//
+// LocationHistorySize is how many past pings are kept per driver for trip
+// reconstruction; the oldest are dropped beyond it, so memory per driver is
+// bounded. It also bounds how long a trip TripDistanceKm can measure from
+// pings.
+//
+// LocationTTL is how long a driver's location is kept without a new ping
+// before a background sweep drops it from the spatial index and location
+// repository, for driver apps that crash without going offline. It should be
+// longer than Matching.MaxLocationAge, past which matching already ignores
+// the location. 0 disables the sweep.
 type GeoConfig struct {
-	GeohashPrecision int
+	GeohashPrecision    int // 0 = derive from Matching.SearchRadiusKm
+	Barriers            []BarrierConfig
+	IndexCoalesceWindow time.Duration
+	LocationHistorySize int
+	LocationTTL         time.Duration
+}
+
+// BarrierConfig splits a market into two sides by geohash prefix. A driver on
+// the opposite side from the rider is ranked as if DetourKm farther away, and
+// is dropped from the search if that puts them out of range — or always, when
+// Exclude is set (e.g., a bridge closed to ride-hail pickups).
+type BarrierConfig struct {
+	Name     string
+	SideA    []string // Geohash prefixes on one side
+	SideB    []string // Geohash prefixes on the other side
+	DetourKm float64  // Extra distance to cross, added to straight-line distance
+	Exclude  bool     // Never match across this barrier
 }
 
 // PricingConfig defines the fare calculation parameters.
 // Fare = (BaseFare + DistanceKm*PerKmRate + DurationMins*PerMinuteRate) * SurgeMultiplier
 // Result is clamped to at least MinimumFare.
+// Amounts are in major units (2.50 = $2.50); the PricingCalculator turns them
+// into exact cents (utils.Money) before any fare arithmetic.
+//
+// CurrencyCode is the ISO 4217 code all rates are expressed in; it also
+// selects the rounding rule for fare output (e.g., JPY has no decimals).
+//
+// SurgePriceMax caps the surge multiplier used for billing. SurgeDisplayMax
+// separately caps the multiplier shown to riders (0 = same as SurgePriceMax),
+// letting operators soften how surge looks without changing what is charged.
+//
+// FareLockWindow is how long an estimated fare is honored. Requesting within
+// the window keeps the quoted price regardless of surge; after it, the fare is
+// re-quoted and the rider must confirm again if it changed (0 = no lock).
+//
+// EstimateTTL is how long an estimate can be requested at all. Past it the
+// quote is gone: requesting fails and the rider has to ask for a new
+// estimate, rather than being re-quoted as after FareLockWindow. It should be
+// longer than FareLockWindow (0 = estimates never expire).
+//
+// ShortTripWarningKm flags suspiciously short trips: estimates below this
+// distance still succeed but carry a warning, since they are often the result
+// of a mis-dropped pin rather than a real trip.
+//
+// PerWaitMinuteRate bills the time a driver waits at the pickup for the
+// rider, from arriving (picking_up) to the trip starting, once the wait runs
+// past FreeWaitTime. It applies to fares priced from the measured trip and is
+// not surged (0 = waiting is free).
+//
+// Tiers prices each vehicle tier ("economy", "comfort", "xl") with its own
+// rates; see ForTier for how a tier falls back to the rates above.
 type PricingConfig struct {
+	BaseFare           float64
+	PerKmRate          float64
+	PerMinuteRate      float64
+	MinimumFare        float64
+	SurgePriceMax      float64
+	SurgeDisplayMax    float64
+	FareLockWindow     time.Duration
+	EstimateTTL        time.Duration
+	ShortTripWarningKm float64
+	CurrencyCode       string
+	PerWaitMinuteRate  float64
+	FreeWaitTime       time.Duration
+	Tiers              map[string]TierPricing
+}
+
+// TierPricing overrides the fare rates for one vehicle tier. A zero field
+// keeps the PricingConfig rate.
+type TierPricing struct {
 	BaseFare      float64
 	PerKmRate     float64
 	PerMinuteRate float64
 	MinimumFare   float64
-	SurgePriceMax float64
+}
+
+// ForTier returns the pricing for a vehicle tier: the tier's non-zero rates
+// over the top-level ones. Surge caps, lock windows and currency are shared
+// by every tier. Unknown or empty tiers get the top-level rates, which are
+// the economy prices. Like MatchingFor, tiers are plain strings and the
+// result is a copy.
+func (p PricingConfig) ForTier(tier string) PricingConfig {
+	merged := p
+	override, ok := p.Tiers[tier]
+	if !ok {
+		return merged
+	}
+	if override.BaseFare > 0 {
+		merged.BaseFare = override.BaseFare
+	}
+	if override.PerKmRate > 0 {
+		merged.PerKmRate = override.PerKmRate
+	}
+	if override.PerMinuteRate > 0 {
+		merged.PerMinuteRate = override.PerMinuteRate
+	}
+	if override.MinimumFare > 0 {
+		merged.MinimumFare = override.MinimumFare
+	}
+	return merged
+}
+
+// RideConfig controls the ride lifecycle state machine.
+//
+// TransitionOverrides adds extra allowed transitions on top of the built-in
+// state machine, keyed by source status (e.g., "accepted": {"in_progress"}
+// lets a market skip PickingUp). Statuses are plain strings so this package
+// stays free of domain imports; they are validated when applied at startup.
+//
+// RiderCancelWhileMatching lets a rider cancel a ride that is still Requested
+// or Matching, which also stops its matching loop. Turning it off makes the
+// rider wait for a driver to accept (or for matching to fail) first. Riders
+// can always cancel once a driver is assigned.
+//
+// RiderConfirmsCompletion guards against a driver ending a trip early: when
+// the driver marks the ride completed it waits in PendingConfirmation, with
+// the fare not yet final, until the rider confirms. A rider who never
+// responds is assumed to agree, and the ride completes on its own after
+// ConfirmationTimeout. The driver is free for new rides either way.
+//
+// ArrivingSoonThreshold tells the rider their driver is almost there once the
+// driver's estimated time to the pickup drops below it while picking them up.
+// It fires once per ride however the estimate moves afterwards. 0 disables it.
+//
+// MaxRouteDistanceKm rejects fare estimates and pickup changes for trips
+// longer than this, measured through every stop, so a client can't create
+// rides spanning a continent. MaxWaypoints caps how many intermediate stops a
+// fare estimate may list. 0 means no cap for either.
+type RideConfig struct {
+	TransitionOverrides      map[string][]string
+	RiderCancelWhileMatching bool
+	RiderConfirmsCompletion  bool
+	ConfirmationTimeout      time.Duration
+	MaxRouteDistanceKm       float64
+	MaxWaypoints             int
+	ArrivingSoonThreshold    time.Duration
+}
+
+// CancellationConfig sets what a rider pays for cancelling a ride. Cancelling
+// before any driver has accepted is always free. Once one has, the rider has
+// FreeCancelWindow from the acceptance to change their mind for free; after
+// that they pay Fee (in major units of Pricing.CurrencyCode) to compensate the
+// driver already on their way. A Fee of 0 turns cancellation fees off.
+//
+// Drivers cancelling an accepted ride are never charged: their ride goes back
+// into matching instead (see RideService.UpdateRideStatus).
+type CancellationConfig struct {
+	FreeCancelWindow time.Duration
+	Fee              float64
 }
 
 // NewDefaultConfig returns a Config populated with sensible defaults.
@@ -78,24 +416,85 @@ type PricingConfig struct {
 func NewDefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port:         ":8080",
-			ReadTimeout:  10 * time.Second,
-			WriteTimeout: 10 * time.Second,
+			Port:                         ":8080",
+			ReadTimeout:                  10 * time.Second,
+			WriteTimeout:                 10 * time.Second,
+			MaxConcurrentLocationUpdates: 256,
+			StrictJSON:                   false,
+			EnablePprof:                  false,
+			EnableSpatialReindex:         false,
+			ReadyRequiresDriver:          false,
+			ShutdownTimeout:              10 * time.Second,
+			DrainTimeout:                 15 * time.Second,
+			JWTSecret:                    "",
+			LocationUpdateRateLimit:      1,
+			LocationUpdateBurst:          10,
+			RideRequestRateLimit:         0.1,
+			RideRequestBurst:             5,
 		},
 		Matching: MatchingConfig{
-			DriverResponseTimeout: 10 * time.Second,
-			TotalMatchingTimeout:  60 * time.Second,
-			SearchRadiusKm:        5.0,
+			DriverResponseTimeout:  10 * time.Second,
+			OfferAckTimeout:        0,
+			TotalMatchingTimeout:   60 * time.Second,
+			SearchRadiusKm:         5.0,
+			AvailabilityBatchSize:  100,
+			RecoverPanics:          true,
+			ReliabilityWeight:      1.0,
+			PrecheckDrivers:        false,
+			AvailabilityPreviewMax: 3,
+			StaleLocationAfter:     30 * time.Second,
+			StalePenaltyKmPerMin:   0.5,
+			MaxLocationAge:         5 * time.Minute,
+			MaxDriversContacted:    10,
+			MatchingStrategy:       MatchingStrategySequential,
+			BroadcastSize:          3,
+			OfferDemandContext:     false,
+			RadiusExpansionFactor:  2.0,
+			MaxSearchRadiusKm:      0,
+			ReofferDecliners:       false,
+			ReofferCooldown:        15 * time.Second,
+		},
+		// Premium riders accept a longer wait for a nicer car, so search
+		// wider; deliveries aren't time-critical, so keep looking longer.
+		MatchingByCategory: map[string]MatchingConfig{
+			"premium":  {SearchRadiusKm: 8.0},
+			"delivery": {TotalMatchingTimeout: 120 * time.Second},
 		},
 		Geo: GeoConfig{
-			GeohashPrecision: 6,
+			GeohashPrecision:    6,
+			LocationHistorySize: 500,
+			LocationTTL:         10 * time.Minute,
 		},
 		Pricing: PricingConfig{
-			BaseFare:      2.50,
-			PerKmRate:     1.50,
-			PerMinuteRate: 0.25,
-			MinimumFare:   5.00,
-			SurgePriceMax: 3.0,
+			BaseFare:           2.50,
+			PerKmRate:          1.50,
+			PerMinuteRate:      0.25,
+			MinimumFare:        5.00,
+			SurgePriceMax:      3.0,
+			SurgeDisplayMax:    0,
+			FareLockWindow:     2 * time.Minute,
+			EstimateTTL:        15 * time.Minute,
+			ShortTripWarningKm: 0.1,
+			CurrencyCode:       "USD",
+			PerWaitMinuteRate:  0.30,
+			FreeWaitTime:       2 * time.Minute,
+			// Comfort is a newer, roomier car; XL seats six.
+			Tiers: map[string]TierPricing{
+				"comfort": {BaseFare: 3.50, PerKmRate: 2.00, PerMinuteRate: 0.35, MinimumFare: 8.00},
+				"xl":      {BaseFare: 4.00, PerKmRate: 2.50, PerMinuteRate: 0.45, MinimumFare: 10.00},
+			},
+		},
+		Ride: RideConfig{
+			RiderCancelWhileMatching: true,
+			RiderConfirmsCompletion:  false,
+			ConfirmationTimeout:      10 * time.Minute,
+			MaxRouteDistanceKm:       300,
+			MaxWaypoints:             3,
+			ArrivingSoonThreshold:    2 * time.Minute,
+		},
+		Cancellation: CancellationConfig{
+			FreeCancelWindow: 2 * time.Minute,
+			Fee:              5.00,
 		},
 	}
 }
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchingFor_FallsBackToDefaults(t *testing.T) {
+	cfg := NewDefaultConfig()
+
+	for _, category := range []string{"", "standard", "unknown"} {
+		if got := cfg.MatchingFor(category); got != cfg.Matching {
+			t.Errorf("MatchingFor(%q) = %+v, expected the default %+v", category, got, cfg.Matching)
+		}
+	}
+}
+
+func TestMatchingFor_MergesOverrides(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.MatchingByCategory["premium"] = MatchingConfig{
+		SearchRadiusKm:        8.0,
+		DriverResponseTimeout: 20 * time.Second,
+	}
+
+	got := cfg.MatchingFor("premium")
+
+	if got.SearchRadiusKm != 8.0 {
+		t.Errorf("Expected overridden radius 8.0, got %v", got.SearchRadiusKm)
+	}
+	if got.DriverResponseTimeout != 20*time.Second {
+		t.Errorf("Expected overridden response timeout 20s, got %v", got.DriverResponseTimeout)
+	}
+	if got.TotalMatchingTimeout != cfg.Matching.TotalMatchingTimeout {
+		t.Errorf("Expected inherited total timeout %v, got %v", cfg.Matching.TotalMatchingTimeout, got.TotalMatchingTimeout)
+	}
+	if got.RecoverPanics != cfg.Matching.RecoverPanics {
+		t.Error("Expected process-wide settings to come from Matching")
+	}
+}
+
+func TestPricingForTier(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Pricing.Tiers["comfort"] = TierPricing{BaseFare: 4.0, PerKmRate: 2.0}
+
+	for _, tier := range []string{"", "economy", "unknown"} {
+		if got := cfg.Pricing.ForTier(tier); got.BaseFare != cfg.Pricing.BaseFare || got.PerKmRate != cfg.Pricing.PerKmRate {
+			t.Errorf("ForTier(%q) = %+v, expected the top-level rates", tier, got)
+		}
+	}
+
+	got := cfg.Pricing.ForTier("comfort")
+	if got.BaseFare != 4.0 || got.PerKmRate != 2.0 {
+		t.Errorf("Expected overridden comfort rates 4.0 + 2.0/km, got %v + %v/km", got.BaseFare, got.PerKmRate)
+	}
+	if got.PerMinuteRate != cfg.Pricing.PerMinuteRate || got.MinimumFare != cfg.Pricing.MinimumFare {
+		t.Errorf("Expected unset comfort rates to be inherited, got %+v", got)
+	}
+	if got.SurgePriceMax != cfg.Pricing.SurgePriceMax || got.CurrencyCode != cfg.Pricing.CurrencyCode {
+		t.Error("Expected surge caps and currency to be shared by every tier")
+	}
+}
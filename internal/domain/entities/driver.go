@@ -10,7 +10,10 @@
 // internal implementation details.
 package entities
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // DriverStatus is a typed string enum representing the driver's current state.
 //
@@ -45,6 +48,58 @@ type Driver struct {
 	VehicleID string       `json:"vehicle_id"`
 	CreatedAt time.Time    `json:"created_at"`
 	UpdatedAt time.Time    `json:"updated_at"`
+
+	// PreferredZone, when set, limits offers to rides whose destination lies
+	// inside the zone (e.g., a driver heading home at the end of a shift).
+	PreferredZone *DestinationZone `json:"preferred_zone,omitempty"`
+
+	// VehicleTier is the class of the driver's car, which decides the rides
+	// they are offered. Empty means economy.
+	VehicleTier VehicleTier `json:"vehicle_tier,omitempty"`
+}
+
+// BoundingBox is a latitude/longitude rectangle, inclusive on every edge.
+type BoundingBox struct {
+	MinLat  float64 `json:"min_lat"`
+	MinLong float64 `json:"min_long"`
+	MaxLat  float64 `json:"max_lat"`
+	MaxLong float64 `json:"max_long"`
+}
+
+// Contains reports whether loc lies inside the box.
+func (b BoundingBox) Contains(loc Location) bool {
+	return loc.Latitude >= b.MinLat && loc.Latitude <= b.MaxLat &&
+		loc.Longitude >= b.MinLong && loc.Longitude <= b.MaxLong
+}
+
+// DestinationZone describes where a driver is willing to drop riders off,
+// either as geohash prefixes (any cell starting with one of them) or as a
+// bounding box. A destination matching either form is inside the zone; a
+// zone with neither set places no restriction.
+//
+// Go Learning Note — Keeping Entities Dependency-Free:
+// Matching a geohash prefix needs the destination's geohash, but entities
+// must not import the geo package (geo already imports entities, and Go
+// forbids import cycles). So Contains takes the pre-computed geohash as an
+// argument and leaves the encoding to the caller.
+type DestinationZone struct {
+	GeohashPrefixes []string     `json:"geohash_prefixes,omitempty"`
+	Bounds          *BoundingBox `json:"bounds,omitempty"`
+}
+
+// Contains reports whether a destination at loc, whose geohash is
+// destGeohash, falls inside the zone. destGeohash should be encoded at least
+// as precisely as the longest prefix.
+func (z *DestinationZone) Contains(loc Location, destGeohash string) bool {
+	if len(z.GeohashPrefixes) == 0 && z.Bounds == nil {
+		return true
+	}
+	for _, prefix := range z.GeohashPrefixes {
+		if strings.HasPrefix(destGeohash, prefix) {
+			return true
+		}
+	}
+	return z.Bounds != nil && z.Bounds.Contains(loc)
 }
 
 // NewDriver creates a Driver with initial status set to Offline.
@@ -74,6 +129,28 @@ func (d *Driver) IsAvailable() bool {
 	return d.Status == DriverStatusAvailable
 }
 
+// AcceptsDestination reports whether the driver is willing to take a ride
+// ending at dest. Drivers without a preferred zone accept any destination.
+func (d *Driver) AcceptsDestination(dest Location, destGeohash string) bool {
+	if d.PreferredZone == nil {
+		return true
+	}
+	return d.PreferredZone.Contains(dest, destGeohash)
+}
+
+// ServesTier reports whether the driver's car is of the given tier. A driver
+// with no tier set drives an economy car.
+func (d *Driver) ServesTier(tier VehicleTier) bool {
+	own := d.VehicleTier
+	if own == "" {
+		own = VehicleTierEconomy
+	}
+	if tier == "" {
+		tier = VehicleTierEconomy
+	}
+	return own == tier
+}
+
 // SetStatus updates the driver's status and records the change timestamp.
 //
 // Go Learning Note — Methods with Pointer Receivers:
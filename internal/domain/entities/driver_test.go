@@ -0,0 +1,58 @@
+package entities
+
+import "testing"
+
+func TestDestinationZone_Contains(t *testing.T) {
+	north := Location{Latitude: 37.85, Longitude: -122.40}
+	south := Location{Latitude: 37.74, Longitude: -122.40}
+
+	tests := []struct {
+		name     string
+		zone     DestinationZone
+		loc      Location
+		geohash  string
+		expected bool
+	}{
+		{"Empty zone allows anything", DestinationZone{}, south, "9q8yww", true},
+		{"Geohash prefix match", DestinationZone{GeohashPrefixes: []string{"9q8z"}}, north, "9q8zqd", true},
+		{"Geohash prefix miss", DestinationZone{GeohashPrefixes: []string{"9q8z"}}, south, "9q8yww", false},
+		{"Inside bounding box", DestinationZone{Bounds: &BoundingBox{MinLat: 37.80, MinLong: -122.50, MaxLat: 37.90, MaxLong: -122.30}}, north, "", true},
+		{"Outside bounding box", DestinationZone{Bounds: &BoundingBox{MinLat: 37.80, MinLong: -122.50, MaxLat: 37.90, MaxLong: -122.30}}, south, "", false},
+		{"Either form matches", DestinationZone{GeohashPrefixes: []string{"dr5r"}, Bounds: &BoundingBox{MinLat: 37.80, MinLong: -122.50, MaxLat: 37.90, MaxLong: -122.30}}, north, "9q8zqd", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.zone.Contains(tt.loc, tt.geohash); got != tt.expected {
+				t.Errorf("Contains() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDriver_AcceptsDestinationWithoutPreference(t *testing.T) {
+	driver := NewDriver("driver-1", "D1", "d1@example.com", "555-0001", "v1")
+
+	if !driver.AcceptsDestination(Location{Latitude: 37.74, Longitude: -122.40}, "9q8yww") {
+		t.Error("Expected a driver without a preferred zone to accept any destination")
+	}
+}
+
+func TestDriver_ServesTier(t *testing.T) {
+	driver := NewDriver("driver-1", "D1", "d1@example.com", "555-0001", "v1")
+
+	if !driver.ServesTier(VehicleTierEconomy) || !driver.ServesTier("") {
+		t.Error("Expected a driver with no tier set to serve economy rides")
+	}
+	if driver.ServesTier(VehicleTierXL) {
+		t.Error("Expected a driver with no tier set not to serve XL rides")
+	}
+
+	driver.VehicleTier = VehicleTierComfort
+	if !driver.ServesTier(VehicleTierComfort) {
+		t.Error("Expected a comfort driver to serve comfort rides")
+	}
+	if driver.ServesTier(VehicleTierEconomy) || driver.ServesTier(VehicleTierXL) {
+		t.Error("Expected a comfort driver to serve only comfort rides")
+	}
+}
@@ -1,6 +1,9 @@
 package entities
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // Location represents a geographic coordinate pair (latitude/longitude).
 //
@@ -39,6 +42,24 @@ func NewLocation(lat, long float64) Location {
 	}
 }
 
+// Valid reports whether the location is a real point on the globe: finite,
+// with latitude in [-90, 90] and longitude in [-180, 180].
+//
+// Go Learning Note — NaN Comparisons:
+// Every comparison involving NaN is false, so a NaN latitude would pass a
+// check written as "lat < -90 || lat > 90 means invalid". Writing the
+// condition as "valid if -90 <= lat && lat <= 90" rejects NaN for free, but
+// the explicit math.IsNaN/IsInf checks say what is meant.
+func (l Location) Valid() bool {
+	for _, v := range []float64{l.Latitude, l.Longitude} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return l.Latitude >= -90 && l.Latitude <= 90 &&
+		l.Longitude >= -180 && l.Longitude <= 180
+}
+
 // NewDriverLocation creates a DriverLocation with the current timestamp.
 // The geohash parameter should be pre-computed by the geo package.
 func NewDriverLocation(driverID string, lat, long float64, geohash string) *DriverLocation {
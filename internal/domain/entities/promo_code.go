@@ -0,0 +1,49 @@
+package entities
+
+import (
+	"time"
+	"uber/pkg/utils"
+)
+
+// PromoType says how a promo code's Value is applied to a fare.
+type PromoType string
+
+const (
+	PromoTypePercentage PromoType = "percentage" // Value is a percentage of the fare (e.g., 20 = 20% off)
+	PromoTypeFlat       PromoType = "flat"       // Value is a fixed amount off, in the fare's currency
+)
+
+// PromoCode is a discount riders can apply to a fare estimate.
+//
+// Value is a percentage for percentage codes and an amount in major units
+// (dollars) for flat ones. MaxDiscount caps how much a percentage code takes
+// off (0 = no cap); it is ignored for flat codes, whose Value already is the
+// amount. A zero ExpiresAt means the code never expires.
+type PromoCode struct {
+	Code        string      `json:"code"`
+	Type        PromoType   `json:"type"`
+	Value       float64     `json:"value"`
+	MaxDiscount utils.Money `json:"max_discount,omitempty"`
+	ExpiresAt   time.Time   `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the code can no longer be applied at time now.
+func (p *PromoCode) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// DiscountOn returns how much the code takes off a fare. It is never more
+// than the fare itself, so a discounted fare can reach zero but not go below.
+func (p *PromoCode) DiscountOn(fare utils.Money) utils.Money {
+	var discount utils.Money
+	switch p.Type {
+	case PromoTypePercentage:
+		discount = fare.Mul(p.Value / 100)
+		if p.MaxDiscount > 0 {
+			discount = min(discount, p.MaxDiscount)
+		}
+	case PromoTypeFlat:
+		discount = utils.NewMoney(p.Value)
+	}
+	return max(0, min(discount, fare))
+}
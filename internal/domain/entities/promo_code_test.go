@@ -0,0 +1,48 @@
+package entities
+
+import (
+	"testing"
+	"time"
+	"uber/pkg/utils"
+)
+
+func TestPromoCode_DiscountOn(t *testing.T) {
+	dollars := utils.NewMoney
+	tests := []struct {
+		name     string
+		promo    PromoCode
+		fare     utils.Money
+		expected utils.Money
+	}{
+		{"Percentage", PromoCode{Type: PromoTypePercentage, Value: 20}, dollars(15), dollars(3)},
+		{"Percentage rounds to the cent", PromoCode{Type: PromoTypePercentage, Value: 12.5}, dollars(10.10), dollars(1.26)},
+		{"Percentage capped", PromoCode{Type: PromoTypePercentage, Value: 50, MaxDiscount: dollars(4)}, dollars(20), dollars(4)},
+		{"Percentage under the cap", PromoCode{Type: PromoTypePercentage, Value: 10, MaxDiscount: dollars(4)}, dollars(20), dollars(2)},
+		{"Flat", PromoCode{Type: PromoTypeFlat, Value: 5}, dollars(20), dollars(5)},
+		{"Flat ignores MaxDiscount", PromoCode{Type: PromoTypeFlat, Value: 5, MaxDiscount: dollars(1)}, dollars(20), dollars(5)},
+		{"Flat larger than the fare", PromoCode{Type: PromoTypeFlat, Value: 25}, dollars(20), dollars(20)},
+		{"Unknown type", PromoCode{Type: "bogus", Value: 5}, dollars(20), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.promo.DiscountOn(tt.fare); got != tt.expected {
+				t.Errorf("DiscountOn(%v) = %v, expected %v", tt.fare, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPromoCode_Expired(t *testing.T) {
+	now := time.Now()
+
+	if (&PromoCode{}).Expired(now) {
+		t.Error("Expected a code without ExpiresAt never to expire")
+	}
+	if (&PromoCode{ExpiresAt: now.Add(time.Minute)}).Expired(now) {
+		t.Error("Expected a code expiring in a minute to still be valid")
+	}
+	if !(&PromoCode{ExpiresAt: now}).Expired(now) {
+		t.Error("Expected a code to be expired at its ExpiresAt")
+	}
+}
@@ -1,8 +1,12 @@
 package entities
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
+	"uber/pkg/utils"
 )
 
 // RideStatus represents the current lifecycle state of a ride.
@@ -13,8 +17,9 @@ import (
 // lifecycles (orders, payments, rides, etc.). The ride's lifecycle is:
 //
 //	Estimate → Requested → Matching → Accepted → PickingUp → InProgress → Completed
-//	                           ↘ Failed
-//	     (any non-terminal state can also transition to Cancelled)
+//	               ↘ Failed    ↘ Failed                              ↘ PendingConfirmation → Completed
+//	     (any state before the trip ends can also transition to Cancelled)
+//	     (Accepted and PickingUp return to Matching if the driver cancels)
 type RideStatus string
 
 const (
@@ -27,8 +32,77 @@ const (
 	RideStatusCompleted  RideStatus = "completed"
 	RideStatusCancelled  RideStatus = "cancelled"
 	RideStatusFailed     RideStatus = "failed"
+
+	// RideStatusPendingConfirmation is a ride the driver has marked completed
+	// that is waiting for the rider to confirm (see
+	// config.RideConfig.RiderConfirmsCompletion). The fare is not final yet.
+	RideStatusPendingConfirmation RideStatus = "pending_confirmation"
+)
+
+// AllRideStatuses lists every RideStatus constant, in lifecycle order. Code
+// that must handle each status (API parsing, reporting) can range over it in
+// tests to catch a newly added status that was not wired in.
+func AllRideStatuses() []RideStatus {
+	return []RideStatus{
+		RideStatusEstimate,
+		RideStatusRequested,
+		RideStatusMatching,
+		RideStatusAccepted,
+		RideStatusPickingUp,
+		RideStatusInProgress,
+		RideStatusPendingConfirmation,
+		RideStatusCompleted,
+		RideStatusCancelled,
+		RideStatusFailed,
+	}
+}
+
+// RideCategory is the product tier a ride was requested under. It selects
+// category-specific matching settings (see config.Config.MatchingFor).
+type RideCategory string
+
+const (
+	RideCategoryStandard RideCategory = "standard"
+	RideCategoryPremium  RideCategory = "premium"
+	RideCategoryDelivery RideCategory = "delivery"
+)
+
+// ParseRideCategory converts an API string into a RideCategory. An empty
+// string means the default, Standard; ok is false for unknown categories.
+func ParseRideCategory(raw string) (category RideCategory, ok bool) {
+	switch RideCategory(raw) {
+	case "", RideCategoryStandard:
+		return RideCategoryStandard, true
+	case RideCategoryPremium, RideCategoryDelivery:
+		return RideCategory(raw), true
+	default:
+		return "", false
+	}
+}
+
+// VehicleTier is the class of car a ride was priced for and the class a
+// driver's car belongs to. A ride is only offered to drivers of its tier.
+type VehicleTier string
+
+const (
+	VehicleTierEconomy VehicleTier = "economy"
+	VehicleTierComfort VehicleTier = "comfort"
+	VehicleTierXL      VehicleTier = "xl"
 )
 
+// ParseVehicleTier converts an API string into a VehicleTier. An empty
+// string means the default, Economy; ok is false for unknown tiers.
+func ParseVehicleTier(raw string) (tier VehicleTier, ok bool) {
+	switch VehicleTier(raw) {
+	case "", VehicleTierEconomy:
+		return VehicleTierEconomy, true
+	case VehicleTierComfort, VehicleTierXL:
+		return VehicleTier(raw), true
+	default:
+		return "", false
+	}
+}
+
 // validTransitions defines which status changes are allowed from each state.
 // Terminal states (Completed, Cancelled, Failed) have empty slices — no
 // transitions out. This map IS the state machine — CanTransitionTo() simply
@@ -40,14 +114,93 @@ const (
 // are initialized before main() runs, in dependency order.
 var validTransitions = map[RideStatus][]RideStatus{
 	RideStatusEstimate:   {RideStatusRequested, RideStatusCancelled},
-	RideStatusRequested:  {RideStatusMatching, RideStatusCancelled},
+	RideStatusRequested:  {RideStatusMatching, RideStatusFailed, RideStatusCancelled},
 	RideStatusMatching:   {RideStatusAccepted, RideStatusFailed, RideStatusCancelled},
-	RideStatusAccepted:   {RideStatusPickingUp, RideStatusCancelled},
-	RideStatusPickingUp:  {RideStatusInProgress, RideStatusCancelled},
-	RideStatusInProgress: {RideStatusCompleted, RideStatusCancelled},
+	RideStatusAccepted:   {RideStatusPickingUp, RideStatusMatching, RideStatusCancelled},
+	RideStatusPickingUp:  {RideStatusInProgress, RideStatusMatching, RideStatusCancelled},
+	RideStatusInProgress: {RideStatusCompleted, RideStatusPendingConfirmation, RideStatusCancelled},
 	RideStatusCompleted:  {},
 	RideStatusCancelled:  {},
 	RideStatusFailed:     {},
+
+	RideStatusPendingConfirmation: {RideStatusCompleted},
+}
+
+// contactlessTransitions replaces entries of validTransitions for rides with
+// Contactless set. A contactless (delivery-style) ride never has a passenger
+// in the car, so there is no InProgress phase: the driver completes straight
+// from PickingUp once the drop-off is made. Statuses not listed here fall back
+// to validTransitions.
+var contactlessTransitions = map[RideStatus][]RideStatus{
+	RideStatusPickingUp: {RideStatusCompleted, RideStatusPendingConfirmation, RideStatusMatching, RideStatusCancelled},
+}
+
+// IsTerminal reports whether no further transitions are possible out of this
+// status (Completed, Cancelled, Failed).
+func (s RideStatus) IsTerminal() bool {
+	switch s {
+	case RideStatusCompleted, RideStatusCancelled, RideStatusFailed:
+		return true
+	}
+	return false
+}
+
+// ApplyTransitionOverrides merges additional allowed transitions into the
+// state machine. It is meant to be called once at startup (before any rides
+// are processed) with config.RideConfig.TransitionOverrides.
+//
+// Every status must be a known RideStatus, and terminal states may not gain
+// outgoing transitions — otherwise an override could resurrect a completed or
+// cancelled ride. Validation happens before anything is merged, so a bad
+// override leaves the defaults untouched.
+func ApplyTransitionOverrides(overrides map[string][]string) error {
+	for from, targets := range overrides {
+		fromStatus := RideStatus(from)
+		if _, known := validTransitions[fromStatus]; !known {
+			return fmt.Errorf("transition override: unknown status %q", from)
+		}
+		if fromStatus.IsTerminal() {
+			return fmt.Errorf("transition override: terminal status %q cannot have outgoing transitions", from)
+		}
+		for _, to := range targets {
+			if _, known := validTransitions[RideStatus(to)]; !known {
+				return fmt.Errorf("transition override: unknown status %q", to)
+			}
+		}
+	}
+
+	for from, targets := range overrides {
+		fromStatus := RideStatus(from)
+		// Build a fresh slice rather than appending in place so the default
+		// slice literals are never shared with the merged result.
+		merged := append([]RideStatus{}, validTransitions[fromStatus]...)
+		for _, to := range targets {
+			if !containsStatus(merged, RideStatus(to)) {
+				merged = append(merged, RideStatus(to))
+			}
+		}
+		validTransitions[fromStatus] = merged
+	}
+	return nil
+}
+
+// containsStatus reports whether statuses includes target.
+func containsStatus(statuses []RideStatus, target RideStatus) bool {
+	for _, s := range statuses {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange is one entry of a ride's status history: the ride moved from
+// From to To at At. The first entry of every ride has an empty From and To
+// set to Estimate, marking when the ride was created.
+type StatusChange struct {
+	From RideStatus `json:"from,omitempty"`
+	To   RideStatus `json:"to"`
+	At   time.Time  `json:"at"`
 }
 
 // Ride is the central domain entity. It tracks a ride from fare estimate through
@@ -58,33 +211,109 @@ var validTransitions = map[RideStatus][]RideStatus{
 // they hold their zero value. For strings that's "", for numbers 0, for
 // time.Time it's the zero time. This keeps API responses clean — DriverID won't
 // appear in the JSON until a driver is assigned, and ActualFare won't appear
-// until the ride is completed.
+// until the ride is completed (or, for a fare priced from the measured trip,
+// until the driver completes it).
+//
+// Waypoints are the stops between Source and Destination, in the order the
+// trip makes them. DistanceKm runs through all of them. They are fixed when
+// the ride is created.
+//
+// EstimatedFare and ActualFare are utils.Money: exact cents, written to JSON as
+// two-decimal strings ("12.50").
+//
+// Contactless marks a delivery-style ride with no passenger contact. It changes
+// the lifecycle (see contactlessTransitions) and is fixed when the ride is
+// created.
+//
+// FareLockExpiresAt is when the quoted EstimatedFare stops being guaranteed.
+// Requesting the ride before then honors the quote; afterwards it is re-priced.
+//
+// ExpiresAt is when an estimate that hasn't been requested lapses for good.
+// It is cleared once the ride is requested, since it only ever applied to
+// the quote.
+//
+// StartedAt is when the trip itself began (InProgress), which is where the
+// running fare starts counting distance and time. The time from PickedUpAt,
+// when the driver reached the pickup, to StartedAt is the driver's wait (see
+// WaitTime); WaitFare is what the final fare charged for it.
+// CancellationFee is what the rider was charged for cancelling the ride, if
+// anything (see config.CancellationConfig).
+//
+// ConfirmBy is set while the ride is PendingConfirmation: if the rider has not
+// confirmed by then, the ride completes on its own.
+//
+// ArrivingSoonAt is when the rider was told the driver is about to reach the
+// pickup. It is set at most once per ride.
+//
+// StatusHistory lists every status the ride has been in, oldest first,
+// including statuses it later left again (a reassigned ride shows both
+// acceptances). The milestone timestamps above only keep the latest of each,
+// so the history is what answers how a ride got where it is and how long it
+// spent in each state. Entries are only ever appended, so a finished ride
+// keeps its whole history.
+//
+// The repository hands the same *Ride to every caller, so the matching
+// goroutine accepting a ride and an HTTP handler cancelling it can hold one
+// pointer at once. mu makes each state change — TransitionTo, AssignDriver
+// and the wrappers built on them — check and apply as one step: of two
+// transitions racing out of the same status, the loser sees the winner's
+// result and is judged against it. Code that may run alongside those changes
+// reads the status through CurrentStatus, LastStatusChange or StatusSnapshot,
+// the driver through AssignedDriver or Acceptance and the charged fare through
+// FinalFare, and writes other fields through Update, or TransitionToWith for
+// those that change together with the status; MarshalJSON holds mu too, so a
+// handler writing a ride out never catches it halfway through a change.
+//
+// Go Learning Note — Mutex as a Struct Field:
+// A sync.Mutex's zero value is an unlocked mutex, so a struct can embed one as
+// a plain field with nothing to initialize. The struct must not be copied
+// after first use, though — a copy gets its own lock, guarding nothing the
+// original's does. `go vet` (the copylocks check) flags such copies, which is
+// one more reason rides are always passed around as *Ride. Being unexported,
+// mu is invisible to encoding/json.
 type Ride struct {
-	ID            string     `json:"id"`
-	RiderID       string     `json:"rider_id"`
-	DriverID      string     `json:"driver_id,omitempty"`
-	Status        RideStatus `json:"status"`
-	Source        Location   `json:"source"`
-	Destination   Location   `json:"destination"`
-	EstimatedFare float64    `json:"estimated_fare"`
-	ActualFare    float64    `json:"actual_fare,omitempty"`
-	DistanceKm    float64    `json:"distance_km"`
-	DurationMins  float64    `json:"duration_mins"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
-	AcceptedAt    time.Time  `json:"accepted_at,omitempty"`
-	PickedUpAt    time.Time  `json:"picked_up_at,omitempty"`
-	CompletedAt   time.Time  `json:"completed_at,omitempty"`
+	ID                string         `json:"id"`
+	RiderID           string         `json:"rider_id"`
+	DriverID          string         `json:"driver_id,omitempty"`
+	Status            RideStatus     `json:"status"`
+	Category          RideCategory   `json:"category"`
+	VehicleTier       VehicleTier    `json:"vehicle_tier"`
+	Source            Location       `json:"source"`
+	Destination       Location       `json:"destination"`
+	Waypoints         []Location     `json:"waypoints,omitempty"`
+	EstimatedFare     utils.Money    `json:"estimated_fare"`
+	ActualFare        utils.Money    `json:"actual_fare,omitempty"`
+	WaitFare          utils.Money    `json:"wait_fare,omitempty"`
+	CancellationFee   utils.Money    `json:"cancellation_fee,omitempty"`
+	DistanceKm        float64        `json:"distance_km"`
+	DurationMins      float64        `json:"duration_mins"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	AcceptedAt        time.Time      `json:"accepted_at,omitempty"`
+	PickedUpAt        time.Time      `json:"picked_up_at,omitempty"`
+	StartedAt         time.Time      `json:"started_at,omitempty"`
+	CompletedAt       time.Time      `json:"completed_at,omitempty"`
+	Contactless       bool           `json:"contactless,omitempty"`
+	Promo             *PromoCode     `json:"promo,omitempty"`
+	FareLockExpiresAt time.Time      `json:"fare_lock_expires_at,omitempty"`
+	ExpiresAt         time.Time      `json:"expires_at,omitempty"`
+	ConfirmBy         time.Time      `json:"confirm_by,omitempty"`
+	ArrivingSoonAt    time.Time      `json:"arriving_soon_at,omitempty"`
+	StatusHistory     []StatusChange `json:"status_history"`
+
+	mu sync.Mutex
 }
 
 // NewRide creates a Ride starting in the Estimate state. No driver is assigned
 // yet — that happens later when a driver accepts during the matching phase.
-func NewRide(id, riderID string, source, destination Location, estimatedFare, distanceKm, durationMins float64) *Ride {
+func NewRide(id, riderID string, source, destination Location, estimatedFare utils.Money, distanceKm, durationMins float64) *Ride {
 	now := time.Now()
 	return &Ride{
 		ID:            id,
 		RiderID:       riderID,
 		Status:        RideStatusEstimate,
+		Category:      RideCategoryStandard,
+		VehicleTier:   VehicleTierEconomy,
 		Source:        source,
 		Destination:   destination,
 		EstimatedFare: estimatedFare,
@@ -92,7 +321,139 @@ func NewRide(id, riderID string, source, destination Location, estimatedFare, di
 		DurationMins:  durationMins,
 		CreatedAt:     now,
 		UpdatedAt:     now,
+		StatusHistory: []StatusChange{{To: RideStatusEstimate, At: now}},
+	}
+}
+
+// EstimateExpired reports whether the ride is an unrequested estimate whose
+// ExpiresAt has passed at time now.
+func (r *Ride) EstimateExpired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// WaitTime is how long the driver waited at the pickup: from entering
+// PickingUp to the trip starting. It is zero until the trip has started, or
+// if the ride skipped PickingUp.
+func (r *Ride) WaitTime() time.Duration {
+	if r.PickedUpAt.IsZero() || r.StartedAt.IsZero() {
+		return 0
+	}
+	return r.StartedAt.Sub(r.PickedUpAt)
+}
+
+// CurrentStatus returns the ride's status. Unlike reading the Status field,
+// it is safe while another goroutine may be changing the status.
+func (r *Ride) CurrentStatus() RideStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Status
+}
+
+// LastStatusChange returns the newest entry of StatusHistory: the current
+// status and when the ride entered it.
+func (r *Ride) LastStatusChange() StatusChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.StatusHistory[len(r.StatusHistory)-1]
+}
+
+// StatusSnapshot returns the current status together with a copy of
+// StatusHistory, read as one step so the two agree.
+func (r *Ride) StatusSnapshot() (RideStatus, []StatusChange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Status, append([]StatusChange(nil), r.StatusHistory...)
+}
+
+// AssignedDriver returns DriverID, which Accept sets and Reassign clears
+// while others may be reading the ride.
+func (r *Ride) AssignedDriver() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.DriverID
+}
+
+// Acceptance returns the assigned driver and when they accepted, read as one
+// step so the two belong to the same acceptance. Both are empty while no
+// driver has the ride.
+func (r *Ride) Acceptance() (driverID string, acceptedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.DriverID, r.AcceptedAt
+}
+
+// FinalFare returns ActualFare, what the rider is charged for the trip. It is
+// zero until the ride completes or is priced for the rider's confirmation.
+func (r *Ride) FinalFare() utils.Money {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ActualFare
+}
+
+// Pickup returns the ride's current pickup point (Source), which MovePickup
+// may change while the ride is being matched.
+func (r *Ride) Pickup() Location {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Source
+}
+
+// CurrentFare returns EstimatedFare, which MovePickup may change while the
+// ride is being matched.
+func (r *Ride) CurrentFare() utils.Money {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.EstimatedFare
+}
+
+// MovePickup moves the pickup point to pickup, with the trip and fare priced
+// from it, if no driver has accepted the ride yet (Requested or Matching).
+// The status check and the writes are one step, so a driver accepting at the
+// same moment either accepts the moved ride or makes MovePickup report false.
+func (r *Ride) MovePickup(pickup Location, distanceKm, durationMins float64, fare utils.Money) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Status != RideStatusRequested && r.Status != RideStatusMatching {
+		return false
 	}
+	r.Source = pickup
+	r.DistanceKm = distanceKm
+	r.DurationMins = durationMins
+	r.EstimatedFare = fare
+	r.UpdatedAt = time.Now()
+	return true
+}
+
+// Update runs fn with mu held, for changes to fields other than the status
+// once the ride has been stored and others may be reading it. fn must not
+// call the ride's other methods, which take mu themselves.
+func (r *Ride) Update(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn()
+}
+
+// rideJSON has Ride's fields and tags but none of its methods, so marshaling
+// it doesn't recurse into Ride.MarshalJSON.
+type rideJSON Ride
+
+// MarshalJSON encodes the ride with mu held, so the response shows one
+// consistent state even while matching moves the ride along.
+//
+// Go Learning Note — Defined Types Drop Methods:
+// `type rideJSON Ride` declares a new type with the same underlying struct but
+// an empty method set. Converting the pointer, (*rideJSON)(r), is free — it
+// neither copies the struct nor its mutex — and json.Marshal then falls back
+// to encoding the fields by reflection instead of calling MarshalJSON again.
+func (r *Ride) MarshalJSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return json.Marshal((*rideJSON)(r))
+}
+
+// FareLocked reports whether the quoted fare is still guaranteed at time now.
+func (r *Ride) FareLocked(now time.Time) bool {
+	return now.Before(r.FareLockExpiresAt)
 }
 
 // CanTransitionTo checks if moving to newStatus is a valid state change.
@@ -103,21 +464,30 @@ func NewRide(id, riderID string, source, destination Location, estimatedFare, di
 // found. Without the second variable, accessing a missing key returns the
 // zero value silently, which can cause subtle bugs.
 func (r *Ride) CanTransitionTo(newStatus RideStatus) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.canTransitionTo(newStatus)
+}
+
+// canTransitionTo is CanTransitionTo for callers already holding mu.
+func (r *Ride) canTransitionTo(newStatus RideStatus) bool {
+	if r.Contactless {
+		if allowedStatuses, exists := contactlessTransitions[r.Status]; exists {
+			return containsStatus(allowedStatuses, newStatus)
+		}
+	}
+
 	allowedStatuses, exists := validTransitions[r.Status]
 	if !exists {
 		return false
 	}
-	for _, s := range allowedStatuses {
-		if s == newStatus {
-			return true
-		}
-	}
-	return false
+	return containsStatus(allowedStatuses, newStatus)
 }
 
 // TransitionTo attempts to move the ride to newStatus. Returns an error if the
-// transition is not allowed by the state machine. On success, it also records
-// phase-specific timestamps (AcceptedAt, PickedUpAt, CompletedAt).
+// transition is not allowed by the state machine. On success, it appends the
+// change to StatusHistory and records phase-specific timestamps (AcceptedAt,
+// PickedUpAt, StartedAt, CompletedAt).
 //
 // Go Learning Note — Error Handling:
 // Go functions signal failure by returning an error as the last return value.
@@ -127,9 +497,32 @@ func (r *Ride) CanTransitionTo(newStatus RideStatus) bool {
 // For richer errors, you can define custom error types or use fmt.Errorf with
 // the %w verb for error wrapping (Go 1.13+).
 func (r *Ride) TransitionTo(newStatus RideStatus) error {
-	if !r.CanTransitionTo(newStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.transitionTo(newStatus)
+}
+
+// TransitionToWith is TransitionTo followed by fn, which sets the fields
+// that go with the new status, both under one lock: nobody reading the ride
+// sees it in the new status with those fields still as they were. fn runs
+// with mu held, and only if the transition succeeded; like Update's, it must
+// not call the ride's other methods.
+func (r *Ride) TransitionToWith(newStatus RideStatus, fn func()) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.transitionTo(newStatus); err != nil {
+		return err
+	}
+	fn()
+	return nil
+}
+
+// transitionTo is TransitionTo for callers already holding mu.
+func (r *Ride) transitionTo(newStatus RideStatus) error {
+	if !r.canTransitionTo(newStatus) {
 		return errors.New("invalid status transition from " + string(r.Status) + " to " + string(newStatus))
 	}
+	r.StatusHistory = append(r.StatusHistory, StatusChange{From: r.Status, To: newStatus, At: time.Now()})
 	r.Status = newStatus
 	r.UpdatedAt = time.Now()
 
@@ -139,9 +532,17 @@ func (r *Ride) TransitionTo(newStatus RideStatus) error {
 		r.AcceptedAt = time.Now()
 	case RideStatusPickingUp:
 		r.PickedUpAt = time.Now()
+	case RideStatusInProgress:
+		r.StartedAt = time.Now()
 	case RideStatusCompleted:
 		r.CompletedAt = time.Now()
-		r.ActualFare = r.EstimatedFare
+		// A fare already priced from the measured trip (set while the ride
+		// waited for the rider's confirmation) stands; otherwise the rider
+		// pays what they were quoted.
+		if r.ActualFare == 0 {
+			r.ActualFare = r.EstimatedFare
+		}
+		r.ConfirmBy = time.Time{}
 	}
 
 	return nil
@@ -149,6 +550,13 @@ func (r *Ride) TransitionTo(newStatus RideStatus) error {
 
 // AssignDriver records which driver is handling this ride.
 func (r *Ride) AssignDriver(driverID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.assignDriver(driverID)
+}
+
+// assignDriver is AssignDriver for callers already holding mu.
+func (r *Ride) assignDriver(driverID string) {
 	r.DriverID = driverID
 	r.UpdatedAt = time.Now()
 }
@@ -169,10 +577,17 @@ func (r *Ride) StartMatching() error {
 	return r.TransitionTo(RideStatusMatching)
 }
 
-// Accept assigns a driver and transitions to Accepted.
+// Accept transitions to Accepted and assigns the driver, both under one lock
+// so no one sees the ride accepted without its driver. A ride that can't be
+// accepted is left without the driver too.
 func (r *Ride) Accept(driverID string) error {
-	r.AssignDriver(driverID)
-	return r.TransitionTo(RideStatusAccepted)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.transitionTo(RideStatusAccepted); err != nil {
+		return err
+	}
+	r.assignDriver(driverID)
+	return nil
 }
 
 // StartPickup transitions to PickingUp (driver is en route to rider).
@@ -190,11 +605,42 @@ func (r *Ride) Complete() error {
 	return r.TransitionTo(RideStatusCompleted)
 }
 
+// Reassign sends an accepted ride back to Matching after its driver cancels
+// before pickup. The driver assignment and its timestamps are cleared so the
+// ride looks exactly like one that has not been accepted yet.
+func (r *Ride) Reassign() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.transitionTo(RideStatusMatching); err != nil {
+		return err
+	}
+	r.DriverID = ""
+	r.AcceptedAt = time.Time{}
+	r.PickedUpAt = time.Time{}
+	return nil
+}
+
 // Cancel transitions to Cancelled (rider or driver cancelled).
 func (r *Ride) Cancel() error {
 	return r.TransitionTo(RideStatusCancelled)
 }
 
+// CancelWithFee cancels the ride like Cancel and records as CancellationFee
+// what fee charges for the driver and acceptance time the ride has as it is
+// cancelled. Pricing and cancelling are one step, so a driver accepting or
+// backing out at the same moment can't leave the rider charged for a
+// different acceptance than the one they cancelled. fee is called with mu
+// held and only if the ride can be cancelled.
+func (r *Ride) CancelWithFee(fee func(driverID string, acceptedAt time.Time) utils.Money) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.transitionTo(RideStatusCancelled); err != nil {
+		return err
+	}
+	r.CancellationFee = fee(r.DriverID, r.AcceptedAt)
+	return nil
+}
+
 // Fail transitions to Failed (no driver found during matching).
 func (r *Ride) Fail() error {
 	return r.TransitionTo(RideStatusFailed)
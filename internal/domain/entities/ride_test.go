@@ -0,0 +1,438 @@
+package entities
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+	"uber/pkg/utils"
+)
+
+// withDefaultTransitions snapshots the state machine and restores it when the
+// test finishes, so overrides applied in one test don't leak into others.
+func withDefaultTransitions(t *testing.T) {
+	t.Helper()
+	saved := make(map[RideStatus][]RideStatus, len(validTransitions))
+	for from, targets := range validTransitions {
+		saved[from] = targets
+	}
+	t.Cleanup(func() {
+		validTransitions = saved
+	})
+}
+
+func newAcceptedRide() *Ride {
+	ride := NewRide("ride-1", "rider-1",
+		Location{Latitude: 37.77, Longitude: -122.41},
+		Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	return ride
+}
+
+func TestApplyTransitionOverrides_AcceptedToInProgress(t *testing.T) {
+	withDefaultTransitions(t)
+
+	ride := newAcceptedRide()
+	if ride.CanTransitionTo(RideStatusInProgress) {
+		t.Fatal("Expected Accepted→InProgress to be disallowed by default")
+	}
+
+	err := ApplyTransitionOverrides(map[string][]string{
+		"accepted": {"in_progress"},
+	})
+	if err != nil {
+		t.Fatalf("ApplyTransitionOverrides failed: %v", err)
+	}
+
+	if err := ride.StartTrip(); err != nil {
+		t.Fatalf("Expected Accepted→InProgress to succeed after override, got %v", err)
+	}
+
+	// Default transitions out of Accepted must still be allowed.
+	other := newAcceptedRide()
+	if !other.CanTransitionTo(RideStatusPickingUp) {
+		t.Error("Expected Accepted→PickingUp to remain allowed")
+	}
+	if !other.CanTransitionTo(RideStatusCancelled) {
+		t.Error("Expected Accepted→Cancelled to remain allowed")
+	}
+}
+
+func TestApplyTransitionOverrides_Invalid(t *testing.T) {
+	withDefaultTransitions(t)
+
+	tests := []struct {
+		name      string
+		overrides map[string][]string
+	}{
+		{
+			name:      "Terminal source",
+			overrides: map[string][]string{"completed": {"in_progress"}},
+		},
+		{
+			name:      "Unknown source",
+			overrides: map[string][]string{"teleporting": {"completed"}},
+		},
+		{
+			name:      "Unknown target",
+			overrides: map[string][]string{"accepted": {"teleporting"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ApplyTransitionOverrides(tt.overrides); err == nil {
+				t.Error("Expected error for invalid override")
+			}
+		})
+	}
+
+	// A rejected override must leave the defaults untouched.
+	ride := newAcceptedRide()
+	if ride.CanTransitionTo(RideStatusInProgress) {
+		t.Error("Expected defaults to be unchanged after rejected overrides")
+	}
+}
+
+func TestRide_ContactlessCompletesFromPickingUp(t *testing.T) {
+	ride := newAcceptedRide()
+	ride.Contactless = true
+
+	if err := ride.StartPickup(); err != nil {
+		t.Fatalf("StartPickup failed: %v", err)
+	}
+	if ride.CanTransitionTo(RideStatusInProgress) {
+		t.Error("Expected contactless ride to skip InProgress")
+	}
+	if err := ride.Complete(); err != nil {
+		t.Fatalf("Expected PickingUp→Completed for contactless ride, got %v", err)
+	}
+	if ride.CompletedAt.IsZero() {
+		t.Error("Expected CompletedAt to be set")
+	}
+}
+
+func TestRide_NormalRideRequiresFullSequence(t *testing.T) {
+	ride := newAcceptedRide()
+
+	if err := ride.StartPickup(); err != nil {
+		t.Fatalf("StartPickup failed: %v", err)
+	}
+	if err := ride.Complete(); err == nil {
+		t.Fatal("Expected PickingUp→Completed to be rejected for a normal ride")
+	}
+	if err := ride.StartTrip(); err != nil {
+		t.Fatalf("StartTrip failed: %v", err)
+	}
+	if err := ride.Complete(); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+}
+
+func TestRide_ReassignClearsDriver(t *testing.T) {
+	ride := newAcceptedRide()
+
+	if err := ride.Reassign(); err != nil {
+		t.Fatalf("Reassign failed: %v", err)
+	}
+	if ride.Status != RideStatusMatching {
+		t.Errorf("Expected status matching, got %s", ride.Status)
+	}
+	if ride.DriverID != "" || !ride.AcceptedAt.IsZero() {
+		t.Errorf("Expected driver assignment cleared, got %q accepted at %v", ride.DriverID, ride.AcceptedAt)
+	}
+
+	// Once the rider is in the car, a ride can no longer be reassigned.
+	inProgress := newAcceptedRide()
+	inProgress.StartPickup()
+	inProgress.StartTrip()
+	if err := inProgress.Reassign(); err == nil {
+		t.Error("Expected Reassign to fail for an in-progress ride")
+	}
+}
+
+func TestAllRideStatuses_MatchesStateMachine(t *testing.T) {
+	all := AllRideStatuses()
+	if len(all) != len(validTransitions) {
+		t.Fatalf("AllRideStatuses has %d entries, state machine has %d", len(all), len(validTransitions))
+	}
+	for _, status := range all {
+		if _, exists := validTransitions[status]; !exists {
+			t.Errorf("Status %q is missing from validTransitions", status)
+		}
+	}
+}
+
+func TestRide_StatusHistoryRecordsEveryTransition(t *testing.T) {
+	ride := NewRide("ride-1", "rider-1",
+		Location{Latitude: 37.77, Longitude: -122.41},
+		Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+
+	steps := []func() error{ride.Request, ride.StartMatching, func() error { return ride.Accept("driver-1") },
+		ride.StartPickup, ride.StartTrip, ride.Complete}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("Transition failed: %v", err)
+		}
+	}
+	// A rejected transition leaves no trace.
+	if err := ride.Cancel(); err == nil {
+		t.Fatal("Expected a completed ride not to be cancellable")
+	}
+
+	expected := []RideStatus{
+		RideStatusEstimate, RideStatusRequested, RideStatusMatching, RideStatusAccepted,
+		RideStatusPickingUp, RideStatusInProgress, RideStatusCompleted,
+	}
+	if len(ride.StatusHistory) != len(expected) {
+		t.Fatalf("Expected %d recorded transitions, got %d: %+v", len(expected), len(ride.StatusHistory), ride.StatusHistory)
+	}
+	for i, change := range ride.StatusHistory {
+		var from RideStatus
+		if i > 0 {
+			from = expected[i-1]
+			if change.At.Before(ride.StatusHistory[i-1].At) {
+				t.Errorf("Entry %d at %v is earlier than the one before it (%v)", i, change.At, ride.StatusHistory[i-1].At)
+			}
+		}
+		if change.From != from || change.To != expected[i] {
+			t.Errorf("Entry %d: expected %q → %q, got %q → %q", i, from, expected[i], change.From, change.To)
+		}
+	}
+	if !ride.StatusHistory[0].At.Equal(ride.CreatedAt) {
+		t.Errorf("Expected the first entry at creation (%v), got %v", ride.CreatedAt, ride.StatusHistory[0].At)
+	}
+}
+
+// Run with -race: without the ride's lock, Accept and Cancel write Status,
+// UpdatedAt and DriverID at the same time.
+func TestRide_ConcurrentAcceptAndCancel(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		ride := NewRide("ride-1", "rider-1",
+			Location{Latitude: 37.77, Longitude: -122.41},
+			Location{Latitude: 37.78, Longitude: -122.40},
+			utils.NewMoney(10.00), 1.5, 5.0)
+		ride.Request()
+		ride.StartMatching()
+
+		var wg sync.WaitGroup
+		var acceptErr, cancelErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			acceptErr = ride.Accept("driver-1")
+		}()
+		go func() {
+			defer wg.Done()
+			cancelErr = ride.Cancel()
+		}()
+		wg.Wait()
+
+		// Exactly one of the two moved the ride out of Matching; the other
+		// was judged against the ride as the winner left it.
+		fromMatching := 0
+		for _, change := range ride.StatusHistory {
+			if change.From == RideStatusMatching {
+				fromMatching++
+			}
+		}
+		if fromMatching != 1 {
+			t.Fatalf("Expected exactly one transition out of matching, got %d: %+v", fromMatching, ride.StatusHistory)
+		}
+
+		if cancelErr != nil {
+			t.Fatalf("Expected cancel to succeed either way, got %v", cancelErr)
+		}
+		if ride.Status != RideStatusCancelled {
+			t.Fatalf("Expected the ride to end cancelled, got %s", ride.Status)
+		}
+		won := ride.StatusHistory[3].To
+		switch {
+		case won == RideStatusAccepted && (acceptErr != nil || ride.DriverID != "driver-1"):
+			t.Fatalf("Accept won but returned %v with driver %q", acceptErr, ride.DriverID)
+		case won == RideStatusCancelled && (acceptErr == nil || ride.DriverID != ""):
+			t.Fatalf("Cancel won but Accept returned %v and assigned %q", acceptErr, ride.DriverID)
+		}
+	}
+}
+
+func TestRide_ReadsWhileTransitioning(t *testing.T) {
+	ride := NewRide("ride-1", "rider-1",
+		Location{Latitude: 37.77, Longitude: -122.41},
+		Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+
+	// Run with -race: the reader goroutine must only see the ride through
+	// its locked accessors while the writer moves it along, including a
+	// driver backing out and another taking over.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ride.Request()
+		ride.StartMatching()
+		ride.Accept("driver-1")
+		ride.Reassign()
+		ride.Accept("driver-2")
+		ride.StartPickup()
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		status, history := ride.StatusSnapshot()
+		if last := history[len(history)-1]; last.To != status {
+			t.Fatalf("Expected the snapshot's status %s to match its last change %s", status, last.To)
+		}
+		if driverID, acceptedAt := ride.Acceptance(); (driverID == "") != acceptedAt.IsZero() {
+			t.Fatalf("Expected driver %q and acceptance time %v to be set together", driverID, acceptedAt)
+		}
+		ride.AssignedDriver()
+		ride.FinalFare()
+		if _, err := json.Marshal(ride); err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+	}
+
+	if got := ride.CurrentStatus(); got != RideStatusPickingUp {
+		t.Errorf("Expected picking_up, got %s", got)
+	}
+	if last := ride.LastStatusChange(); last.From != RideStatusAccepted || last.To != RideStatusPickingUp {
+		t.Errorf("Expected the last change accepted → picking_up, got %+v", last)
+	}
+
+	// The JSON keeps the field names, not the method's.
+	data, _ := json.Marshal(ride)
+	var decoded map[string]any
+	json.Unmarshal(data, &decoded)
+	if decoded["status"] != "picking_up" || decoded["driver_id"] != "driver-2" {
+		t.Errorf("Expected status and driver_id in the JSON, got %s", data)
+	}
+}
+
+func TestRide_CancelWithFeeRacesReassign(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		ride := newAcceptedRide()
+
+		var charged string
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ride.CancelWithFee(func(driverID string, acceptedAt time.Time) utils.Money {
+				charged = driverID
+				if driverID == "" {
+					return 0
+				}
+				return utils.NewMoney(5.00)
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			ride.Reassign()
+		}()
+		wg.Wait()
+
+		// Cancelled first, the driver who accepted is charged for and stays
+		// on the ride; reassigned first, the cancellation is free.
+		if ride.Status != RideStatusCancelled {
+			t.Fatalf("Expected the ride cancelled, got %s", ride.Status)
+		}
+		if charged != ride.DriverID {
+			t.Fatalf("Fee priced for driver %q, but the cancelled ride has driver %q", charged, ride.DriverID)
+		}
+		if (ride.CancellationFee != 0) != (ride.DriverID != "") {
+			t.Fatalf("Expected a fee only with a driver, got %v for %q", ride.CancellationFee, ride.DriverID)
+		}
+	}
+
+	// A ride that can't be cancelled isn't priced.
+	done := newAcceptedRide()
+	done.StartPickup()
+	done.StartTrip()
+	done.Complete()
+	if err := done.CancelWithFee(func(string, time.Time) utils.Money {
+		t.Error("Expected no fee for a ride that can't be cancelled")
+		return 0
+	}); err == nil {
+		t.Error("Expected CancelWithFee to fail for a completed ride")
+	}
+}
+
+func TestRide_TransitionToWithIsOneStep(t *testing.T) {
+	ride := newAcceptedRide()
+	ride.StartPickup()
+	ride.StartTrip()
+	measured := ride.EstimatedFare + utils.NewMoney(2.50)
+
+	// Run with -race: once the reader sees the ride completed, the fare
+	// priced from the measured trip must already be in place.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ride.TransitionToWith(RideStatusCompleted, func() { ride.ActualFare = measured })
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		if ride.CurrentStatus() == RideStatusCompleted && ride.FinalFare() != measured {
+			t.Fatalf("Saw the ride completed with fare %v before the measured %v", ride.FinalFare(), measured)
+		}
+	}
+
+	// fn isn't run for a transition that fails.
+	if err := ride.TransitionToWith(RideStatusMatching, func() {
+		t.Error("Expected fn not to run for a refused transition")
+	}); err == nil {
+		t.Error("Expected a completed ride to refuse going back to matching")
+	}
+}
+
+func TestRide_MovePickupRacesAccept(t *testing.T) {
+	moved := Location{Latitude: 37.771, Longitude: -122.409}
+	for i := 0; i < 200; i++ {
+		ride := NewRide("ride-1", "rider-1",
+			Location{Latitude: 37.77, Longitude: -122.41},
+			Location{Latitude: 37.78, Longitude: -122.40},
+			utils.NewMoney(10.00), 1.5, 5.0)
+		ride.Request()
+		ride.StartMatching()
+
+		// What the driver was offered, read just before they accept.
+		var offeredPickup Location
+		var offeredFare utils.Money
+		var wg sync.WaitGroup
+		var ok bool
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ok = ride.MovePickup(moved, 1.4, 4.5, utils.NewMoney(9.50))
+		}()
+		go func() {
+			defer wg.Done()
+			ride.mu.Lock()
+			defer ride.mu.Unlock()
+			offeredPickup, offeredFare = ride.Source, ride.EstimatedFare
+			ride.transitionTo(RideStatusAccepted)
+		}()
+		wg.Wait()
+
+		// A move either landed before the acceptance, so the driver
+		// accepted the moved ride, or was refused.
+		if ride.Source != offeredPickup || ride.EstimatedFare != offeredFare {
+			t.Fatalf("Accepted ride has pickup %v / fare %v, but the driver accepted %v / %v",
+				ride.Source, ride.EstimatedFare, offeredPickup, offeredFare)
+		}
+		if ok != (ride.Source == moved) {
+			t.Fatalf("MovePickup returned %v but the pickup is %v", ok, ride.Source)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package geo
+
+import (
+	"sort"
+	"strings"
+)
+
+// Barrier approximates a physical divide such as a river or bay that makes the
+// straight-line distance between its two sides misleading. Each side is a set
+// of geohash prefixes; crossing from one side to the other costs DetourKm on
+// top of the Haversine distance (the extra drive to the nearest bridge), or is
+// ruled out entirely when Exclude is set.
+//
+// This is deliberately coarse: it knows nothing about roads, only which cells
+// sit on which bank. Points outside both sides are unaffected.
+type Barrier struct {
+	Name     string
+	SideA    []string
+	SideB    []string
+	DetourKm float64
+	Exclude  bool
+}
+
+// side reports which side of the barrier a full-precision geohash falls on:
+// 'A', 'B', or 0 for neither.
+func (b Barrier) side(hash string) byte {
+	if hasAnyPrefix(hash, b.SideA) {
+		return 'A'
+	}
+	if hasAnyPrefix(hash, b.SideB) {
+		return 'B'
+	}
+	return 0
+}
+
+// Separates reports whether the two geohashes lie on opposite sides of the
+// barrier. Prefixes are matched against the hashes as given, so callers should
+// encode at MaxPrecision to let prefixes of any length match.
+func (b Barrier) Separates(hashA, hashB string) bool {
+	sideA, sideB := b.side(hashA), b.side(hashB)
+	return sideA != 0 && sideB != 0 && sideA != sideB
+}
+
+func hasAnyPrefix(hash string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if prefix != "" && strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyBarriers adjusts nearest-first search results around (lat, lon) for
+// the given barriers. A driver across a barrier has its Distance increased by
+// the barrier's DetourKm, and is dropped if that pushes them past radiusKm or
+// the barrier excludes crossings outright. The result is re-sorted by the
+// adjusted distance, so a driver just across the river ranks below a farther
+// driver on the rider's own side.
+//
+// Go Learning Note — Filtering In Place:
+// results[:0] reuses the input's backing array for the output. Since we only
+// ever write at or behind the read position, no element is overwritten before
+// it has been read — a common allocation-free filtering idiom in Go.
+func ApplyBarriers(results []DriverWithDistance, lat, lon, radiusKm float64, barriers []Barrier) []DriverWithDistance {
+	if len(barriers) == 0 || len(results) == 0 {
+		return results
+	}
+
+	origin := Encode(lat, lon, MaxPrecision)
+	kept := results[:0]
+	for _, dwd := range results {
+		hash := Encode(dwd.Driver.Location.Latitude, dwd.Driver.Location.Longitude, MaxPrecision)
+		excluded := false
+		for _, barrier := range barriers {
+			if !barrier.Separates(origin, hash) {
+				continue
+			}
+			if barrier.Exclude {
+				excluded = true
+				break
+			}
+			dwd.Distance += barrier.DetourKm
+		}
+		if excluded || dwd.Distance > radiusKm {
+			continue
+		}
+		kept = append(kept, dwd)
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].Distance < kept[j].Distance
+	})
+	return kept
+}
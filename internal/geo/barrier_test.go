@@ -0,0 +1,88 @@
+package geo
+
+import (
+	"math"
+	"testing"
+	"uber/internal/domain/entities"
+)
+
+// A north-south barrier east of the rider at 37.7750,-122.4180 (9q8yyk9):
+// the cell north of the rider (9q8yymc) is on the same bank, the cell about
+// 0.5 km east (9q8yykw) is across.
+func testBarrier() Barrier {
+	return Barrier{
+		Name:     "river",
+		SideA:    []string{"9q8yyk9", "9q8yymc"},
+		SideB:    []string{"9q8yykw"},
+		DetourKm: 3.0,
+	}
+}
+
+func withDistance(driverID string, lat, lon, distance float64) DriverWithDistance {
+	return DriverWithDistance{
+		Driver: &entities.DriverLocation{
+			DriverID: driverID,
+			Location: entities.Location{Latitude: lat, Longitude: lon},
+		},
+		Distance: distance,
+	}
+}
+
+func TestBarrier_Separates(t *testing.T) {
+	b := testBarrier()
+
+	if !b.Separates("9q8yyk9abc", "9q8yykwxyz") {
+		t.Error("Expected opposite sides to be separated")
+	}
+	if b.Separates("9q8yyk9abc", "9q8yymcxyz") {
+		t.Error("Expected the same side not to be separated")
+	}
+	if b.Separates("9q8yyk9abc", "9q9zzzzzzz") {
+		t.Error("Expected a point on neither side not to be separated")
+	}
+}
+
+func TestApplyBarriers_AcrossRanksBelowFartherSameSide(t *testing.T) {
+	results := []DriverWithDistance{
+		withDistance("across", 37.7750, -122.4120, 0.53),
+		withDistance("same-side", 37.7810, -122.4180, 0.67),
+	}
+
+	ranked := ApplyBarriers(results, 37.7750, -122.4180, 5.0, []Barrier{testBarrier()})
+
+	if len(ranked) != 2 {
+		t.Fatalf("Expected both drivers kept, got %d", len(ranked))
+	}
+	if ranked[0].Driver.DriverID != "same-side" {
+		t.Errorf("Expected same-side driver first, got %s", ranked[0].Driver.DriverID)
+	}
+	if math.Abs(ranked[1].Distance-3.53) > 1e-9 {
+		t.Errorf("Expected across driver at detour distance 3.53, got %v", ranked[1].Distance)
+	}
+}
+
+func TestApplyBarriers_DropsOutOfRangeAndExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		barrier  func(Barrier) Barrier
+		radiusKm float64
+	}{
+		{"Detour beyond radius", func(b Barrier) Barrier { return b }, 2.0},
+		{"Exclude", func(b Barrier) Barrier { b.Exclude = true; return b }, 5.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := []DriverWithDistance{
+				withDistance("across", 37.7750, -122.4120, 0.53),
+				withDistance("same-side", 37.7810, -122.4180, 0.67),
+			}
+
+			ranked := ApplyBarriers(results, 37.7750, -122.4180, tt.radiusKm, []Barrier{tt.barrier(testBarrier())})
+
+			if len(ranked) != 1 || ranked[0].Driver.DriverID != "same-side" {
+				t.Errorf("Expected only same-side driver, got %+v", ranked)
+			}
+		})
+	}
+}
@@ -11,15 +11,22 @@
 // Precision determines the cell size:
 //
 //	1 → ~5000 km    4 → ~39 km     7 → ~153 m    10 → ~1.2 m
-//	2 → ~1250 km    5 → ~5 km      8 → ~19 m     11 → ~15 cm
-//	3 → ~156 km     6 → ~1.2 km    9 → ~2.4 m    12 → ~1.9 cm
+//	2 → ~1250 km    5 → ~5 km      8 → ~38 m     11 → ~15 cm
+//	3 → ~156 km     6 → ~1.2 km    9 → ~4.8 m    12 → ~3.7 cm
 //
-// This project uses precision 6 (~1.2 km cells) — a good balance for
-// ride-sharing where drivers within a few kilometers are relevant.
+// The sizes are cell widths at the equator; cells are as tall as they are
+// wide at odd precisions and half as tall at even ones.
+//
+// This project stores drivers at precision 6 (~1.2 km cells) — a good balance
+// for ride-sharing where drivers within a few kilometers are relevant. Queries
+// may search at a different precision; see PrecisionForRadius. EncodeInt packs
+// the same bits into a uint64 for compact, range-scannable keys.
 package geo
 
 import (
+	"math"
 	"strings"
+	"uber/pkg/utils"
 )
 
 // base32 is the geohash character set (32 characters). Note that 'a', 'i',
@@ -36,9 +43,9 @@ var (
 	base32Map = map[byte]int{}
 	neighbors = map[string]map[byte]string{
 		"n": {'e': "p0r21436x8zb9dcf5h7kjnmqesgutwvy", 'o': "bc01fg45238967deuvhjyznpkmstqrwx"},
-		"s": {'e': "14365h7k9dcfesgujnmqp0r2twvyx8zb", 'o': "238967debc01teleuvhjyznpkmstqrwx"},
+		"s": {'e': "14365h7k9dcfesgujnmqp0r2twvyx8zb", 'o': "238967debc01fg45kmstqrwxuvhjyznp"},
 		"e": {'e': "bc01fg45238967deuvhjyznpkmstqrwx", 'o': "p0r21436x8zb9dcf5h7kjnmqesgutwvy"},
-		"w": {'e': "238967debc01fg45teleuvhjyznpkmstqrwx", 'o': "14365h7k9dcfesgujnmqp0r2twvyx8zb"},
+		"w": {'e': "238967debc01fg45kmstqrwxuvhjyznp", 'o': "14365h7k9dcfesgujnmqp0r2twvyx8zb"},
 	}
 	borders = map[string]map[byte]string{
 		"n": {'e': "prxz", 'o': "bcfguvyz"},
@@ -125,7 +132,7 @@ func Encode(lat, lon float64, precision int) string {
 
 // Decode converts a geohash string back to the center latitude and longitude
 // of the encoded cell. This is the inverse of Encode — it recovers the
-// bounding box by replaying the binary subdivision, then returns the center.
+// bounding box with DecodeBBox, then returns the center.
 //
 // Go Learning Note — Named Return Values:
 // The signature `(lat, lon float64)` uses named return values. This serves as
@@ -133,8 +140,19 @@ func Encode(lat, lon float64, precision int) string {
 // allows a bare `return` statement at the end. Named returns are idiomatic for
 // short functions, but for longer functions, explicit returns are often clearer.
 func Decode(hash string) (lat, lon float64) {
-	minLat, maxLat := -90.0, 90.0
-	minLon, maxLon := -180.0, 180.0
+	minLat, minLon, maxLat, maxLon := DecodeBBox(hash)
+	lat = (minLat + maxLat) / 2
+	lon = (minLon + maxLon) / 2
+	return
+}
+
+// DecodeBBox returns the bounds of the cell a geohash names, by replaying the
+// binary subdivision Encode performed. Unlike Decode, it keeps the cell's
+// size, so callers can tell how much ground a hash (or a 3x3 grid of them)
+// actually covers. Characters outside the geohash alphabet are skipped.
+func DecodeBBox(hash string) (minLat, minLon, maxLat, maxLon float64) {
+	minLat, maxLat = -90.0, 90.0
+	minLon, maxLon = -180.0, 180.0
 	isEven := true
 
 	for i := 0; i < len(hash); i++ {
@@ -164,9 +182,28 @@ func Decode(hash string) (lat, lon float64) {
 		}
 	}
 
-	lat = (minLat + maxLat) / 2
-	lon = (minLon + maxLon) / 2
-	return
+	return minLat, minLon, maxLat, maxLon
+}
+
+// kmPerDegree is the length of one degree of latitude (or of longitude at the
+// equator).
+var kmPerDegree = 2 * math.Pi * utils.EarthRadiusKm / 360
+
+// CellSizeKm returns the width and height in km of a geohash cell at the given
+// precision and latitude. Height is the same everywhere; width shrinks with
+// cos(lat) as meridians converge toward the poles.
+//
+// A geohash spends its bits alternately on longitude and latitude, starting
+// with longitude, so after 5×precision bits longitude has the extra one at odd
+// precisions — which is why odd-precision cells come out square at the equator.
+func CellSizeKm(precision int, lat float64) (widthKm, heightKm float64) {
+	precision = max(MinPrecision, min(precision, MaxPrecision))
+	bits := 5 * precision
+	lonBits, latBits := (bits+1)/2, bits/2
+
+	heightKm = 180 / math.Exp2(float64(latBits)) * kmPerDegree
+	widthKm = 360 / math.Exp2(float64(lonBits)) * kmPerDegree * math.Cos(lat*math.Pi/180)
+	return widthKm, heightKm
 }
 
 // Neighbor returns the geohash of the adjacent cell in the specified direction
@@ -184,9 +221,9 @@ func Neighbor(hash string, direction string) string {
 	lastChar := hash[len(hash)-1]
 	parent := hash[:len(hash)-1]
 
-	var t byte = 'e'
+	var t byte = 'o'
 	if len(hash)%2 == 0 {
-		t = 'o'
+		t = 'e'
 	}
 
 	if strings.ContainsRune(borders[direction][t], rune(lastChar)) && len(parent) > 0 {
@@ -219,3 +256,69 @@ func AllNeighbors(hash string) []string {
 		Neighbor(Neighbor(hash, "s"), "w"),
 	}
 }
+
+// NeighborRing returns every cell within ring steps of hash, not counting hash
+// itself: the 8 neighbors of AllNeighbors for ring 1, the 24 cells of a 5×5
+// block for ring 2, and so on. Cells are listed once even where neighbor
+// chains meet — around the antimeridian, or when the ring is wider than the
+// world at a coarse precision.
+func NeighborRing(hash string, ring int) []string {
+	grid := neighborGrid(hash, ring, ring)
+	cells := make([]string, 0, len(grid))
+	for _, cell := range grid {
+		if cell != hash {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// neighborGrid returns the cells up to latRings rows north and south of hash
+// and lonRings columns east and west of each row, hash included, without
+// duplicates.
+//
+// Neighbor wraps around in both directions, which is right for longitude (east
+// of 180° is -180°) but not for latitude: north of the northernmost row it
+// would jump to the south pole. So rows stop at the poles instead, and only
+// columns wrap.
+func neighborGrid(hash string, latRings, lonRings int) []string {
+	seen := make(map[string]struct{})
+	var cells []string
+	add := func(cell string) {
+		if _, dup := seen[cell]; !dup {
+			seen[cell] = struct{}{}
+			cells = append(cells, cell)
+		}
+	}
+
+	rows := []string{hash}
+	for _, direction := range []string{"n", "s"} {
+		row := hash
+		for i := 0; i < latRings && !atPole(row, direction); i++ {
+			row = Neighbor(row, direction)
+			rows = append(rows, row)
+		}
+	}
+
+	for _, row := range rows {
+		add(row)
+		for _, direction := range []string{"e", "w"} {
+			cell := row
+			for i := 0; i < lonRings; i++ {
+				cell = Neighbor(cell, direction)
+				add(cell)
+			}
+		}
+	}
+	return cells
+}
+
+// atPole reports whether hash is in the northernmost ("n") or southernmost
+// ("s") row of cells, so there is nothing further in that direction.
+func atPole(hash, direction string) bool {
+	minLat, _, maxLat, _ := DecodeBBox(hash)
+	if direction == "n" {
+		return maxLat >= 90
+	}
+	return minLat <= -90
+}
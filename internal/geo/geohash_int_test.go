@@ -0,0 +1,104 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+var intTestPoints = []struct {
+	name     string
+	lat, lon float64
+}{
+	{"san francisco", 37.7749, -122.4194},
+	{"new york", 40.7128, -74.0060},
+	{"sydney", -33.8688, 151.2093},
+	{"origin", 0, 0},
+	{"antimeridian", -16.5, 180},
+	{"south pole", -90, -180},
+}
+
+// stringToInt reads a geohash string as a base-32 number.
+func stringToInt(hash string) uint64 {
+	var n uint64
+	for i := 0; i < len(hash); i++ {
+		n = n<<5 | uint64(base32Map[hash[i]])
+	}
+	return n
+}
+
+func TestEncodeInt_MatchesStringGeohash(t *testing.T) {
+	for _, p := range intTestPoints {
+		full := EncodeInt(p.lat, p.lon, MaxIntBits)
+		for precision := MinPrecision; precision <= MaxPrecision; precision++ {
+			want := stringToInt(Encode(p.lat, p.lon, precision))
+			bits := uint(5 * precision)
+
+			if got := EncodeInt(p.lat, p.lon, bits); got != want {
+				t.Errorf("%s, precision %d: EncodeInt(%d bits) = %#x, string geohash is %#x", p.name, precision, bits, got, want)
+			}
+			if got := full >> (MaxIntBits - bits); got != want {
+				t.Errorf("%s, precision %d: top %d of 64 bits = %#x, string geohash is %#x", p.name, precision, bits, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeInt_RoundTrip(t *testing.T) {
+	for _, p := range intTestPoints {
+		for _, bits := range []uint{5, 30, 52, MaxIntBits} {
+			lat, lon := DecodeInt(EncodeInt(p.lat, p.lon, bits), bits)
+
+			// The center is at most half a cell from the original point.
+			latErr := 180 / math.Pow(2, float64(bits/2)) / 2
+			lonErr := 360 / math.Pow(2, float64((bits+1)/2)) / 2
+			if math.Abs(lat-p.lat) > latErr || math.Abs(lon-p.lon) > lonErr {
+				t.Errorf("%s, %d bits: decoded (%v, %v), more than a half cell from (%v, %v)", p.name, bits, lat, lon, p.lat, p.lon)
+			}
+		}
+
+		// At a whole number of characters the cell is the string geohash's.
+		hash := Encode(p.lat, p.lon, 6)
+		wantLat, wantLon := Decode(hash)
+		if lat, lon := DecodeInt(stringToInt(hash), 30); lat != wantLat || lon != wantLon {
+			t.Errorf("%s: DecodeInt = (%v, %v), Decode(%q) = (%v, %v)", p.name, lat, lon, hash, wantLat, wantLon)
+		}
+	}
+}
+
+func TestEncodeInt_ChildrenFormARange(t *testing.T) {
+	// Everything inside a precision-6 cell encodes at 64 bits into the cell's
+	// range, so an ordered store can find it with one range scan.
+	cell := EncodeInt(37.7749, -122.4194, 30)
+	lo, hi := cell<<34, (cell+1)<<34
+
+	for _, offset := range []float64{0, 0.001, -0.001, 0.002, 0.02, -0.02} {
+		h := EncodeInt(37.7749+offset, -122.4194+offset, MaxIntBits)
+		inside := Encode(37.7749+offset, -122.4194+offset, 6) == Encode(37.7749, -122.4194, 6)
+		if inRange := h >= lo && h < hi; inRange != inside {
+			t.Errorf("offset %v: in range = %v, in cell = %v", offset, inRange, inside)
+		}
+	}
+}
+
+func TestEncodeInt_ClampsBits(t *testing.T) {
+	if got, want := EncodeInt(37.7749, -122.4194, 0), EncodeInt(37.7749, -122.4194, 1); got != want {
+		t.Errorf("Expected 0 bits to clamp to 1: got %#x, want %#x", got, want)
+	}
+	if got, want := EncodeInt(37.7749, -122.4194, 100), EncodeInt(37.7749, -122.4194, MaxIntBits); got != want {
+		t.Errorf("Expected 100 bits to clamp to %d: got %#x, want %#x", MaxIntBits, got, want)
+	}
+}
+
+// BenchmarkEncodeInt and BenchmarkEncode_Precision12 encode the same 60 bits,
+// as an integer and as a 12-character string.
+func BenchmarkEncodeInt(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		EncodeInt(37.7749, -122.4194, 60)
+	}
+}
+
+func BenchmarkEncode_Precision12(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Encode(37.7749, -122.4194, 12)
+	}
+}
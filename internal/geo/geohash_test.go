@@ -90,6 +90,50 @@ func TestDecode(t *testing.T) {
 	}
 }
 
+func TestDecodeBBox_ContainsPointAndCenter(t *testing.T) {
+	lat, lon := 37.7749, -122.4194
+	for precision := MinPrecision; precision <= MaxPrecision; precision++ {
+		hash := Encode(lat, lon, precision)
+		minLat, minLon, maxLat, maxLon := DecodeBBox(hash)
+
+		if lat < minLat || lat > maxLat || lon < minLon || lon > maxLon {
+			t.Errorf("Precision %d: box [%f,%f]x[%f,%f] does not contain the encoded point",
+				precision, minLat, maxLat, minLon, maxLon)
+		}
+
+		centerLat, centerLon := Decode(hash)
+		if centerLat != (minLat+maxLat)/2 || centerLon != (minLon+maxLon)/2 {
+			t.Errorf("Precision %d: Decode (%f, %f) is not the box center", precision, centerLat, centerLon)
+		}
+	}
+}
+
+func TestDecodeBBox_WidthsMatchPrecisionTable(t *testing.T) {
+	// Cell widths at the equator, as documented at the top of geohash.go.
+	documentedKm := map[int]float64{
+		1: 5000, 2: 1250, 3: 156, 4: 39, 5: 5, 6: 1.2,
+		7: 0.153, 8: 0.038, 9: 0.0048, 10: 0.0012, 11: 0.00015, 12: 0.000037,
+	}
+	const kmPerDegree = 111.32 // At the equator, for both latitude and longitude.
+
+	for precision, want := range documentedKm {
+		minLat, minLon, maxLat, maxLon := DecodeBBox(Encode(0.1, 0.1, precision))
+		width := (maxLon - minLon) * kmPerDegree
+		height := (maxLat - minLat) * kmPerDegree
+
+		if math.Abs(width-want)/want > 0.1 {
+			t.Errorf("Precision %d: width %.6g km, documented ~%.6g km", precision, width, want)
+		}
+		expectedHeight := width
+		if precision%2 == 0 {
+			expectedHeight = width / 2
+		}
+		if math.Abs(height-expectedHeight) > 1e-9*width {
+			t.Errorf("Precision %d: height %.6g km, expected %.6g km", precision, height, expectedHeight)
+		}
+	}
+}
+
 func TestEncodeDecodeRoundTrip(t *testing.T) {
 	testCases := []struct {
 		lat float64
@@ -143,6 +187,62 @@ func TestNeighbor(t *testing.T) {
 	}
 }
 
+// spreadHashes encodes a grid of points around the globe at precisions 2 to
+// 8, so neighbor tests cover both hash parities and cell borders. Points stay
+// clear of the poles and the antimeridian, where neighbors wrap around.
+func spreadHashes() []string {
+	var hashes []string
+	for lat := -60.0; lat < 60; lat += 11.7 {
+		for lon := -150.0; lon < 150; lon += 23.3 {
+			for precision := 2; precision <= 8; precision++ {
+				hashes = append(hashes, Encode(lat, lon, precision))
+			}
+		}
+	}
+	return append(hashes, "9q8yyk", "9q8yy")
+}
+
+func TestNeighbor_RoundTrip(t *testing.T) {
+	opposite := map[string]string{"n": "s", "s": "n", "e": "w", "w": "e"}
+
+	for _, h := range spreadHashes() {
+		for direction, back := range opposite {
+			if got := Neighbor(Neighbor(h, direction), back); got != h {
+				t.Errorf("Neighbor(Neighbor(%s, %q), %q) = %s", h, direction, back, got)
+			}
+		}
+	}
+}
+
+func TestNeighbor_Direction(t *testing.T) {
+	for _, h := range spreadHashes() {
+		lat, lon := Decode(h)
+		for _, direction := range []string{"n", "s", "e", "w"} {
+			neighbor := Neighbor(h, direction)
+			if len(neighbor) != len(h) {
+				t.Fatalf("Neighbor(%s, %q) = %s changed length", h, direction, neighbor)
+			}
+			nLat, nLon := Decode(neighbor)
+
+			var ok bool
+			switch direction {
+			case "n":
+				ok = nLat > lat && nLon == lon
+			case "s":
+				ok = nLat < lat && nLon == lon
+			case "e":
+				ok = nLon > lon && nLat == lat
+			case "w":
+				ok = nLon < lon && nLat == lat
+			}
+			if !ok {
+				t.Errorf("Neighbor(%s, %q) = %s lies at (%f, %f), center is (%f, %f)",
+					h, direction, neighbor, nLat, nLon, lat, lon)
+			}
+		}
+	}
+}
+
 func TestAllNeighbors(t *testing.T) {
 	center := "9q8yyk"
 	neighbors := AllNeighbors(center)
@@ -167,6 +267,70 @@ func TestAllNeighbors(t *testing.T) {
 	}
 }
 
+func TestNeighborRing(t *testing.T) {
+	center := "9q8yyk"
+
+	ring1 := NeighborRing(center, 1)
+	if len(ring1) != 8 {
+		t.Fatalf("Expected 8 cells in ring 1, got %d", len(ring1))
+	}
+	inRing1 := make(map[string]bool)
+	for _, cell := range ring1 {
+		inRing1[cell] = true
+	}
+	for _, cell := range AllNeighbors(center)[1:] {
+		if !inRing1[cell] {
+			t.Errorf("Ring 1 is missing neighbor %s", cell)
+		}
+	}
+
+	ring2 := NeighborRing(center, 2)
+	if len(ring2) != 24 {
+		t.Errorf("Expected 24 cells in ring 2, got %d", len(ring2))
+	}
+	for _, cell := range ring2 {
+		if cell == center {
+			t.Error("Ring should not include the center cell")
+		}
+	}
+}
+
+func TestNeighborRing_Dedup(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		ring     int
+		maxCells int
+		farPole  string // the pole the ring must not wrap around to
+	}{
+		// Precision 1 has 8 columns; ring 4 reaches all the way around.
+		{"antimeridian wrap", Encode(0, 179, 1), 4, 4*8 - 1, ""},
+		// Top row: nothing to the north, and no jump to the south pole.
+		{"north pole", Encode(89, 0, 2), 2, 3*5 - 1, "s"},
+		{"south pole", Encode(-89, 0, 2), 2, 3*5 - 1, "n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells := NeighborRing(tt.hash, tt.ring)
+			seen := make(map[string]bool)
+			for _, cell := range cells {
+				if seen[cell] {
+					t.Errorf("Duplicate cell %s", cell)
+				}
+				seen[cell] = true
+
+				if tt.farPole != "" && atPole(cell, tt.farPole) {
+					t.Errorf("Cell %s wrapped around to the other pole", cell)
+				}
+			}
+			if len(cells) > tt.maxCells {
+				t.Errorf("Expected at most %d cells, got %d", tt.maxCells, len(cells))
+			}
+		})
+	}
+}
+
 func BenchmarkEncode(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		Encode(37.7749, -122.4194, 6)
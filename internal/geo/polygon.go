@@ -0,0 +1,113 @@
+package geo
+
+import (
+	"math"
+	"sort"
+	"uber/internal/domain/entities"
+)
+
+// maxPolygonCells caps the geohash grid used to prefilter a polygon's bounding
+// box, the same budget as the largest nearby-search grid.
+const maxPolygonCells = (2*maxQueryRings + 1) * (2*maxQueryRings + 1)
+
+// PointInPolygon reports whether (lat, lon) lies inside polygon, whose
+// vertices are given in order (either winding) and implicitly closed. Points
+// exactly on an edge may land on either side.
+//
+// It uses ray casting: cast a ray from the point due east and count how many
+// polygon edges it crosses. An odd count means the point is inside, since
+// every time the ray crosses an edge it passes from inside to outside or back.
+// This works for concave polygons too — a ray through a notch simply crosses
+// more edges, in pairs. Coordinates are treated as a flat plane, which is
+// fine at neighborhood scale but not for polygons spanning the antimeridian.
+//
+// Go Learning Note — The j := i Trick:
+// The loop walks edges as (vertex j, vertex i) with j trailing one behind i,
+// starting at j = len-1 so the first edge closes the polygon from the last
+// vertex back to the first. It avoids a special case for the wrap-around edge.
+func PointInPolygon(lat, lon float64, polygon []entities.Location) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		// Only edges that straddle the ray's latitude can cross it. The strict
+		// and non-strict comparisons make a vertex on the ray count for exactly
+		// one of its two edges.
+		if (a.Latitude > lat) == (b.Latitude > lat) {
+			continue
+		}
+		// Longitude where the edge crosses the ray's latitude.
+		crossLon := a.Longitude + (lat-a.Latitude)/(b.Latitude-a.Latitude)*(b.Longitude-a.Longitude)
+		if lon < crossLon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// FindDriversInPolygon returns the drivers inside polygon (see PointInPolygon),
+// sorted by driver ID. A polygon with fewer than three vertices has no inside
+// and returns nil.
+//
+// Like FindNearbyDrivers it filters coarse then fine: the polygon's bounding
+// box is covered with a grid of geohash cells, only drivers in those cells are
+// candidates, and each candidate gets the exact point-in-polygon test.
+func (s *SpatialIndex) FindDriversInPolygon(polygon []entities.Location) []*entities.DriverLocation {
+	if len(polygon) < 3 {
+		return nil
+	}
+
+	minLat, minLon := math.Inf(1), math.Inf(1)
+	maxLat, maxLon := math.Inf(-1), math.Inf(-1)
+	for _, vertex := range polygon {
+		minLat, maxLat = math.Min(minLat, vertex.Latitude), math.Max(maxLat, vertex.Latitude)
+		minLon, maxLon = math.Min(minLon, vertex.Longitude), math.Max(maxLon, vertex.Longitude)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cells, precision := bboxCells(minLat, minLon, maxLat, maxLon)
+
+	var found []*entities.DriverLocation
+	s.scanCells(cells, precision, func(drivers map[string]*entities.DriverLocation) {
+		for _, driver := range drivers {
+			if PointInPolygon(driver.Location.Latitude, driver.Location.Longitude, polygon) {
+				found = append(found, driver)
+			}
+		}
+	})
+
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].DriverID < found[j].DriverID
+	})
+	return found
+}
+
+// bboxCells returns the geohash cells covering a bounding box, at the finest
+// precision (up to 7, as in PrecisionForRadius) whose grid stays within
+// maxPolygonCells.
+func bboxCells(minLat, minLon, maxLat, maxLon float64) (map[string]struct{}, int) {
+	precision := 7
+	for ; precision > MinPrecision; precision-- {
+		cellMinLat, cellMinLon, cellMaxLat, cellMaxLon := DecodeBBox(Encode(minLat, minLon, precision))
+		latStep, lonStep := cellMaxLat-cellMinLat, cellMaxLon-cellMinLon
+		rows := math.Floor((maxLat-cellMinLat)/latStep) + 1
+		cols := math.Floor((maxLon-cellMinLon)/lonStep) + 1
+		if rows*cols <= maxPolygonCells {
+			break
+		}
+	}
+
+	// Step from the corner cell's center so every step lands inside a cell,
+	// and stop once a step's cell starts past the box.
+	cellMinLat, cellMinLon, cellMaxLat, cellMaxLon := DecodeBBox(Encode(minLat, minLon, precision))
+	latStep, lonStep := cellMaxLat-cellMinLat, cellMaxLon-cellMinLon
+
+	cells := make(map[string]struct{})
+	for lat := cellMinLat + latStep/2; lat-latStep/2 <= maxLat; lat += latStep {
+		for lon := cellMinLon + lonStep/2; lon-lonStep/2 <= maxLon; lon += lonStep {
+			cells[Encode(lat, lon, precision)] = struct{}{}
+		}
+	}
+	return cells, precision
+}
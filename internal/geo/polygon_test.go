@@ -0,0 +1,115 @@
+package geo
+
+import (
+	"testing"
+	"uber/internal/domain/entities"
+)
+
+func driverIDs(locations []*entities.DriverLocation) []string {
+	ids := make([]string, len(locations))
+	for i, loc := range locations {
+		ids[i] = loc.DriverID
+	}
+	return ids
+}
+
+func TestFindDriversInPolygon_Square(t *testing.T) {
+	index := NewSpatialIndex(6)
+
+	// A ~2.2 km square in San Francisco.
+	square := []entities.Location{
+		{Latitude: 37.77, Longitude: -122.43},
+		{Latitude: 37.77, Longitude: -122.41},
+		{Latitude: 37.79, Longitude: -122.41},
+		{Latitude: 37.79, Longitude: -122.43},
+	}
+
+	index.UpdateLocation("inside-center", 37.78, -122.42)
+	index.UpdateLocation("inside-corner", 37.7705, -122.4295)
+	index.UpdateLocation("outside-north", 37.7905, -122.42)
+	index.UpdateLocation("outside-east", 37.78, -122.4095)
+	index.UpdateLocation("far-away", 40.7128, -74.0060)
+
+	got := driverIDs(index.FindDriversInPolygon(square))
+	if len(got) != 2 || got[0] != "inside-center" || got[1] != "inside-corner" {
+		t.Errorf("Expected [inside-center inside-corner], got %v", got)
+	}
+}
+
+func TestFindDriversInPolygon_Concave(t *testing.T) {
+	index := NewSpatialIndex(6)
+
+	// A U shape: the notch between the arms is inside the bounding box (and
+	// its geohash cells) but outside the polygon.
+	u := []entities.Location{
+		{Latitude: 37.70, Longitude: -122.50},
+		{Latitude: 37.70, Longitude: -122.40},
+		{Latitude: 37.80, Longitude: -122.40},
+		{Latitude: 37.80, Longitude: -122.43},
+		{Latitude: 37.73, Longitude: -122.43},
+		{Latitude: 37.73, Longitude: -122.47},
+		{Latitude: 37.80, Longitude: -122.47},
+		{Latitude: 37.80, Longitude: -122.50},
+	}
+
+	index.UpdateLocation("left-arm", 37.78, -122.49)
+	index.UpdateLocation("right-arm", 37.78, -122.41)
+	index.UpdateLocation("base", 37.71, -122.45)
+	index.UpdateLocation("notch", 37.78, -122.45)
+
+	got := driverIDs(index.FindDriversInPolygon(u))
+	want := []string{"base", "left-arm", "right-arm"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, got)
+			break
+		}
+	}
+}
+
+func TestFindDriversInPolygon_Degenerate(t *testing.T) {
+	index := NewSpatialIndex(6)
+	index.UpdateLocation("driver-1", 37.78, -122.42)
+
+	line := []entities.Location{
+		{Latitude: 37.77, Longitude: -122.43},
+		{Latitude: 37.79, Longitude: -122.41},
+	}
+	if got := index.FindDriversInPolygon(line); got != nil {
+		t.Errorf("Expected nil for a two-vertex polygon, got %v", driverIDs(got))
+	}
+}
+
+func TestPointInPolygon_VertexOnRay(t *testing.T) {
+	// The eastward ray from (0, 0) passes exactly through the vertex at
+	// (0, 1). Counting it for both edges that meet there would flip the
+	// result back to "outside".
+	diamond := []entities.Location{
+		{Latitude: -1, Longitude: 0},
+		{Latitude: 0, Longitude: 1},
+		{Latitude: 1, Longitude: 0},
+		{Latitude: 0, Longitude: -1},
+	}
+	if !PointInPolygon(0, 0, diamond) {
+		t.Error("Expected the center of the diamond to be inside")
+	}
+	if PointInPolygon(0, -2, diamond) {
+		t.Error("Expected a point west of the diamond to be outside")
+	}
+}
+
+func TestBBoxCells_CoversLargeBox(t *testing.T) {
+	// ~45 km across: too big for precision 7, so the grid coarsens.
+	cells, precision := bboxCells(37.5, -122.6, 37.9, -122.1)
+	if len(cells) > maxPolygonCells {
+		t.Errorf("Expected at most %d cells, got %d", maxPolygonCells, len(cells))
+	}
+	for _, corner := range [][2]float64{{37.5, -122.6}, {37.5, -122.1}, {37.9, -122.6}, {37.9, -122.1}} {
+		if _, ok := cells[Encode(corner[0], corner[1], precision)]; !ok {
+			t.Errorf("Corner %v not covered at precision %d", corner, precision)
+		}
+	}
+}
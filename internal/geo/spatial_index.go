@@ -1,13 +1,28 @@
 package geo
 
 import (
+	"container/heap"
 	"context"
+	"errors"
+	"math"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 	"uber/internal/domain/entities"
 	"uber/pkg/utils"
 )
 
+// MinPrecision and MaxPrecision bound the geohash precisions the index accepts.
+const (
+	MinPrecision = 1
+	MaxPrecision = 12
+)
+
+// ErrInvalidPrecision is returned by Reindex for a precision outside
+// [MinPrecision, MaxPrecision].
+var ErrInvalidPrecision = errors.New("geohash precision out of range")
+
 // DriverWithDistance pairs a driver's location with their computed distance
 // from a search point. Used to return sorted proximity results.
 type DriverWithDistance struct {
@@ -17,8 +32,8 @@ type DriverWithDistance struct {
 
 // SpatialIndex is an in-memory geospatial data structure that enables fast
 // "find nearby drivers" queries. It organizes drivers into geohash cells,
-// so a proximity search only needs to check the center cell and its 8 neighbors
-// (9 cells total) instead of scanning every driver in the system.
+// so a proximity search only needs to check a small grid of cells around the
+// search point instead of scanning every driver in the system.
 //
 // Go Learning Note — sync.RWMutex:
 // RWMutex provides read-write locking. Multiple goroutines can hold a read lock
@@ -28,81 +43,236 @@ type DriverWithDistance struct {
 // sync.Mutex would serialize all reads unnecessarily.
 //
 // Go Learning Note — Nested Maps:
-// The drivers field is map[string]map[string]*DriverLocation — a two-level map.
-// The outer key is the geohash string (which cell), the inner key is driverID
-// (which driver in that cell). This gives O(1) lookup by both cell and driver.
-// Go maps must be initialized with make() before use; a nil map will panic on
-// write (but reads return the zero value).
+// Each shard's drivers field is map[string]map[string]*DriverLocation — a
+// two-level map. The outer key is the geohash string (which cell), the inner
+// key is driverID (which driver in that cell). This gives O(1) lookup by both
+// cell and driver. Go maps must be initialized with make() before use; a nil
+// map will panic on write (but reads return the zero value).
+//
+// Lock striping: with a single lock, every location ping in the city would
+// queue behind every other. Instead the cell map is split across indexShards
+// shards by a hash of the geohash, each with its own RWMutex, so pings in
+// different cells rarely contend. cellOf, the secondary index from driverID
+// to geohash that makes finding a driver's current cell one lookup, is
+// striped the same way by driverID. mu guards only the precision and is held
+// exclusively just by Reindex, which rebuilds every shard.
+//
+// Lock order, to rule out deadlock: mu, then a driver's cellOf stripe, then
+// cell shards in ascending shard order. Queries take no cellOf stripes and
+// read-lock all the cell shards they need up front, so a driver moving
+// between shards can't be seen twice or missed by one query.
 type SpatialIndex struct {
 	mu        sync.RWMutex
 	precision int
-	drivers   map[string]map[string]*entities.DriverLocation // geohash -> driverID -> location
+	cells     [indexShards]cellShard
+	owners    [indexShards]ownerShard
+}
+
+// indexShards is how many stripes the cell map and cellOf are each split
+// into. It only needs to comfortably exceed the number of cores pinging at
+// once.
+const indexShards = 64
+
+// cellShard holds the cells whose geohash hashes to it.
+type cellShard struct {
+	mu      sync.RWMutex
+	drivers map[string]map[string]*entities.DriverLocation // geohash -> driverID -> location
+}
+
+// ownerShard holds cellOf for the drivers whose ID hashes to it.
+type ownerShard struct {
+	mu     sync.Mutex
+	cellOf map[string]string // driverID -> geohash
 }
 
+// shardSet marks which of the cell shards an operation needs to lock.
+type shardSet [indexShards]bool
+
 // NewSpatialIndex creates an empty spatial index with the given geohash precision.
 func NewSpatialIndex(precision int) *SpatialIndex {
-	return &SpatialIndex{
-		precision: precision,
-		drivers:   make(map[string]map[string]*entities.DriverLocation),
+	s := &SpatialIndex{precision: precision}
+	s.resetShards()
+	return s
+}
+
+// resetShards empties every shard. The caller must hold mu exclusively (or
+// own the index outright, as the constructor does).
+func (s *SpatialIndex) resetShards() {
+	for i := range s.cells {
+		s.cells[i].drivers = make(map[string]map[string]*entities.DriverLocation)
+		s.owners[i].cellOf = make(map[string]string)
+	}
+}
+
+// shardOf maps a geohash or driver ID to a stripe with 32-bit FNV-1a.
+//
+// Go Learning Note — Type Sets:
+// The constraint string | []byte lets one generic function hash both a
+// string key and the reused byte buffer in scanChildren without converting
+// (and so copying) the buffer. Both types support len and indexing to a
+// byte, which is all the body uses.
+func shardOf[K string | []byte](key K) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % indexShards)
+}
+
+// lockCells write-locks the shards in set in ascending order and returns the
+// matching unlock.
+func (s *SpatialIndex) lockCells(set *shardSet) (unlock func()) {
+	for i := range set {
+		if set[i] {
+			s.cells[i].mu.Lock()
+		}
+	}
+	return func() {
+		for i := range set {
+			if set[i] {
+				s.cells[i].mu.Unlock()
+			}
+		}
 	}
 }
 
+// rlockCells is lockCells for readers.
+func (s *SpatialIndex) rlockCells(set *shardSet) (runlock func()) {
+	for i := range set {
+		if set[i] {
+			s.cells[i].mu.RLock()
+		}
+	}
+	return func() {
+		for i := range set {
+			if set[i] {
+				s.cells[i].mu.RUnlock()
+			}
+		}
+	}
+}
+
+// allShards is a shardSet with every shard in it.
+func allShards() *shardSet {
+	var set shardSet
+	for i := range set {
+		set[i] = true
+	}
+	return &set
+}
+
 // UpdateLocation updates a driver's position in the spatial index. If the driver
 // has moved to a different geohash cell, they're removed from the old cell and
 // added to the new one. This is called every time a driver sends a location ping.
 //
 // Go Learning Note — defer:
-// `defer s.mu.Unlock()` schedules the unlock to run when the function returns,
-// regardless of how it returns (normal return, early return, or even panic).
-// This prevents forgetting to unlock — a common source of deadlocks. The defer
-// pattern is idiomatic for any resource that needs cleanup: file handles,
-// database connections, mutexes, etc.
+// `defer owner.mu.Unlock()` schedules the unlock to run when the function
+// returns, regardless of how it returns (normal return, early return, or even
+// panic). This prevents forgetting to unlock — a common source of deadlocks.
+// The defer pattern is idiomatic for any resource that needs cleanup: file
+// handles, database connections, mutexes, etc. Deferred calls run last in,
+// first out, so the locks below are released in the reverse of the order
+// they were taken.
 func (s *SpatialIndex) UpdateLocation(driverID string, lat, lon float64) *entities.DriverLocation {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
 	geohash := Encode(lat, lon, s.precision)
+	location := entities.NewDriverLocation(driverID, lat, lon, geohash)
+
+	// The driver's cellOf stripe serializes pings from the same driver, so
+	// their old cell can't change underneath us.
+	owner := &s.owners[shardOf(driverID)]
+	owner.mu.Lock()
+	defer owner.mu.Unlock()
+
+	old, indexed := owner.cellOf[driverID]
+
+	// Lock the old and new cells' shards together, so the move from one to
+	// the other is atomic to queries.
+	var set shardSet
+	set[shardOf(geohash)] = true
+	if indexed {
+		set[shardOf(old)] = true
+	}
+	defer s.lockCells(&set)()
 
 	// Remove the driver from their previous geohash cell (if any).
-	// We iterate all cells because we don't track which cell the driver was in.
-	// With a secondary index (driverID → geohash), this could be O(1) instead
-	// of O(n) — a good optimization for production.
-	for gh, drivers := range s.drivers {
-		if _, exists := drivers[driverID]; exists {
-			delete(drivers, driverID)
-			if len(drivers) == 0 {
-				delete(s.drivers, gh) // Clean up empty cells to prevent memory leaks.
-			}
-			break
-		}
+	if indexed {
+		s.removeFromCell(driverID, old)
 	}
 
 	// Add to the new geohash cell, creating the cell map if needed.
-	if _, exists := s.drivers[geohash]; !exists {
-		s.drivers[geohash] = make(map[string]*entities.DriverLocation)
+	shard := &s.cells[shardOf(geohash)]
+	if _, exists := shard.drivers[geohash]; !exists {
+		shard.drivers[geohash] = make(map[string]*entities.DriverLocation)
 	}
-
-	location := entities.NewDriverLocation(driverID, lat, lon, geohash)
-	s.drivers[geohash][driverID] = location
+	shard.drivers[geohash][driverID] = location
+	owner.cellOf[driverID] = geohash
 
 	return location
 }
 
+// removeFromCell deletes a driver from one cell. The caller must hold that
+// cell's shard lock.
+func (s *SpatialIndex) removeFromCell(driverID, geohash string) {
+	shard := &s.cells[shardOf(geohash)]
+	drivers := shard.drivers[geohash]
+	delete(drivers, driverID)
+	if len(drivers) == 0 {
+		delete(shard.drivers, geohash) // Clean up empty cells to prevent memory leaks.
+	}
+}
+
 // RemoveDriver removes a driver from the spatial index entirely (e.g., when
 // they go offline).
 func (s *SpatialIndex) RemoveDriver(driverID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	for gh, drivers := range s.drivers {
-		if _, exists := drivers[driverID]; exists {
-			delete(drivers, driverID)
-			if len(drivers) == 0 {
-				delete(s.drivers, gh)
-			}
-			return
-		}
+	owner := &s.owners[shardOf(driverID)]
+	owner.mu.Lock()
+	defer owner.mu.Unlock()
+
+	geohash, indexed := owner.cellOf[driverID]
+	if !indexed {
+		return
+	}
+	delete(owner.cellOf, driverID)
+
+	shard := &s.cells[shardOf(geohash)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	s.removeFromCell(driverID, geohash)
+}
+
+// RemoveDriverIfStale removes a driver from the index like RemoveDriver, but
+// only if their indexed location was last updated before cutoff. It reports
+// whether it did; a ping that has reached the index since the driver was
+// found stale keeps them.
+func (s *SpatialIndex) RemoveDriverIfStale(driverID string, cutoff time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	owner := &s.owners[shardOf(driverID)]
+	owner.mu.Lock()
+	defer owner.mu.Unlock()
+
+	geohash, indexed := owner.cellOf[driverID]
+	if !indexed {
+		return false
 	}
+
+	shard := &s.cells[shardOf(geohash)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if location := shard.drivers[geohash][driverID]; location != nil && !location.UpdatedAt.Before(cutoff) {
+		return false
+	}
+	delete(owner.cellOf, driverID)
+	s.removeFromCell(driverID, geohash)
+	return true
 }
 
 // GetDriverLocation returns the current location of a driver, or nil if not
@@ -111,54 +281,167 @@ func (s *SpatialIndex) GetDriverLocation(driverID string) *entities.DriverLocati
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	for _, drivers := range s.drivers {
-		if loc, exists := drivers[driverID]; exists {
-			return loc
+	owner := &s.owners[shardOf(driverID)]
+	owner.mu.Lock()
+	defer owner.mu.Unlock()
+
+	geohash, indexed := owner.cellOf[driverID]
+	if !indexed {
+		return nil
+	}
+
+	shard := &s.cells[shardOf(geohash)]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.drivers[geohash][driverID]
+}
+
+// FindNearbyDrivers finds all drivers within a given radius (in km) from a
+// point, searching at the precision PrecisionForRadius picks for that radius.
+// See FindNearbyDriversWithPrecision for how the search works.
+func (s *SpatialIndex) FindNearbyDrivers(ctx context.Context, lat, lon float64, radiusKm float64) []DriverWithDistance {
+	return s.FindNearbyDriversWithPrecision(ctx, lat, lon, radiusKm, PrecisionForRadius(radiusKm))
+}
+
+// maxQueryRings caps how many rings of cells a query grid extends around its
+// center cell (8 rings = a 17×17 grid). A precision too fine for the radius is
+// coarsened until the grid fits, so an explicit precision can't blow up into
+// millions of cell lookups.
+const maxQueryRings = 8
+
+// PrecisionForRadius picks the query precision for a search radius: the finest
+// precision whose cells are at least half the radius on their short side at
+// the equator, so the search grid is at most 5×5 cells there. Using the cell
+// sizes from CellSizeKm, that works out to:
+//
+//	radius ≤ 0.3 km  → 7 (153 m × 153 m cells)
+//	radius ≤ 1.2 km  → 6 (1.2 km × 0.6 km)
+//	radius ≤ 9.8 km  → 5 (4.9 km × 4.9 km)
+//	radius ≤ 39 km   → 4 (39 km × 19.5 km)
+//	radius ≤ 313 km  → 3 (156 km × 156 km)
+//	radius ≤ 1250 km → 2 (1250 km × 625 km)
+//	larger           → 1
+//
+// Finer precisions are never chosen: below ~150 m the grid lookups cost more
+// than the few extra distance checks a coarser cell brings.
+func PrecisionForRadius(radiusKm float64) int {
+	for precision := 7; precision > MinPrecision; precision-- {
+		width, height := CellSizeKm(precision, 0)
+		if min(width, height) >= radiusKm/2 {
+			return precision
 		}
 	}
-	return nil
+	return MinPrecision
+}
+
+// IndexPrecision returns the precision to store drivers at: configured when
+// it is a valid precision, otherwise the one PrecisionForRadius picks for the
+// usual search radius, so the stored cells and the searches over them can't
+// drift apart.
+func IndexPrecision(configured int, searchRadiusKm float64) int {
+	if configured >= MinPrecision && configured <= MaxPrecision {
+		return configured
+	}
+	return PrecisionForRadius(searchRadiusKm)
+}
+
+// queryCells returns the geohash cells that together cover every point within
+// radiusKm of (lat, lon), along with the precision they are at — precision,
+// or coarser if the grid would exceed maxQueryRings. The grid is centered on
+// the query point's cell and extends enough rings of neighbors in each
+// direction to cover the radius (see neighborGrid); longitude rings are sized
+// at the poleward edge of the search, where cells are narrowest.
+func queryCells(lat, lon, radiusKm float64, precision int) (map[string]struct{}, int) {
+	edgeLat := math.Min(math.Abs(lat)+radiusKm/kmPerDegree, 89.0)
+
+	var latRings, lonRings int
+	for {
+		bits := 5 * precision
+		lonCells, latCells := math.Exp2(float64((bits+1)/2)), math.Exp2(float64(bits/2))
+		width, _ := CellSizeKm(precision, edgeLat)
+		_, height := CellSizeKm(precision, 0)
+
+		// Rings past half the cells around the globe would only revisit
+		// cells, so the counts stop there.
+		latRingsF := math.Min(math.Ceil(radiusKm/height), math.Ceil(latCells/2))
+		lonRingsF := math.Min(math.Ceil(radiusKm/width), math.Ceil(lonCells/2))
+		if (latRingsF <= maxQueryRings && lonRingsF <= maxQueryRings) || precision == MinPrecision {
+			latRings, lonRings = int(latRingsF), int(lonRingsF)
+			break
+		}
+		precision--
+	}
+
+	grid := neighborGrid(Encode(lat, lon, precision), latRings, lonRings)
+	cells := make(map[string]struct{}, len(grid))
+	for _, cell := range grid {
+		cells[cell] = struct{}{}
+	}
+	return cells, precision
+}
+
+// SearchCells returns the geohash cells FindNearbyDrivers scans for a search
+// of radiusKm around (lat, lon), sorted, and the precision they are at. The
+// first is not necessarily the center cell; that is Encode(lat, lon,
+// precision).
+func SearchCells(lat, lon, radiusKm float64) ([]string, int) {
+	cellSet, precision := queryCells(lat, lon, radiusKm, PrecisionForRadius(radiusKm))
+	cells := make([]string, 0, len(cellSet))
+	for cell := range cellSet {
+		cells = append(cells, cell)
+	}
+	sort.Strings(cells)
+	return cells, precision
 }
 
-// FindNearbyDrivers finds all drivers within a given radius (in km) from a point.
+// FindNearbyDriversWithPrecision finds all drivers within radiusKm of a point,
+// searching a grid of geohash cells at the given precision. A precision of 0
+// or less means "pick one for the radius" (see PrecisionForRadius).
 //
 // Strategy: Coarse filter → Fine filter
-//  1. Coarse: Compute the geohash of the search point, then get all 9 cells
-//     (center + 8 neighbors). Only scan drivers in those cells.
+//  1. Coarse: Encode the search point at the query precision and take enough
+//     rings of neighboring cells around it to cover the radius. Only scan
+//     drivers in those cells.
 //  2. Fine: For each candidate, compute the exact Haversine distance and
 //     filter to those within the radius.
 //  3. Sort results by distance (nearest first).
 //
-// This two-phase approach is dramatically faster than computing distances to
-// every driver in the system.
+// The query precision is independent of the index's storage precision; see
+// scanCells for how the two are reconciled.
 //
 // Go Learning Note — sort.Slice:
 // sort.Slice sorts a slice in-place using a provided less function. The less
 // function takes two indices and returns true if element i should come before
 // element j. This is more flexible than sort.Sort (which requires implementing
 // the sort.Interface with Len/Less/Swap methods on a named type).
-func (s *SpatialIndex) FindNearbyDrivers(ctx context.Context, lat, lon float64, radiusKm float64) []DriverWithDistance {
+func (s *SpatialIndex) FindNearbyDriversWithPrecision(ctx context.Context, lat, lon, radiusKm float64, precision int) []DriverWithDistance {
+	if precision < MinPrecision {
+		precision = PrecisionForRadius(radiusKm)
+	}
+	if precision > MaxPrecision {
+		precision = MaxPrecision
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	centerGeohash := Encode(lat, lon, s.precision)
-	neighborGeohashes := AllNeighbors(centerGeohash)
+	cells, precision := queryCells(lat, lon, radiusKm, precision)
 
 	var candidates []DriverWithDistance
-
-	for _, gh := range neighborGeohashes {
-		if drivers, exists := s.drivers[gh]; exists {
-			for _, driver := range drivers {
-				distance := utils.HaversineDistance(lat, lon, driver.Location.Latitude, driver.Location.Longitude)
-				if distance <= radiusKm {
-					candidates = append(candidates, DriverWithDistance{
-						Driver:   driver,
-						Distance: distance,
-					})
-				}
+	scan := func(drivers map[string]*entities.DriverLocation) {
+		for _, driver := range drivers {
+			distance := utils.HaversineDistance(lat, lon, driver.Location.Latitude, driver.Location.Longitude)
+			if distance <= radiusKm {
+				candidates = append(candidates, DriverWithDistance{
+					Driver:   driver,
+					Distance: distance,
+				})
 			}
 		}
 	}
 
+	s.scanCells(cells, precision, scan)
+
 	// Sort by distance so the matching service can try the nearest driver first.
 	sort.Slice(candidates, func(i, j int) bool {
 		return candidates[i].Distance < candidates[j].Distance
@@ -167,6 +450,139 @@ func (s *SpatialIndex) FindNearbyDrivers(ctx context.Context, lat, lon float64,
 	return candidates
 }
 
+// FindNearestDrivers returns the k drivers closest to a point within radiusKm,
+// nearest first — the same as the first k results of FindNearbyDrivers, but
+// without building and sorting the full candidate list.
+//
+// Go Learning Note — container/heap:
+// container/heap turns any type implementing heap.Interface (sort.Interface
+// plus Push and Pop) into a binary heap. Keeping a max-heap of the best k seen
+// so far means the worst of them is always at index 0: a new candidate only
+// needs comparing against it, and replacing it costs O(log k). Scanning n
+// candidates is O(n log k) instead of the O(n log n) of a full sort.
+func (s *SpatialIndex) FindNearestDrivers(ctx context.Context, lat, lon, radiusKm float64, k int) []DriverWithDistance {
+	if k <= 0 {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cells, precision := queryCells(lat, lon, radiusKm, PrecisionForRadius(radiusKm))
+
+	nearest := make(farthestFirst, 0, k)
+	s.scanCells(cells, precision, func(drivers map[string]*entities.DriverLocation) {
+		for _, driver := range drivers {
+			distance := utils.HaversineDistance(lat, lon, driver.Location.Latitude, driver.Location.Longitude)
+			if distance > radiusKm {
+				continue
+			}
+			candidate := DriverWithDistance{Driver: driver, Distance: distance}
+			if len(nearest) < k {
+				heap.Push(&nearest, candidate)
+			} else if distance < nearest[0].Distance {
+				nearest[0] = candidate
+				heap.Fix(&nearest, 0)
+			}
+		}
+	})
+
+	// Popping a max-heap yields farthest first, so fill the result from the
+	// back to end up nearest-first.
+	result := make([]DriverWithDistance, len(nearest))
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = heap.Pop(&nearest).(DriverWithDistance)
+	}
+	return result
+}
+
+// farthestFirst is a max-heap of search results by distance, for
+// FindNearestDrivers.
+type farthestFirst []DriverWithDistance
+
+func (h farthestFirst) Len() int           { return len(h) }
+func (h farthestFirst) Less(i, j int) bool { return h[i].Distance > h[j].Distance }
+func (h farthestFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *farthestFirst) Push(x any) { *h = append(*h, x.(DriverWithDistance)) }
+
+func (h *farthestFirst) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// scanCells calls scan with the drivers of every stored cell that lies inside
+// one of cells, which are all at the given precision. The caller must hold mu
+// for reading; scanCells read-locks the cell shards itself, all of them for
+// the duration of the scan, and calls scan with them held.
+//
+// Query cells finer than the stored ones are truncated to the stored cell that
+// contains them; coarser ones are expanded into their stored children, or
+// matched by prefix against the occupied cells when that is fewer lookups.
+func (s *SpatialIndex) scanCells(cells map[string]struct{}, precision int, scan func(map[string]*entities.DriverLocation)) {
+	depth := s.precision - precision
+	if depth <= 0 {
+		// Several query cells can share one stored cell; scan it once.
+		stored := make(map[string]struct{}, len(cells))
+		var set shardSet
+		for cell := range cells {
+			gh := cell[:s.precision]
+			stored[gh] = struct{}{}
+			set[shardOf(gh)] = true
+		}
+		defer s.rlockCells(&set)()
+		for gh := range stored {
+			scan(s.cells[shardOf(gh)].drivers[gh])
+		}
+		return
+	}
+
+	// Children and prefixes can land in any shard.
+	defer s.rlockCells(allShards())()
+
+	occupied := 0
+	for i := range s.cells {
+		occupied += len(s.cells[i].drivers)
+	}
+
+	if math.Pow(32, float64(depth))*float64(len(cells)) <= float64(occupied) {
+		buf := make([]byte, 0, s.precision)
+		for cell := range cells {
+			s.scanChildren(append(buf[:0], cell...), depth, scan)
+		}
+		return
+	}
+
+	for i := range s.cells {
+		for gh, drivers := range s.cells[i].drivers {
+			if _, ok := cells[gh[:precision]]; ok {
+				scan(drivers)
+			}
+		}
+	}
+}
+
+// scanChildren calls scan with the drivers of every stored cell depth
+// characters finer than the cell in buf. The caller must hold every shard's
+// read lock.
+//
+// Go Learning Note — map[string(bytes)]:
+// Converting a []byte to a string normally copies it, but the compiler skips
+// the copy when the conversion appears directly as a map index. Building child
+// cells in one reused buffer therefore costs no allocations per lookup, where
+// parent+string(c) would allocate a new string for each of the 32^depth cells.
+func (s *SpatialIndex) scanChildren(buf []byte, depth int, scan func(map[string]*entities.DriverLocation)) {
+	if depth == 0 {
+		scan(s.cells[shardOf(buf)].drivers[string(buf)])
+		return
+	}
+	for i := 0; i < len(base32); i++ {
+		s.scanChildren(append(buf, base32[i]), depth-1, scan)
+	}
+}
+
 // FindNearbyDriverIDs returns just the driver IDs within range, sorted by distance.
 // This is a convenience wrapper when you only need IDs, not full location data.
 //
@@ -185,14 +601,142 @@ func (s *SpatialIndex) FindNearbyDriverIDs(ctx context.Context, lat, lon float64
 	return ids
 }
 
-// Count returns the total number of drivers in the index.
+// Precision returns the geohash precision the index currently uses.
+func (s *SpatialIndex) Precision() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.precision
+}
+
+// Reindex switches the index to a new geohash precision. Entries stored at the
+// old precision would otherwise be unreachable, since queries encode the search
+// point at the new precision and look up cells that don't exist. Every driver's
+// geohash is recomputed from their stored coordinates and the cell map is
+// rebuilt, all under the write lock so no query sees a half-migrated index.
+//
+// Fresh DriverLocation values are created rather than updating Geohash in
+// place, because callers may still hold pointers returned by earlier queries.
+// UpdatedAt is carried over — re-homing a driver is not a location update.
+func (s *SpatialIndex) Reindex(newPrecision int) error {
+	if newPrecision < MinPrecision || newPrecision > MaxPrecision {
+		return ErrInvalidPrecision
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var moved []*entities.DriverLocation
+	for i := range s.cells {
+		for _, drivers := range s.cells[i].drivers {
+			for _, old := range drivers {
+				location := *old
+				location.Geohash = Encode(old.Location.Latitude, old.Location.Longitude, newPrecision)
+				moved = append(moved, &location)
+			}
+		}
+	}
+
+	s.rebuild(moved)
+	s.precision = newPrecision
+	return nil
+}
+
+// rebuild replaces the index contents with locations, whose Geohash must
+// already be at the index precision. If a driver appears more than once, the
+// last entry wins. The caller must hold mu exclusively, which shuts out every
+// other operation, so the shards are rebuilt without their own locks.
+func (s *SpatialIndex) rebuild(locations []*entities.DriverLocation) {
+	s.resetShards()
+	for _, location := range locations {
+		owner := &s.owners[shardOf(location.DriverID)]
+		if old, indexed := owner.cellOf[location.DriverID]; indexed {
+			s.removeFromCell(location.DriverID, old)
+		}
+
+		shard := &s.cells[shardOf(location.Geohash)]
+		if _, exists := shard.drivers[location.Geohash]; !exists {
+			shard.drivers[location.Geohash] = make(map[string]*entities.DriverLocation)
+		}
+		shard.drivers[location.Geohash][location.DriverID] = location
+		owner.cellOf[location.DriverID] = location.Geohash
+	}
+}
+
+// Snapshot returns a copy of every indexed driver location, sorted by driver
+// ID, for persisting the index across a restart (see Restore). The values are
+// copies, so the caller can hold or serialize them without racing with
+// further updates.
+func (s *SpatialIndex) Snapshot() []entities.DriverLocation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defer s.rlockCells(allShards())()
+
+	var locations []entities.DriverLocation
+	for i := range s.cells {
+		for _, drivers := range s.cells[i].drivers {
+			for _, location := range drivers {
+				locations = append(locations, *location)
+			}
+		}
+	}
+
+	sort.Slice(locations, func(i, j int) bool {
+		return locations[i].DriverID < locations[j].DriverID
+	})
+	return locations
+}
+
+// Restore replaces the index contents with a Snapshot, e.g. one reloaded from
+// disk on boot, so matching can see drivers before they ping again. Geohashes
+// are recomputed at this index's precision, which need not match the one the
+// snapshot was taken at; UpdatedAt is kept, so drivers who were already stale
+// stay stale. Like Reindex, the whole swap happens under the write lock and no
+// query sees a partly restored index.
+func (s *SpatialIndex) Restore(locations []entities.DriverLocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	restored := make([]*entities.DriverLocation, len(locations))
+	for i := range locations {
+		location := locations[i]
+		location.Geohash = Encode(location.Location.Latitude, location.Location.Longitude, s.precision)
+		restored[i] = &location
+	}
+	s.rebuild(restored)
+}
+
+// CountInCell returns the number of indexed drivers whose geohash starts with
+// cell. Passing a cell coarser than the index precision counts every indexed
+// cell inside it, so callers can aggregate at their own granularity.
+func (s *SpatialIndex) CountInCell(cell string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defer s.rlockCells(allShards())()
+
+	count := 0
+	for i := range s.cells {
+		for gh, drivers := range s.cells[i].drivers {
+			if strings.HasPrefix(gh, cell) {
+				count += len(drivers)
+			}
+		}
+	}
+	return count
+}
+
+// Count returns the total number of drivers in the index. Each stripe is
+// counted under its own lock, so with pings arriving the total is a
+// near-instant snapshot rather than an exact one.
 func (s *SpatialIndex) Count() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	count := 0
-	for _, drivers := range s.drivers {
-		count += len(drivers)
+	for i := range s.owners {
+		owner := &s.owners[i]
+		owner.mu.Lock()
+		count += len(owner.cellOf)
+		owner.mu.Unlock()
 	}
 	return count
 }
@@ -2,7 +2,14 @@ package geo
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"uber/internal/domain/entities"
+	"uber/pkg/utils"
 )
 
 func TestSpatialIndex_UpdateLocation(t *testing.T) {
@@ -148,6 +155,66 @@ func TestSpatialIndex_UpdateLocationMovesDriver(t *testing.T) {
 	}
 }
 
+func TestSpatialIndex_CellOfStaysConsistent(t *testing.T) {
+	index := NewSpatialIndex(6)
+
+	index.UpdateLocation("driver-1", 37.7749, -122.4194)
+	index.UpdateLocation("driver-1", 40.7128, -74.0060) // moves across the country
+	index.UpdateLocation("driver-2", 40.7128, -74.0060)
+	index.RemoveDriver("driver-2")
+	index.RemoveDriver("driver-unknown")
+
+	owned, cells := 0, 0
+	for i := range index.owners {
+		owned += len(index.owners[i].cellOf)
+		cells += len(index.cells[i].drivers)
+	}
+	if owned != 1 || cells != 1 {
+		t.Fatalf("Expected one driver in one cell, got cellOf entries=%d cells=%d", owned, cells)
+	}
+	gh := index.owners[shardOf("driver-1")].cellOf["driver-1"]
+	if index.cells[shardOf(gh)].drivers[gh]["driver-1"] == nil {
+		t.Errorf("cellOf points driver-1 at %s, but the cell doesn't hold them", gh)
+	}
+	if loc := index.GetDriverLocation("driver-2"); loc != nil {
+		t.Errorf("Expected removed driver to be gone, got %+v", loc)
+	}
+}
+
+// TestSpatialIndex_ConcurrentUpdatesAcrossShards moves drivers between cells
+// (and so between shards) from many goroutines while querying, then checks
+// the aggregates. Run with -race to check the locking too.
+func TestSpatialIndex_ConcurrentUpdatesAcrossShards(t *testing.T) {
+	index := NewSpatialIndex(6)
+	const workers, perWorker = 8, 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := fmt.Sprintf("driver-%d-%d", w, i)
+				// Zig-zag each driver through a few cells a few hundred
+				// meters apart, ending at the last one.
+				for step := 0; step < 4; step++ {
+					index.UpdateLocation(id, 37.75+float64(step)*0.005, -122.45+float64(i)*0.001)
+					index.FindNearbyDrivers(context.Background(), 37.76, -122.43, 3)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := index.Count(); got != workers*perWorker {
+		t.Errorf("Expected count %d, got %d", workers*perWorker, got)
+	}
+	nearby := index.FindNearbyDrivers(context.Background(), 37.765, -122.425, 5)
+	if len(nearby) != workers*perWorker {
+		t.Errorf("Expected all %d drivers nearby, got %d", workers*perWorker, len(nearby))
+	}
+}
+
 func TestSpatialIndex_Count(t *testing.T) {
 	index := NewSpatialIndex(6)
 
@@ -170,6 +237,316 @@ func TestSpatialIndex_Count(t *testing.T) {
 	}
 }
 
+func TestSpatialIndex_Reindex(t *testing.T) {
+	index := NewSpatialIndex(6)
+	ctx := context.Background()
+
+	index.UpdateLocation("driver-1", 37.7750, -122.4180)
+	index.UpdateLocation("driver-2", 37.7755, -122.4176) // ~65 m away
+	index.UpdateLocation("driver-3", 40.7128, -74.0060)  // New York
+
+	if err := index.Reindex(7); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+
+	if index.Precision() != 7 {
+		t.Errorf("Expected precision 7, got %d", index.Precision())
+	}
+	if index.Count() != 3 {
+		t.Errorf("Expected count 3 after reindex, got %d", index.Count())
+	}
+
+	loc := index.GetDriverLocation("driver-1")
+	if loc == nil || len(loc.Geohash) != 7 {
+		t.Fatalf("Expected driver-1 to be re-homed at precision 7, got %+v", loc)
+	}
+
+	ids := index.FindNearbyDriverIDs(ctx, 37.7750, -122.4180, 1.0)
+	if len(ids) != 2 {
+		t.Errorf("Expected 2 drivers found after reindex, got %v", ids)
+	}
+
+	// Updates after the reindex land at the new precision too.
+	moved := index.UpdateLocation("driver-3", 37.7750, -122.4195)
+	if len(moved.Geohash) != 7 {
+		t.Errorf("Expected new updates at precision 7, got geohash %s", moved.Geohash)
+	}
+}
+
+func TestSpatialIndex_ReindexInvalidPrecision(t *testing.T) {
+	index := NewSpatialIndex(6)
+	index.UpdateLocation("driver-1", 37.7749, -122.4194)
+
+	for _, precision := range []int{0, 13} {
+		if err := index.Reindex(precision); err != ErrInvalidPrecision {
+			t.Errorf("Reindex(%d): expected ErrInvalidPrecision, got %v", precision, err)
+		}
+	}
+
+	if index.Precision() != 6 {
+		t.Errorf("Expected precision to remain 6, got %d", index.Precision())
+	}
+}
+
+func TestSpatialIndex_SnapshotRestoreRoundTrip(t *testing.T) {
+	original := NewSpatialIndex(6)
+	for i := 0; i < 200; i++ {
+		// A 20x10 grid ~100 m apart, so no two drivers tie on distance.
+		original.UpdateLocation(fmt.Sprintf("driver-%03d", i), 37.75+float64(i%20)*0.0009, -122.45+float64(i/20)*0.0011)
+	}
+	original.UpdateLocation("driver-far", 40.7128, -74.0060)
+	snapshot := original.Snapshot()
+
+	if len(snapshot) != 201 {
+		t.Fatalf("Expected 201 locations in the snapshot, got %d", len(snapshot))
+	}
+	if !sort.SliceIsSorted(snapshot, func(i, j int) bool { return snapshot[i].DriverID < snapshot[j].DriverID }) {
+		t.Error("Expected the snapshot sorted by driver ID")
+	}
+
+	restored := NewSpatialIndex(6)
+	restored.UpdateLocation("driver-gone", 37.76, -122.44) // replaced by the restore
+	restored.Restore(snapshot)
+
+	if restored.Count() != original.Count() {
+		t.Errorf("Expected count %d after restore, got %d", original.Count(), restored.Count())
+	}
+	if loc := restored.GetDriverLocation("driver-gone"); loc != nil {
+		t.Errorf("Expected Restore to replace existing entries, still have %+v", loc)
+	}
+
+	ctx := context.Background()
+	for _, point := range [][3]float64{{37.755, -122.445, 0.5}, {37.76, -122.44, 2}, {37.7, -122.5, 10}, {40.71, -74.0, 1}} {
+		want := original.FindNearbyDrivers(ctx, point[0], point[1], point[2])
+		got := restored.FindNearbyDrivers(ctx, point[0], point[1], point[2])
+		if len(got) != len(want) {
+			t.Errorf("%v: expected %d drivers, got %d", point, len(want), len(got))
+			continue
+		}
+		for i := range want {
+			if *got[i].Driver != *want[i].Driver || got[i].Distance != want[i].Distance {
+				t.Errorf("%v: result %d differs: got %+v at %v, want %+v at %v",
+					point, i, *got[i].Driver, got[i].Distance, *want[i].Driver, want[i].Distance)
+				break
+			}
+		}
+	}
+
+	// Snapshots are copies: changing the index afterwards leaves them alone.
+	original.UpdateLocation("driver-000", 0, 0)
+	if snapshot[0].DriverID != "driver-000" || snapshot[0].Location.Latitude == 0 {
+		t.Errorf("Expected the snapshot to be unaffected by later updates, got %+v", snapshot[0])
+	}
+}
+
+func TestSpatialIndex_RestoreAtDifferentPrecision(t *testing.T) {
+	original := NewSpatialIndex(6)
+	original.UpdateLocation("driver-1", 37.7749, -122.4194)
+	snapshot := original.Snapshot()
+
+	restored := NewSpatialIndex(7)
+	restored.Restore(snapshot)
+
+	loc := restored.GetDriverLocation("driver-1")
+	if loc == nil || loc.Geohash != Encode(37.7749, -122.4194, 7) {
+		t.Fatalf("Expected driver-1 re-encoded at precision 7, got %+v", loc)
+	}
+	if !loc.UpdatedAt.Equal(snapshot[0].UpdatedAt) {
+		t.Errorf("Expected UpdatedAt to be kept, got %v want %v", loc.UpdatedAt, snapshot[0].UpdatedAt)
+	}
+	if nearby := restored.FindNearbyDrivers(context.Background(), 37.775, -122.419, 0.2); len(nearby) != 1 {
+		t.Errorf("Expected driver-1 to be found after restore, got %d drivers", len(nearby))
+	}
+}
+
+func TestSpatialIndex_FindNearestDrivers(t *testing.T) {
+	index := NewSpatialIndex(6)
+	ctx := context.Background()
+
+	// 50 drivers spiralling outward, inserted in scrambled order.
+	for i := 0; i < 50; i++ {
+		n := (i * 17) % 50
+		angle := float64(n) * 0.7
+		dist := 0.0005 * float64(n+1)
+		index.UpdateLocation(fmt.Sprintf("driver-%02d", n), 37.7749+dist*math.Sin(angle), -122.4194+dist*math.Cos(angle))
+	}
+
+	all := index.FindNearbyDrivers(ctx, 37.7749, -122.4194, 5.0)
+	nearest := index.FindNearestDrivers(ctx, 37.7749, -122.4194, 5.0, 5)
+
+	if len(nearest) != 5 {
+		t.Fatalf("Expected 5 drivers, got %d", len(nearest))
+	}
+	for i, d := range nearest {
+		if d.Driver.DriverID != all[i].Driver.DriverID {
+			t.Errorf("Position %d: expected %s, got %s", i, all[i].Driver.DriverID, d.Driver.DriverID)
+		}
+		if want := fmt.Sprintf("driver-%02d", i); d.Driver.DriverID != want {
+			t.Errorf("Position %d: expected %s, got %s", i, want, d.Driver.DriverID)
+		}
+	}
+
+	if got := index.FindNearestDrivers(ctx, 37.7749, -122.4194, 5.0, 100); len(got) != len(all) {
+		t.Errorf("Expected k larger than the candidates to return all %d, got %d", len(all), len(got))
+	}
+	if got := index.FindNearestDrivers(ctx, 37.7749, -122.4194, 5.0, 0); got != nil {
+		t.Errorf("Expected nil for k=0, got %v", got)
+	}
+}
+
+func TestPrecisionForRadius(t *testing.T) {
+	tests := []struct {
+		radiusKm float64
+		expected int
+	}{
+		{0.1, 7},
+		{0.3, 7},
+		{0.5, 6},
+		{1.2, 6},
+		{5.0, 5},
+		{10.0, 4},
+		{50.0, 3},
+		{500.0, 2},
+		{5000.0, 1},
+	}
+
+	for _, tt := range tests {
+		if got := PrecisionForRadius(tt.radiusKm); got != tt.expected {
+			t.Errorf("PrecisionForRadius(%v) = %d, want %d", tt.radiusKm, got, tt.expected)
+		}
+	}
+}
+
+func TestIndexPrecision(t *testing.T) {
+	tests := []struct {
+		configured int
+		radiusKm   float64
+		expected   int
+	}{
+		{6, 5.0, 6},  // explicit precision wins
+		{0, 5.0, 5},  // derived from the radius
+		{0, 0.3, 7},  // small radius, fine cells
+		{13, 5.0, 5}, // out of range is treated as unset
+	}
+
+	for _, tt := range tests {
+		if got := IndexPrecision(tt.configured, tt.radiusKm); got != tt.expected {
+			t.Errorf("IndexPrecision(%d, %v) = %d, want %d", tt.configured, tt.radiusKm, got, tt.expected)
+		}
+	}
+}
+
+func TestSpatialIndex_FindNearbyDrivers_LargeRadius(t *testing.T) {
+	index := NewSpatialIndex(6)
+	ctx := context.Background()
+
+	// ~8 km north: well outside the 3x3 grid of 1.2 km cells around the point.
+	index.UpdateLocation("driver-far", 37.8469, -122.4194)
+
+	nearby := index.FindNearbyDrivers(ctx, 37.7749, -122.4194, 10.0)
+	if len(nearby) != 1 || nearby[0].Driver.DriverID != "driver-far" {
+		t.Errorf("Expected driver-far within 10 km, got %v", nearby)
+	}
+}
+
+func TestSpatialIndex_FindNearbyDrivers_TwoCellsAway(t *testing.T) {
+	index := NewSpatialIndex(6)
+	ctx := context.Background()
+
+	// ~3 km north and ~3 km east: several 1.2 km × 0.6 km cells away, past
+	// the immediate neighbors.
+	index.UpdateLocation("driver-north", 37.8019, -122.4194)
+	index.UpdateLocation("driver-east", 37.7749, -122.3854)
+
+	for _, precision := range []int{0, 6} {
+		nearby := index.FindNearbyDriversWithPrecision(ctx, 37.7749, -122.4194, 3.5, precision)
+		if len(nearby) != 2 {
+			t.Errorf("precision %d: expected both drivers ~3 km away, got %d", precision, len(nearby))
+		}
+	}
+}
+
+func TestSpatialIndex_FindNearbyDrivers_Antimeridian(t *testing.T) {
+	index := NewSpatialIndex(6)
+	ctx := context.Background()
+
+	index.UpdateLocation("driver-1", -16.5, -179.99) // ~2 km east, across 180°
+
+	nearby := index.FindNearbyDrivers(ctx, -16.5, 179.99, 5.0)
+	if len(nearby) != 1 {
+		t.Errorf("Expected the driver across the antimeridian, got %v", nearby)
+	}
+}
+
+// TestSpatialIndex_FindNearbyDriversWithPrecision checks every query
+// precision against a brute-force scan, covering query cells finer than,
+// equal to and coarser than the stored ones.
+func TestSpatialIndex_FindNearbyDriversWithPrecision(t *testing.T) {
+	index := NewSpatialIndex(6)
+	ctx := context.Background()
+
+	// A deterministic scatter over roughly 40 km × 40 km.
+	type point struct{ lat, lon float64 }
+	drivers := map[string]point{}
+	for i := 0; i < 3000; i++ {
+		id := fmt.Sprintf("driver-%d", i)
+		p := point{
+			lat: 37.6 + float64((i*7919)%3000)/3000*0.36,
+			lon: -122.6 + float64((i*104729)%3001)/3001*0.45,
+		}
+		drivers[id] = p
+		index.UpdateLocation(id, p.lat, p.lon)
+	}
+
+	for _, radius := range []float64{0.3, 1.0, 5.0, 12.0} {
+		var expected []string
+		for id, p := range drivers {
+			if utils.HaversineDistance(37.78, -122.42, p.lat, p.lon) <= radius {
+				expected = append(expected, id)
+			}
+		}
+		sort.Strings(expected)
+
+		for _, precision := range []int{0, 3, 4, 5, 6, 7, 8, 12} {
+			nearby := index.FindNearbyDriversWithPrecision(ctx, 37.78, -122.42, radius, precision)
+			got := make([]string, len(nearby))
+			for i, d := range nearby {
+				got[i] = d.Driver.DriverID
+			}
+			sort.Strings(got)
+
+			if fmt.Sprint(got) != fmt.Sprint(expected) {
+				t.Errorf("radius %v precision %d: found %d drivers, want %d", radius, precision, len(got), len(expected))
+			}
+		}
+	}
+}
+
+func TestQueryCells_CapsRings(t *testing.T) {
+	// 10 km at precision 9 (~5 m cells) would be a grid thousands of cells
+	// across; the query coarsens instead.
+	cells, precision := queryCells(37.78, -122.42, 10.0, 9)
+	side := 2*maxQueryRings + 1
+	if len(cells) > side*side {
+		t.Errorf("Expected at most %d cells, got %d", side*side, len(cells))
+	}
+	if precision >= 9 {
+		t.Errorf("Expected a coarser precision than 9, got %d", precision)
+	}
+}
+
+func TestCellSizeKm(t *testing.T) {
+	width, height := CellSizeKm(5, 0)
+	if math.Abs(width-4.89) > 0.01 || math.Abs(height-4.89) > 0.01 {
+		t.Errorf("Precision 5 at the equator: got %.3f × %.3f km, want ~4.89 × 4.89", width, height)
+	}
+
+	width, height = CellSizeKm(6, 60)
+	if math.Abs(width-0.611) > 0.01 || math.Abs(height-0.611) > 0.01 {
+		t.Errorf("Precision 6 at 60°: got %.3f × %.3f km, want ~0.61 × 0.61", width, height)
+	}
+}
+
 func BenchmarkFindNearbyDrivers(b *testing.B) {
 	index := NewSpatialIndex(6)
 	ctx := context.Background()
@@ -186,3 +563,88 @@ func BenchmarkFindNearbyDrivers(b *testing.B) {
 		index.FindNearbyDrivers(ctx, 37.5, -122.0, 5.0)
 	}
 }
+
+// BenchmarkUpdateLocation_100k measures a location ping with 100k drivers
+// already indexed, each in their own cell. The index is filled directly and
+// then rebuilt with Reindex, so setup stays fast however UpdateLocation
+// scales.
+func BenchmarkUpdateLocation_100k(b *testing.B) {
+	index := NewSpatialIndex(6)
+	const drivers = 100000
+	for i := 0; i < drivers; i++ {
+		id := fmt.Sprintf("driver-%d", i)
+		lat := -60.0 + float64(i%400)*0.3
+		lon := -170.0 + float64(i/400)*1.3
+		geohash := Encode(lat, lon, 6)
+		shard := &index.cells[shardOf(geohash)]
+		if shard.drivers[geohash] == nil {
+			shard.drivers[geohash] = make(map[string]*entities.DriverLocation)
+		}
+		shard.drivers[geohash][id] = entities.NewDriverLocation(id, lat, lon, geohash)
+	}
+	index.Reindex(6)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := i % drivers
+		lat := -60.0 + float64(id%400)*0.3 + 0.01*float64(i%2)
+		lon := -170.0 + float64(id/400)*1.3
+		index.UpdateLocation(fmt.Sprintf("driver-%d", id), lat, lon)
+	}
+}
+
+// benchmarkNearestIndex packs 5000 drivers into a few square km, so the two
+// benchmarks below compare taking the 5 nearest of thousands of candidates
+// with the heap against sorting them all.
+func benchmarkNearestIndex() *SpatialIndex {
+	index := NewSpatialIndex(6)
+	for i := 0; i < 5000; i++ {
+		lat := 37.75 + float64(i%100)*0.0005
+		lon := -122.45 + float64(i/100)*0.001
+		index.UpdateLocation(fmt.Sprintf("driver-%d", i), lat, lon)
+	}
+	return index
+}
+
+func BenchmarkFindNearest_TopK(b *testing.B) {
+	index := benchmarkNearestIndex()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.FindNearestDrivers(ctx, 37.775, -122.425, 5.0, 5)
+	}
+}
+
+func BenchmarkFindNearest_FullSort(b *testing.B) {
+	index := benchmarkNearestIndex()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = index.FindNearbyDrivers(ctx, 37.775, -122.425, 5.0)[:5]
+	}
+}
+
+// BenchmarkUpdateLocation_Parallel has every goroutine pinging its own set of
+// drivers, each in a distinct cell, so the only contention is the index's own
+// locking. Compare -cpu=1,4,8 to see how updates scale.
+func BenchmarkUpdateLocation_Parallel(b *testing.B) {
+	index := NewSpatialIndex(6)
+	var next atomic.Int64
+
+	b.RunParallel(func(pb *testing.PB) {
+		worker := next.Add(1)
+		lat := -60.0 + float64(worker%100)*1.1
+		ids := make([]string, 100)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("driver-%d-%d", worker, i)
+		}
+
+		i := 0
+		for pb.Next() {
+			index.UpdateLocation(ids[i%len(ids)], lat+float64(i%2)*0.001, -170.0+float64(i%len(ids))*3.1)
+			i++
+		}
+	})
+}
@@ -0,0 +1,181 @@
+// Package i18n holds the user-facing message catalog and picks a language for
+// each user. Messages are text/template strings keyed by a stable message ID,
+// so code refers to "error.ride_not_found" rather than to English text, and
+// each market only has to supply a table of translations.
+//
+// Go Learning Note — text/template:
+// text/template fills {{.Field}} placeholders from a data value (a struct or
+// map). Unlike fmt verbs, placeholders are named, so a translation can put
+// them in whatever order its grammar needs. Templates are parsed once up
+// front; executing a parsed template is cheap and safe for concurrent use.
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// DefaultLanguage is used when a user has no preference or asks for a
+// language the catalog doesn't have, and for any message a language lacks.
+const DefaultLanguage = "en"
+
+// messages is the catalog source: language → message ID → template. English
+// must define every message. Other languages may be partial while their
+// translations are in progress; missing IDs fall back to English.
+var messages = map[string]map[string]string{
+	"en": {
+		"notify.ride_request":         "New ride request {{.Ride}} from ({{.FromLat}}, {{.FromLong}}) to ({{.ToLat}}, {{.ToLong}}). Estimated fare: {{.Fare}}",
+		"notify.offer_demand":         "Nearby: surge {{.Surge}}x, {{.Pending}} pending requests",
+		"notify.driver_accepted":      "Driver {{.Driver}} has accepted your ride {{.Ride}}",
+		"notify.pickup_eta":           "Arriving in about {{.Minutes}} min",
+		"notify.driver_arriving":      "Driver {{.Driver}} is arriving for ride {{.Ride}}",
+		"notify.driver_arriving_soon": "Driver {{.Driver}} is about to arrive for ride {{.Ride}}",
+		"notify.contactless_dropoff":  "Driver {{.Driver}} is on the way with order {{.Ride}} and will leave it at your door",
+		"notify.trip_started":         "Your trip {{.Ride}} has started",
+		"notify.trip_completed":       "Your trip {{.Ride}} has been completed. Fare: {{.Fare}}",
+		"notify.confirm_completion":   "Your driver has marked trip {{.Ride}} as completed. Please confirm it.",
+		"notify.driver_reassignment":  "Your driver cancelled ride {{.Ride}}. Finding a new driver...",
+		"notify.no_drivers_available": "No drivers available for ride {{.Ride}}. Please try again later.",
+		"notify.ride_timeout":         "Your response time for ride {{.Ride}} has expired",
+		"notify.ride_taken":           "Ride {{.Ride}} has been taken by another driver",
+		"notify.ride_cancelled":       "Ride {{.Ride}} was cancelled by the rider",
+
+		"error.ride_not_found":            "ride not found",
+		"error.no_active_ride":            "no active ride",
+		"error.estimate_expired":          "fare estimate has expired; please request a new estimate",
+		"error.not_authorized":            "not authorized",
+		"error.active_ride_exists":        "active ride already exists",
+		"error.invalid_ride_category":     "invalid ride category",
+		"error.invalid_vehicle_tier":      "invalid vehicle tier",
+		"error.invalid_promo_code":        "promo code is not valid",
+		"error.promo_code_expired":        "promo code has expired",
+		"error.invalid_pagination":        "limit must be between 1 and 100 and offset must not be negative",
+		"error.no_driver_en_route":        "no driver is on the way to the pickup",
+		"error.driver_location_unknown":   "the driver's location is not known yet",
+		"error.invalid_status":            "invalid status",
+		"error.invalid_status_transition": "invalid status transition",
+		"error.no_trip_in_progress":       "driver has no ride in progress",
+		"error.ride_terminal":             "ride is already finished",
+		"error.ride_not_matching":         "ride is not waiting for a driver",
+		"error.no_open_offer":             "you have no open offer for this ride",
+		"error.driver_in_ride":            "finish your current ride first",
+		"error.route_not_found":           "no endpoint {{.Method}} {{.Path}}",
+		"error.method_not_allowed":        "{{.Method}} is not allowed on {{.Path}}",
+	},
+	// Spanish covers everything riders see. Driver-side messages still fall
+	// back to English.
+	"es": {
+		"notify.driver_accepted":      "El conductor {{.Driver}} aceptó tu viaje {{.Ride}}",
+		"notify.pickup_eta":           "Llega en unos {{.Minutes}} min",
+		"notify.driver_arriving":      "El conductor {{.Driver}} está llegando para el viaje {{.Ride}}",
+		"notify.driver_arriving_soon": "El conductor {{.Driver}} está a punto de llegar para el viaje {{.Ride}}",
+		"notify.contactless_dropoff":  "El conductor {{.Driver}} está en camino con el pedido {{.Ride}} y lo dejará en tu puerta",
+		"notify.trip_started":         "Tu viaje {{.Ride}} ha comenzado",
+		"notify.trip_completed":       "Tu viaje {{.Ride}} ha finalizado. Tarifa: {{.Fare}}",
+		"notify.confirm_completion":   "Tu conductor marcó el viaje {{.Ride}} como finalizado. Por favor, confírmalo.",
+		"notify.driver_reassignment":  "Tu conductor canceló el viaje {{.Ride}}. Buscando otro conductor...",
+		"notify.no_drivers_available": "No hay conductores disponibles para el viaje {{.Ride}}. Inténtalo más tarde.",
+
+		"error.ride_not_found":          "viaje no encontrado",
+		"error.no_active_ride":          "no tienes un viaje activo",
+		"error.estimate_expired":        "la estimación de tarifa expiró; solicita una nueva",
+		"error.not_authorized":          "no autorizado",
+		"error.active_ride_exists":      "ya tienes un viaje activo",
+		"error.invalid_ride_category":   "categoría de viaje no válida",
+		"error.invalid_vehicle_tier":    "tipo de vehículo no válido",
+		"error.invalid_promo_code":      "el código promocional no es válido",
+		"error.promo_code_expired":      "el código promocional ha expirado",
+		"error.invalid_pagination":      "el límite debe estar entre 1 y 100 y el desplazamiento no puede ser negativo",
+		"error.no_driver_en_route":      "ningún conductor va en camino al punto de recogida",
+		"error.driver_location_unknown": "aún no se conoce la ubicación del conductor",
+		"error.route_not_found":         "no existe el endpoint {{.Method}} {{.Path}}",
+		"error.method_not_allowed":      "{{.Method}} no está permitido en {{.Path}}",
+	},
+}
+
+// templates is messages parsed once at startup.
+var templates = map[string]map[string]*template.Template{}
+
+// init parses the catalog. A malformed template is a programming error in
+// this file, so it panics rather than surfacing later as a garbled message.
+func init() {
+	for lang, table := range messages {
+		templates[lang] = make(map[string]*template.Template, len(table))
+		for id, text := range table {
+			templates[lang][id] = template.Must(template.New(lang + ":" + id).Parse(text))
+		}
+	}
+}
+
+// Supported reports whether the catalog has a table for lang.
+func Supported(lang string) bool {
+	_, ok := templates[lang]
+	return ok
+}
+
+// Render returns message id in lang, filled in from data. A language the
+// catalog lacks, or a message missing from that language, falls back to
+// DefaultLanguage; an ID missing even there is returned as-is so the gap is
+// visible instead of producing an empty message.
+func Render(lang, id string, data any) string {
+	tmpl, ok := templates[lang][id]
+	if !ok {
+		tmpl, ok = templates[DefaultLanguage][id]
+	}
+	if !ok {
+		return id
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return id
+	}
+	return b.String()
+}
+
+// Negotiate picks the best supported language from an Accept-Language header
+// such as "es-MX,es;q=0.9,en;q=0.5". Tags are tried in descending q order and
+// matched on their primary subtag ("es-MX" → "es"); q=0 means "not this one".
+// It returns DefaultLanguage if nothing matches or the header is empty.
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+	var candidates []candidate
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		primary, _, _ := strings.Cut(tag, "-")
+		candidates = append(candidates, candidate{lang: primary, q: q})
+	}
+
+	// Stable, so equal q values keep the client's listed order.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	for _, c := range candidates {
+		if Supported(c.lang) {
+			return c.lang
+		}
+	}
+	return DefaultLanguage
+}
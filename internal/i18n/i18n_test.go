@@ -0,0 +1,61 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                        "en",
+		"es":                      "es",
+		"es-MX":                   "es",
+		"ES-mx,en;q=0.5":          "es",
+		"fr-FR,es;q=0.8,en;q=0.5": "es",
+		"en;q=0.4,es;q=0.9":       "es",
+		"es;q=0,en":               "en",
+		"fr,de":                   "en",
+		" , ;q=1":                 "en",
+	}
+	for header, expected := range tests {
+		if got := Negotiate(header); got != expected {
+			t.Errorf("Negotiate(%q) = %s, expected %s", header, got, expected)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := map[string]any{"Driver": "driver-1", "Ride": "ride-1"}
+
+	if got := Render("es", "notify.driver_accepted", data); got != "El conductor driver-1 aceptó tu viaje ride-1" {
+		t.Errorf("Unexpected Spanish message: %q", got)
+	}
+	if got := Render("en", "notify.driver_accepted", data); got != "Driver driver-1 has accepted your ride ride-1" {
+		t.Errorf("Unexpected English message: %q", got)
+	}
+}
+
+func TestRender_FallsBackToEnglish(t *testing.T) {
+	data := map[string]any{"Ride": "ride-1"}
+	english := "Your response time for ride ride-1 has expired"
+
+	// Spanish has no translation for this driver message yet.
+	if got := Render("es", "notify.ride_timeout", data); got != english {
+		t.Errorf("Expected English fallback for missing key, got %q", got)
+	}
+	// A language with no catalog at all.
+	if got := Render("fr", "notify.ride_timeout", data); got != english {
+		t.Errorf("Expected English fallback for unknown language, got %q", got)
+	}
+	// An ID nobody defines is returned as-is.
+	if got := Render("es", "notify.nope", data); got != "notify.nope" {
+		t.Errorf("Expected unknown ID returned verbatim, got %q", got)
+	}
+}
+
+func TestCatalog_EnglishIsComplete(t *testing.T) {
+	for lang, table := range messages {
+		for id := range table {
+			if _, ok := messages[DefaultLanguage][id]; !ok {
+				t.Errorf("Message %q in %s has no %s version to fall back to", id, lang, DefaultLanguage)
+			}
+		}
+	}
+}
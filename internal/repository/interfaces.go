@@ -47,6 +47,7 @@ type RiderRepository interface {
 type DriverRepository interface {
 	Create(ctx context.Context, driver *entities.Driver) error
 	GetByID(ctx context.Context, id string) (*entities.Driver, error)
+	GetByIDs(ctx context.Context, ids []string) (map[string]*entities.Driver, error)
 	Update(ctx context.Context, driver *entities.Driver) error
 	Delete(ctx context.Context, id string) error
 	GetAvailableDrivers(ctx context.Context) ([]*entities.Driver, error)
@@ -54,20 +55,22 @@ type DriverRepository interface {
 }
 
 // RideRepository provides ride persistence with query methods for looking up
-// rides by rider or driver.
+// rides by rider or driver. GetByRiderIDPaged returns a page of a rider's
+// rides, newest first, plus their total count.
 type RideRepository interface {
 	Create(ctx context.Context, ride *entities.Ride) error
 	GetByID(ctx context.Context, id string) (*entities.Ride, error)
 	Update(ctx context.Context, ride *entities.Ride) error
 	Delete(ctx context.Context, id string) error
 	GetByRiderID(ctx context.Context, riderID string) ([]*entities.Ride, error)
+	GetByRiderIDPaged(ctx context.Context, riderID string, limit, offset int) ([]*entities.Ride, int, error)
 	GetByDriverID(ctx context.Context, driverID string) ([]*entities.Ride, error)
 	GetActiveRideByRiderID(ctx context.Context, riderID string) (*entities.Ride, error)
 }
 
 // LocationRepository manages driver GPS positions with geohash-based indexing.
 type LocationRepository interface {
-	UpdateDriverLocation(ctx context.Context, location *entities.DriverLocation) error
+	UpdateDriverLocation(ctx context.Context, location *entities.DriverLocation) (created bool, err error)
 	GetDriverLocation(ctx context.Context, driverID string) (*entities.DriverLocation, error)
 	RemoveDriverLocation(ctx context.Context, driverID string) error
 	GetDriversInGeohash(ctx context.Context, geohash string) ([]*entities.DriverLocation, error)
@@ -34,15 +34,39 @@ import (
 var ErrDriverNotFound = errors.New("driver not found")
 
 // DriverRepository stores drivers in an in-memory map protected by a RWMutex.
+//
+// available holds the IDs of the drivers whose status is available, so
+// GetAvailableDrivers doesn't have to scan every driver. It is kept in sync
+// by every write — Create, Update, SetStatus, Delete, GetOrCreate — under the
+// same mutex as drivers. Like the ride indices, it reflects each driver as of
+// their last save: services change a driver in place (driver.StartRide())
+// and then call Update, which is when the set catches up.
+//
+// Go Learning Note — Sets with map[string]struct{}:
+// Go has no set type; a map with empty-struct values is the idiom. struct{}
+// takes zero bytes, so the map stores only keys, and membership is the usual
+// "_, ok := m[key]" lookup.
 type DriverRepository struct {
-	mu      sync.RWMutex
-	drivers map[string]*entities.Driver
+	mu        sync.RWMutex
+	drivers   map[string]*entities.Driver
+	available map[string]struct{}
 }
 
 // NewDriverRepository initializes an empty driver store.
 func NewDriverRepository() *DriverRepository {
 	return &DriverRepository{
-		drivers: make(map[string]*entities.Driver),
+		drivers:   make(map[string]*entities.Driver),
+		available: make(map[string]struct{}),
+	}
+}
+
+// trackAvailability adds the driver to or removes them from the available
+// set to match their current status. Callers hold mu for writing.
+func (r *DriverRepository) trackAvailability(driver *entities.Driver) {
+	if driver.IsAvailable() {
+		r.available[driver.ID] = struct{}{}
+	} else {
+		delete(r.available, driver.ID)
 	}
 }
 
@@ -52,6 +76,7 @@ func (r *DriverRepository) Create(ctx context.Context, driver *entities.Driver)
 	defer r.mu.Unlock()
 
 	r.drivers[driver.ID] = driver
+	r.trackAvailability(driver)
 	return nil
 }
 
@@ -68,6 +93,29 @@ func (r *DriverRepository) GetByID(ctx context.Context, id string) (*entities.Dr
 	return driver, nil
 }
 
+// GetByIDs retrieves several drivers under a single read lock. IDs that don't
+// exist are simply absent from the result, so callers filtering a candidate
+// list can treat a missing key the same way they'd treat ErrDriverNotFound.
+//
+// Go Learning Note — Batching Lock Acquisitions:
+// Calling GetByID in a loop takes and releases the RWMutex once per ID. Each
+// acquisition is cheap, but under write contention (drivers pinging locations)
+// the loop can interleave with many writers. One RLock for the whole batch
+// gives a consistent snapshot and mirrors a real database's "WHERE id IN (...)"
+// query, which replaces N round-trips with one.
+func (r *DriverRepository) GetByIDs(ctx context.Context, ids []string) (map[string]*entities.Driver, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := make(map[string]*entities.Driver, len(ids))
+	for _, id := range ids {
+		if driver, exists := r.drivers[id]; exists {
+			found[id] = driver
+		}
+	}
+	return found, nil
+}
+
 // Update replaces a driver's data. Checks existence first to return a
 // meaningful error rather than silently creating a new entry.
 func (r *DriverRepository) Update(ctx context.Context, driver *entities.Driver) error {
@@ -78,6 +126,7 @@ func (r *DriverRepository) Update(ctx context.Context, driver *entities.Driver)
 		return ErrDriverNotFound
 	}
 	r.drivers[driver.ID] = driver
+	r.trackAvailability(driver)
 	return nil
 }
 
@@ -95,19 +144,21 @@ func (r *DriverRepository) Delete(ctx context.Context, id string) error {
 		return ErrDriverNotFound
 	}
 	delete(r.drivers, id)
+	delete(r.available, id)
 	return nil
 }
 
-// GetAvailableDrivers returns all drivers with status "available". This
-// requires a full scan of the map — in production, you'd use a database index
-// on the status column, or maintain a separate set of available driver IDs.
+// GetAvailableDrivers returns all drivers with status "available", read
+// straight from the available set: O(available drivers) rather than a scan of
+// every driver. A driver changed in place but not yet saved can still be in
+// the set, so each one's status is checked again on the way out.
 func (r *DriverRepository) GetAvailableDrivers(ctx context.Context) ([]*entities.Driver, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	var available []*entities.Driver
-	for _, driver := range r.drivers {
-		if driver.IsAvailable() {
+	available := make([]*entities.Driver, 0, len(r.available))
+	for id := range r.available {
+		if driver := r.drivers[id]; driver.IsAvailable() {
 			available = append(available, driver)
 		}
 	}
@@ -124,9 +175,24 @@ func (r *DriverRepository) SetStatus(ctx context.Context, id string, status enti
 		return ErrDriverNotFound
 	}
 	driver.SetStatus(status)
+	r.trackAvailability(driver)
 	return nil
 }
 
+// CountByStatus returns the number of drivers in each status. Statuses with
+// no drivers are omitted from the map, so callers should treat a missing key
+// as zero.
+func (r *DriverRepository) CountByStatus(ctx context.Context) map[entities.DriverStatus]int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counts := make(map[entities.DriverStatus]int)
+	for _, driver := range r.drivers {
+		counts[driver.Status]++
+	}
+	return counts
+}
+
 // GetOrCreate returns an existing driver or creates a new one with default
 // data. This is a convenience for the MVP — real apps would require proper
 // driver registration.
@@ -141,5 +207,6 @@ func (r *DriverRepository) GetOrCreate(ctx context.Context, id string) (*entitie
 	driver := entities.NewDriver(id, "Driver "+id, id+"@example.com", "555-0000", "vehicle-"+id)
 	driver.GoOnline()
 	r.drivers[id] = driver
+	r.trackAvailability(driver)
 	return driver, nil
 }
@@ -0,0 +1,134 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"uber/internal/domain/entities"
+)
+
+func TestDriverRepository_GetByIDs_MatchesGetByID(t *testing.T) {
+	repo := NewDriverRepository()
+	ctx := context.Background()
+
+	repo.Create(ctx, entities.NewDriver("driver-1", "D1", "d1@example.com", "555-0001", "v1"))
+	repo.Create(ctx, entities.NewDriver("driver-2", "D2", "d2@example.com", "555-0002", "v2"))
+	repo.Create(ctx, entities.NewDriver("driver-3", "D3", "d3@example.com", "555-0003", "v3"))
+
+	ids := []string{"driver-1", "driver-3", "driver-missing"}
+	found, err := repo.GetByIDs(ctx, ids)
+	if err != nil {
+		t.Fatalf("GetByIDs failed: %v", err)
+	}
+
+	for _, id := range ids {
+		single, err := repo.GetByID(ctx, id)
+		bulk, ok := found[id]
+		if err != nil {
+			if ok {
+				t.Errorf("%s: GetByID returned %v but GetByIDs found it", id, err)
+			}
+			continue
+		}
+		if !ok || bulk != single {
+			t.Errorf("%s: expected bulk result %p to match GetByID %p", id, bulk, single)
+		}
+	}
+
+	if len(found) != 2 {
+		t.Errorf("Expected 2 drivers found, got %d", len(found))
+	}
+	if _, ok := found["driver-2"]; ok {
+		t.Error("Expected driver-2 to be absent since it wasn't requested")
+	}
+}
+
+func TestDriverRepository_GetByIDs_Empty(t *testing.T) {
+	repo := NewDriverRepository()
+
+	found, err := repo.GetByIDs(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("GetByIDs failed: %v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("Expected empty result, got %d drivers", len(found))
+	}
+}
+
+// assertAvailableMatchesScan checks GetAvailableDrivers against a brute-force
+// scan of every driver's status.
+func assertAvailableMatchesScan(t *testing.T, repo *DriverRepository) {
+	t.Helper()
+	var want []string
+	for id, driver := range repo.drivers {
+		if driver.IsAvailable() {
+			want = append(want, id)
+		}
+	}
+	sort.Strings(want)
+
+	drivers, err := repo.GetAvailableDrivers(context.Background())
+	if err != nil {
+		t.Fatalf("GetAvailableDrivers failed: %v", err)
+	}
+	var got []string
+	for _, driver := range drivers {
+		got = append(got, driver.ID)
+	}
+	sort.Strings(got)
+
+	if fmt.Sprint(got) != fmt.Sprint(want) || len(repo.available) != len(want) {
+		t.Errorf("Expected available drivers %v, got %v (set holds %d)", want, got, len(repo.available))
+	}
+}
+
+func TestDriverRepository_AvailableSetFollowsStatus(t *testing.T) {
+	repo := NewDriverRepository()
+	ctx := context.Background()
+
+	repo.Create(ctx, entities.NewDriver("driver-1", "D1", "d1@example.com", "555-0001", "v1")) // offline
+	repo.GetOrCreate(ctx, "driver-2")                                                          // online
+	repo.GetOrCreate(ctx, "driver-3")
+	assertAvailableMatchesScan(t, repo)
+
+	repo.SetStatus(ctx, "driver-1", entities.DriverStatusAvailable)
+	assertAvailableMatchesScan(t, repo)
+
+	driver, _ := repo.GetByID(ctx, "driver-2")
+	driver.StartRide()
+	repo.Update(ctx, driver)
+	assertAvailableMatchesScan(t, repo)
+
+	driver.EndRide()
+	repo.Update(ctx, driver)
+	repo.SetStatus(ctx, "driver-3", entities.DriverStatusOffline)
+	assertAvailableMatchesScan(t, repo)
+
+	repo.Delete(ctx, "driver-1")
+	assertAvailableMatchesScan(t, repo)
+
+	// Changed in place but not saved: the set is stale, the result is not.
+	driver.GoOffline()
+	if drivers, _ := repo.GetAvailableDrivers(ctx); len(drivers) != 0 {
+		t.Errorf("Expected no available drivers, got %d", len(drivers))
+	}
+}
+
+func BenchmarkGetAvailableDrivers_50k(b *testing.B) {
+	repo := NewDriverRepository()
+	ctx := context.Background()
+	for i := 0; i < 50000; i++ {
+		driver, _ := repo.GetOrCreate(ctx, fmt.Sprintf("driver-%d", i))
+		// One driver in ten is free; the rest are on a trip.
+		if i%10 != 0 {
+			driver.StartRide()
+			repo.Update(ctx, driver)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		repo.GetAvailableDrivers(ctx)
+	}
+}
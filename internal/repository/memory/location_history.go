@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"time"
+	"uber/internal/domain/entities"
+)
+
+// DefaultLocationHistorySize is how many pings are kept per driver. At one
+// ping every 5 seconds this covers roughly the last 40 minutes of driving —
+// enough to reconstruct a typical trip.
+const DefaultLocationHistorySize = 500
+
+// locationRing is a fixed-capacity ring buffer of one driver's past locations.
+// Once full, each new ping overwrites the oldest one, so memory per driver is
+// bounded no matter how long they stay online.
+//
+// Go Learning Note — Ring Buffers:
+// A ring buffer reuses a fixed slice with a moving write index (next) instead
+// of appending and trimming. Appending to a slice and slicing off the front
+// (s = s[1:]) looks cheaper but keeps the whole backing array alive and
+// reallocates as it grows; the ring never allocates after it fills up.
+type locationRing struct {
+	entries []entities.DriverLocation
+	next    int  // index the next ping is written to
+	full    bool // whether the ring has wrapped at least once
+}
+
+func newLocationRing(capacity int) *locationRing {
+	return &locationRing{entries: make([]entities.DriverLocation, capacity)}
+}
+
+// add stores a copy of the location, overwriting the oldest entry when full.
+func (r *locationRing) add(location entities.DriverLocation) {
+	r.entries[r.next] = location
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// between returns the stored locations with from <= UpdatedAt <= to, oldest
+// first.
+func (r *locationRing) between(from, to time.Time) []entities.DriverLocation {
+	start, count := 0, r.next
+	if r.full {
+		start, count = r.next, len(r.entries)
+	}
+
+	result := make([]entities.DriverLocation, 0)
+	for i := 0; i < count; i++ {
+		location := r.entries[(start+i)%len(r.entries)]
+		if location.UpdatedAt.Before(from) || location.UpdatedAt.After(to) {
+			continue
+		}
+		result = append(result, location)
+	}
+	return result
+}
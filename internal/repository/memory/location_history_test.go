@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+)
+
+func pingAt(driverID string, lat float64, at time.Time) *entities.DriverLocation {
+	location := entities.NewDriverLocation(driverID, lat, -122.41, "9q8yy")
+	location.UpdatedAt = at
+	return location
+}
+
+func TestLocationRepository_GetLocationHistory_SubRange(t *testing.T) {
+	repo := NewLocationRepository()
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Five pings one minute apart, plus another driver's ping that must not leak in.
+	for i := 0; i < 5; i++ {
+		repo.UpdateDriverLocation(ctx, pingAt("driver-1", 37.77+float64(i)*0.001, base.Add(time.Duration(i)*time.Minute)))
+	}
+	repo.UpdateDriverLocation(ctx, pingAt("driver-2", 37.70, base.Add(2*time.Minute)))
+
+	history, err := repo.GetLocationHistory(ctx, "driver-1", base.Add(1*time.Minute), base.Add(3*time.Minute))
+	if err != nil {
+		t.Fatalf("GetLocationHistory failed: %v", err)
+	}
+
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 pings in range, got %d", len(history))
+	}
+	for i, location := range history {
+		expected := base.Add(time.Duration(i+1) * time.Minute)
+		if !location.UpdatedAt.Equal(expected) {
+			t.Errorf("Ping %d: expected time %v, got %v", i, expected, location.UpdatedAt)
+		}
+		if location.DriverID != "driver-1" {
+			t.Errorf("Ping %d: expected driver-1, got %s", i, location.DriverID)
+		}
+	}
+
+	// The latest-location view is unaffected by history.
+	latest, _ := repo.GetDriverLocation(ctx, "driver-1")
+	if !latest.UpdatedAt.Equal(base.Add(4 * time.Minute)) {
+		t.Errorf("Expected latest ping at +4m, got %v", latest.UpdatedAt)
+	}
+}
+
+func TestLocationRepository_GetLocationHistory_RingBufferCap(t *testing.T) {
+	repo := NewLocationRepositoryWithHistory(3)
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		repo.UpdateDriverLocation(ctx, pingAt("driver-1", 37.77, base.Add(time.Duration(i)*time.Minute)))
+	}
+
+	history, _ := repo.GetLocationHistory(ctx, "driver-1", base, base.Add(time.Hour))
+	if len(history) != 3 {
+		t.Fatalf("Expected history capped at 3, got %d", len(history))
+	}
+	if !history[0].UpdatedAt.Equal(base.Add(2*time.Minute)) || !history[2].UpdatedAt.Equal(base.Add(4*time.Minute)) {
+		t.Errorf("Expected the 3 newest pings oldest first, got %v .. %v", history[0].UpdatedAt, history[2].UpdatedAt)
+	}
+}
+
+func TestLocationRepository_GetLocationHistory_UnknownDriver(t *testing.T) {
+	repo := NewLocationRepository()
+
+	history, err := repo.GetLocationHistory(context.Background(), "driver-unknown", time.Time{}, time.Now())
+	if err != nil || len(history) != 0 {
+		t.Errorf("Expected empty history, got %v (err %v)", history, err)
+	}
+}
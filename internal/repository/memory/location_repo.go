@@ -3,6 +3,7 @@ package memory
 import (
 	"context"
 	"sync"
+	"time"
 	"uber/internal/domain/entities"
 )
 
@@ -13,29 +14,48 @@ import (
 //
 // This dual-index pattern is common when you need fast lookups by two different
 // keys. The tradeoff is that both indices must be kept in sync on every write.
+//
+// Alongside the latest location, every ping is also appended to a per-driver
+// history ring (capped at historySize) for trip reconstruction and disputes.
 type LocationRepository struct {
 	mu           sync.RWMutex
 	locations    map[string]*entities.DriverLocation            // driverID → location
 	geohashIndex map[string]map[string]*entities.DriverLocation // geohash → driverID → location
+	history      map[string]*locationRing                       // driverID → past locations
+	historySize  int
 }
 
 func NewLocationRepository() *LocationRepository {
+	return NewLocationRepositoryWithHistory(DefaultLocationHistorySize)
+}
+
+// NewLocationRepositoryWithHistory creates a repository that keeps up to
+// historySize past pings per driver.
+func NewLocationRepositoryWithHistory(historySize int) *LocationRepository {
+	if historySize < 1 {
+		historySize = 1
+	}
 	return &LocationRepository{
 		locations:    make(map[string]*entities.DriverLocation),
 		geohashIndex: make(map[string]map[string]*entities.DriverLocation),
+		history:      make(map[string]*locationRing),
+		historySize:  historySize,
 	}
 }
 
 // UpdateDriverLocation upserts a driver's location, maintaining both indices.
 // If the driver moved to a different geohash cell, the old cell's entry is
-// cleaned up first to prevent stale references.
-func (r *LocationRepository) UpdateDriverLocation(ctx context.Context, location *entities.DriverLocation) error {
+// cleaned up first to prevent stale references. created is true when the
+// driver had no stored location before this call (their first ping, or the
+// first since RemoveDriverLocation), false when an existing entry was updated.
+func (r *LocationRepository) UpdateDriverLocation(ctx context.Context, location *entities.DriverLocation) (created bool, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	// If driver has a previous location in a different geohash cell, remove
 	// the old entry from the geohash index.
 	oldLocation, exists := r.locations[location.DriverID]
+	created = !exists
 	if exists && oldLocation.Geohash != location.Geohash {
 		if geohashMap, ok := r.geohashIndex[oldLocation.Geohash]; ok {
 			delete(geohashMap, location.DriverID)
@@ -54,7 +74,30 @@ func (r *LocationRepository) UpdateDriverLocation(ctx context.Context, location
 	}
 	r.geohashIndex[location.Geohash][location.DriverID] = location
 
-	return nil
+	// Append a copy to the driver's history so later changes to the current
+	// location can't rewrite the past.
+	ring, exists := r.history[location.DriverID]
+	if !exists {
+		ring = newLocationRing(r.historySize)
+		r.history[location.DriverID] = ring
+	}
+	ring.add(*location)
+
+	return created, nil
+}
+
+// GetLocationHistory returns a driver's recorded pings with UpdatedAt between
+// from and to (inclusive), oldest first. Only the most recent historySize
+// pings are retained, so older ranges may come back partially or empty.
+func (r *LocationRepository) GetLocationHistory(ctx context.Context, driverID string, from, to time.Time) ([]entities.DriverLocation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ring, exists := r.history[driverID]
+	if !exists {
+		return []entities.DriverLocation{}, nil
+	}
+	return ring.between(from, to), nil
 }
 
 // GetDriverLocation returns a driver's current location, or (nil, nil) if
@@ -79,7 +122,13 @@ func (r *LocationRepository) RemoveDriverLocation(ctx context.Context, driverID
 	if !exists {
 		return nil
 	}
+	r.removeLocked(driverID, location)
+	return nil
+}
 
+// removeLocked drops a driver's current location from both indices. The
+// caller holds r.mu for writing.
+func (r *LocationRepository) removeLocked(driverID string, location *entities.DriverLocation) {
 	if geohashMap, ok := r.geohashIndex[location.Geohash]; ok {
 		delete(geohashMap, driverID)
 		if len(geohashMap) == 0 {
@@ -88,7 +137,37 @@ func (r *LocationRepository) RemoveDriverLocation(ctx context.Context, driverID
 	}
 
 	delete(r.locations, driverID)
-	return nil
+}
+
+// StaleDriverIDs returns the drivers whose current location was last updated
+// before cutoff.
+func (r *LocationRepository) StaleDriverIDs(ctx context.Context, cutoff time.Time) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var stale []string
+	for driverID, location := range r.locations {
+		if location.UpdatedAt.Before(cutoff) {
+			stale = append(stale, driverID)
+		}
+	}
+	return stale
+}
+
+// RemoveDriverLocationIfStale removes a driver's current location like
+// RemoveDriverLocation, but only if it was last updated before cutoff. It
+// reports whether it did; a ping that arrived since the driver was found
+// stale keeps them. History is left alone in both cases.
+func (r *LocationRepository) RemoveDriverLocationIfStale(ctx context.Context, driverID string, cutoff time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	location, exists := r.locations[driverID]
+	if !exists || !location.UpdatedAt.Before(cutoff) {
+		return false
+	}
+	r.removeLocked(driverID, location)
+	return true
 }
 
 // GetDriversInGeohash returns all drivers in a specific geohash cell.
@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+)
+
+func TestLocationRepository_UpdateDriverLocation_Created(t *testing.T) {
+	repo := NewLocationRepository()
+	ctx := context.Background()
+
+	created, err := repo.UpdateDriverLocation(ctx, entities.NewDriverLocation("driver-1", 37.771, -122.411, "9q8yyk"))
+	if err != nil {
+		t.Fatalf("UpdateDriverLocation failed: %v", err)
+	}
+	if !created {
+		t.Error("Expected created=true for the first location")
+	}
+
+	created, _ = repo.UpdateDriverLocation(ctx, entities.NewDriverLocation("driver-1", 37.781, -122.401, "9q8yym"))
+	if created {
+		t.Error("Expected created=false when updating an existing location")
+	}
+
+	// Once removed, the next ping creates the entry again.
+	repo.RemoveDriverLocation(ctx, "driver-1")
+	created, _ = repo.UpdateDriverLocation(ctx, entities.NewDriverLocation("driver-1", 37.771, -122.411, "9q8yyk"))
+	if !created {
+		t.Error("Expected created=true after the location was removed")
+	}
+}
+
+func TestLocationRepository_RemoveDriverLocationIfStale(t *testing.T) {
+	repo := NewLocationRepository()
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	repo.UpdateDriverLocation(ctx, pingAt("driver-1", 37.77, base))
+	repo.UpdateDriverLocation(ctx, pingAt("driver-2", 37.78, base.Add(10*time.Minute)))
+
+	cutoff := base.Add(5 * time.Minute)
+	if stale := repo.StaleDriverIDs(ctx, cutoff); len(stale) != 1 || stale[0] != "driver-1" {
+		t.Fatalf("Expected only driver-1 to be stale, got %v", stale)
+	}
+
+	// driver-1 pings before the removal: the fresh location survives.
+	repo.UpdateDriverLocation(ctx, pingAt("driver-1", 37.77, base.Add(9*time.Minute)))
+	if repo.RemoveDriverLocationIfStale(ctx, "driver-1", cutoff) {
+		t.Error("Expected a location refreshed since the scan to be kept")
+	}
+
+	if !repo.RemoveDriverLocationIfStale(ctx, "driver-1", base.Add(time.Hour)) {
+		t.Fatal("Expected the stale location to be removed")
+	}
+	if loc, _ := repo.GetDriverLocation(ctx, "driver-1"); loc != nil {
+		t.Error("Expected no current location after removal")
+	}
+	if inCell, _ := repo.GetDriversInGeohash(ctx, "9q8yy"); len(inCell) != 1 || inCell[0].DriverID != "driver-2" {
+		t.Errorf("Expected only driver-2 left in the geohash index, got %d drivers", len(inCell))
+	}
+	if history, _ := repo.GetLocationHistory(ctx, "driver-1", base, base.Add(time.Hour)); len(history) != 2 {
+		t.Errorf("Expected history to be kept, got %d pings", len(history))
+	}
+}
@@ -2,6 +2,7 @@ package memory
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 )
@@ -31,6 +32,10 @@ type LockManager struct {
 	mu    sync.RWMutex
 	locks map[string]*lockEntry
 	stop  chan struct{}
+
+	// contended counts AcquireLock calls that found the lock already held,
+	// by the kind of lock (see lockKind).
+	contended map[string]uint64
 }
 
 // NewLockManager creates a LockManager and starts a background goroutine to
@@ -44,8 +49,9 @@ type LockManager struct {
 // background goroutines to prevent goroutine leaks in tests.
 func NewLockManager() *LockManager {
 	lm := &LockManager{
-		locks: make(map[string]*lockEntry),
-		stop:  make(chan struct{}),
+		locks:     make(map[string]*lockEntry),
+		stop:      make(chan struct{}),
+		contended: make(map[string]uint64),
 	}
 	go lm.cleanupExpiredLocks()
 	return lm
@@ -62,6 +68,7 @@ func (lm *LockManager) AcquireLock(ctx context.Context, key string, ttl time.Dur
 
 	if entry, exists := lm.locks[key]; exists {
 		if time.Now().Before(entry.expiresAt) {
+			lm.contended[lockKind(key)]++
 			return false, nil // Lock is still held — acquisition fails.
 		}
 		// Lock has expired — fall through to acquire it.
@@ -73,6 +80,23 @@ func (lm *LockManager) AcquireLock(ctx context.Context, key string, ttl time.Dur
 	return true, nil
 }
 
+// Contended returns how many AcquireLock calls for locks of kind have failed
+// because the lock was held. Kinds are the key's prefix: "driver" counts
+// matching goroutines racing for a driver; "ride" counts every retry of an
+// accept or cancel waiting for a ride's lock.
+func (lm *LockManager) Contended(kind string) uint64 {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	return lm.contended[kind]
+}
+
+// lockKind is the part of a lock key before its first colon ("driver" for
+// "driver:driver-1"), or the whole key if it has none.
+func lockKind(key string) string {
+	kind, _, _ := strings.Cut(key, ":")
+	return kind
+}
+
 // ReleaseLock explicitly releases a lock before its TTL expires.
 func (lm *LockManager) ReleaseLock(ctx context.Context, key string) error {
 	lm.mu.Lock()
@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLockManager_ContendedByKind(t *testing.T) {
+	lm := NewLockManager()
+	defer lm.Stop()
+	ctx := context.Background()
+
+	lm.AcquireLock(ctx, "driver:driver-1", time.Minute)
+	lm.AcquireLock(ctx, "ride:ride-1", time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := lm.AcquireLock(ctx, "ride:ride-1", time.Minute); ok {
+			t.Fatal("Expected the held ride lock to be refused")
+		}
+	}
+	lm.AcquireLock(ctx, "driver:driver-1", time.Minute)
+	// A free lock isn't contention.
+	lm.AcquireLock(ctx, "driver:driver-2", time.Minute)
+
+	if got := lm.Contended("driver"); got != 1 {
+		t.Errorf("Expected 1 contended driver lock, got %d", got)
+	}
+	if got := lm.Contended("ride"); got != 3 {
+		t.Errorf("Expected 3 contended ride locks, got %d", got)
+	}
+}
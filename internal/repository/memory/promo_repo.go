@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"uber/internal/domain/entities"
+)
+
+var ErrPromoCodeNotFound = errors.New("promo code not found")
+
+// PromoCodeRepository is the in-memory promo code store, keyed by code.
+// Codes are case-insensitive: they are stored and looked up upper-cased, so
+// a rider typing "save10" gets the SAVE10 code.
+type PromoCodeRepository struct {
+	mu    sync.RWMutex
+	codes map[string]*entities.PromoCode
+}
+
+func NewPromoCodeRepository() *PromoCodeRepository {
+	return &PromoCodeRepository{
+		codes: make(map[string]*entities.PromoCode),
+	}
+}
+
+// Create stores a promo code, replacing any existing code with the same name.
+func (r *PromoCodeRepository) Create(ctx context.Context, promo *entities.PromoCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	promo.Code = strings.ToUpper(promo.Code)
+	r.codes[promo.Code] = promo
+	return nil
+}
+
+func (r *PromoCodeRepository) GetByCode(ctx context.Context, code string) (*entities.PromoCode, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	promo, exists := r.codes[strings.ToUpper(code)]
+	if !exists {
+		return nil, ErrPromoCodeNotFound
+	}
+	return promo, nil
+}
@@ -3,6 +3,7 @@ package memory
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
 	"uber/internal/domain/entities"
 )
@@ -12,14 +13,41 @@ var ErrRideNotFound = errors.New("ride not found")
 // RideRepository stores rides in memory. It includes query methods for finding
 // rides by rider or driver, and for checking if a rider has an active ride
 // (to prevent double-booking).
+//
+// byRider and byDriver are secondary indices from a rider or driver ID to the
+// IDs of their rides, so those queries cost O(rides for that user) rather than
+// a scan of every ride ever stored. A ride is indexed under its current
+// DriverID only: once a driver backs out and the ride is reassigned, it leaves
+// their list.
+//
+// Go Learning Note — Indexing Shared Pointers:
+// The repository hands out *Ride pointers and services change them in place
+// (ride.Accept sets DriverID) before calling Update, so by the time Update
+// runs the stored ride already carries the new driver — there is no "old
+// version" left to compare against. indexedAs remembers the keys each ride was
+// last indexed under, which is what Update needs to move it between lists.
+// The indices are therefore only as fresh as the last Create or Update: a
+// change made to a ride must be saved before lookups see it.
 type RideRepository struct {
-	mu    sync.RWMutex
-	rides map[string]*entities.Ride
+	mu        sync.RWMutex
+	rides     map[string]*entities.Ride
+	byRider   map[string][]string // Rider ID → ride IDs, oldest first
+	byDriver  map[string][]string // Driver ID → ride IDs, oldest first
+	indexedAs map[string]rideKeys // Ride ID → the keys it is indexed under
+}
+
+// rideKeys are the rider and driver a ride is indexed under.
+type rideKeys struct {
+	riderID  string
+	driverID string
 }
 
 func NewRideRepository() *RideRepository {
 	return &RideRepository{
-		rides: make(map[string]*entities.Ride),
+		rides:     make(map[string]*entities.Ride),
+		byRider:   make(map[string][]string),
+		byDriver:  make(map[string][]string),
+		indexedAs: make(map[string]rideKeys),
 	}
 }
 
@@ -28,6 +56,7 @@ func (r *RideRepository) Create(ctx context.Context, ride *entities.Ride) error
 	defer r.mu.Unlock()
 
 	r.rides[ride.ID] = ride
+	r.reindex(ride)
 	return nil
 }
 
@@ -50,6 +79,7 @@ func (r *RideRepository) Update(ctx context.Context, ride *entities.Ride) error
 		return ErrRideNotFound
 	}
 	r.rides[ride.ID] = ride
+	r.reindex(ride)
 	return nil
 }
 
@@ -61,42 +91,128 @@ func (r *RideRepository) Delete(ctx context.Context, id string) error {
 		return ErrRideNotFound
 	}
 	delete(r.rides, id)
+	r.unindex(id)
 	return nil
 }
 
-// GetByRiderID returns all rides for a given rider (history + active).
-// This is an O(n) scan — in production, you'd index rides by riderID.
+// reindex files ride under its current rider and driver, moving it out of
+// the lists it was previously in if either has changed. Callers hold mu.
+func (r *RideRepository) reindex(ride *entities.Ride) {
+	keys := rideKeys{riderID: ride.RiderID, driverID: ride.AssignedDriver()}
+	old, indexed := r.indexedAs[ride.ID]
+	if indexed && old == keys {
+		return
+	}
+	if !indexed || old.riderID != keys.riderID {
+		if indexed {
+			removeID(r.byRider, old.riderID, ride.ID)
+		}
+		r.byRider[keys.riderID] = append(r.byRider[keys.riderID], ride.ID)
+	}
+	if !indexed || old.driverID != keys.driverID {
+		if indexed && old.driverID != "" {
+			removeID(r.byDriver, old.driverID, ride.ID)
+		}
+		if keys.driverID != "" {
+			r.byDriver[keys.driverID] = append(r.byDriver[keys.driverID], ride.ID)
+		}
+	}
+	r.indexedAs[ride.ID] = keys
+}
+
+// unindex drops a deleted ride from both indices. Callers hold mu.
+func (r *RideRepository) unindex(rideID string) {
+	keys, indexed := r.indexedAs[rideID]
+	if !indexed {
+		return
+	}
+	removeID(r.byRider, keys.riderID, rideID)
+	if keys.driverID != "" {
+		removeID(r.byDriver, keys.driverID, rideID)
+	}
+	delete(r.indexedAs, rideID)
+}
+
+// removeID deletes rideID from index[key], dropping the key once its list is
+// empty so users with no rides left don't accumulate.
+func removeID(index map[string][]string, key, rideID string) {
+	ids := index[key]
+	for i, id := range ids {
+		if id == rideID {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(index, key)
+		return
+	}
+	index[key] = ids
+}
+
+// ridesFor resolves a list of ride IDs from one of the indices. Callers hold
+// mu.
+func (r *RideRepository) ridesFor(ids []string) []*entities.Ride {
+	var rides []*entities.Ride
+	for _, id := range ids {
+		rides = append(rides, r.rides[id])
+	}
+	return rides
+}
+
+// GetByRiderID returns all rides for a given rider (history + active), oldest
+// first.
 func (r *RideRepository) GetByRiderID(ctx context.Context, riderID string) ([]*entities.Ride, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	var rides []*entities.Ride
-	for _, ride := range r.rides {
-		if ride.RiderID == riderID {
-			rides = append(rides, ride)
+	return r.ridesFor(r.byRider[riderID]), nil
+}
+
+// GetByRiderIDPaged returns one page of a rider's rides, newest first, along
+// with how many rides they have in total. Rides created at the same instant
+// are ordered by ID so pages never overlap or skip. An offset at or past the
+// end gives an empty page, not an error; limit and offset are not validated
+// here (see RideService.RideHistory).
+//
+// Go Learning Note — Sorting Under a Read Lock:
+// Many readers can hold the RLock at once, so nothing shared may be modified
+// here — sorting the index slice itself would be a data race. ridesFor builds
+// a fresh slice for this call, which is what makes sorting it safe.
+func (r *RideRepository) GetByRiderIDPaged(ctx context.Context, riderID string, limit, offset int) ([]*entities.Ride, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rides := r.ridesFor(r.byRider[riderID])
+	sort.Slice(rides, func(i, j int) bool {
+		if !rides[i].CreatedAt.Equal(rides[j].CreatedAt) {
+			return rides[i].CreatedAt.After(rides[j].CreatedAt)
 		}
+		return rides[i].ID > rides[j].ID
+	})
+
+	total := len(rides)
+	if offset >= total {
+		return []*entities.Ride{}, total, nil
 	}
-	return rides, nil
+	end := min(offset+limit, total)
+	return rides[offset:end], total, nil
 }
 
-// GetByDriverID returns all rides for a given driver.
+// GetByDriverID returns all rides currently assigned to a given driver, oldest
+// first.
 func (r *RideRepository) GetByDriverID(ctx context.Context, driverID string) ([]*entities.Ride, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	var rides []*entities.Ride
-	for _, ride := range r.rides {
-		if ride.DriverID == driverID {
-			rides = append(rides, ride)
-		}
-	}
-	return rides, nil
+	return r.ridesFor(r.byDriver[driverID]), nil
 }
 
 // GetActiveRideByRiderID returns a ride that is currently in progress for
-// a given rider, or nil if none exists. A ride is "active" if it's in any
-// non-terminal state (not completed, cancelled, or failed). This prevents
-// riders from requesting a new ride while they already have one in progress.
+// a given rider, or nil if none exists. A ride is "active" once requested and
+// until it ends: completed, cancelled or failed. A ride waiting for the rider
+// to confirm its completion still counts. This prevents riders from
+// requesting a new ride while they already have one in progress.
 //
 // Go Learning Note — Multiple Return Values:
 // Returning (nil, nil) means "no active ride found, and that's not an error."
@@ -108,16 +224,16 @@ func (r *RideRepository) GetActiveRideByRiderID(ctx context.Context, riderID str
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	for _, ride := range r.rides {
-		if ride.RiderID == riderID {
-			switch ride.Status {
-			case entities.RideStatusRequested,
-				entities.RideStatusMatching,
-				entities.RideStatusAccepted,
-				entities.RideStatusPickingUp,
-				entities.RideStatusInProgress:
-				return ride, nil
-			}
+	for _, id := range r.byRider[riderID] {
+		ride := r.rides[id]
+		switch ride.CurrentStatus() {
+		case entities.RideStatusRequested,
+			entities.RideStatusMatching,
+			entities.RideStatusAccepted,
+			entities.RideStatusPickingUp,
+			entities.RideStatusInProgress,
+			entities.RideStatusPendingConfirmation:
+			return ride, nil
 		}
 	}
 	return nil, nil
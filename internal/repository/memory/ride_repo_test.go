@@ -0,0 +1,209 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+	"uber/pkg/utils"
+)
+
+func newTestRide(id, riderID string) *entities.Ride {
+	return entities.NewRide(id, riderID,
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+}
+
+func rideIDs(rides []*entities.Ride) []string {
+	var ids []string
+	for _, ride := range rides {
+		ids = append(ids, ride.ID)
+	}
+	return ids
+}
+
+func TestRideRepository_IndicesFollowDriverAssignment(t *testing.T) {
+	repo := NewRideRepository()
+	ctx := context.Background()
+
+	ride := newTestRide("ride-1", "rider-1")
+	repo.Create(ctx, ride)
+	repo.Create(ctx, newTestRide("ride-2", "rider-2"))
+
+	if rides, _ := repo.GetByRiderID(ctx, "rider-1"); fmt.Sprint(rideIDs(rides)) != "[ride-1]" {
+		t.Errorf("Expected rider-1 to have [ride-1], got %v", rideIDs(rides))
+	}
+	if active, _ := repo.GetActiveRideByRiderID(ctx, "rider-1"); active != nil {
+		t.Errorf("Expected no active ride while estimating, got %s", active.Status)
+	}
+
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	repo.Update(ctx, ride)
+
+	if rides, _ := repo.GetByDriverID(ctx, "driver-1"); fmt.Sprint(rideIDs(rides)) != "[ride-1]" {
+		t.Errorf("Expected driver-1 to have [ride-1] after accepting, got %v", rideIDs(rides))
+	}
+	if active, _ := repo.GetActiveRideByRiderID(ctx, "rider-1"); active != ride {
+		t.Errorf("Expected ride-1 to be rider-1's active ride, got %v", active)
+	}
+
+	// driver-1 backs out and driver-2 takes the ride.
+	ride.Reassign()
+	repo.Update(ctx, ride)
+	if rides, _ := repo.GetByDriverID(ctx, "driver-1"); len(rides) != 0 {
+		t.Errorf("Expected driver-1 to have no rides after the reassignment, got %v", rideIDs(rides))
+	}
+	ride.Accept("driver-2")
+	ride.StartPickup()
+	ride.StartTrip()
+	ride.Complete()
+	repo.Update(ctx, ride)
+
+	if rides, _ := repo.GetByDriverID(ctx, "driver-2"); fmt.Sprint(rideIDs(rides)) != "[ride-1]" {
+		t.Errorf("Expected driver-2 to have [ride-1], got %v", rideIDs(rides))
+	}
+	if active, _ := repo.GetActiveRideByRiderID(ctx, "rider-1"); active != nil {
+		t.Errorf("Expected no active ride once completed, got %s", active.Status)
+	}
+	if rides, _ := repo.GetByRiderID(ctx, "rider-1"); len(rides) != 1 {
+		t.Errorf("Expected updates not to duplicate the rider's ride, got %v", rideIDs(rides))
+	}
+}
+
+func TestRideRepository_DeleteRemovesFromIndices(t *testing.T) {
+	repo := NewRideRepository()
+	ctx := context.Background()
+
+	for _, id := range []string{"ride-1", "ride-2", "ride-3"} {
+		ride := newTestRide(id, "rider-1")
+		ride.Request()
+		ride.StartMatching()
+		ride.Accept("driver-1")
+		repo.Create(ctx, ride)
+	}
+
+	if err := repo.Delete(ctx, "ride-2"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if rides, _ := repo.GetByRiderID(ctx, "rider-1"); fmt.Sprint(rideIDs(rides)) != "[ride-1 ride-3]" {
+		t.Errorf("Expected rider-1 to have [ride-1 ride-3], got %v", rideIDs(rides))
+	}
+	if rides, _ := repo.GetByDriverID(ctx, "driver-1"); fmt.Sprint(rideIDs(rides)) != "[ride-1 ride-3]" {
+		t.Errorf("Expected driver-1 to have [ride-1 ride-3], got %v", rideIDs(rides))
+	}
+
+	repo.Delete(ctx, "ride-1")
+	repo.Delete(ctx, "ride-3")
+	if len(repo.byRider) != 0 || len(repo.byDriver) != 0 || len(repo.indexedAs) != 0 {
+		t.Errorf("Expected empty indices once every ride is deleted, got %d riders, %d drivers, %d rides",
+			len(repo.byRider), len(repo.byDriver), len(repo.indexedAs))
+	}
+	if err := repo.Delete(ctx, "ride-1"); err != ErrRideNotFound {
+		t.Errorf("Expected ErrRideNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestRideRepository_GetByRiderIDPaged(t *testing.T) {
+	repo := NewRideRepository()
+	ctx := context.Background()
+
+	// Five rides stored out of order, minutes after base; rides 2 and 3 share
+	// a creation time and are ordered by ID. Newest first: ride-5, ride-4,
+	// ride-3, ride-2, ride-1.
+	base := time.Now()
+	createdAfter := map[int]time.Duration{1: 1, 2: 2, 3: 2, 4: 4, 5: 5}
+	for _, n := range []int{3, 1, 5, 2, 4} {
+		ride := newTestRide(fmt.Sprintf("ride-%d", n), "rider-1")
+		ride.CreatedAt = base.Add(createdAfter[n] * time.Minute)
+		repo.Create(ctx, ride)
+	}
+	repo.Create(ctx, newTestRide("other", "rider-2"))
+
+	tests := []struct {
+		name          string
+		limit, offset int
+		want          string
+	}{
+		{"first page", 2, 0, "[ride-5 ride-4]"},
+		{"middle page", 2, 2, "[ride-3 ride-2]"},
+		{"last partial page", 2, 4, "[ride-1]"},
+		{"everything", 10, 0, "[ride-5 ride-4 ride-3 ride-2 ride-1]"},
+		{"offset at the end", 2, 5, "[]"},
+		{"offset past the end", 2, 50, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rides, total, err := repo.GetByRiderIDPaged(ctx, "rider-1", tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("GetByRiderIDPaged failed: %v", err)
+			}
+			if total != 5 {
+				t.Errorf("Expected a total of 5, got %d", total)
+			}
+			if got := fmt.Sprint(rideIDs(rides)); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+			if rides == nil {
+				t.Error("Expected an empty page, not nil")
+			}
+		})
+	}
+
+	if rides, total, _ := repo.GetByRiderIDPaged(ctx, "rider-unknown", 10, 0); len(rides) != 0 || total != 0 {
+		t.Errorf("Expected nothing for an unknown rider, got %d of %d", len(rides), total)
+	}
+}
+
+// benchmarkRideRepo stores 100k rides spread over 10k riders and 1k drivers,
+// ten rides per rider and a hundred per driver.
+func benchmarkRideRepo() *RideRepository {
+	repo := NewRideRepository()
+	ctx := context.Background()
+	for i := 0; i < 100000; i++ {
+		ride := newTestRide(fmt.Sprintf("ride-%d", i), fmt.Sprintf("rider-%d", i%10000))
+		ride.DriverID = fmt.Sprintf("driver-%d", i%1000)
+		repo.Create(ctx, ride)
+	}
+	return repo
+}
+
+// BenchmarkGetByRiderID_100k and BenchmarkGetByRiderID_Scan_100k compare the
+// indexed lookup with the full scan it replaced.
+func BenchmarkGetByRiderID_100k(b *testing.B) {
+	repo := benchmarkRideRepo()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		repo.GetByRiderID(ctx, fmt.Sprintf("rider-%d", i%10000))
+	}
+}
+
+func BenchmarkGetByRiderID_Scan_100k(b *testing.B) {
+	repo := benchmarkRideRepo()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		riderID := fmt.Sprintf("rider-%d", i%10000)
+		var rides []*entities.Ride
+		for _, ride := range repo.rides {
+			if ride.RiderID == riderID {
+				rides = append(rides, ride)
+			}
+		}
+	}
+}
+
+func BenchmarkGetByDriverID_100k(b *testing.B) {
+	repo := benchmarkRideRepo()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		repo.GetByDriverID(ctx, fmt.Sprintf("driver-%d", i%1000))
+	}
+}
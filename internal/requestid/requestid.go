@@ -0,0 +1,26 @@
+// Package requestid carries the ID of the HTTP request that started a piece
+// of work in its context.Context, so code below the HTTP layer (services,
+// background matching) can tag its logs without importing Gin.
+package requestid
+
+import "context"
+
+// contextKey is unexported so no other package can collide with it.
+//
+// Go Learning Note — Context Keys:
+// context.WithValue compares keys with ==, so two packages both using the
+// string "request_id" would overwrite each other. A private named type makes
+// the key unique to this package; callers go through NewContext and
+// FromContext instead of touching the key.
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID stored in ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
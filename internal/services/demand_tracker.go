@@ -0,0 +1,142 @@
+package services
+
+import (
+	"sync"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+)
+
+// DemandSnapshot is the supply/demand picture for one geohash cell.
+// Ratio is Demand divided by Supply, with an empty cell counted as one driver
+// so that demand with no supply still produces a finite ratio.
+type DemandSnapshot struct {
+	Cell   string  `json:"cell"`
+	Demand int     `json:"demand"`
+	Supply int     `json:"supply"`
+	Ratio  float64 `json:"ratio"`
+}
+
+// DemandTracker counts pending ride requests per geohash cell and reads driver
+// supply for the same cell from the spatial index. It is the input to surge
+// pricing.
+//
+// Each pending ride remembers the cell it was counted in, so resolving it
+// always decrements that cell — even if the pickup has moved since, or the
+// spatial index has been reindexed at a different precision.
+//
+// Go Learning Note — One Mutex, Two Maps:
+// pending and byRide must always agree, so a single mutex guards both. Using
+// separate locks (or sync.Map) would allow a reader to observe one map updated
+// and the other not.
+type DemandTracker struct {
+	mu           sync.RWMutex
+	spatialIndex *geo.SpatialIndex
+	precision    int
+	pending      map[string]int    // cell -> pending request count
+	byRide       map[string]string // rideID -> cell it was counted in
+}
+
+// NewDemandTracker creates a tracker that aggregates demand into geohash cells
+// of the given precision. The precision should not be finer than the spatial
+// index's, since supply is counted by cell prefix.
+func NewDemandTracker(spatialIndex *geo.SpatialIndex, precision int) *DemandTracker {
+	return &DemandTracker{
+		spatialIndex: spatialIndex,
+		precision:    precision,
+		pending:      make(map[string]int),
+		byRide:       make(map[string]string),
+	}
+}
+
+// CellFor returns the demand cell containing a location.
+func (t *DemandTracker) CellFor(loc entities.Location) string {
+	return geo.Encode(loc.Latitude, loc.Longitude, t.precision)
+}
+
+// RecordRequest counts a ride as pending demand at its pickup point. Recording
+// the same ride twice is a no-op.
+func (t *DemandTracker) RecordRequest(rideID string, pickup entities.Location) {
+	cell := t.CellFor(pickup)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.byRide[rideID]; exists {
+		return
+	}
+	t.byRide[rideID] = cell
+	t.pending[cell]++
+}
+
+// Resolve removes a ride from pending demand once it is matched, fails, or is
+// cancelled. Resolving an unknown or already-resolved ride is a no-op.
+func (t *DemandTracker) Resolve(rideID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cell, exists := t.byRide[rideID]
+	if !exists {
+		return
+	}
+	delete(t.byRide, rideID)
+	t.pending[cell]--
+	if t.pending[cell] <= 0 {
+		delete(t.pending, cell)
+	}
+}
+
+// Snapshot returns current demand and supply for the cell containing loc.
+func (t *DemandTracker) Snapshot(loc entities.Location) DemandSnapshot {
+	cell := t.CellFor(loc)
+
+	t.mu.RLock()
+	demand := t.pending[cell]
+	t.mu.RUnlock()
+
+	supply := t.spatialIndex.CountInCell(cell)
+
+	effectiveSupply := supply
+	if effectiveSupply == 0 {
+		effectiveSupply = 1
+	}
+
+	return DemandSnapshot{
+		Cell:   cell,
+		Demand: demand,
+		Supply: supply,
+		Ratio:  float64(demand) / float64(effectiveSupply),
+	}
+}
+
+// AreaSnapshot returns demand and supply summed over the cell containing loc
+// and its eight neighbors. Cell is the center cell. Surge pricing uses this
+// rather than Snapshot so that drivers just across a cell boundary count as
+// supply for a pickup near the edge.
+func (t *DemandTracker) AreaSnapshot(loc entities.Location) DemandSnapshot {
+	center := t.CellFor(loc)
+	cells := geo.AllNeighbors(center) // The center and its 8 neighbors
+
+	demand := 0
+	t.mu.RLock()
+	for _, cell := range cells {
+		demand += t.pending[cell]
+	}
+	t.mu.RUnlock()
+
+	supply := 0
+	for _, cell := range cells {
+		supply += t.spatialIndex.CountInCell(cell)
+	}
+
+	effectiveSupply := supply
+	if effectiveSupply == 0 {
+		effectiveSupply = 1
+	}
+
+	return DemandSnapshot{
+		Cell:   center,
+		Demand: demand,
+		Supply: supply,
+		Ratio:  float64(demand) / float64(effectiveSupply),
+	}
+}
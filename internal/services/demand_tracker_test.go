@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+)
+
+func TestDemandTracker_ConcurrentRequestsAndDrivers(t *testing.T) {
+	index := geo.NewSpatialIndex(6)
+	tracker := NewDemandTracker(index, 6)
+
+	busy := entities.Location{Latitude: 37.7750, Longitude: -122.4180}
+	quiet := entities.Location{Latitude: 40.7128, Longitude: -74.0060}
+
+	var wg sync.WaitGroup
+
+	// 40 requests in the busy cell, 5 in the quiet one, and 10 drivers in the
+	// busy cell, all arriving concurrently.
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tracker.RecordRequest(fmt.Sprintf("busy-%d", i), busy)
+		}(i)
+	}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tracker.RecordRequest(fmt.Sprintf("quiet-%d", i), quiet)
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			index.UpdateLocation(fmt.Sprintf("driver-%d", i), busy.Latitude, busy.Longitude)
+		}(i)
+	}
+	wg.Wait()
+
+	snapshot := tracker.Snapshot(busy)
+	if snapshot.Demand != 40 || snapshot.Supply != 10 || snapshot.Ratio != 4.0 {
+		t.Errorf("Expected 40/10 = 4.0 in busy cell, got %+v", snapshot)
+	}
+
+	// Half the busy requests resolve concurrently (some twice, which must
+	// not double-decrement).
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			tracker.Resolve(fmt.Sprintf("busy-%d", i))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			tracker.Resolve(fmt.Sprintf("busy-%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	snapshot = tracker.Snapshot(busy)
+	if snapshot.Demand != 20 || snapshot.Ratio != 2.0 {
+		t.Errorf("Expected 20/10 = 2.0 after resolving, got %+v", snapshot)
+	}
+
+	// No drivers in the quiet cell: supply is reported as 0 but the ratio
+	// treats it as one driver.
+	quietSnapshot := tracker.Snapshot(quiet)
+	if quietSnapshot.Demand != 5 || quietSnapshot.Supply != 0 || quietSnapshot.Ratio != 5.0 {
+		t.Errorf("Expected 5 requests and no supply in quiet cell, got %+v", quietSnapshot)
+	}
+}
+
+func TestDemandTracker_RecordRequestIdempotent(t *testing.T) {
+	tracker := NewDemandTracker(geo.NewSpatialIndex(6), 6)
+	pickup := entities.Location{Latitude: 37.7750, Longitude: -122.4180}
+
+	tracker.RecordRequest("ride-1", pickup)
+	tracker.RecordRequest("ride-1", pickup)
+
+	if demand := tracker.Snapshot(pickup).Demand; demand != 1 {
+		t.Errorf("Expected demand 1 for a ride recorded twice, got %d", demand)
+	}
+}
+
+func TestSurgeService_MultiplierAt(t *testing.T) {
+	index := geo.NewSpatialIndex(6)
+	tracker := NewDemandTracker(index, 6)
+	surge := NewSurgeService(tracker, 3.0)
+	ctx := context.Background()
+	pickup := entities.Location{Latitude: 37.7750, Longitude: -122.4180}
+
+	index.UpdateLocation("driver-1", pickup.Latitude, pickup.Longitude)
+	index.UpdateLocation("driver-2", pickup.Latitude, pickup.Longitude)
+	index.UpdateLocation("driver-3", pickup.Latitude, pickup.Longitude)
+
+	tracker.RecordRequest("ride-1", pickup)
+	if m := surge.MultiplierAt(ctx, pickup); m != 1.0 {
+		t.Errorf("Expected no surge with supply > demand, got %v", m)
+	}
+
+	for i := 2; i <= 5; i++ {
+		tracker.RecordRequest(fmt.Sprintf("ride-%d", i), pickup)
+	}
+	if m := surge.MultiplierAt(ctx, pickup); m != 1.7 {
+		t.Errorf("Expected surge 1.7 for 5 requests / 3 drivers, got %v", m)
+	}
+}
+
+func TestSurgeMultiplier(t *testing.T) {
+	tests := []struct {
+		name           string
+		demand, supply int
+		max            float64
+		want           float64
+	}{
+		{"no demand, no drivers", 0, 0, 3.0, 1.0},
+		{"no demand", 0, 5, 3.0, 1.0},
+		{"supply exceeds demand", 2, 5, 3.0, 1.0},
+		{"supply matches demand", 4, 4, 3.0, 1.0},
+		{"ratio rounded to one decimal", 5, 3, 3.0, 1.7},
+		{"ratio at the cap", 6, 2, 3.0, 3.0},
+		{"ratio above the cap", 10, 2, 3.0, 3.0},
+		{"no drivers caps at max", 1, 0, 3.0, 3.0},
+		{"no drivers, uncapped", 4, 0, 0, 4.0},
+		{"uncapped ratio", 10, 2, 0, 5.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := surgeMultiplier(tt.demand, tt.supply, tt.max); got != tt.want {
+				t.Errorf("surgeMultiplier(%d, %d, %v) = %v, want %v", tt.demand, tt.supply, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSurgeService_CountsNeighboringCells(t *testing.T) {
+	index := geo.NewSpatialIndex(6)
+	tracker := NewDemandTracker(index, 6)
+	surge := NewSurgeService(tracker, 3.0)
+	ctx := context.Background()
+	pickup := entities.Location{Latitude: 37.7750, Longitude: -122.4180}
+
+	// Two requests at the pickup and none of the drivers in its cell: with
+	// no supply anywhere the multiplier is the cap.
+	tracker.RecordRequest("ride-1", pickup)
+	tracker.RecordRequest("ride-2", pickup)
+	if m := surge.MultiplierAt(ctx, pickup); m != 3.0 {
+		t.Errorf("Expected max surge with no drivers around, got %v", m)
+	}
+
+	// Drivers one cell north still count as supply for this pickup.
+	north := geo.Neighbor(tracker.CellFor(pickup), "n")
+	lat, lon := geo.Decode(north)
+	index.UpdateLocation("driver-1", lat, lon)
+	index.UpdateLocation("driver-2", lat, lon)
+
+	snapshot := tracker.AreaSnapshot(pickup)
+	if snapshot.Demand != 2 || snapshot.Supply != 2 || snapshot.Cell != tracker.CellFor(pickup) {
+		t.Errorf("Expected demand 2 and supply 2 around %s, got %+v", tracker.CellFor(pickup), snapshot)
+	}
+	if m := surge.MultiplierAt(ctx, pickup); m != 1.0 {
+		t.Errorf("Expected no surge with neighboring drivers matching demand, got %v", m)
+	}
+}
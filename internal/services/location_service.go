@@ -2,11 +2,26 @@ package services
 
 import (
 	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 	"uber/internal/domain/entities"
 	"uber/internal/geo"
 	"uber/internal/repository/memory"
+	"uber/pkg/utils"
 )
 
+// ErrDriverInRide is returned when a driver on a ride tries to go online or
+// offline; their status is driven by the ride until it ends.
+var ErrDriverInRide = errors.New("driver is on a ride")
+
+// ErrDriverLocationUnknown is returned by EstimatePickupETA for a driver who
+// has no stored location: they haven't pinged yet, went offline, or were
+// swept as stale.
+var ErrDriverLocationUnknown = errors.New("driver location is not known")
+
 // LocationService manages real-time driver location tracking. It coordinates
 // between the spatial index (for fast proximity queries) and the location
 // repository (for persistent storage). Both are updated on every location ping.
@@ -14,8 +29,47 @@ type LocationService struct {
 	spatialIndex *geo.SpatialIndex
 	driverRepo   *memory.DriverRepository
 	locationRepo *memory.LocationRepository
+
+	// availabilityBatchSize caps how many drivers FindNearbyAvailableDrivers
+	// looks up per bulk repository read. 0 means a single read for all.
+	availabilityBatchSize int
+
+	// barriers penalize or rule out drivers across a river or similar
+	// divide from the search point (see geo.ApplyBarriers).
+	barriers []geo.Barrier
+
+	// coalesceWindow skips index writes for same-cell pings that follow the
+	// last write this closely (see config.GeoConfig.IndexCoalesceWindow).
+	coalesceWindow time.Duration
+
+	// Ping counters for the stats endpoint. Every received ping is either an
+	// index write or coalesced.
+	updatesReceived  atomic.Uint64
+	indexWrites      atomic.Uint64
+	updatesCoalesced atomic.Uint64
+
+	// readyRequiresDriver makes Readiness report not ready until the first
+	// location ping arrives.
+	readyRequiresDriver bool
+
+	// onRidePing is called with each location of a driver on a ride; nil
+	// until SetRidePingFunc.
+	onRidePing RidePingFunc
+
+	// now is the clock SweepStaleLocations measures location age against;
+	// time.Now outside tests.
+	now func() time.Time
+
+	// stopSweeper ends the goroutine started by StartLocationSweeper;
+	// stopOnce makes Stop safe to call twice, or with no sweeper running.
+	stopSweeper chan struct{}
+	stopOnce    sync.Once
 }
 
+// RidePingFunc receives the location of a driver who is on a ride, after it
+// has been stored.
+type RidePingFunc func(ctx context.Context, location *entities.DriverLocation)
+
 // NewLocationService creates a LocationService with its dependencies.
 func NewLocationService(
 	spatialIndex *geo.SpatialIndex,
@@ -26,38 +80,119 @@ func NewLocationService(
 		spatialIndex: spatialIndex,
 		driverRepo:   driverRepo,
 		locationRepo: locationRepo,
+		now:          time.Now,
+		stopSweeper:  make(chan struct{}),
+	}
+}
+
+// SetAvailabilityBatchSize sets how many candidate drivers are checked per
+// bulk availability lookup. Smaller batches hold the repository lock for
+// shorter stretches; 0 (the default) checks every candidate in one read.
+func (s *LocationService) SetAvailabilityBatchSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	s.availabilityBatchSize = n
+}
+
+// SetBarriers configures the physical barriers applied to nearby-driver
+// searches. Passing nil (the default) leaves results as plain straight-line
+// distance.
+func (s *LocationService) SetBarriers(barriers []geo.Barrier) {
+	s.barriers = barriers
+}
+
+// SetCoalesceWindow sets how soon after a driver's last spatial index write a
+// same-cell ping is left out of the index. 0 (the default) writes every ping.
+func (s *LocationService) SetCoalesceWindow(window time.Duration) {
+	if window < 0 {
+		window = 0
 	}
+	s.coalesceWindow = window
+}
+
+// SetReadyRequiresDriver sets whether Readiness holds the service not ready
+// until some driver has sent a location (see
+// config.ServerConfig.ReadyRequiresDriver). Off by default.
+func (s *LocationService) SetReadyRequiresDriver(require bool) {
+	s.readyRequiresDriver = require
+}
+
+// SetRidePingFunc sets who hears about location pings from drivers on a ride,
+// typically RideService.ObserveDriverLocation. It is a setter because the
+// RideService is built after the LocationService it depends on.
+func (s *LocationService) SetRidePingFunc(onRidePing RidePingFunc) {
+	s.onRidePing = onRidePing
 }
 
 // UpdateDriverLocation processes a driver's GPS location ping. It auto-creates
 // the driver if needed (for the MVP) and automatically marks offline drivers
 // as available when they start sending location updates — the assumption being
 // that a driver sending their location means they're ready to accept rides.
-func (s *LocationService) UpdateDriverLocation(ctx context.Context, driverID string, lat, lon float64) (*entities.DriverLocation, error) {
+//
+// created reports whether this ping gave the driver their first stored
+// location (making them trackable), as opposed to updating an existing one.
+func (s *LocationService) UpdateDriverLocation(ctx context.Context, driverID string, lat, lon float64) (location *entities.DriverLocation, created bool, err error) {
 	// Ensure driver exists (creates with default data if not).
 	driver, err := s.driverRepo.GetOrCreate(ctx, driverID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	// Automatically set driver to available when they start sending location.
 	if driver.Status == entities.DriverStatusOffline {
 		driver.GoOnline()
 		if err := s.driverRepo.Update(ctx, driver); err != nil {
-			return nil, err
+			return nil, false, err
 		}
 	}
 
+	s.updatesReceived.Add(1)
+
 	// Update spatial index — this computes the geohash and moves the driver
-	// to the correct cell.
-	location := s.spatialIndex.UpdateLocation(driverID, lat, lon)
+	// to the correct cell — unless the ping is coalesced, in which case only
+	// the location repository hears about it.
+	if prev := s.coalescible(driverID, lat, lon); prev != nil {
+		s.updatesCoalesced.Add(1)
+		location = entities.NewDriverLocation(driverID, lat, lon, prev.Geohash)
+	} else {
+		s.indexWrites.Add(1)
+		location = s.spatialIndex.UpdateLocation(driverID, lat, lon)
+	}
 
 	// Also persist to the location repository for historical/debug queries.
-	if err := s.locationRepo.UpdateDriverLocation(ctx, location); err != nil {
-		return nil, err
+	created, err = s.locationRepo.UpdateDriverLocation(ctx, location)
+	if err != nil {
+		return nil, false, err
+	}
+	if created {
+		log.Printf("[LOCATION] Driver %s is now trackable at %s", driverID, location.Geohash)
+	}
+
+	// Most pings come from drivers waiting for a ride; only the rest can
+	// matter to a ride in progress.
+	if s.onRidePing != nil && driver.Status == entities.DriverStatusInRide {
+		s.onRidePing(ctx, location)
 	}
 
-	return location, nil
+	return location, created, nil
+}
+
+// coalescible returns the driver's indexed location if a ping at (lat, lon)
+// can skip the index write: it is still in the same cell and the index entry
+// is younger than the coalesce window. Otherwise it returns nil.
+func (s *LocationService) coalescible(driverID string, lat, lon float64) *entities.DriverLocation {
+	if s.coalesceWindow == 0 {
+		return nil
+	}
+	prev := s.spatialIndex.GetDriverLocation(driverID)
+	if prev == nil || time.Since(prev.UpdatedAt) >= s.coalesceWindow {
+		return nil
+	}
+	if geo.Encode(lat, lon, s.spatialIndex.Precision()) != prev.Geohash {
+		return nil
+	}
+	return prev
 }
 
 // GetDriverLocation retrieves a driver's last known location.
@@ -65,10 +200,45 @@ func (s *LocationService) GetDriverLocation(ctx context.Context, driverID string
 	return s.locationRepo.GetDriverLocation(ctx, driverID)
 }
 
+// GetLocationHistory returns a driver's recorded pings between from and to
+// (inclusive), oldest first. A positive limit keeps only the most recent
+// limit of them — the tail of the trail, still in order; 0 returns them all.
+func (s *LocationService) GetLocationHistory(ctx context.Context, driverID string, from, to time.Time, limit int) ([]entities.DriverLocation, error) {
+	history, err := s.locationRepo.GetLocationHistory(ctx, driverID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	return history, nil
+}
+
+// TripDistanceKm sums the straight-line legs of the path a driver has pinged
+// since the given time, starting from start (normally the pickup point). It
+// is only as precise as the ping rate and the location history size allow:
+// corners cut between pings, and pings older than the history ring, are lost.
+func (s *LocationService) TripDistanceKm(ctx context.Context, driverID string, start entities.Location, since time.Time) (float64, error) {
+	pings, err := s.locationRepo.GetLocationHistory(ctx, driverID, since, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0.0
+	prev := start
+	for _, ping := range pings {
+		total += utils.HaversineDistance(prev.Latitude, prev.Longitude, ping.Location.Latitude, ping.Location.Longitude)
+		prev = ping.Location
+	}
+	return total, nil
+}
+
 // FindNearbyAvailableDrivers finds drivers that are both geographically nearby
 // AND have a status of "available." The spatial index provides the coarse
 // proximity filter, then we check each driver's status against the driver
-// repository.
+// repository in bulk (see DriverRepository.GetByIDs), batchSize IDs at a time.
+// Any configured barriers are applied last, so drivers across a river rank
+// by their detour distance rather than as the crow flies.
 //
 // Go Learning Note — Filtering Pattern:
 // The pattern of "query a broad set, then filter" is common in Go. Here we get
@@ -80,18 +250,225 @@ func (s *LocationService) FindNearbyAvailableDrivers(ctx context.Context, lat, l
 	nearbyDrivers := s.spatialIndex.FindNearbyDrivers(ctx, lat, lon, radiusKm)
 
 	// Filter to only available drivers by checking each driver's current status.
+	// Batches preserve the distance ordering from the spatial index.
+	batchSize := s.availabilityBatchSize
+	if batchSize <= 0 || batchSize > len(nearbyDrivers) {
+		batchSize = len(nearbyDrivers)
+	}
+
 	var availableDrivers []geo.DriverWithDistance
-	for _, dwd := range nearbyDrivers {
-		driver, err := s.driverRepo.GetByID(ctx, dwd.Driver.DriverID)
+	for start := 0; start < len(nearbyDrivers); start += batchSize {
+		end := start + batchSize
+		if end > len(nearbyDrivers) {
+			end = len(nearbyDrivers)
+		}
+		batch := nearbyDrivers[start:end]
+
+		ids := make([]string, len(batch))
+		for i, dwd := range batch {
+			ids[i] = dwd.Driver.DriverID
+		}
+		drivers, err := s.driverRepo.GetByIDs(ctx, ids)
 		if err != nil {
-			continue // Driver might have been deleted; skip them.
+			return nil, err
 		}
-		if driver.IsAvailable() {
-			availableDrivers = append(availableDrivers, dwd)
+
+		for _, dwd := range batch {
+			driver, ok := drivers[dwd.Driver.DriverID]
+			if !ok {
+				continue // Driver might have been deleted; skip them.
+			}
+			if driver.IsAvailable() {
+				availableDrivers = append(availableDrivers, dwd)
+			}
 		}
 	}
 
-	return availableDrivers, nil
+	return geo.ApplyBarriers(availableDrivers, lat, lon, radiusKm, s.barriers), nil
+}
+
+// EstimateNearestDriverETA returns how many minutes the nearest available
+// driver within radiusKm would need to reach the given point, using the same
+// straight-line distance and average-speed model as trip estimates. found is
+// false when no available driver is in range.
+func (s *LocationService) EstimateNearestDriverETA(ctx context.Context, lat, lon float64, radiusKm float64) (etaMins float64, found bool, err error) {
+	_, etas, err := s.EstimateNearbyDriverETAs(ctx, lat, lon, radiusKm, 1)
+	if err != nil || len(etas) == 0 {
+		return 0, false, err
+	}
+	return etas[0], true, nil
+}
+
+// EstimatePickupETA returns how many minutes driverID needs to reach the
+// pickup point from their last known location, using the same straight-line
+// distance and average-speed model as EstimateNearestDriverETA. It fails
+// with ErrDriverLocationUnknown when there is no location to start from.
+func (s *LocationService) EstimatePickupETA(ctx context.Context, driverID string, pickupLat, pickupLon float64) (float64, error) {
+	location, err := s.locationRepo.GetDriverLocation(ctx, driverID)
+	if err != nil {
+		return 0, err
+	}
+	if location == nil {
+		return 0, ErrDriverLocationUnknown
+	}
+	distanceKm := utils.HaversineDistance(location.Location.Latitude, location.Location.Longitude, pickupLat, pickupLon)
+	return utils.EstimateDuration(distanceKm), nil
+}
+
+// EstimateNearbyDriverETAs returns how many available drivers are within
+// radiusKm of the point and the ETAs in minutes of the nearest of them,
+// ascending, at most limit entries (limit <= 0 returns them all). ETAs use the
+// same model as EstimateNearestDriverETA.
+func (s *LocationService) EstimateNearbyDriverETAs(ctx context.Context, lat, lon float64, radiusKm float64, limit int) (count int, etasMins []float64, err error) {
+	nearby, err := s.FindNearbyAvailableDrivers(ctx, lat, lon, radiusKm)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Results are sorted nearest-first, so the first entries are the fastest.
+	n := len(nearby)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	etasMins = make([]float64, n)
+	for i := range etasMins {
+		etasMins[i] = utils.EstimateDuration(nearby[i].Distance)
+	}
+	return len(nearby), etasMins, nil
+}
+
+// DriverStats is a point-in-time breakdown of the driver fleet, used to
+// diagnose "no drivers available" complaints. Indexed counts drivers present
+// in the spatial index, which can differ from Available (e.g., an in-ride
+// driver still pinging, or an available driver who never sent a location).
+//
+// LocationUpdates counts pings since startup, so operators can see how much
+// index write traffic coalescing is saving.
+type DriverStats struct {
+	Available       int                 `json:"available"`
+	InRide          int                 `json:"in_ride"`
+	Offline         int                 `json:"offline"`
+	Total           int                 `json:"total"`
+	Indexed         int                 `json:"indexed"`
+	LocationUpdates LocationUpdateStats `json:"location_updates"`
+}
+
+// LocationUpdateStats counts location pings: every one received either
+// became a spatial index write or was coalesced (kept out of the index).
+type LocationUpdateStats struct {
+	Received    uint64 `json:"received"`
+	IndexWrites uint64 `json:"index_writes"`
+	Coalesced   uint64 `json:"coalesced"`
+}
+
+// GetDriverStats counts drivers by status from the driver repository and
+// reports how many drivers the spatial index currently holds.
+func (s *LocationService) GetDriverStats(ctx context.Context) DriverStats {
+	counts := s.driverRepo.CountByStatus(ctx)
+
+	stats := DriverStats{
+		Available: counts[entities.DriverStatusAvailable],
+		InRide:    counts[entities.DriverStatusInRide],
+		Offline:   counts[entities.DriverStatusOffline],
+		Indexed:   s.spatialIndex.Count(),
+		LocationUpdates: LocationUpdateStats{
+			Received:    s.updatesReceived.Load(),
+			IndexWrites: s.indexWrites.Load(),
+			Coalesced:   s.updatesCoalesced.Load(),
+		},
+	}
+	for _, n := range counts {
+		stats.Total += n
+	}
+	return stats
+}
+
+// Readiness statuses reported by LocationService.Readiness.
+const (
+	ReadinessReady    = "ready"
+	ReadinessDegraded = "degraded"
+	ReadinessNotReady = "not_ready"
+)
+
+// Readiness says whether the service can usefully take ride requests. Ready is
+// what a load balancer should act on; Status and Reason explain it.
+type Readiness struct {
+	Ready  bool   `json:"ready"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Readiness reports ReadinessNotReady if ReadyRequiresDriver is set and no
+// driver has pinged since startup. Otherwise the service is ready, but
+// ReadinessDegraded while no driver is online, since every match would fail.
+// The warm-up gate only applies once: a fleet that later goes offline leaves
+// the service degraded rather than not ready, so a quiet hour doesn't pull
+// the instance out of rotation.
+func (s *LocationService) Readiness(ctx context.Context) Readiness {
+	if s.readyRequiresDriver && s.updatesReceived.Load() == 0 {
+		return Readiness{Ready: false, Status: ReadinessNotReady, Reason: "waiting for the first driver location"}
+	}
+
+	counts := s.driverRepo.CountByStatus(ctx)
+	if counts[entities.DriverStatusAvailable]+counts[entities.DriverStatusInRide] == 0 {
+		return Readiness{Ready: true, Status: ReadinessDegraded, Reason: "no drivers online"}
+	}
+	return Readiness{Ready: true, Status: ReadinessReady}
+}
+
+// ReindexSpatialIndex re-homes every indexed driver at a new geohash
+// precision. Returns geo.ErrInvalidPrecision for an out-of-range precision.
+func (s *LocationService) ReindexSpatialIndex(ctx context.Context, precision int) error {
+	return s.spatialIndex.Reindex(precision)
+}
+
+// SpatialIndexPrecision returns the geohash precision currently in use.
+func (s *LocationService) SpatialIndexPrecision() int {
+	return s.spatialIndex.Precision()
+}
+
+// SetDriverOnline lets a driver explicitly go online (available for offers)
+// or offline. Going offline also removes them from the spatial index and the
+// location repository, so they stop showing up in nearby-driver searches at
+// once rather than only failing the availability check; their next location
+// ping puts them back, just as it would for a driver who never went offline.
+// Going online doesn't index anyone, since there is no position to index until
+// the driver sends one.
+//
+// A driver on a ride can do neither: going online would free them for a
+// second ride, and going offline would drop the location the ride's tracking
+// relies on. Both return ErrDriverInRide.
+func (s *LocationService) SetDriverOnline(ctx context.Context, driverID string, online bool) (*entities.Driver, error) {
+	driver, err := s.driverRepo.GetOrCreate(ctx, driverID)
+	if err != nil {
+		return nil, err
+	}
+	if driver.Status == entities.DriverStatusInRide {
+		return nil, ErrDriverInRide
+	}
+
+	if online {
+		if driver.Status == entities.DriverStatusOffline {
+			driver.GoOnline()
+			if err := s.driverRepo.Update(ctx, driver); err != nil {
+				return nil, err
+			}
+			log.Printf("[LOCATION] Driver %s went online", driverID)
+		}
+		return driver, nil
+	}
+
+	if driver.Status != entities.DriverStatusOffline {
+		driver.GoOffline()
+		if err := s.driverRepo.Update(ctx, driver); err != nil {
+			return nil, err
+		}
+		log.Printf("[LOCATION] Driver %s went offline", driverID)
+	}
+	if err := s.RemoveDriverLocation(ctx, driverID); err != nil {
+		return nil, err
+	}
+	return driver, nil
 }
 
 // RemoveDriverLocation removes a driver from both the spatial index and the
@@ -100,3 +477,72 @@ func (s *LocationService) RemoveDriverLocation(ctx context.Context, driverID str
 	s.spatialIndex.RemoveDriver(driverID)
 	return s.locationRepo.RemoveDriverLocation(ctx, driverID)
 }
+
+// SweepStaleLocations removes every driver whose last location ping is older
+// than ttl from the spatial index and the location repository, and returns
+// how many it removed. A driver app that crashes never sends the offline
+// request, so without this its last position would stay in the index for
+// good, counted in stats and found by every search near it. Drivers on a ride
+// are kept: the ride's tracking reads their location, and the ride ends one
+// way or another. A swept driver's next ping makes them trackable again.
+func (s *LocationService) SweepStaleLocations(ctx context.Context, ttl time.Duration) int {
+	cutoff := s.now().Add(-ttl)
+
+	removed := 0
+	for _, driverID := range s.locationRepo.StaleDriverIDs(ctx, cutoff) {
+		if driver, err := s.driverRepo.GetByID(ctx, driverID); err == nil && driver.Status == entities.DriverStatusInRide {
+			continue
+		}
+		// Checked again under the repository lock, in case a ping has
+		// arrived since the scan.
+		if !s.locationRepo.RemoveDriverLocationIfStale(ctx, driverID, cutoff) {
+			continue
+		}
+		// And again under the index's locks: a ping writes the index before
+		// the repository, so one that lands between the two removals has
+		// already refreshed the index entry, and its repository write is
+		// about to put back what was just removed. Unconditionally removing
+		// it here would leave the driver in the repository but unsearchable.
+		s.spatialIndex.RemoveDriverIfStale(driverID, cutoff)
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("[LOCATION] Removed %d drivers with no location ping in %s", removed, ttl)
+	}
+	return removed
+}
+
+// StartLocationSweeper runs SweepStaleLocations every ttl/2 in a background
+// goroutine until Stop is called, so a driver who stops pinging is gone from
+// the index between ttl and 1.5×ttl later. A ttl of 0 or less starts nothing.
+//
+// Go Learning Note — Sweeping vs. Checking on Read:
+// Matching already skips locations older than Matching.MaxLocationAge when it
+// reads them (dropStaleLocations), which keeps stale drivers from being
+// offered rides. The sweeper is about what a read-time check can't fix: the
+// entries still take memory, still make every nearby search scan and discard
+// them, and still count towards the index size in /debug/drivers/stats and
+// /metrics.
+func (s *LocationService) StartLocationSweeper(ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.SweepStaleLocations(context.Background(), ttl)
+			case <-s.stopSweeper:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the location sweeper, if one was started. Calling it again is
+// harmless.
+func (s *LocationService) Stop() {
+	s.stopOnce.Do(func() { close(s.stopSweeper) })
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+	"uber/internal/repository/memory"
+	"uber/pkg/utils"
+)
+
+func setupLocationService() (*LocationService, *memory.DriverRepository) {
+	driverRepo := memory.NewDriverRepository()
+	locationRepo := memory.NewLocationRepository()
+	spatialIndex := geo.NewSpatialIndex(6)
+
+	service := NewLocationService(spatialIndex, driverRepo, locationRepo)
+	return service, driverRepo
+}
+
+func TestLocationService_GetDriverStats(t *testing.T) {
+	service, driverRepo := setupLocationService()
+	ctx := context.Background()
+
+	// Two available drivers that have pinged their location.
+	service.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	service.UpdateDriverLocation(ctx, "driver-2", 37.772, -122.412)
+
+	// One driver on a ride (still pinging).
+	service.UpdateDriverLocation(ctx, "driver-3", 37.773, -122.413)
+	driverRepo.SetStatus(ctx, "driver-3", entities.DriverStatusInRide)
+
+	// Two offline drivers that never sent a location.
+	driverRepo.Create(ctx, entities.NewDriver("driver-4", "D4", "d4@example.com", "555-0004", "v4"))
+	driverRepo.Create(ctx, entities.NewDriver("driver-5", "D5", "d5@example.com", "555-0005", "v5"))
+
+	stats := service.GetDriverStats(ctx)
+
+	if stats.Available != 2 {
+		t.Errorf("Expected 2 available, got %d", stats.Available)
+	}
+	if stats.InRide != 1 {
+		t.Errorf("Expected 1 in_ride, got %d", stats.InRide)
+	}
+	if stats.Offline != 2 {
+		t.Errorf("Expected 2 offline, got %d", stats.Offline)
+	}
+	if stats.Total != 5 {
+		t.Errorf("Expected 5 total, got %d", stats.Total)
+	}
+	if stats.Indexed != 3 {
+		t.Errorf("Expected 3 indexed, got %d", stats.Indexed)
+	}
+}
+
+func TestLocationService_Readiness(t *testing.T) {
+	service, driverRepo := setupLocationService()
+	service.SetReadyRequiresDriver(true)
+	ctx := context.Background()
+
+	if got := service.Readiness(ctx); got.Ready || got.Status != ReadinessNotReady {
+		t.Fatalf("Expected not ready before any driver pings, got %+v", got)
+	}
+
+	service.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	if got := service.Readiness(ctx); !got.Ready || got.Status != ReadinessReady {
+		t.Fatalf("Expected ready after a driver pinged, got %+v", got)
+	}
+
+	// The fleet going offline later degrades the service but keeps it in
+	// rotation.
+	driverRepo.SetStatus(ctx, "driver-1", entities.DriverStatusOffline)
+	if got := service.Readiness(ctx); !got.Ready || got.Status != ReadinessDegraded {
+		t.Errorf("Expected ready but degraded with no drivers online, got %+v", got)
+	}
+}
+
+func TestLocationService_ReadinessWithoutGate(t *testing.T) {
+	service, _ := setupLocationService()
+
+	got := service.Readiness(context.Background())
+	if !got.Ready || got.Status != ReadinessDegraded || got.Reason != "no drivers online" {
+		t.Errorf("Expected ready but degraded on a cold start without the gate, got %+v", got)
+	}
+}
+
+// findNearbyAvailablePerID is the original one-GetByID-per-candidate filter,
+// kept here as the reference the bulk path must agree with.
+func findNearbyAvailablePerID(ctx context.Context, service *LocationService, lat, lon, radiusKm float64) []string {
+	var ids []string
+	for _, dwd := range service.spatialIndex.FindNearbyDrivers(ctx, lat, lon, radiusKm) {
+		driver, err := service.driverRepo.GetByID(ctx, dwd.Driver.DriverID)
+		if err != nil || !driver.IsAvailable() {
+			continue
+		}
+		ids = append(ids, dwd.Driver.DriverID)
+	}
+	return ids
+}
+
+func TestLocationService_CoalescesRapidPings(t *testing.T) {
+	service, _ := setupLocationService()
+	service.SetCoalesceWindow(time.Minute)
+	ctx := context.Background()
+
+	// Ten rapid pings creeping a few meters within one cell.
+	for i := 0; i < 10; i++ {
+		service.UpdateDriverLocation(ctx, "driver-1", 37.7710+0.00001*float64(i), -122.4110)
+	}
+
+	stats := service.GetDriverStats(ctx).LocationUpdates
+	if stats.Received != 10 || stats.IndexWrites != 1 || stats.Coalesced != 9 {
+		t.Errorf("Expected 10 received, 1 index write, 9 coalesced; got %+v", stats)
+	}
+
+	// History still has the latest position even though the index doesn't.
+	latest, _ := service.GetDriverLocation(ctx, "driver-1")
+	if latest.Location.Latitude != 37.7710+0.00001*9 {
+		t.Errorf("Expected the repository to hold the last ping, got %v", latest.Location)
+	}
+
+	// Leaving the cell is always written, window or not.
+	service.UpdateDriverLocation(ctx, "driver-1", 37.80, -122.45)
+	stats = service.GetDriverStats(ctx).LocationUpdates
+	if stats.IndexWrites != 2 {
+		t.Errorf("Expected a cell change to write the index, got %+v", stats)
+	}
+	if loc := service.spatialIndex.GetDriverLocation("driver-1"); loc.Location.Latitude != 37.80 {
+		t.Errorf("Expected the index to follow the driver to the new cell, got %v", loc.Location)
+	}
+}
+
+func TestLocationService_NoCoalescingByDefault(t *testing.T) {
+	service, _ := setupLocationService()
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		service.UpdateDriverLocation(ctx, "driver-1", 37.7710, -122.4110)
+	}
+
+	stats := service.GetDriverStats(ctx).LocationUpdates
+	if stats.IndexWrites != 5 || stats.Coalesced != 0 {
+		t.Errorf("Expected every ping written, got %+v", stats)
+	}
+}
+
+func TestLocationService_FindNearbyAvailableDrivers_MatchesPerIDPath(t *testing.T) {
+	service, driverRepo := setupLocationService()
+	ctx := context.Background()
+
+	for i := 0; i < 12; i++ {
+		id := fmt.Sprintf("driver-%d", i)
+		service.UpdateDriverLocation(ctx, id, 37.7750+float64(i)*0.0005, -122.4180)
+	}
+	// A mix of statuses, plus one indexed driver missing from the repository.
+	driverRepo.SetStatus(ctx, "driver-2", entities.DriverStatusInRide)
+	driverRepo.SetStatus(ctx, "driver-5", entities.DriverStatusOffline)
+	driverRepo.SetStatus(ctx, "driver-9", entities.DriverStatusInRide)
+	driverRepo.Delete(ctx, "driver-7")
+
+	expected := findNearbyAvailablePerID(ctx, service, 37.7750, -122.4180, 5.0)
+	if len(expected) != 8 {
+		t.Fatalf("Expected 8 available drivers on the per-ID path, got %d", len(expected))
+	}
+
+	for _, batchSize := range []int{0, 1, 3, 5, 100} {
+		service.SetAvailabilityBatchSize(batchSize)
+
+		nearby, err := service.FindNearbyAvailableDrivers(ctx, 37.7750, -122.4180, 5.0)
+		if err != nil {
+			t.Fatalf("batch %d: FindNearbyAvailableDrivers failed: %v", batchSize, err)
+		}
+		if len(nearby) != len(expected) {
+			t.Fatalf("batch %d: expected %d drivers, got %d", batchSize, len(expected), len(nearby))
+		}
+		for i, dwd := range nearby {
+			if dwd.Driver.DriverID != expected[i] {
+				t.Errorf("batch %d: position %d expected %s, got %s", batchSize, i, expected[i], dwd.Driver.DriverID)
+			}
+		}
+	}
+}
+
+func TestLocationService_UpdateDriverLocation_ReportsCreated(t *testing.T) {
+	service, _ := setupLocationService()
+	ctx := context.Background()
+
+	first, created, err := service.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	if err != nil {
+		t.Fatalf("First ping failed: %v", err)
+	}
+	if !created {
+		t.Error("Expected created=true on the driver's first ping")
+	}
+
+	second, created, err := service.UpdateDriverLocation(ctx, "driver-1", 37.772, -122.412)
+	if err != nil {
+		t.Fatalf("Second ping failed: %v", err)
+	}
+	if created {
+		t.Error("Expected created=false on a subsequent ping")
+	}
+	if second.Location.Latitude == first.Location.Latitude {
+		t.Error("Expected the second ping to update the stored location")
+	}
+}
+
+func TestLocationService_GetLocationHistory_Limit(t *testing.T) {
+	service, _ := setupLocationService()
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		service.UpdateDriverLocation(ctx, "driver-1", 37.770+float64(i)*0.001, -122.41)
+	}
+
+	all, _ := service.GetLocationHistory(ctx, "driver-1", time.Time{}, time.Now(), 0)
+	if len(all) != 5 {
+		t.Fatalf("Expected all 5 pings with no limit, got %d", len(all))
+	}
+
+	// The newest two, still oldest first.
+	tail, err := service.GetLocationHistory(ctx, "driver-1", time.Time{}, time.Now(), 2)
+	if err != nil {
+		t.Fatalf("GetLocationHistory failed: %v", err)
+	}
+	if len(tail) != 2 || tail[0].Location.Latitude != 37.773 || tail[1].Location.Latitude != 37.774 {
+		t.Errorf("Expected the last two pings in order, got %+v", tail)
+	}
+
+	if more, _ := service.GetLocationHistory(ctx, "driver-1", time.Time{}, time.Now(), 10); len(more) != 5 {
+		t.Errorf("Expected a limit above the count to return everything, got %d", len(more))
+	}
+}
+
+func TestLocationService_SweepStaleLocations(t *testing.T) {
+	service, driverRepo := setupLocationService()
+	ctx := context.Background()
+
+	service.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	service.UpdateDriverLocation(ctx, "driver-2", 37.772, -122.412)
+	service.UpdateDriverLocation(ctx, "driver-3", 37.773, -122.413)
+	driverRepo.SetStatus(ctx, "driver-3", entities.DriverStatusInRide)
+
+	// Ten minutes on, driver-2 has pinged again; the others have gone quiet.
+	later := time.Now().Add(10 * time.Minute)
+	service.now = func() time.Time { return later }
+	fresh := entities.NewDriverLocation("driver-2", 37.774, -122.414, geo.Encode(37.774, -122.414, 6))
+	fresh.UpdatedAt = later
+	service.locationRepo.UpdateDriverLocation(ctx, fresh)
+
+	if removed := service.SweepStaleLocations(ctx, 5*time.Minute); removed != 1 {
+		t.Fatalf("Expected 1 driver swept, got %d", removed)
+	}
+	if loc, _ := service.GetDriverLocation(ctx, "driver-1"); loc != nil {
+		t.Error("Expected driver-1's stale location to be removed from the repository")
+	}
+	if service.spatialIndex.GetDriverLocation("driver-1") != nil {
+		t.Error("Expected driver-1 to be removed from the spatial index")
+	}
+	if loc, _ := service.GetDriverLocation(ctx, "driver-2"); loc == nil {
+		t.Error("Expected driver-2, who pinged recently, to be kept")
+	}
+	if loc, _ := service.GetDriverLocation(ctx, "driver-3"); loc == nil {
+		t.Error("Expected driver-3, who is on a ride, to be kept")
+	}
+	if service.spatialIndex.Count() != 2 {
+		t.Errorf("Expected 2 drivers left in the index, got %d", service.spatialIndex.Count())
+	}
+
+	// The next ping makes a swept driver trackable again.
+	if _, created, _ := service.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411); !created {
+		t.Error("Expected driver-1's next ping to re-create their location")
+	}
+}
+
+func TestLocationService_SweepKeepsIndexEntryRefreshedMidSweep(t *testing.T) {
+	service, _ := setupLocationService()
+	ctx := context.Background()
+
+	// A ping has refreshed driver-1 in the index but not yet reached the
+	// repository, whose entry is still stale.
+	stale := entities.NewDriverLocation("driver-1", 37.771, -122.411, geo.Encode(37.771, -122.411, 6))
+	stale.UpdatedAt = time.Now().Add(-10 * time.Minute)
+	service.locationRepo.UpdateDriverLocation(ctx, stale)
+	service.spatialIndex.UpdateLocation("driver-1", 37.771, -122.411)
+
+	if removed := service.SweepStaleLocations(ctx, 5*time.Minute); removed != 1 {
+		t.Fatalf("Expected the stale repository entry to be swept, got %d", removed)
+	}
+	if service.spatialIndex.GetDriverLocation("driver-1") == nil {
+		t.Fatal("Expected driver-1's fresh index entry to survive the sweep")
+	}
+
+	// The ping's repository write then lands, and the two agree again.
+	fresh := entities.NewDriverLocation("driver-1", 37.771, -122.411, geo.Encode(37.771, -122.411, 6))
+	service.locationRepo.UpdateDriverLocation(ctx, fresh)
+	if loc, _ := service.GetDriverLocation(ctx, "driver-1"); loc == nil {
+		t.Error("Expected driver-1 back in the repository")
+	}
+}
+
+func TestLocationService_StopWithoutSweeper(t *testing.T) {
+	service, _ := setupLocationService()
+	service.StartLocationSweeper(0)
+	service.Stop()
+	service.Stop()
+}
+
+func TestLocationService_EstimateNearbyDriverETAs_SortedAndCapped(t *testing.T) {
+	service, _ := setupLocationService()
+	ctx := context.Background()
+
+	// Pinged out of distance order on purpose.
+	service.UpdateDriverLocation(ctx, "driver-far", 37.7790, -122.4180)
+	service.UpdateDriverLocation(ctx, "driver-near", 37.7752, -122.4180)
+	service.UpdateDriverLocation(ctx, "driver-mid", 37.7770, -122.4180)
+	service.UpdateDriverLocation(ctx, "driver-mid2", 37.7775, -122.4180)
+
+	count, etas, err := service.EstimateNearbyDriverETAs(ctx, 37.7750, -122.4180, 5.0, 3)
+	if err != nil {
+		t.Fatalf("EstimateNearbyDriverETAs failed: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected count 4, got %d", count)
+	}
+	if len(etas) != 3 {
+		t.Fatalf("Expected ETAs capped at 3, got %d", len(etas))
+	}
+	for i := 1; i < len(etas); i++ {
+		if etas[i] < etas[i-1] {
+			t.Errorf("Expected ETAs ascending, got %v", etas)
+		}
+	}
+
+	// The nearest ETA matches the single-driver estimate.
+	nearest, found, _ := service.EstimateNearestDriverETA(ctx, 37.7750, -122.4180, 5.0)
+	if !found || nearest != etas[0] {
+		t.Errorf("Expected nearest ETA %v to equal first preview ETA %v", nearest, etas[0])
+	}
+
+	// No cap returns every driver.
+	_, all, _ := service.EstimateNearbyDriverETAs(ctx, 37.7750, -122.4180, 5.0, 0)
+	if len(all) != 4 {
+		t.Errorf("Expected 4 ETAs with no cap, got %d", len(all))
+	}
+}
+
+func TestLocationService_FindNearbyAvailableDrivers_AppliesBarriers(t *testing.T) {
+	service, _ := setupLocationService()
+	ctx := context.Background()
+
+	// driver-across is closer as the crow flies, but across the river.
+	service.UpdateDriverLocation(ctx, "driver-across", 37.7750, -122.4120)
+	service.UpdateDriverLocation(ctx, "driver-same-side", 37.7810, -122.4180)
+	service.SetBarriers([]geo.Barrier{{
+		Name:     "river",
+		SideA:    []string{"9q8yyk9", "9q8yymc"},
+		SideB:    []string{"9q8yykw"},
+		DetourKm: 3.0,
+	}})
+
+	nearby, err := service.FindNearbyAvailableDrivers(ctx, 37.7750, -122.4180, 5.0)
+	if err != nil {
+		t.Fatalf("FindNearbyAvailableDrivers failed: %v", err)
+	}
+	if len(nearby) != 2 {
+		t.Fatalf("Expected 2 drivers, got %d", len(nearby))
+	}
+	if nearby[0].Driver.DriverID != "driver-same-side" {
+		t.Errorf("Expected same-side driver ranked first, got %s", nearby[0].Driver.DriverID)
+	}
+}
+
+func TestLocationService_EstimatePickupETA(t *testing.T) {
+	service, _ := setupLocationService()
+	ctx := context.Background()
+
+	service.UpdateDriverLocation(ctx, "driver-1", 37.7850, -122.4180)
+
+	eta, err := service.EstimatePickupETA(ctx, "driver-1", 37.7750, -122.4180)
+	if err != nil {
+		t.Fatalf("EstimatePickupETA failed: %v", err)
+	}
+	distanceKm := utils.HaversineDistance(37.7850, -122.4180, 37.7750, -122.4180)
+	if want := utils.EstimateDuration(distanceKm); eta != want {
+		t.Errorf("Expected %v minutes for %.2f km, got %v", want, distanceKm, eta)
+	}
+	if eta <= 0 {
+		t.Errorf("Expected a positive ETA, got %v", eta)
+	}
+
+	if _, err := service.EstimatePickupETA(ctx, "driver-unknown", 37.7750, -122.4180); err != ErrDriverLocationUnknown {
+		t.Errorf("Expected ErrDriverLocationUnknown, got %v", err)
+	}
+}
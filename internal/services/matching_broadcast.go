@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"time"
+	"uber/internal/config"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+)
+
+// broadcastRun is the per-ride state matchingLoop hands over to
+// broadcastMatch once the candidates are ranked. heldLocks is matchingLoop's
+// own set, so its panic recovery still releases every outstanding offer.
+type broadcastRun struct {
+	ride         *entities.Ride
+	settings     config.MatchingConfig
+	candidates   []geo.DriverWithDistance
+	pickup       entities.Location
+	radiusKm     float64
+	offered      map[string]bool
+	heldLocks    map[string]bool
+	destGeohash  string
+	responses    <-chan DriverResponse
+	pickups      <-chan entities.Location
+	totalTimeout <-chan time.Time
+	deadline     time.Time
+	recorder     *sessionRecorder
+}
+
+// broadcastMatch is the MatchingStrategyBroadcast version of matchingLoop's
+// offer loop. Instead of one driver at a time, it offers the ride to the next
+// BroadcastSize eligible candidates at once and gives it to whoever accepts
+// first. The rest of the batch have their offers withdrawn: they are told the
+// ride is gone, their locks are released, and the offer doesn't count against
+// their reliability. Once every driver in a batch has declined or timed out,
+// the next batch goes out.
+//
+// All offers in a batch are sent together, so they share one set of timers:
+// drivers who haven't acknowledged by OfferAckTimeout are dropped, and the
+// rest have until OfferAckTimeout+DriverResponseTimeout to decide.
+//
+// Two drivers accepting at nearly the same moment can't both win. Responses
+// reach this goroutine one at a time through the ride's response channel, so
+// the first accept it reads is the one passed to acceptOffer, and by the time
+// it reads the second that driver's offer has already been withdrawn. Their
+// response is dropped like any response for a ride no longer being matched.
+//
+// Go Learning Note — Deleting While Ranging:
+// Deleting map entries inside a for-range over the same map is allowed in Go:
+// an entry removed before the loop reaches it is simply not produced. The
+// timeout cases below rely on this to drop drivers from the batch as they go.
+func (s *MatchingService) broadcastMatch(ctx context.Context, run *broadcastRun) MatchingResult {
+	ride, settings := run.ride, run.settings
+	batchSize := max(settings.BroadcastSize, 1)
+	lockTTL := settings.OfferAckTimeout + settings.DriverResponseTimeout
+
+	fail := func(err error) MatchingResult {
+		s.rideService.FailMatching(ctx, ride.ID)
+		s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
+		return MatchingResult{Success: false, Error: err}
+	}
+
+	// batch maps each driver with an outstanding offer to whether their app
+	// has acknowledged it.
+	batch := make(map[string]bool)
+	// seqs holds the sequence number of each outstanding offer; a response
+	// stamped with any other number answers an offer already closed.
+	seqs := make(map[string]uint64)
+	defer func() {
+		for driverID := range seqs {
+			s.closeOffer(ride.ID, driverID)
+		}
+	}()
+	// Released with an uncancellable context, as in matchingLoop.
+	unlockCtx := context.WithoutCancel(ctx)
+	release := func(driverID string) {
+		lockKey := "driver:" + driverID
+		s.lockManager.ReleaseLock(unlockCtx, lockKey)
+		s.closeOffer(ride.ID, driverID)
+		delete(run.heldLocks, lockKey)
+		delete(batch, driverID)
+		delete(seqs, driverID)
+	}
+	releaseAll := func() {
+		for driverID := range batch {
+			release(driverID)
+		}
+	}
+
+	// Each batch's ack and decision windows overlap, so they need a clock
+	// each; both are reused from batch to batch.
+	ackClock, decisionClock := newOfferClock(run.deadline), newOfferClock(run.deadline)
+	defer ackClock.stop()
+	defer decisionClock.stop()
+
+	contacted := 0
+	for {
+		select {
+		case pickup := <-run.pickups:
+			run.pickup = pickup
+			run.candidates = s.requeryCandidates(ctx, ride.ID, pickup, run.radiusKm, run.offered)
+		case <-run.totalTimeout:
+			logf(ctx, "[MATCHING] Total timeout exceeded for ride %s", ride.ID)
+			return fail(ErrMatchingTimeout)
+		case <-ctx.Done():
+			return MatchingResult{Success: false, Error: context.Cause(ctx)}
+		default:
+		}
+
+		capped := false
+		for len(batch) < batchSize && len(run.candidates) > 0 {
+			if settings.MaxDriversContacted > 0 && contacted >= settings.MaxDriversContacted {
+				capped = true
+				break
+			}
+
+			dwd := run.candidates[0]
+			run.candidates = run.candidates[1:]
+			driverID := dwd.Driver.DriverID
+			if run.offered[driverID] {
+				continue
+			}
+
+			lockKey, reserved := s.reserveDriver(ctx, ride, driverID, run.destGeohash, lockTTL)
+			if !reserved {
+				continue
+			}
+			run.heldLocks[lockKey] = true
+			seqs[driverID] = s.openOffer(ride.ID, driverID)
+
+			logf(ctx, "[MATCHING] Broadcasting ride %s to driver %s (%.2f km away)", ride.ID, driverID, dwd.Distance)
+			s.notificationService.NotifyDriverOfRideRequest(driverID, ride, run.pickup, s.offerDemand(ctx, run.pickup))
+			s.reliability.RecordOffer(driverID)
+			s.metrics.recordOffer()
+			run.offered[driverID] = true
+			contacted++
+			run.recorder.record(ride.ID, SessionEventOffer, driverID)
+			s.publish(ride.ID, MatchingEventOfferSent, driverID)
+			batch[driverID] = settings.OfferAckTimeout <= 0
+		}
+
+		if len(batch) == 0 {
+			if capped {
+				logf(ctx, "[MATCHING] Contacted %d drivers for ride %s without a match; giving up", contacted, ride.ID)
+				return fail(ErrMaxDriversContacted)
+			}
+			// Widen the search before giving up, as the sequential loop does.
+			run.candidates, run.radiusKm = s.expandSearch(ctx, ride.ID, run.pickup, run.radiusKm, settings, run.offered)
+			if len(run.candidates) > 0 {
+				continue
+			}
+			// As in the sequential loop, an accept that lost the race with
+			// a cancellation leaves the ride cancelled, not failed.
+			if err := context.Cause(ctx); err != nil {
+				return MatchingResult{Success: false, Error: err}
+			}
+			logf(ctx, "[MATCHING] No driver accepted ride %s", ride.ID)
+			return fail(nil)
+		}
+
+		var ackTimeout <-chan time.Time
+		if settings.OfferAckTimeout > 0 {
+			ackTimeout = ackClock.start(settings.OfferAckTimeout)
+		}
+		decisionTimeout := decisionClock.start(lockTTL)
+
+		for len(batch) > 0 {
+			select {
+			case resp := <-run.responses:
+				acked, inBatch := batch[resp.DriverID]
+				if !inBatch || resp.Seq != seqs[resp.DriverID] {
+					continue
+				}
+				if resp.Ack {
+					if !acked {
+						logf(ctx, "[MATCHING] Driver %s acknowledged ride %s", resp.DriverID, ride.ID)
+						run.recorder.record(ride.ID, SessionEventAck, resp.DriverID)
+						batch[resp.DriverID] = true
+					}
+					continue
+				}
+
+				if !resp.Accept {
+					logf(ctx, "[MATCHING] Driver %s denied ride %s", resp.DriverID, ride.ID)
+					s.recordDecline(resp.DriverID, ride.ID)
+					run.recorder.record(ride.ID, SessionEventDecline, resp.DriverID)
+					s.publish(ride.ID, MatchingEventDeclined, resp.DriverID)
+					release(resp.DriverID)
+					continue
+				}
+
+				logf(ctx, "[MATCHING] Driver %s accepted ride %s", resp.DriverID, ride.ID)
+				s.reliability.RecordAccept(resp.DriverID)
+				run.recorder.record(ride.ID, SessionEventAccept, resp.DriverID)
+
+				// As in the sequential loop, the driver lock is held until
+				// acceptOffer has taken and dropped the ride lock.
+				err := s.acceptOffer(ctx, resp.DriverID, ride.ID)
+				release(resp.DriverID)
+				if err != nil {
+					logf(ctx, "[MATCHING] Error accepting ride: %v", err)
+					s.acceptLost(ctx, resp.DriverID, ride.ID)
+					continue
+				}
+
+				for driverID := range batch {
+					logf(ctx, "[MATCHING] Withdrawing ride %s from driver %s", ride.ID, driverID)
+					s.notificationService.NotifyDriverOfRideTaken(driverID, ride.ID)
+					s.reliability.RecordWithdrawn(driverID)
+					release(driverID)
+				}
+				s.notificationService.NotifyRiderOfDriverAccepted(ride.RiderID, resp.DriverID, ride.ID, s.pickupETA(ctx, resp.DriverID, run.pickup))
+				return MatchingResult{Success: true, DriverID: resp.DriverID}
+
+			case pickup := <-run.pickups:
+				// Outstanding offers stay open; only later batches are drawn
+				// from around the new pickup point.
+				run.pickup = pickup
+				run.candidates = s.requeryCandidates(ctx, ride.ID, pickup, run.radiusKm, run.offered)
+
+			case <-ackTimeout:
+				ackTimeout = nil
+				for driverID, acked := range batch {
+					if acked {
+						continue
+					}
+					logf(ctx, "[MATCHING] Driver %s did not acknowledge ride %s", driverID, ride.ID)
+					s.notificationService.NotifyDriverOfRideTimeout(driverID, ride.ID)
+					s.reliability.RecordTimeout(driverID)
+					run.recorder.record(ride.ID, SessionEventAckTimeout, driverID)
+					s.publish(ride.ID, MatchingEventTimedOut, driverID)
+					release(driverID)
+				}
+
+			case <-decisionTimeout:
+				for driverID := range batch {
+					logf(ctx, "[MATCHING] Driver %s timed out for ride %s", driverID, ride.ID)
+					s.notificationService.NotifyDriverOfRideTimeout(driverID, ride.ID)
+					s.reliability.RecordTimeout(driverID)
+					run.recorder.record(ride.ID, SessionEventTimeout, driverID)
+					s.publish(ride.ID, MatchingEventTimedOut, driverID)
+					release(driverID)
+				}
+
+			case <-ctx.Done():
+				// The rider cancelled (or the caller gave up); let the whole
+				// batch go.
+				if context.Cause(ctx) == ErrMatchCancelled {
+					for driverID := range batch {
+						s.notificationService.NotifyDriverOfRideCancelled(driverID, ride.ID)
+					}
+				}
+				releaseAll()
+				return MatchingResult{Success: false, Error: context.Cause(ctx)}
+
+			case <-run.totalTimeout:
+				releaseAll()
+				logf(ctx, "[MATCHING] Total timeout exceeded for ride %s", ride.ID)
+				return fail(ErrMatchingTimeout)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"uber/internal/config"
+	"uber/internal/domain/entities"
+)
+
+// setupBroadcast returns a matching service using the broadcast strategy with
+// batches of batchSize, drivers driver-1..driver-n placed nearest first, and a
+// requested ride for rider-1.
+func setupBroadcast(t *testing.T, batchSize, n int) (*MatchingService, *RideService, *entities.Ride) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.MatchingStrategy = config.MatchingStrategyBroadcast
+	matchingService.config.Matching.BroadcastSize = batchSize
+	ctx := context.Background()
+
+	for i := 1; i <= n; i++ {
+		driverID := fmt.Sprintf("driver-%d", i)
+		driverRepo.GetOrCreate(ctx, driverID)
+		locationService.UpdateDriverLocation(ctx, driverID, 37.77+0.001*float64(i), -122.41)
+	}
+
+	estimate, err := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	if err != nil {
+		t.Fatalf("CreateFareEstimate failed: %v", err)
+	}
+	ride, err := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+	if err != nil {
+		t.Fatalf("RequestRide failed: %v", err)
+	}
+	return matchingService, rideService, ride
+}
+
+func TestMatchingService_BroadcastSimultaneousAcceptsOneWinner(t *testing.T) {
+	// Repeat so either driver gets a chance to be first.
+	for i := 0; i < 5; i++ {
+		matchingService, rideService, ride := setupBroadcast(t, 3, 3)
+		ctx := context.Background()
+
+		resultChan := matchingService.StartMatching(ctx, ride)
+		time.Sleep(100 * time.Millisecond)
+
+		// The whole batch is offered at once; nobody waits behind driver-1.
+		for _, driverID := range []string{"driver-1", "driver-2", "driver-3"} {
+			if offers := matchingService.DriverReliability(driverID).Offers; offers != 1 {
+				t.Fatalf("%s: expected the ride to be offered, got %d offers", driverID, offers)
+			}
+		}
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for _, driverID := range []string{"driver-1", "driver-2"} {
+			wg.Add(1)
+			go func(driverID string) {
+				defer wg.Done()
+				<-start
+				matchingService.SubmitDriverResponse(driverID, ride.ID, true)
+			}(driverID)
+		}
+		close(start)
+		wg.Wait()
+
+		var result MatchingResult
+		select {
+		case result = <-resultChan:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the first accept to win promptly")
+		}
+		if !result.Success || (result.DriverID != "driver-1" && result.DriverID != "driver-2") {
+			t.Fatalf("Expected driver-1 or driver-2 to win, got %+v", result)
+		}
+
+		stored, _ := rideService.GetRide(ctx, ride.ID)
+		if stored.Status != entities.RideStatusAccepted || stored.DriverID != result.DriverID {
+			t.Errorf("Expected ride accepted by %s, got %s by %q", result.DriverID, stored.Status, stored.DriverID)
+		}
+
+		// Everyone else had the offer withdrawn: unlocked, and not held
+		// against their acceptance rate.
+		for _, driverID := range []string{"driver-1", "driver-2", "driver-3"} {
+			if locked, _ := matchingService.lockManager.IsLocked(ctx, "driver:"+driverID); locked {
+				t.Errorf("%s: expected the driver lock to be released", driverID)
+			}
+			if driverID == result.DriverID {
+				continue
+			}
+			if offers := matchingService.DriverReliability(driverID).Offers; offers != 0 {
+				t.Errorf("%s: expected the withdrawn offer not to count, got %d offers", driverID, offers)
+			}
+		}
+	}
+}
+
+func TestMatchingService_BroadcastAllDeclineMovesToNextBatch(t *testing.T) {
+	matchingService, _, ride := setupBroadcast(t, 2, 4)
+	ctx := context.Background()
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+
+	for driverID, want := range map[string]int{"driver-1": 1, "driver-2": 1, "driver-3": 0, "driver-4": 0} {
+		if offers := matchingService.DriverReliability(driverID).Offers; offers != want {
+			t.Errorf("%s: expected %d offers in the first batch, got %d", driverID, want, offers)
+		}
+	}
+
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, false)
+	time.Sleep(100 * time.Millisecond)
+
+	for _, driverID := range []string{"driver-3", "driver-4"} {
+		if offers := matchingService.DriverReliability(driverID).Offers; offers != 1 {
+			t.Errorf("%s: expected an offer in the second batch, got %d", driverID, offers)
+		}
+	}
+
+	matchingService.SubmitDriverResponse("driver-4", ride.ID, true)
+
+	select {
+	case result := <-resultChan:
+		if !result.Success || result.DriverID != "driver-4" {
+			t.Fatalf("Expected driver-4 to win the second batch, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to finish after driver-4 accepted")
+	}
+
+	for _, driverID := range []string{"driver-1", "driver-2"} {
+		if declines := matchingService.DriverReliability(driverID).Declines; declines != 1 {
+			t.Errorf("%s: expected 1 decline, got %d", driverID, declines)
+		}
+	}
+	for i := 1; i <= 4; i++ {
+		driverID := fmt.Sprintf("driver-%d", i)
+		if locked, _ := matchingService.lockManager.IsLocked(ctx, "driver:"+driverID); locked {
+			t.Errorf("%s: expected the driver lock to be released", driverID)
+		}
+	}
+}
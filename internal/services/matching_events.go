@@ -0,0 +1,117 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// MatchingEventType names a step in a ride's matching lifecycle.
+type MatchingEventType string
+
+const (
+	MatchingEventOfferSent MatchingEventType = "offer_sent" // The ride was offered to DriverID
+	MatchingEventDeclined  MatchingEventType = "declined"   // DriverID declined
+	MatchingEventTimedOut  MatchingEventType = "timed_out"  // DriverID didn't acknowledge or answer in time
+	MatchingEventAccepted  MatchingEventType = "accepted"   // The match ended with DriverID assigned
+	MatchingEventFailed    MatchingEventType = "failed"     // The match ended without a driver
+)
+
+// MatchingEvent is one step of a match as it happens, for live observers
+// such as an ops dashboard. DriverID is empty on MatchingEventFailed.
+type MatchingEvent struct {
+	RideID    string            `json:"ride_id"`
+	Type      MatchingEventType `json:"type"`
+	DriverID  string            `json:"driver_id,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// eventBufferSize is how many events a subscriber can fall behind by before
+// further events to it are dropped.
+const eventBufferSize = 100
+
+// eventHub fans matching events out to subscribers. Publishing never blocks:
+// a subscriber whose buffer is full misses the event, and with no subscribers
+// events are simply discarded, so a slow dashboard can't stall matching.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan MatchingEvent]bool
+	closed      bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan MatchingEvent]bool)}
+}
+
+func (h *eventHub) publish(event MatchingEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (h *eventHub) subscribe() chan MatchingEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan MatchingEvent, eventBufferSize)
+	if h.closed {
+		close(ch)
+		return ch
+	}
+	h.subscribers[ch] = true
+	return ch
+}
+
+func (h *eventHub) unsubscribe(events <-chan MatchingEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		if ch == events {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every subscription; later subscribers get a closed channel.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		close(ch)
+	}
+	h.subscribers = nil
+	h.closed = true
+}
+
+// Events subscribes to the matching events of every ride from now on. Each
+// call returns a new subscription with its own buffer; events are dropped for
+// a subscriber that falls eventBufferSize behind. The channel is closed by
+// Unsubscribe or Shutdown. Replays publish no events.
+//
+// Go Learning Note — Comparing Channels:
+// Channels are comparable: two channel values are equal if they refer to the
+// same channel, even when one is the receive-only view handed to the caller.
+// That is how Unsubscribe finds the subscription it was given.
+func (s *MatchingService) Events() <-chan MatchingEvent {
+	return s.events.subscribe()
+}
+
+// Unsubscribe ends a subscription returned by Events and closes its channel.
+func (s *MatchingService) Unsubscribe(events <-chan MatchingEvent) {
+	s.events.unsubscribe(events)
+}
+
+// publish sends one event to every subscriber.
+func (s *MatchingService) publish(rideID string, eventType MatchingEventType, driverID string) {
+	s.events.publish(MatchingEvent{
+		RideID:    rideID,
+		Type:      eventType,
+		DriverID:  driverID,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+)
+
+func TestMatchingService_EventsFollowTheMatch(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.775, -122.415)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	events := matchingService.Events()
+	defer matchingService.Unsubscribe(events)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, true)
+	if result := <-resultChan; !result.Success {
+		t.Fatalf("Expected driver-2 to be matched, got %+v", result)
+	}
+
+	want := []MatchingEvent{
+		{RideID: ride.ID, Type: MatchingEventOfferSent, DriverID: "driver-1"},
+		{RideID: ride.ID, Type: MatchingEventDeclined, DriverID: "driver-1"},
+		{RideID: ride.ID, Type: MatchingEventOfferSent, DriverID: "driver-2"},
+		{RideID: ride.ID, Type: MatchingEventAccepted, DriverID: "driver-2"},
+	}
+	var last time.Time
+	for i, w := range want {
+		select {
+		case got := <-events:
+			if got.RideID != w.RideID || got.Type != w.Type || got.DriverID != w.DriverID {
+				t.Fatalf("Event %d: expected %s from %s, got %+v", i, w.Type, w.DriverID, got)
+			}
+			if got.Timestamp.Before(last) {
+				t.Errorf("Event %d: timestamp %v is before the previous event's %v", i, got.Timestamp, last)
+			}
+			last = got.Timestamp
+		case <-time.After(time.Second):
+			t.Fatalf("Expected event %d (%s from %s), got nothing", i, w.Type, w.DriverID)
+		}
+	}
+	select {
+	case extra := <-events:
+		t.Errorf("Expected no further events, got %+v", extra)
+	default:
+	}
+}
+
+func TestMatchingService_UnsubscribeClosesEvents(t *testing.T) {
+	matchingService, _, _, _ := setupMatchingService()
+	events := matchingService.Events()
+	matchingService.Unsubscribe(events)
+
+	if _, open := <-events; open {
+		t.Error("Expected the events channel to be closed")
+	}
+
+	// Publishing with nobody subscribed is a no-op rather than a block.
+	matchingService.publish("ride-1", MatchingEventFailed, "")
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// MatchingMetricsSnapshot is a point-in-time copy of MatchingMetrics.
+//
+// Attempted counts every match started, including ones still running.
+// Finished counts those that have produced a result, whatever it was, so
+// Finished minus the three outcome counters is the matches that were
+// cancelled, shut down or hit an internal error. SuccessRate is Succeeded
+// over Finished (0 before any match finishes), and AvgTimeToMatch is the
+// mean time from the start of a successful match to its driver accepting.
+type MatchingMetricsSnapshot struct {
+	Attempted      int64         `json:"attempted"`
+	Finished       int64         `json:"finished"`
+	Succeeded      int64         `json:"succeeded"`
+	FailedNoDriver int64         `json:"failed_no_driver"`
+	FailedTimeout  int64         `json:"failed_timeout"`
+	DriversOffered int64         `json:"drivers_offered"`
+	SuccessRate    float64       `json:"success_rate"`
+	AvgTimeToMatch time.Duration `json:"avg_time_to_match_ns"`
+}
+
+// MatchingMetrics counts matching outcomes across all rides. It is safe for
+// concurrent use by multiple matching goroutines.
+//
+// Time to match is kept as a running sum and count rather than a histogram:
+// the average is what the stats endpoint reports, and it costs two fields.
+type MatchingMetrics struct {
+	mu               sync.Mutex
+	attempted        int64
+	finished         int64
+	succeeded        int64
+	failedNoDriver   int64
+	failedTimeout    int64
+	driversOffered   int64
+	timeToMatchTotal time.Duration
+}
+
+// recordAttempt counts a match being started.
+func (m *MatchingMetrics) recordAttempt() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempted++
+}
+
+// recordOffer counts a ride offer sent to one driver.
+func (m *MatchingMetrics) recordOffer() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.driversOffered++
+}
+
+// recordResult counts a match's outcome. elapsed is how long the match ran.
+//
+// A failure with no error is the loop running out of candidates, which is a
+// no-driver failure like ErrNoDriversNearby and ErrMaxDriversContacted: every
+// driver in reach was tried.
+func (m *MatchingMetrics) recordResult(result MatchingResult, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.finished++
+	switch {
+	case result.Success:
+		m.succeeded++
+		m.timeToMatchTotal += elapsed
+	case errors.Is(result.Error, ErrMatchingTimeout):
+		m.failedTimeout++
+	case result.Error == nil,
+		errors.Is(result.Error, ErrNoDriversNearby),
+		errors.Is(result.Error, ErrMaxDriversContacted):
+		m.failedNoDriver++
+	}
+}
+
+// snapshot returns a copy of the counters with the derived rates filled in.
+func (m *MatchingMetrics) snapshot() MatchingMetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap := MatchingMetricsSnapshot{
+		Attempted:      m.attempted,
+		Finished:       m.finished,
+		Succeeded:      m.succeeded,
+		FailedNoDriver: m.failedNoDriver,
+		FailedTimeout:  m.failedTimeout,
+		DriversOffered: m.driversOffered,
+	}
+	if m.finished > 0 {
+		snap.SuccessRate = float64(m.succeeded) / float64(m.finished)
+	}
+	if m.succeeded > 0 {
+		snap.AvgTimeToMatch = m.timeToMatchTotal / time.Duration(m.succeeded)
+	}
+	return snap
+}
+
+// Metrics returns the matching counters accumulated since the service
+// started. Replays (ReplaySession) are not counted.
+func (s *MatchingService) Metrics() MatchingMetricsSnapshot {
+	return s.metrics.snapshot()
+}
+
+// ActiveMatches returns how many rides are being matched right now.
+func (s *MatchingService) ActiveMatches() int {
+	s.pendingMu.RLock()
+	defer s.pendingMu.RUnlock()
+	return len(s.pendingMatches)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+)
+
+func TestMatchingService_MetricsCountOutcomes(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.TotalMatchingTimeout = 300 * time.Millisecond
+	ctx := context.Background()
+
+	request := func(riderID string, source entities.Location) *entities.Ride {
+		estimate, _ := rideService.CreateFareEstimate(ctx, riderID, FareEstimateRequest{
+			Source:      source,
+			Destination: entities.Location{Latitude: source.Latitude + 0.01, Longitude: source.Longitude + 0.01},
+		})
+		ride, err := rideService.RequestRide(ctx, riderID, estimate.RideID)
+		if err != nil {
+			t.Fatalf("RequestRide failed: %v", err)
+		}
+		return ride
+	}
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-2", 40.001, -74.001)
+
+	// driver-1 accepts after about 100ms.
+	ride := request("rider-1", entities.Location{Latitude: 37.77, Longitude: -122.41})
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+	if result := <-resultChan; !result.Success {
+		t.Fatalf("Expected a match, got %+v", result)
+	}
+
+	// Nobody within range of this pickup.
+	ride = request("rider-2", entities.Location{Latitude: 51.5, Longitude: -0.12})
+	if result := <-matchingService.StartMatching(ctx, ride); !errors.Is(result.Error, ErrNoDriversNearby) {
+		t.Fatalf("Expected ErrNoDriversNearby, got %+v", result)
+	}
+
+	// driver-2 never answers before the total timeout.
+	ride = request("rider-3", entities.Location{Latitude: 40.0, Longitude: -74.0})
+	if result := <-matchingService.StartMatching(ctx, ride); !errors.Is(result.Error, ErrMatchingTimeout) {
+		t.Fatalf("Expected ErrMatchingTimeout, got %+v", result)
+	}
+
+	metrics := matchingService.Metrics()
+	if metrics.Attempted != 3 || metrics.Finished != 3 {
+		t.Errorf("Expected 3 attempts, all finished, got %+v", metrics)
+	}
+	if metrics.Succeeded != 1 || metrics.FailedNoDriver != 1 || metrics.FailedTimeout != 1 {
+		t.Errorf("Expected one success, one no-driver and one timeout, got %+v", metrics)
+	}
+	if metrics.DriversOffered != 2 {
+		t.Errorf("Expected 2 drivers offered, got %d", metrics.DriversOffered)
+	}
+	if metrics.SuccessRate < 0.33 || metrics.SuccessRate > 0.34 {
+		t.Errorf("Expected a success rate of 1/3, got %.3f", metrics.SuccessRate)
+	}
+	if metrics.AvgTimeToMatch < 100*time.Millisecond || metrics.AvgTimeToMatch > 300*time.Millisecond {
+		t.Errorf("Expected about 100ms to match, got %v", metrics.AvgTimeToMatch)
+	}
+}
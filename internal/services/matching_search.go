@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+)
+
+// SearchReason says why the matching loop searched for drivers.
+type SearchReason string
+
+const (
+	SearchReasonPrecheck    SearchReason = "precheck"     // PrecheckDrivers, before the ride entered Matching
+	SearchReasonInitial     SearchReason = "initial"      // The first search of a match
+	SearchReasonPickupMoved SearchReason = "pickup_moved" // The rider moved the pickup mid-match
+	SearchReasonExpanded    SearchReason = "expanded"     // The radius was widened after candidates ran out
+	SearchReasonReoffer     SearchReason = "reoffer"      // Looking for earlier decliners to offer again
+)
+
+// SearchArea describes one driver search made while matching a ride: where
+// it was centered, exactly which geohash cells the spatial index scanned, how
+// far it reached and how many available drivers it found. Ops use it to
+// answer "why didn't my driver get this ride": a driver outside Cells was
+// never looked at, and one inside them but missing from the count was busy,
+// stale or beyond the radius.
+type SearchArea struct {
+	Reason        SearchReason      `json:"reason"`
+	Pickup        entities.Location `json:"pickup"`
+	CenterGeohash string            `json:"center_geohash"`
+	Cells         []string          `json:"cells"` // Every cell scanned, the center included
+	Precision     int               `json:"precision"`
+	RadiusKm      float64           `json:"radius_km"`
+	Candidates    int               `json:"candidates"` // Available drivers found within RadiusKm
+	SearchedAt    time.Time         `json:"searched_at"`
+}
+
+// maxSearchLogRides bounds how many rides' searches are kept. Unlike session
+// recording, the search log is always on, so the oldest rides are dropped to
+// keep a long-running server's memory flat.
+const maxSearchLogRides = 1000
+
+// searchLog keeps the SearchAreas of the most recently matched rides.
+type searchLog struct {
+	mu    sync.Mutex
+	rides map[string][]SearchArea
+	order []string // Ride IDs, oldest first, for eviction
+}
+
+func newSearchLog() *searchLog {
+	return &searchLog{rides: make(map[string][]SearchArea)}
+}
+
+func (l *searchLog) record(rideID string, area SearchArea) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, exists := l.rides[rideID]; !exists {
+		if len(l.order) >= maxSearchLogRides {
+			delete(l.rides, l.order[0])
+			l.order = l.order[1:]
+		}
+		l.order = append(l.order, rideID)
+	}
+	l.rides[rideID] = append(l.rides[rideID], area)
+}
+
+// get returns a copy of the ride's searches, oldest first.
+func (l *searchLog) get(rideID string) []SearchArea {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]SearchArea(nil), l.rides[rideID]...)
+}
+
+// SearchAreas returns every driver search made while matching rideID, oldest
+// first, or nil if the ride has not been matched recently.
+func (s *MatchingService) SearchAreas(rideID string) []SearchArea {
+	return s.searches.get(rideID)
+}
+
+// searchNearby finds the available drivers within radiusKm of pickup, minus
+// those with stale locations, and records the search in the search log. Every
+// driver search the matching loop makes goes through here.
+func (s *MatchingService) searchNearby(ctx context.Context, rideID string, reason SearchReason, pickup entities.Location, radiusKm float64) ([]geo.DriverWithDistance, error) {
+	nearby, err := s.locationService.FindNearbyAvailableDrivers(ctx, pickup.Latitude, pickup.Longitude, radiusKm)
+	if err != nil {
+		return nil, err
+	}
+	nearby = s.dropStaleLocations(ctx, nearby)
+
+	cells, precision := geo.SearchCells(pickup.Latitude, pickup.Longitude, radiusKm)
+	area := SearchArea{
+		Reason:        reason,
+		Pickup:        pickup,
+		CenterGeohash: geo.Encode(pickup.Latitude, pickup.Longitude, precision),
+		Cells:         cells,
+		Precision:     precision,
+		RadiusKm:      radiusKm,
+		Candidates:    len(nearby),
+		SearchedAt:    time.Now(),
+	}
+	s.searches.record(rideID, area)
+	logf(ctx, "[MATCHING] Search for ride %s (%s): center %s, %d cells at precision %d, %.1f km, %d candidates",
+		rideID, reason, area.CenterGeohash, len(cells), precision, radiusKm, len(nearby))
+	return nearby, nil
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+)
+
+func TestMatchingService_RecordsSearchArea(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	// 3 km needs precision-5 cells (about 4.9 km × 3.9 km at this latitude),
+	// so one ring of neighbors covers it: 9 cells.
+	matchingService.config.Matching.SearchRadiusKm = 3
+
+	// Two drivers in range; driver-3 is about 14 km north.
+	for id, loc := range map[string]entities.Location{
+		"driver-1": {Latitude: 37.771, Longitude: -122.411},
+		"driver-2": {Latitude: 37.78, Longitude: -122.41},
+		"driver-3": {Latitude: 37.9, Longitude: -122.41},
+	} {
+		driverRepo.GetOrCreate(ctx, id)
+		locationService.UpdateDriverLocation(ctx, id, loc.Latitude, loc.Longitude)
+	}
+
+	pickup := entities.Location{Latitude: 37.77, Longitude: -122.41}
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      pickup,
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+	if result := <-resultChan; !result.Success {
+		t.Fatalf("Expected driver-1 to be matched, got %+v", result)
+	}
+
+	searches := matchingService.SearchAreas(ride.ID)
+	if len(searches) != 1 {
+		t.Fatalf("Expected one search, got %d: %+v", len(searches), searches)
+	}
+	area := searches[0]
+
+	center := geo.Encode(pickup.Latitude, pickup.Longitude, 5)
+	if area.Reason != SearchReasonInitial || area.Precision != 5 || area.CenterGeohash != center {
+		t.Errorf("Expected an initial search centered on %s at precision 5, got %+v", center, area)
+	}
+	if area.RadiusKm != 3 || area.Pickup != pickup {
+		t.Errorf("Expected a 3 km search around the pickup, got %.1f km around %+v", area.RadiusKm, area.Pickup)
+	}
+	if area.Candidates != 2 {
+		t.Errorf("Expected 2 candidates in range, got %d", area.Candidates)
+	}
+
+	if len(area.Cells) != 9 {
+		t.Fatalf("Expected 9 cells searched, got %d: %v", len(area.Cells), area.Cells)
+	}
+	searched := make(map[string]bool)
+	for _, cell := range area.Cells {
+		searched[cell] = true
+	}
+	for _, cell := range append(geo.AllNeighbors(center), center) {
+		if !searched[cell] {
+			t.Errorf("Expected cell %s (center or neighbor) to be searched, got %v", cell, area.Cells)
+		}
+	}
+}
+
+func TestMatchingService_SearchAreasForUnknownRide(t *testing.T) {
+	matchingService, _, _, _ := setupMatchingService()
+	if searches := matchingService.SearchAreas("no-such-ride"); searches != nil {
+		t.Errorf("Expected no searches for an unknown ride, got %+v", searches)
+	}
+}
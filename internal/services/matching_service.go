@@ -2,14 +2,68 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
 	"uber/internal/config"
 	"uber/internal/domain/entities"
+	"uber/internal/geo"
 	"uber/internal/repository/memory"
+	"uber/internal/requestid"
 )
 
+// ErrMatchingPanicked is wrapped into the MatchingResult of a matching run
+// that was aborted by a recovered panic.
+var ErrMatchingPanicked = errors.New("matching aborted by internal error")
+
+// ErrRideLockHeld is returned by acceptOffer when another accept for the same
+// ride is already in progress.
+var ErrRideLockHeld = errors.New("ride is already being accepted")
+
+// rideLockTTL bounds how long a ride lock can outlive a crashed accept. The
+// accept transition itself takes microseconds.
+const rideLockTTL = 5 * time.Second
+
+// logf logs like log.Printf, appending the ID of the HTTP request that
+// started the work when ctx carries one, so a match's log lines can be found
+// from the access log entry of the /ride/request call behind it.
+func logf(ctx context.Context, format string, args ...any) {
+	if id := requestid.FromContext(ctx); id != "" {
+		format += " [request_id=%s]"
+		args = append(args, id)
+	}
+	log.Printf(format, args...)
+}
+
+// ErrNoDriversNearby is the MatchingResult error when no available driver was
+// within the search radius, whether found by the pre-check or the full loop.
+var ErrNoDriversNearby = errors.New("no available drivers nearby")
+
+// ErrMatchCancelled is the MatchingResult error of a match stopped because
+// the rider cancelled the ride.
+var ErrMatchCancelled = errors.New("ride cancelled by rider")
+
+// rideLockRetry is how often CancelRide retries a ride lock held by an accept
+// in progress.
+const rideLockRetry = 5 * time.Millisecond
+
+// ErrMaxDriversContacted is the MatchingResult error when the ride was offered
+// to MatchingConfig.MaxDriversContacted drivers and none accepted.
+var ErrMaxDriversContacted = errors.New("maximum number of drivers contacted")
+
+// ErrMatchingTimeout is the MatchingResult error when
+// MatchingConfig.TotalMatchingTimeout passed before any driver accepted.
+var ErrMatchingTimeout = errors.New("no driver accepted within the matching timeout")
+
+// ErrShuttingDown is the MatchingResult error of a match that was refused
+// because Shutdown had been called, or stopped because Shutdown's deadline
+// passed before it finished.
+var ErrShuttingDown = errors.New("matching service is shutting down")
+
 // MatchingRequest represents a request to find a driver for a ride.
 type MatchingRequest struct {
 	RideID   string
@@ -26,16 +80,29 @@ type MatchingResult struct {
 }
 
 // DriverResponse represents a driver's accept/decline response to a ride offer.
+// When Ack is true the message is only a delivery acknowledgement (the driver
+// app received the offer) and Accept is ignored.
+//
+// Seq identifies the offer being answered. It is stamped when the response is
+// submitted, from the offer outstanding to that driver for that ride at the
+// time, and is 0 if there was none. The matching loop only accepts a
+// response whose Seq is the offer it is waiting on, so an answer that arrives
+// after its window closed can never be taken as the answer to a later offer,
+// even one to the same driver.
 type DriverResponse struct {
 	DriverID string
 	RideID   string
 	Accept   bool
+	Ack      bool
+	Seq      uint64
 }
 
 // MatchingService is the async ride-driver matching engine. When a rider
 // requests a ride, this service runs a goroutine that:
 //  1. Finds nearby available drivers sorted by distance
-//  2. Offers the ride to each driver in order (nearest first)
+//  2. Offers the ride to drivers as its MatchingStrategy chooses (by default
+//     one at a time, nearest first), or to several at once with
+//     MatchingStrategyBroadcast (see broadcastMatch)
 //  3. Waits for each driver to accept or times out after DriverResponseTimeout
 //  4. If no driver accepts within TotalMatchingTimeout, the ride fails
 //
@@ -66,6 +133,10 @@ type MatchingService struct {
 	lockManager         *memory.LockManager
 	driverRepo          *memory.DriverRepository
 
+	// reliability records per-driver offer outcomes (accepts, declines,
+	// timeouts) as the matching loop observes them.
+	reliability *ReliabilityTracker
+
 	// driverResponses receives all driver accept/decline responses from the HTTP
 	// handler. The processDriverResponses goroutine routes each response to the
 	// correct matching goroutine.
@@ -75,6 +146,58 @@ type MatchingService struct {
 	// registers its ride here so driver responses can be routed to it.
 	pendingMatches map[string]chan DriverResponse
 	pendingMu      sync.RWMutex
+
+	// outstandingOffers maps rideID → driverID → sequence number of each
+	// offer whose window is open, for stamping responses as they are
+	// submitted. offerSeq numbers offers across all rides. Guarded by
+	// pendingMu.
+	outstandingOffers map[string]map[string]uint64
+	offerSeq          uint64
+
+	// pickupUpdates maps rideID → per-ride channel carrying the rider's new
+	// pickup point while the ride is still being matched. It shares pendingMu
+	// with pendingMatches since both are registered and removed together.
+	pickupUpdates map[string]chan entities.Location
+
+	// stopMatches maps rideID → the function that cancels the ride's matching
+	// context, so a rider cancellation can stop the loop. Also guarded by
+	// pendingMu.
+	stopMatches map[string]context.CancelCauseFunc
+
+	// recorder captures each ride's offers and responses when session
+	// recording is enabled; nil otherwise. Guarded by pendingMu.
+	recorder *sessionRecorder
+
+	// strategy chooses which candidates are offered the ride and in what
+	// order. SequentialStrategy unless replaced with SetStrategy.
+	strategy MatchingStrategy
+
+	// loops counts the matching goroutines still running, so Shutdown can
+	// wait for them. closing is set by Shutdown to turn new matches away;
+	// it is guarded by pendingMu, which every loops.Add also holds.
+	// abortMatches cancels shutdownCtx, which stops every loop still running
+	// once Shutdown's deadline has passed.
+	loops        sync.WaitGroup
+	closing      bool
+	shutdownCtx  context.Context
+	abortMatches context.CancelCauseFunc
+
+	// submitMu guards sends on driverResponses against Shutdown closing it.
+	// It is separate from pendingMu because a sender may block on a full
+	// channel until the router, which takes pendingMu, drains it.
+	// routerDone is closed when processDriverResponses returns.
+	submitMu        sync.RWMutex
+	responsesClosed bool
+	routerDone      chan struct{}
+
+	// searches logs every driver search made while matching, per ride.
+	searches *searchLog
+
+	// events fans matching lifecycle events out to Events subscribers.
+	events *eventHub
+
+	// metrics counts match attempts, outcomes and offers across all rides.
+	metrics MatchingMetrics
 }
 
 // NewMatchingService creates and starts the matching service. It launches a
@@ -94,9 +217,18 @@ func NewMatchingService(
 		notificationService: notificationService,
 		lockManager:         lockManager,
 		driverRepo:          driverRepo,
+		reliability:         NewReliabilityTracker(),
 		driverResponses:     make(chan DriverResponse, 100),
 		pendingMatches:      make(map[string]chan DriverResponse),
+		outstandingOffers:   make(map[string]map[string]uint64),
+		pickupUpdates:       make(map[string]chan entities.Location),
+		stopMatches:         make(map[string]context.CancelCauseFunc),
+		strategy:            SequentialStrategy{},
+		routerDone:          make(chan struct{}),
+		searches:            newSearchLog(),
+		events:              newEventHub(),
 	}
+	ms.shutdownCtx, ms.abortMatches = context.WithCancelCause(context.Background())
 
 	// Start the response router goroutine.
 	go ms.processDriverResponses()
@@ -119,18 +251,42 @@ func NewMatchingService(
 // channel but falls through to `default` if the channel's buffer is full. This
 // prevents the router from blocking if a matching goroutine is slow to consume.
 func (s *MatchingService) processDriverResponses() {
-	for resp := range s.driverResponses {
-		s.pendingMu.RLock()
-		ch, exists := s.pendingMatches[resp.RideID]
-		s.pendingMu.RUnlock()
-
-		if exists {
-			select {
-			case ch <- resp:
-			default:
-				log.Printf("[MATCHING] Response channel full for ride %s", resp.RideID)
+	// If routing a response panics, log it and start a fresh router so later
+	// driver responses are still delivered. The response being routed is lost,
+	// which the matching loop already tolerates as a driver timeout.
+	defer func() {
+		if r := recover(); r != nil {
+			if !s.config.Matching.RecoverPanics {
+				panic(r)
 			}
+			log.Printf("[MATCHING] Recovered from panic routing driver response: %v\n%s", r, debug.Stack())
+			go s.processDriverResponses()
 		}
+	}()
+
+	for resp := range s.driverResponses {
+		s.route(resp)
+	}
+	close(s.routerDone)
+}
+
+// route delivers one driver response to its ride's matching loop, if the
+// ride is still being matched. The send happens under pendingMu: a loop
+// unregisters its channel under the write lock before closing it, so holding
+// the read lock guarantees the channel is still open. The send never blocks,
+// so the lock is held only briefly.
+func (s *MatchingService) route(resp DriverResponse) {
+	s.pendingMu.RLock()
+	defer s.pendingMu.RUnlock()
+
+	ch, exists := s.pendingMatches[resp.RideID]
+	if !exists {
+		return
+	}
+	select {
+	case ch <- resp:
+	default:
+		log.Printf("[MATCHING] Response channel full for ride %s", resp.RideID)
 	}
 }
 
@@ -143,10 +299,149 @@ func (s *MatchingService) processDriverResponses() {
 // can only read from it, not write. This is a Go idiom for returning "futures"
 // or async results. The caller does `result := <-resultChan` to block until
 // the result is ready.
+//
+// With PrecheckDrivers enabled, a ride with no available driver in range is
+// failed before it enters Matching, and the returned channel already holds
+// the ErrNoDriversNearby result.
 func (s *MatchingService) StartMatching(ctx context.Context, ride *entities.Ride) <-chan MatchingResult {
 	resultChan := make(chan MatchingResult, 1)
 
-	go s.matchingLoop(ctx, ride, resultChan)
+	if s.config.Matching.PrecheckDrivers && s.noDriversInRange(ctx, ride) {
+		logf(ctx, "[MATCHING] Pre-check found no drivers for ride %s", ride.ID)
+		if err := s.rideService.FailMatching(ctx, ride.ID); err != nil {
+			logf(ctx, "[MATCHING] Could not fail ride %s: %v", ride.ID, err)
+		}
+		s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
+		result := MatchingResult{Success: false, Error: ErrNoDriversNearby}
+		s.metrics.recordAttempt()
+		s.metrics.recordResult(result, 0)
+		s.publish(ride.ID, MatchingEventFailed, "")
+		resultChan <- result
+		close(resultChan)
+		return resultChan
+	}
+
+	s.launch(ctx, ride, nil, nil, resultChan)
+
+	return resultChan
+}
+
+// launch runs matchingLoop in a new goroutine counted in s.loops. After
+// Shutdown no goroutine is started: resultChan gets ErrShuttingDown instead,
+// and the ride is left as it is.
+//
+// Go Learning Note — WaitGroup.Add Before Wait:
+// Add must not race with a Wait that could see the counter at zero, or Wait
+// may return while a goroutine is just starting. Checking closing and calling
+// Add under the same lock Shutdown takes to set closing rules that out: once
+// closing is set, no Add can happen.
+func (s *MatchingService) launch(ctx context.Context, ride *entities.Ride, excludeDriverIDs []string, script *sessionScript, resultChan chan MatchingResult) {
+	s.pendingMu.Lock()
+	if s.closing {
+		s.pendingMu.Unlock()
+		logf(ctx, "[MATCHING] Not matching ride %s: shutting down", ride.ID)
+		resultChan <- MatchingResult{Success: false, Error: ErrShuttingDown}
+		close(resultChan)
+		return
+	}
+	s.loops.Add(1)
+	s.pendingMu.Unlock()
+
+	// A replay re-runs a match that was already counted, so it bypasses the
+	// metrics. Otherwise the loop's result is counted on its way to the
+	// caller; the loop sends at most one before closing the channel.
+	if script != nil {
+		go func() {
+			defer s.loops.Done()
+			s.matchingLoop(ctx, ride, excludeDriverIDs, script, resultChan)
+		}()
+		return
+	}
+
+	// Time to match runs from the request, not from when the goroutine
+	// gets scheduled.
+	s.metrics.recordAttempt()
+	started := time.Now()
+	go func() {
+		defer s.loops.Done()
+		results := make(chan MatchingResult, 1)
+		s.matchingLoop(ctx, ride, excludeDriverIDs, script, results)
+		for result := range results {
+			s.metrics.recordResult(result, time.Since(started))
+			if result.Success {
+				s.publish(ride.ID, MatchingEventAccepted, result.DriverID)
+			} else {
+				s.publish(ride.ID, MatchingEventFailed, "")
+			}
+			resultChan <- result
+		}
+		close(resultChan)
+	}()
+}
+
+// Shutdown stops the service. New matches are refused with ErrShuttingDown
+// straight away, while matches already running are left to finish: they may
+// be waiting on a driver who is about to accept, so driver responses keep
+// being routed to them. Once they are done, the driverResponses channel is
+// closed, which ends processDriverResponses, and later driver responses are
+// dropped. Events subscriptions are closed last.
+//
+// If ctx expires first, the remaining matches are stopped with
+// ErrShuttingDown (their driver locks are released and their rides stay in
+// Matching), and Shutdown returns ctx's error once they have exited. Calling
+// it again is harmless.
+func (s *MatchingService) Shutdown(ctx context.Context) error {
+	s.pendingMu.Lock()
+	s.closing = true
+	inFlight := len(s.pendingMatches)
+	s.pendingMu.Unlock()
+	log.Printf("[MATCHING] Shutting down; waiting for %d matches in flight", inFlight)
+
+	drained := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Printf("[MATCHING] Shutdown deadline passed; stopping matches still in flight")
+		s.abortMatches(ErrShuttingDown)
+		<-drained
+	}
+
+	s.submitMu.Lock()
+	if !s.responsesClosed {
+		s.responsesClosed = true
+		close(s.driverResponses)
+	}
+	s.submitMu.Unlock()
+	<-s.routerDone
+	s.events.close()
+
+	return err
+}
+
+// noDriversInRange reports whether the pre-check positively found no
+// available driver within the ride's search radius, or within
+// MaxSearchRadiusKm when the search can expand that far. A failed search returns
+// false so the full matching loop gets to handle (and report) the error.
+func (s *MatchingService) noDriversInRange(ctx context.Context, ride *entities.Ride) bool {
+	settings := s.config.MatchingFor(string(ride.Category))
+	nearby, err := s.searchNearby(ctx, ride.ID, SearchReasonPrecheck, ride.Pickup(), max(settings.SearchRadiusKm, settings.MaxSearchRadiusKm))
+	return err == nil && len(nearby) == 0
+}
+
+// RestartMatching re-runs matching for a ride that is already back in the
+// Matching state because its driver cancelled before pickup. The drivers in
+// excludeDriverIDs (normally the one who cancelled) are never offered the ride.
+func (s *MatchingService) RestartMatching(ctx context.Context, ride *entities.Ride, excludeDriverIDs ...string) <-chan MatchingResult {
+	resultChan := make(chan MatchingResult, 1)
+
+	s.launch(ctx, ride, excludeDriverIDs, nil, resultChan)
 
 	return resultChan
 }
@@ -156,172 +451,1065 @@ func (s *MatchingService) StartMatching(ctx context.Context, ride *entities.Ride
 //  1. Register a per-ride response channel in pendingMatches
 //  2. Transition ride to Matching state
 //  3. Find nearby available drivers (sorted by distance)
-//  4. For each driver: acquire lock → notify → wait for response/timeout
+//  4. For each driver: acquire lock → notify → wait for ack → wait for
+//     response/timeout
 //  5. On accept: transition ride to Accepted, notify rider, return success
 //  6. On decline/timeout: release lock, try next driver
 //  7. If all drivers exhausted or total timeout: mark ride as Failed
 //
-// Go Learning Note — time.After:
-// time.After(d) returns a channel that receives a value after duration d.
-// Used in select statements for timeouts. Note: each call creates a new timer
-// that isn't garbage collected until it fires, so avoid using it in tight loops.
-// For cancellable/resettable timers, use time.NewTimer instead.
+// If the rider moves their pickup point mid-match (UpdatePickup), the
+// remaining candidates are replaced by a fresh nearby-driver query around the
+// new point. Drivers already offered this ride are not offered it again, and
+// an offer that is outstanding when the update arrives keeps running.
+//
+// With a script (see ReplaySession), each offer's responses and timeouts come
+// from the recording rather than from drivers and timers.
+//
+// Steps 4–6 describe the default SequentialStrategy. Another MatchingStrategy
+// set with SetStrategy decides which candidates are offered the ride and in
+// what order; each offer still works as described. With
+// MatchingStrategyBroadcast, the loop hands the ranked candidates to
+// broadcastMatch, which offers the ride to several drivers at once.
 //
 // Go Learning Note — chan<- (Send-Only Channel):
 // The parameter `resultChan chan<- MatchingResult` is send-only — this
 // goroutine can write to it but not read. This enforces the direction of
 // communication at compile time.
-func (s *MatchingService) matchingLoop(ctx context.Context, ride *entities.Ride, resultChan chan<- MatchingResult) {
+//
+// Go Learning Note — recover() in Goroutines:
+// Gin's recovery middleware only protects the goroutine serving the HTTP
+// request. A panic in any other goroutine that isn't recovered there crashes
+// the whole process, so every long-lived background goroutine needs its own
+// deferred recover(). recover() only has an effect inside a deferred function,
+// and deferred functions run last-in-first-out — the recovery below is
+// deferred after the cleanup, so it runs first and can still use the ride's
+// registrations and send on resultChan before they are torn down.
+//
+// Go Learning Note — context.WithCancelCause:
+// The loop runs under its own child context so CancelRide can stop it without
+// touching the caller's. WithCancelCause (Go 1.20) is WithCancel plus a
+// reason: stop(ErrMatchCancelled) cancels the context, and context.Cause(ctx)
+// then returns ErrMatchCancelled instead of the generic context.Canceled, so
+// the MatchingResult says why matching stopped. If the parent context is what
+// got cancelled, Cause returns the parent's error as ctx.Err() would.
+func (s *MatchingService) matchingLoop(ctx context.Context, ride *entities.Ride, excludeDriverIDs []string, script *sessionScript, resultChan chan<- MatchingResult) {
 	defer close(resultChan)
 
-	// Register a per-ride channel so driver responses can be routed here.
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	stopOnAbort := context.AfterFunc(s.shutdownCtx, func() { stop(ErrShuttingDown) })
+	defer stopOnAbort()
+
+	// Register a per-ride channel so driver responses can be routed here. A
+	// replay stays unregistered so live responses can't interfere with it.
+	replaying := script != nil
 	responseChan := make(chan DriverResponse, 10)
+	pickupChan := make(chan entities.Location, 1)
 	s.pendingMu.Lock()
-	s.pendingMatches[ride.ID] = responseChan
+	recorder := s.recorder
+	if !replaying {
+		s.pendingMatches[ride.ID] = responseChan
+		s.pickupUpdates[ride.ID] = pickupChan
+		s.stopMatches[ride.ID] = stop
+	}
 	s.pendingMu.Unlock()
 
 	// Clean up when done: remove from pendingMatches and close the channel.
 	defer func() {
-		s.pendingMu.Lock()
-		delete(s.pendingMatches, ride.ID)
-		s.pendingMu.Unlock()
+		if !replaying {
+			s.pendingMu.Lock()
+			delete(s.pendingMatches, ride.ID)
+			delete(s.pickupUpdates, ride.ID)
+			delete(s.stopMatches, ride.ID)
+			s.pendingMu.Unlock()
+		}
 		close(responseChan)
 	}()
 
-	// Transition ride from Requested → Matching.
-	if err := s.rideService.StartMatching(ctx, ride); err != nil {
-		resultChan <- MatchingResult{Success: false, Error: err}
-		return
+	// heldLocks are the driver locks for the offers currently outstanding
+	// (at most one, except when broadcasting), so a recovered panic can
+	// release them instead of leaving them to their TTL.
+	//
+	// Locks are released with unlockCtx rather than ctx. Most releases
+	// happen because ctx was just cancelled (the rider cancelled, the
+	// caller gave up), and a LockManager backed by a network store would
+	// refuse to do anything with an already-cancelled context, stranding
+	// the driver until the TTL expires.
+	heldLocks := make(map[string]bool)
+	unlockCtx := context.WithoutCancel(ctx)
+	releaseLock := func() {
+		for lockKey := range heldLocks {
+			s.lockManager.ReleaseLock(unlockCtx, lockKey)
+			delete(heldLocks, lockKey)
+		}
 	}
 
-	// Set an overall deadline for the entire matching process.
-	totalTimeout := time.After(s.config.Matching.TotalMatchingTimeout)
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if !s.config.Matching.RecoverPanics {
+			panic(r)
+		}
+		logf(ctx, "[MATCHING] Recovered from panic matching ride %s: %v\n%s", ride.ID, r, debug.Stack())
+		releaseLock()
+		if err := s.rideService.FailMatching(ctx, ride.ID); err != nil {
+			logf(ctx, "[MATCHING] Could not fail ride %s after panic: %v", ride.ID, err)
+		}
+		s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
 
-	// Find nearby available drivers, sorted by distance (nearest first).
-	nearbyDrivers, err := s.locationService.FindNearbyAvailableDrivers(
-		ctx,
-		ride.Source.Latitude,
-		ride.Source.Longitude,
-		s.config.Matching.SearchRadiusKm,
-	)
+		// A result may already have been sent if the panic came after it.
+		select {
+		case resultChan <- MatchingResult{Success: false, Error: fmt.Errorf("%w: %v", ErrMatchingPanicked, r)}:
+		default:
+		}
+	}()
 
+	// Transition ride from Requested → Matching. A reassigned ride is already
+	// in Matching.
+	if ride.CurrentStatus() != entities.RideStatusMatching {
+		if err := s.rideService.StartMatching(ctx, ride); err != nil {
+			resultChan <- MatchingResult{Success: false, Error: err}
+			return
+		}
+	}
+
+	// Search radius and timeouts can be tuned per ride category.
+	settings := s.config.MatchingFor(string(ride.Category))
+
+	// Set an overall deadline for the entire matching process. Every other
+	// wait in the match is budgeted against it (see offerClock).
+	deadline := time.Now().Add(settings.TotalMatchingTimeout)
+	totalTimer := time.NewTimer(settings.TotalMatchingTimeout)
+	defer totalTimer.Stop()
+	totalTimeout := totalTimer.C
+	clock := newOfferClock(deadline)
+	defer clock.stop()
+
+	// The pickup is read once, after pickupChan is registered: a move that
+	// lands later arrives on pickupChan, and the loop only ever uses the
+	// point it was handed rather than re-reading the ride.
+	pickup := ride.Pickup()
+
+	// Find nearby available drivers, sorted by distance (nearest first).
+	nearbyDrivers, err := s.searchNearby(ctx, ride.ID, SearchReasonInitial, pickup, settings.SearchRadiusKm)
 	if err != nil {
-		log.Printf("[MATCHING] Error finding drivers for ride %s: %v", ride.ID, err)
+		logf(ctx, "[MATCHING] Error finding drivers for ride %s: %v", ride.ID, err)
 		s.rideService.FailMatching(ctx, ride.ID)
 		s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
 		resultChan <- MatchingResult{Success: false, Error: err}
 		return
 	}
 
+	// Nobody in range at all: widen the search up front rather than wait
+	// for candidates to run out.
+	radiusKm := settings.SearchRadiusKm
 	if len(nearbyDrivers) == 0 {
-		log.Printf("[MATCHING] No drivers found for ride %s", ride.ID)
+		nearbyDrivers, radiusKm = s.expandSearch(ctx, ride.ID, pickup, radiusKm, settings, nil)
+	}
+
+	if len(nearbyDrivers) == 0 {
+		logf(ctx, "[MATCHING] No drivers found for ride %s", ride.ID)
 		s.rideService.FailMatching(ctx, ride.ID)
 		s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
-		resultChan <- MatchingResult{Success: false}
+		resultChan <- MatchingResult{Success: false, Error: ErrNoDriversNearby}
 		return
 	}
 
-	log.Printf("[MATCHING] Found %d nearby drivers for ride %s", len(nearbyDrivers), ride.ID)
+	logf(ctx, "[MATCHING] Found %d nearby drivers for ride %s", len(nearbyDrivers), ride.ID)
+	s.rankCandidates(nearbyDrivers)
 
-	// Try each driver in order of proximity (nearest first).
-	for _, dwd := range nearbyDrivers {
-		// Check if we've exceeded the total timeout or the context was cancelled
-		// before trying the next driver.
-		select {
-		case <-totalTimeout:
-			log.Printf("[MATCHING] Total timeout exceeded for ride %s", ride.ID)
-			s.rideService.FailMatching(ctx, ride.ID)
-			s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
-			resultChan <- MatchingResult{Success: false}
-			return
-		case <-ctx.Done():
-			resultChan <- MatchingResult{Success: false, Error: ctx.Err()}
-			return
-		default:
-			// No timeout yet — proceed to try this driver.
+	// Encode the destination once at full precision so any preferred-zone
+	// geohash prefix can be matched against it.
+	destGeohash := geo.Encode(ride.Destination.Latitude, ride.Destination.Longitude, geo.MaxPrecision)
+
+	// offered records every driver this ride has been sent to, so nothing
+	// offers the same ride to a driver twice: not a pickup re-query, a wider
+	// search, the next broadcast batch, a strategy that names a driver again,
+	// or a duplicate in the candidate list. A driver who declined was offered
+	// the ride, so they are covered too. Excluded drivers are seeded in as if
+	// they had already been offered it.
+	//
+	// The one exception is MatchingConfig.ReofferDecliners: declined holds
+	// when each decliner said no, and reoffering the drivers currently let
+	// past offered for their single second offer.
+	offered := make(map[string]bool)
+	for _, id := range excludeDriverIDs {
+		offered[id] = true
+	}
+	declined := make(map[string]time.Time)
+	reoffering := make(map[string]bool)
+
+	// publish reports a step of the match to Events subscribers. A replay
+	// re-runs steps that were already reported, so it stays silent.
+	publish := func(eventType MatchingEventType, driverID string) {
+		if !replaying {
+			s.publish(ride.ID, eventType, driverID)
 		}
+	}
 
-		driverID := dwd.Driver.DriverID
+	// A replay follows the recording one offer at a time, so it always runs
+	// sequentially.
+	if s.config.Matching.MatchingStrategy == config.MatchingStrategyBroadcast && !replaying {
+		resultChan <- s.broadcastMatch(ctx, &broadcastRun{
+			ride:         ride,
+			settings:     settings,
+			candidates:   nearbyDrivers,
+			pickup:       pickup,
+			radiusKm:     radiusKm,
+			offered:      offered,
+			heldLocks:    heldLocks,
+			destGeohash:  destGeohash,
+			responses:    responseChan,
+			pickups:      pickupChan,
+			totalTimeout: totalTimeout,
+			deadline:     deadline,
+			recorder:     recorder,
+		})
+		return
+	}
 
-		// Re-check driver availability (they might have been matched to another
-		// ride while we were trying other drivers).
-		driver, err := s.driverRepo.GetByID(ctx, driverID)
-		if err != nil || !driver.IsAvailable() {
-			continue
+	fail := func(err error) MatchingResult {
+		s.rideService.FailMatching(ctx, ride.ID)
+		s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
+		return MatchingResult{Success: false, Error: err}
+	}
+
+	// The strategy picks which candidates to offer the ride to; offer below
+	// does the rest. Each round hands the strategy the current candidate
+	// list. A pickup change re-queries the candidates and ends the round, so
+	// the next round starts from the new list.
+	candidates := nearbyDrivers
+	contacted := 0
+	for len(candidates) > 0 {
+		roundCtx, endRound := context.WithCancel(ctx)
+		var (
+			round      = candidates
+			requeried  bool
+			acceptedBy string
+			done       *MatchingResult
+		)
+		candidates = nil
+
+		distances := make(map[string]float64, len(round))
+		for _, dwd := range round {
+			distances[dwd.Driver.DriverID] = dwd.Distance
 		}
 
-		// Acquire a distributed lock on this driver to prevent double-booking.
-		// If another matching goroutine already locked this driver, skip them.
-		lockKey := "driver:" + driverID
-		acquired, err := s.lockManager.AcquireLock(ctx, lockKey, s.config.Matching.DriverResponseTimeout)
-		if err != nil || !acquired {
-			log.Printf("[MATCHING] Could not acquire lock for driver %s", driverID)
-			continue
+		requery := func(moved entities.Location) {
+			pickup = moved
+			candidates = s.requeryCandidates(ctx, ride.ID, pickup, radiusKm, offered)
+			requeried = true
+			endRound()
+		}
+		finish := func(result MatchingResult) bool {
+			done = &result
+			endRound()
+			return false
 		}
 
-		log.Printf("[MATCHING] Requesting driver %s (%.2f km away) for ride %s",
-			driverID, dwd.Distance, ride.ID)
+		offer := func(driverID string) bool {
+			if done != nil || acceptedBy != "" || requeried {
+				return false
+			}
+			if settings.MaxDriversContacted > 0 && contacted >= settings.MaxDriversContacted {
+				logf(ctx, "[MATCHING] Contacted %d drivers for ride %s without a match; giving up", contacted, ride.ID)
+				return finish(fail(ErrMaxDriversContacted))
+			}
 
-		// Notify the driver about the ride request (in production, this would
-		// be a push notification via FCM/APNs).
-		s.notificationService.NotifyDriverOfRideRequest(driverID, ride)
+			// Check if we've exceeded the total timeout or the context was
+			// cancelled before trying this driver.
+			select {
+			case moved := <-pickupChan:
+				// This driver has not been offered yet, so the re-query
+				// includes them again if they are still near the new point.
+				requery(moved)
+				return false
+			case <-totalTimeout:
+				logf(ctx, "[MATCHING] Total timeout exceeded for ride %s", ride.ID)
+				return finish(fail(ErrMatchingTimeout))
+			case <-ctx.Done():
+				return finish(MatchingResult{Success: false, Error: context.Cause(ctx)})
+			default:
+				// No timeout yet — proceed to try this driver.
+			}
 
-		// Wait for this specific driver to respond, or timeout.
-		driverTimeout := time.After(s.config.Matching.DriverResponseTimeout)
+			// Declined, timed out or still outstanding, a driver who has seen
+			// this ride is never sent it again, short of a re-offer.
+			secondOffer := reoffering[driverID]
+			if offered[driverID] && !secondOffer {
+				return false
+			}
+			delete(reoffering, driverID)
 
-		select {
-		case resp := <-responseChan:
-			if resp.DriverID == driverID && resp.Accept {
-				// Driver accepted the ride.
-				log.Printf("[MATCHING] Driver %s accepted ride %s", driverID, ride.ID)
-				s.lockManager.ReleaseLock(ctx, lockKey)
+			lockKey, reserved := s.reserveDriver(ctx, ride, driverID, destGeohash, settings.OfferAckTimeout+settings.DriverResponseTimeout)
+			if !reserved {
+				return false
+			}
+			heldLocks[lockKey] = true
+
+			// From here until the closure returns, responses this driver
+			// submits for the ride are stamped with seq; anything else that
+			// reaches responseChan is an answer to some other offer.
+			seq := s.openOffer(ride.ID, driverID)
+			defer s.closeOffer(ride.ID, driverID)
+
+			if secondOffer {
+				logf(ctx, "[MATCHING] Re-offering ride %s to driver %s (%.2f km away)",
+					ride.ID, driverID, distances[driverID])
+			} else {
+				logf(ctx, "[MATCHING] Requesting driver %s (%.2f km away) for ride %s",
+					driverID, distances[driverID], ride.ID)
+			}
 
-				_, err := s.rideService.AcceptRide(ctx, driverID, ride.ID, true)
+			// Notify the driver about the ride request (in production, this
+			// would be a push notification via FCM/APNs).
+			s.notificationService.NotifyDriverOfRideRequest(driverID, ride, pickup, s.offerDemand(ctx, pickup))
+			s.reliability.RecordOffer(driverID)
+			if !replaying {
+				s.metrics.recordOffer()
+			}
+			offered[driverID] = true
+			contacted++
+			recorder.record(ride.ID, SessionEventOffer, driverID)
+			publish(MatchingEventOfferSent, driverID)
+
+			// A replay looks up what happened to this offer in the recording
+			// and queues the driver's responses as if they had just arrived.
+			var scripted []SessionEvent
+			if replaying {
+				var err error
+				scripted, err = script.nextOffer(driverID)
 				if err != nil {
-					log.Printf("[MATCHING] Error accepting ride: %v", err)
-					continue
+					logf(ctx, "[MATCHING] Replay of ride %s: %v", ride.ID, err)
+					releaseLock()
+					s.rideService.FailMatching(ctx, ride.ID)
+					return finish(MatchingResult{Success: false, Error: err})
 				}
+				for _, resp := range scriptedResponses(ride.ID, seq, scripted) {
+					responseChan <- resp
+				}
+			}
 
-				s.notificationService.NotifyRiderOfDriverAccepted(ride.RiderID, driverID, ride.ID)
-				resultChan <- MatchingResult{Success: true, DriverID: driverID}
-				return
+			// Wait for this specific driver to respond, or timeout. With the
+			// ack phase enabled, the driver app must first confirm receipt
+			// within OfferAckTimeout; only then does the DriverResponseTimeout
+			// decision window start. A nil channel never fires in a select,
+			// so whichever timer is not yet active is simply left nil. Both
+			// run on the match's clock, one after the other, and whichever
+			// is running when the driver answers is stopped on the way out.
+			defer clock.stop()
+			var ackTimeout, driverTimeout <-chan time.Time
+			if settings.OfferAckTimeout > 0 {
+				ackTimeout = offerTimer(settings.OfferAckTimeout, clock, scripted, replaying, SessionEventAckTimeout)
 			} else {
-				// Driver declined — release lock and try next driver.
-				log.Printf("[MATCHING] Driver %s denied ride %s", driverID, ride.ID)
-				s.lockManager.ReleaseLock(ctx, lockKey)
+				driverTimeout = offerTimer(settings.DriverResponseTimeout, clock, scripted, replaying, SessionEventTimeout)
+			}
+
+			for {
+				select {
+				case resp := <-responseChan:
+					// Only this driver can answer this offer. A late answer
+					// to an earlier offer whose window already closed — from
+					// another driver, or from this one before a re-offer —
+					// or one from a driver never offered the ride, is
+					// dropped rather than taken as this driver's answer.
+					if resp.DriverID != driverID || resp.Seq != seq {
+						logf(ctx, "[MATCHING] Ignoring response from driver %s to ride %s (offer %d); waiting on %s (offer %d)",
+							resp.DriverID, ride.ID, resp.Seq, driverID, seq)
+						continue
+					}
+
+					if resp.Ack {
+						if ackTimeout != nil {
+							logf(ctx, "[MATCHING] Driver %s acknowledged ride %s", driverID, ride.ID)
+							recorder.record(ride.ID, SessionEventAck, driverID)
+							ackTimeout = nil
+							driverTimeout = offerTimer(settings.DriverResponseTimeout, clock, scripted, replaying, SessionEventTimeout)
+						}
+						continue
+					}
+
+					if resp.Accept {
+						// Driver accepted the ride.
+						logf(ctx, "[MATCHING] Driver %s accepted ride %s", driverID, ride.ID)
+						s.reliability.RecordAccept(driverID)
+						recorder.record(ride.ID, SessionEventAccept, driverID)
+
+						// The driver lock is still held here and is only
+						// released after acceptOffer has taken and dropped
+						// the ride lock.
+						err := s.acceptOffer(ctx, driverID, ride.ID)
+						releaseLock()
+						if err != nil {
+							logf(ctx, "[MATCHING] Error accepting ride: %v", err)
+							s.acceptLost(ctx, driverID, ride.ID)
+							return false
+						}
+						acceptedBy = driverID
+						return true
+					}
+
+					// Driver declined — release lock and try next driver.
+					logf(ctx, "[MATCHING] Driver %s denied ride %s", driverID, ride.ID)
+					s.recordDecline(driverID, ride.ID)
+					recorder.record(ride.ID, SessionEventDecline, driverID)
+					publish(MatchingEventDeclined, driverID)
+					if settings.ReofferDecliners && !secondOffer {
+						declined[driverID] = time.Now()
+					}
+					releaseLock()
+					return false
+
+				case moved := <-pickupChan:
+					// The outstanding offer stays open; only the drivers
+					// after it are re-ranked against the new pickup point.
+					requery(moved)
+
+				case <-ackTimeout:
+					// The offer was never acknowledged — most likely the push
+					// did not reach the driver's phone. Skip without waiting
+					// out the full decision window.
+					logf(ctx, "[MATCHING] Driver %s did not acknowledge ride %s", driverID, ride.ID)
+					s.notificationService.NotifyDriverOfRideTimeout(driverID, ride.ID)
+					s.reliability.RecordTimeout(driverID)
+					recorder.record(ride.ID, SessionEventAckTimeout, driverID)
+					publish(MatchingEventTimedOut, driverID)
+					releaseLock()
+					return false
+
+				case <-driverTimeout:
+					// Driver didn't respond within the timeout window.
+					logf(ctx, "[MATCHING] Driver %s timed out for ride %s", driverID, ride.ID)
+					s.notificationService.NotifyDriverOfRideTimeout(driverID, ride.ID)
+					s.reliability.RecordTimeout(driverID)
+					recorder.record(ride.ID, SessionEventTimeout, driverID)
+					publish(MatchingEventTimedOut, driverID)
+					releaseLock()
+					return false
+
+				case <-ctx.Done():
+					// The rider cancelled (or the caller gave up). The ride's
+					// state is already settled; just let the driver go, and
+					// tell them if it was the rider.
+					releaseLock()
+					if context.Cause(ctx) == ErrMatchCancelled {
+						s.notificationService.NotifyDriverOfRideCancelled(driverID, ride.ID)
+					}
+					return finish(MatchingResult{Success: false, Error: context.Cause(ctx)})
+
+				case <-totalTimeout:
+					// Overall matching timeout exceeded while waiting for
+					// this driver.
+					releaseLock()
+					logf(ctx, "[MATCHING] Total timeout exceeded for ride %s", ride.ID)
+					return finish(fail(ErrMatchingTimeout))
+				}
 			}
+		}
 
-		case <-driverTimeout:
-			// Driver didn't respond within the timeout window.
-			log.Printf("[MATCHING] Driver %s timed out for ride %s", driverID, ride.ID)
-			s.notificationService.NotifyDriverOfRideTimeout(driverID, ride.ID)
-			s.lockManager.ReleaseLock(ctx, lockKey)
-
-		case <-totalTimeout:
-			// Overall matching timeout exceeded while waiting for this driver.
-			s.lockManager.ReleaseLock(ctx, lockKey)
-			log.Printf("[MATCHING] Total timeout exceeded for ride %s", ride.ID)
-			s.rideService.FailMatching(ctx, ride.ID)
-			s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
-			resultChan <- MatchingResult{Success: false}
+		s.strategy.SelectAndOffer(roundCtx, ride, round, offer)
+		endRound()
+
+		// The outcome is what offer saw, not what the strategy returned, so a
+		// strategy can't report a driver who was never assigned the ride.
+		if done != nil {
+			resultChan <- *done
+			return
+		}
+		if acceptedBy != "" {
+			s.notificationService.NotifyRiderOfDriverAccepted(ride.RiderID, acceptedBy, ride.ID, s.pickupETA(ctx, acceptedBy, pickup))
+			resultChan <- MatchingResult{Success: true, DriverID: acceptedBy}
 			return
 		}
+
+		// Everyone in range has been tried; look a little farther out if
+		// the config allows. A moved pickup keeps the widened radius.
+		if len(candidates) == 0 {
+			candidates, radiusKm = s.expandSearch(ctx, ride.ID, pickup, radiusKm, settings, offered)
+		}
+
+		// Still nobody: go back to the drivers who declined, waiting out
+		// their cooldown if need be. Each pass hands at least one decliner
+		// their second chance (or drops them if they have gone), so this
+		// ends once declined is empty.
+		for len(candidates) == 0 && len(declined) > 0 && ctx.Err() == nil {
+			if wait := time.Until(nextReoffer(declined, settings.ReofferCooldown)); wait > 0 {
+				// No offer is running between rounds, so the cooldown
+				// borrows the match's clock; a wait cut short by a moved
+				// pickup or a cancellation leaves no timer behind.
+				select {
+				case <-clock.start(wait):
+				case moved := <-pickupChan:
+					pickup = moved
+					candidates = s.requeryCandidates(ctx, ride.ID, pickup, radiusKm, offered)
+					continue
+				case <-totalTimeout:
+					logf(ctx, "[MATCHING] Total timeout exceeded for ride %s", ride.ID)
+					resultChan <- fail(ErrMatchingTimeout)
+					return
+				case <-ctx.Done():
+					continue
+				}
+			}
+			candidates = s.reofferCandidates(ctx, ride.ID, pickup, radiusKm, declined, settings.ReofferCooldown)
+			for _, dwd := range candidates {
+				reoffering[dwd.Driver.DriverID] = true
+			}
+		}
+	}
+
+	// An accept that lost the race with a cancellation can leave no
+	// candidates behind it; the ride is already cancelled, not failed.
+	if err := context.Cause(ctx); err != nil {
+		resultChan <- MatchingResult{Success: false, Error: err}
+		return
 	}
 
 	// All nearby drivers were tried and none accepted.
-	log.Printf("[MATCHING] No driver accepted ride %s", ride.ID)
+	logf(ctx, "[MATCHING] No driver accepted ride %s", ride.ID)
 	s.rideService.FailMatching(ctx, ride.ID)
 	s.notificationService.NotifyRiderOfNoDriversAvailable(ride.RiderID, ride.ID)
 	resultChan <- MatchingResult{Success: false}
 }
 
+// offerClock times the windows of an offer (ack, then decision), and the
+// reoffer cooldown between rounds, within a match whose TotalMatchingTimeout
+// runs out at deadline. One clock serves every wait the match makes: start
+// re-arms the same timer for each window.
+//
+// A window that would not end before the deadline gets neverFires instead,
+// so the total timeout ends it. Without that, a driver window longer than the
+// time left would either outlast the match or, firing together with the total
+// timer, let select pick it and count a driver timeout for a window that was
+// cut short.
+//
+// Go Learning Note — time.After vs a Reused Timer:
+// time.After allocates a new timer each call, and (before Go 1.23) that
+// timer stays live until it fires even if nobody is waiting any more. A
+// driver who declines after a second leaves their 10-second timer behind, so
+// a match over a long candidate list piles up one timer per offer. Stopping
+// and resetting one time.Timer keeps it to one per clock. Reset is only safe
+// on a stopped timer whose channel has been drained, which is why stop empties
+// the channel when Stop reports the timer had already fired.
+type offerClock struct {
+	timer    *time.Timer
+	deadline time.Time
+}
+
+// neverFires is the channel of a window the total timeout will end first.
+// Nothing ever sends on it, so every clock can share it.
+var neverFires = make(chan time.Time)
+
+func newOfferClock(deadline time.Time) *offerClock {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &offerClock{timer: timer, deadline: deadline}
+}
+
+// start arms the clock for a d-long window, cancelling any window still
+// running, and returns the channel that fires when it ends.
+func (c *offerClock) start(d time.Duration) <-chan time.Time {
+	c.stop()
+	if !time.Now().Add(d).Before(c.deadline) {
+		return neverFires
+	}
+	c.timer.Reset(d)
+	return c.timer.C
+}
+
+// stop cancels the running window, if any.
+func (c *offerClock) stop() {
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+}
+
+// offerDemand returns the demand context around pickup to include in an
+// offer, or nil when MatchingConfig.OfferDemandContext is off. It is looked up
+// for each offer rather than once per ride, since surge can move while a ride
+// waits.
+func (s *MatchingService) offerDemand(ctx context.Context, pickup entities.Location) *DemandContext {
+	if !s.config.Matching.OfferDemandContext {
+		return nil
+	}
+	demand := s.rideService.DemandContextAt(ctx, pickup)
+	return &demand
+}
+
+// reserveDriver checks that driverID can be offered ride right now and, if
+// so, locks them for lockTTL and returns the lock key. A driver is skipped if
+// they are no longer available (they may have been matched to another ride
+// since the search), if their car is not of the ride's vehicle tier, if the
+// ride ends outside their preferred destination zone, or if another matching
+// goroutine already holds their lock. None of
+// these is a decline, so reliability stats are untouched.
+func (s *MatchingService) reserveDriver(ctx context.Context, ride *entities.Ride, driverID, destGeohash string, lockTTL time.Duration) (string, bool) {
+	driver, err := s.driverRepo.GetByID(ctx, driverID)
+	if err != nil || !driver.IsAvailable() {
+		return "", false
+	}
+
+	if !driver.ServesTier(ride.VehicleTier) {
+		logf(ctx, "[MATCHING] Skipping driver %s: ride %s needs a %s car", driverID, ride.ID, ride.VehicleTier)
+		return "", false
+	}
+
+	if !driver.AcceptsDestination(ride.Destination, destGeohash) {
+		logf(ctx, "[MATCHING] Skipping driver %s: ride %s ends outside their preferred zone", driverID, ride.ID)
+		return "", false
+	}
+
+	// Acquire a distributed lock on this driver to prevent double-booking.
+	lockKey := "driver:" + driverID
+	acquired, err := s.lockManager.AcquireLock(ctx, lockKey, lockTTL)
+	if err != nil || !acquired {
+		logf(ctx, "[MATCHING] Could not acquire lock for driver %s", driverID)
+		return "", false
+	}
+	return lockKey, true
+}
+
+// acceptOffer performs the Matching → Accepted transition for driverID while
+// holding the "ride:"+rideID lock, so two accepts racing on the same ride
+// can't both pass the state check; the loser gets ErrRideLockHeld (or
+// ErrInvalidTransition if it arrives after the winner has finished).
+//
+// Callers must already hold the "driver:"+driverID lock. Taking locks in one
+// fixed order — driver, then ride — everywhere means no two goroutines can
+// each hold the lock the other is waiting for, which rules out deadlock. The
+// ride lock is also try-once rather than blocking, so contention fails fast.
+func (s *MatchingService) acceptOffer(ctx context.Context, driverID, rideID string) error {
+	lockKey := "ride:" + rideID
+	acquired, err := s.lockManager.AcquireLock(ctx, lockKey, rideLockTTL)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrRideLockHeld
+	}
+	// The rider may cancel while AcceptRide runs; the lock must still go.
+	defer s.lockManager.ReleaseLock(context.WithoutCancel(ctx), lockKey)
+
+	_, err = s.rideService.AcceptRide(ctx, driverID, rideID, true)
+	return err
+}
+
+// waitForRideLock takes the "ride:"+rideID lock, retrying every rideLockRetry
+// while an accept holds it. Accepts are quick, so after rideLockTTL it gives
+// up with ErrRideLockHeld.
+func (s *MatchingService) waitForRideLock(ctx context.Context, rideID string) (release func(), err error) {
+	lockKey := "ride:" + rideID
+	deadline := time.Now().Add(rideLockTTL)
+	for {
+		acquired, err := s.lockManager.AcquireLock(ctx, lockKey, rideLockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return func() { s.lockManager.ReleaseLock(context.WithoutCancel(ctx), lockKey) }, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrRideLockHeld
+		}
+		time.Sleep(rideLockRetry)
+	}
+}
+
+// acceptLost is called when acceptOffer fails for a driver who accepted. If
+// the reason is that the rider cancelled at the same moment, the driver is
+// told, since from their side the accept went through. The cancellation
+// holds the ride lock while it runs, so waiting for that lock first means the
+// ride's status has settled by the time it is read. The driver lock must
+// already be released.
+//
+// Go Learning Note — context.WithoutCancel:
+// The matching context is being cancelled by that very cancellation.
+// context.WithoutCancel (Go 1.21) keeps the parent's values but drops its
+// cancellation and deadline, for follow-up work that must run anyway.
+func (s *MatchingService) acceptLost(ctx context.Context, driverID, rideID string) {
+	ctx = context.WithoutCancel(ctx)
+	release, err := s.waitForRideLock(ctx, rideID)
+	if err != nil {
+		return
+	}
+	release()
+
+	ride, err := s.rideService.GetRide(ctx, rideID)
+	if err != nil || ride.CurrentStatus() != entities.RideStatusCancelled {
+		return
+	}
+	logf(ctx, "[MATCHING] Driver %s accepted ride %s as the rider cancelled it", driverID, rideID)
+	s.notificationService.NotifyDriverOfRideCancelled(driverID, rideID)
+}
+
+// pickupETA estimates how many minutes driverID is from pickup, for the
+// rider's driver-accepted notification; nil when their location isn't known,
+// and the notification goes out without an ETA.
+func (s *MatchingService) pickupETA(ctx context.Context, driverID string, pickup entities.Location) *float64 {
+	mins, err := s.locationService.EstimatePickupETA(ctx, driverID, pickup.Latitude, pickup.Longitude)
+	if err != nil {
+		return nil
+	}
+	return &mins
+}
+
+// requeryCandidates re-runs the nearby-driver search around a moved pickup
+// point and drops drivers that have already been offered the ride. A failed
+// search yields no candidates, which ends matching the same way as running
+// out of drivers.
+func (s *MatchingService) requeryCandidates(ctx context.Context, rideID string, pickup entities.Location, radiusKm float64, offered map[string]bool) []geo.DriverWithDistance {
+	candidates := s.unofferedNearby(ctx, rideID, SearchReasonPickupMoved, pickup, radiusKm, offered)
+	logf(ctx, "[MATCHING] Pickup moved for ride %s; %d candidate drivers near new point", rideID, len(candidates))
+	return candidates
+}
+
+// expandSearch widens the search for a ride whose candidates have run out
+// (see MatchingConfig.MaxSearchRadiusKm). It grows the radius by
+// RadiusExpansionFactor until the wider circle holds a driver not yet offered
+// the ride, and returns those drivers ranked along with the radius it reached.
+// It returns no candidates once the radius is at the cap.
+func (s *MatchingService) expandSearch(ctx context.Context, rideID string, pickup entities.Location, radiusKm float64, settings config.MatchingConfig, offered map[string]bool) ([]geo.DriverWithDistance, float64) {
+	for radiusKm < settings.MaxSearchRadiusKm && ctx.Err() == nil {
+		radiusKm *= settings.RadiusExpansionFactor
+		if settings.RadiusExpansionFactor <= 1 || radiusKm > settings.MaxSearchRadiusKm {
+			radiusKm = settings.MaxSearchRadiusKm
+		}
+		candidates := s.unofferedNearby(ctx, rideID, SearchReasonExpanded, pickup, radiusKm, offered)
+		logf(ctx, "[MATCHING] Widened search for ride %s to %.1f km; %d new candidate drivers", rideID, radiusKm, len(candidates))
+		if len(candidates) > 0 {
+			return candidates, radiusKm
+		}
+	}
+	return nil, radiusKm
+}
+
+// nextReoffer returns the earliest time any driver in declined may be offered
+// the ride again.
+func nextReoffer(declined map[string]time.Time, cooldown time.Duration) time.Time {
+	var next time.Time
+	for _, at := range declined {
+		if ready := at.Add(cooldown); next.IsZero() || ready.Before(next) {
+			next = ready
+		}
+	}
+	return next
+}
+
+// reofferCandidates takes every driver in declined whose cooldown has passed
+// out of it, and returns those still available within radiusKm of pickup,
+// ranked for offering. A driver taken out is not reconsidered, whether or not
+// they were in range, so each decliner gets at most one second offer.
+func (s *MatchingService) reofferCandidates(ctx context.Context, rideID string, pickup entities.Location, radiusKm float64, declined map[string]time.Time, cooldown time.Duration) []geo.DriverWithDistance {
+	ready := make(map[string]bool)
+	for driverID, at := range declined {
+		if time.Since(at) >= cooldown {
+			ready[driverID] = true
+			delete(declined, driverID)
+		}
+	}
+
+	nearby, err := s.searchNearby(ctx, rideID, SearchReasonReoffer, pickup, radiusKm)
+	if err != nil {
+		logf(ctx, "[MATCHING] Error re-querying drivers for ride %s: %v", rideID, err)
+		return nil
+	}
+
+	candidates := make([]geo.DriverWithDistance, 0, len(ready))
+	for _, dwd := range nearby {
+		if ready[dwd.Driver.DriverID] {
+			candidates = append(candidates, dwd)
+		}
+	}
+	s.rankCandidates(candidates)
+	logf(ctx, "[MATCHING] Re-offering ride %s to %d of the drivers who declined it", rideID, len(candidates))
+	return candidates
+}
+
+// unofferedNearby finds available drivers within radiusKm of pickup that
+// have not been offered the ride yet, ranked for offering.
+func (s *MatchingService) unofferedNearby(ctx context.Context, rideID string, reason SearchReason, pickup entities.Location, radiusKm float64, offered map[string]bool) []geo.DriverWithDistance {
+	nearby, err := s.searchNearby(ctx, rideID, reason, pickup, radiusKm)
+	if err != nil {
+		logf(ctx, "[MATCHING] Error re-querying drivers for ride %s: %v", rideID, err)
+		return nil
+	}
+
+	candidates := make([]geo.DriverWithDistance, 0, len(nearby))
+	for _, dwd := range nearby {
+		if !offered[dwd.Driver.DriverID] {
+			candidates = append(candidates, dwd)
+		}
+	}
+	s.rankCandidates(candidates)
+	return candidates
+}
+
+// dropStaleLocations removes candidates whose last location ping is older
+// than MaxLocationAge: wherever they are now, it is probably not where the
+// index says. They stay online — this only keeps them out of this search.
+func (s *MatchingService) dropStaleLocations(ctx context.Context, candidates []geo.DriverWithDistance) []geo.DriverWithDistance {
+	maxAge := s.config.Matching.MaxLocationAge
+	if maxAge <= 0 {
+		return candidates
+	}
+
+	fresh := candidates[:0]
+	for _, dwd := range candidates {
+		if age := time.Since(dwd.Driver.UpdatedAt); age > maxAge {
+			logf(ctx, "[MATCHING] Skipping driver %s: location is %s old", dwd.Driver.DriverID, age.Round(time.Second))
+			continue
+		}
+		fresh = append(fresh, dwd)
+	}
+	return fresh
+}
+
+// stalenessPenaltyKm is how much farther away a driver is treated as being
+// because their location has aged past StaleLocationAfter: the distance they
+// could have covered since, at StalePenaltyKmPerMin.
+func (s *MatchingService) stalenessPenaltyKm(location *entities.DriverLocation) float64 {
+	staleAfter := s.config.Matching.StaleLocationAfter
+	if staleAfter <= 0 {
+		return 0
+	}
+	overdue := time.Since(location.UpdatedAt) - staleAfter
+	if overdue <= 0 {
+		return 0
+	}
+	return overdue.Minutes() * s.config.Matching.StalePenaltyKmPerMin
+}
+
+// rankCandidates reorders nearest-first candidates in place by an effective
+// distance. A driver whose location has gone stale is pushed out by
+// stalenessPenaltyKm, and an unreliable driver is tried later, as if they were
+// farther away (see config.MatchingConfig.ReliabilityWeight). Fresh drivers
+// with no history keep their distance, so the order only changes once
+// locations age or drivers have declined, timed out, or cancelled after
+// accepting.
+//
+// Go Learning Note — sort.SliceStable:
+// sort.SliceStable keeps equal elements in their original order. Candidates
+// arrive sorted by distance, so ties in weighted distance stay nearest-first.
+func (s *MatchingService) rankCandidates(candidates []geo.DriverWithDistance) {
+	if len(candidates) < 2 {
+		return
+	}
+	weight := s.config.Matching.ReliabilityWeight
+
+	weighted := make(map[string]float64, len(candidates))
+	for _, dwd := range candidates {
+		effective := dwd.Distance + s.stalenessPenaltyKm(dwd.Driver)
+		if weight > 0 {
+			score := s.reliability.Get(dwd.Driver.DriverID).Score()
+			effective *= 1 + weight*(1-score)
+		}
+		weighted[dwd.Driver.DriverID] = effective
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return weighted[candidates[i].Driver.DriverID] < weighted[candidates[j].Driver.DriverID]
+	})
+}
+
+// UpdatePickup hands a rider's new pickup point to the ride's matching loop.
+// It reports false if the ride is not currently being matched. Only the
+// latest point matters, so an update that has not been consumed yet is
+// replaced rather than queued behind.
+func (s *MatchingService) UpdatePickup(rideID string, pickup entities.Location) bool {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	ch, exists := s.pickupUpdates[rideID]
+	if !exists {
+		return false
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- pickup
+	return true
+}
+
+// CancelRide cancels a ride for its rider (see RideService.CancelRide) and
+// stops the ride's matching loop, which releases any driver it was holding an
+// offer open for.
+//
+// It takes the same "ride:"+rideID lock as acceptOffer, so a cancellation and
+// a driver's accept arriving together are applied one after the other. If the
+// cancel goes first the accept fails its state check; if the accept goes
+// first the ride is cancelled from Accepted and the driver is released. Either
+// way the ride ends Cancelled and the driver is told once: here if they were
+// already assigned, by the matching loop otherwise. Unlike an accept, a
+// cancel waits for the lock rather than failing fast, because the rider's
+// intent doesn't depend on who got there first.
+func (s *MatchingService) CancelRide(ctx context.Context, riderID, rideID string) (*entities.Ride, error) {
+	release, err := s.waitForRideLock(ctx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ride, err := s.rideService.CancelRide(ctx, riderID, rideID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A driver already assigned loses the ride. One with an offer still open,
+	// or whose accept is racing this cancellation, is told by the matching
+	// loop (see acceptLost).
+	if driverID := ride.AssignedDriver(); driverID != "" {
+		s.notificationService.NotifyDriverOfRideCancelled(driverID, rideID)
+	}
+
+	s.pendingMu.RLock()
+	stop, matching := s.stopMatches[rideID]
+	s.pendingMu.RUnlock()
+	if matching {
+		logf(ctx, "[MATCHING] Rider cancelled ride %s; stopping matching", rideID)
+		stop(ErrMatchCancelled)
+	}
+
+	return ride, nil
+}
+
+// recordDecline applies the configured fairness rule to a decline: either it
+// is tracked as a decline, or (DeclineCountsAsTimeout) it is treated exactly
+// like a missed offer, including the timeout notification to the driver.
+func (s *MatchingService) recordDecline(driverID, rideID string) {
+	if s.config.Matching.DeclineCountsAsTimeout {
+		s.notificationService.NotifyDriverOfRideTimeout(driverID, rideID)
+		s.reliability.RecordTimeout(driverID)
+		return
+	}
+	s.reliability.RecordDecline(driverID)
+}
+
+// RecordCancelAfterAccept records that a driver cancelled a ride they had
+// accepted before reaching the rider, which counts against their reliability
+// more than a decline.
+func (s *MatchingService) RecordCancelAfterAccept(driverID string) {
+	s.reliability.RecordCancelAfterAccept(driverID)
+}
+
+// PendingCount returns how many rides are being matched right now. Replays
+// (see ReplaySession) aren't counted: they never register for live driver
+// responses.
+//
+// Go Learning Note — RLock for Reads:
+// len of a map is a read, but a read racing with a write to the same map is
+// still a data race, so it takes pendingMu like every other access. RLock lets
+// any number of readers in at once and only excludes writers.
+func (s *MatchingService) PendingCount() int {
+	s.pendingMu.RLock()
+	defer s.pendingMu.RUnlock()
+	return len(s.pendingMatches)
+}
+
+// DriverReliability returns the offer history recorded for a driver.
+func (s *MatchingService) DriverReliability(driverID string) DriverReliability {
+	return s.reliability.Get(driverID)
+}
+
+// AcknowledgeOffer is called by the HTTP handler when a driver app confirms it
+// received a ride offer. It travels the same path as SubmitDriverResponse so
+// the matching loop can start the driver's decision window.
+//
+// It reports whether the driver has an offer of the ride open. An ack for any
+// other ride — never offered to them, or whose offer has already closed — is
+// dropped and false is returned with a nil error. After Shutdown the error is
+// ErrShuttingDown.
+func (s *MatchingService) AcknowledgeOffer(driverID, rideID string) (bool, error) {
+	s.pendingMu.RLock()
+	_, open := s.outstandingOffers[rideID][driverID]
+	s.pendingMu.RUnlock()
+	if !open {
+		s.submitMu.RLock()
+		defer s.submitMu.RUnlock()
+		if s.responsesClosed {
+			return false, ErrShuttingDown
+		}
+		log.Printf("[MATCHING] Dropping ack from driver %s to ride %s: no open offer", driverID, rideID)
+		return false, nil
+	}
+	return s.submit(DriverResponse{
+		DriverID: driverID,
+		RideID:   rideID,
+		Ack:      true,
+	})
+}
+
 // SubmitDriverResponse is called by the HTTP handler when a driver accepts or
 // declines a ride. It sends the response through the driverResponses channel,
 // which is consumed by processDriverResponses and routed to the matching loop.
-func (s *MatchingService) SubmitDriverResponse(driverID, rideID string, accept bool) {
-	s.driverResponses <- DriverResponse{
+//
+// It reports whether a matching loop is waiting for the ride. When none is —
+// the ride was never matched, or its match already ended — the response is
+// dropped and false is returned with a nil error, so the caller can tell the
+// driver their answer went nowhere. After Shutdown the error is
+// ErrShuttingDown. True only means the response was queued for the loop; the
+// loop may still ignore it, for example if the driver's offer has closed.
+func (s *MatchingService) SubmitDriverResponse(driverID, rideID string, accept bool) (bool, error) {
+	return s.submit(DriverResponse{
 		DriverID: driverID,
 		RideID:   rideID,
 		Accept:   accept,
+	})
+}
+
+// submit sends resp to the router, unless no matching loop is waiting for
+// the ride or Shutdown has closed the channel; sending on a closed channel
+// would panic, and no match is left to receive it by then anyway. The send
+// itself doesn't wait for the loop: driverResponses is buffered, and the
+// router hands each response on to its ride's own buffered channel.
+func (s *MatchingService) submit(resp DriverResponse) (bool, error) {
+	s.pendingMu.RLock()
+	_, waiting := s.pendingMatches[resp.RideID]
+	resp.Seq = s.outstandingOffers[resp.RideID][resp.DriverID]
+	s.pendingMu.RUnlock()
+
+	s.submitMu.RLock()
+	defer s.submitMu.RUnlock()
+	if s.responsesClosed {
+		log.Printf("[MATCHING] Dropping response from driver %s to ride %s: shut down", resp.DriverID, resp.RideID)
+		return false, ErrShuttingDown
+	}
+	if !waiting {
+		log.Printf("[MATCHING] Dropping response from driver %s to ride %s: not being matched", resp.DriverID, resp.RideID)
+		return false, nil
+	}
+	s.driverResponses <- resp
+	return true, nil
+}
+
+// openOffer numbers a new offer of rideID to driverID and records it as
+// outstanding, so responses submitted from now on are stamped with it.
+func (s *MatchingService) openOffer(rideID, driverID string) uint64 {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	s.offerSeq++
+	offers, exists := s.outstandingOffers[rideID]
+	if !exists {
+		offers = make(map[string]uint64)
+		s.outstandingOffers[rideID] = offers
+	}
+	offers[driverID] = s.offerSeq
+	return s.offerSeq
+}
+
+// closeOffer marks the offer of rideID to driverID as no longer open.
+// Responses submitted after it carry Seq 0 and are ignored.
+func (s *MatchingService) closeOffer(rideID, driverID string) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	delete(s.outstandingOffers[rideID], driverID)
+	if len(s.outstandingOffers[rideID]) == 0 {
+		delete(s.outstandingOffers, rideID)
 	}
 }
@@ -2,12 +2,21 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 	"uber/internal/config"
 	"uber/internal/domain/entities"
 	"uber/internal/geo"
 	"uber/internal/repository/memory"
+	"uber/internal/requestid"
 )
 
 func setupMatchingService() (*MatchingService, *RideService, *LocationService, *memory.DriverRepository) {
@@ -16,6 +25,7 @@ func setupMatchingService() (*MatchingService, *RideService, *LocationService, *
 	cfg.Matching.TotalMatchingTimeout = 5 * time.Second
 
 	rideRepo := memory.NewRideRepository()
+	promoRepo := memory.NewPromoCodeRepository()
 	riderRepo := memory.NewRiderRepository()
 	driverRepo := memory.NewDriverRepository()
 	locationRepo := memory.NewLocationRepository()
@@ -24,7 +34,8 @@ func setupMatchingService() (*MatchingService, *RideService, *LocationService, *
 
 	notificationService := NewNotificationService()
 	locationService := NewLocationService(spatialIndex, driverRepo, locationRepo)
-	rideService := NewRideService(rideRepo, riderRepo, driverRepo, cfg)
+	demandTracker := NewDemandTracker(spatialIndex, cfg.Geo.GeohashPrecision)
+	rideService := NewRideService(rideRepo, riderRepo, driverRepo, promoRepo, locationService, demandTracker, cfg)
 	matchingService := NewMatchingService(
 		cfg,
 		rideService,
@@ -193,6 +204,53 @@ func TestMatchingService_StartMatching_SecondDriverAccepts(t *testing.T) {
 	}
 }
 
+func TestMatchingService_StopsAfterMaxDriversContacted(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.MaxDriversContacted = 3
+	ctx := context.Background()
+
+	// Five drivers, nearest first, all of whom would decline.
+	for i := 1; i <= 5; i++ {
+		driverID := fmt.Sprintf("driver-%d", i)
+		driverRepo.GetOrCreate(ctx, driverID)
+		locationService.UpdateDriverLocation(ctx, driverID, 37.77+0.001*float64(i), -122.41)
+	}
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+
+	for i := 1; i <= 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		matchingService.SubmitDriverResponse(fmt.Sprintf("driver-%d", i), ride.ID, false)
+	}
+
+	result := <-resultChan
+	if result.Success || result.Error != ErrMaxDriversContacted {
+		t.Fatalf("Expected ErrMaxDriversContacted, got %+v", result)
+	}
+
+	for i := 1; i <= 5; i++ {
+		driverID := fmt.Sprintf("driver-%d", i)
+		want := 0
+		if i <= 3 {
+			want = 1
+		}
+		if offers := matchingService.DriverReliability(driverID).Offers; offers != want {
+			t.Errorf("%s: expected %d offers, got %d", driverID, want, offers)
+		}
+	}
+
+	failed, _ := rideService.GetRide(ctx, ride.ID)
+	if failed.Status != entities.RideStatusFailed {
+		t.Errorf("Expected ride to fail, got %s", failed.Status)
+	}
+}
+
 func TestMatchingService_DriverTimeout(t *testing.T) {
 	matchingService, rideService, locationService, driverRepo := setupMatchingService()
 	ctx := context.Background()
@@ -226,3 +284,1475 @@ func TestMatchingService_DriverTimeout(t *testing.T) {
 		t.Error("Expected matching to fail when driver times out")
 	}
 }
+
+func TestMatchingService_UnacknowledgedOfferSkippedPromptly(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.OfferAckTimeout = 300 * time.Millisecond
+	matchingService.config.Matching.DriverResponseTimeout = 5 * time.Second
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411) // Closest, never acks
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.775, -122.415)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	start := time.Now()
+	resultChan := matchingService.StartMatching(ctx, ride)
+
+	// Driver-1 never acknowledges. Driver-2 should be offered once the short
+	// ack window expires (~300ms), well before driver-1's 5s decision window
+	// would, and must itself ack within its own window (by ~600ms).
+	time.Sleep(450 * time.Millisecond)
+	matchingService.AcknowledgeOffer("driver-2", ride.ID)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, true)
+
+	result := <-resultChan
+	elapsed := time.Since(start)
+
+	if !result.Success || result.DriverID != "driver-2" {
+		t.Errorf("Expected driver-2 to be matched, got %+v", result)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("Expected unacknowledged driver to be skipped promptly, took %v", elapsed)
+	}
+}
+
+func TestMatchingService_AcknowledgedOfferGetsDecisionWindow(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.OfferAckTimeout = 200 * time.Millisecond
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+
+	time.Sleep(50 * time.Millisecond)
+	if open, err := matchingService.AcknowledgeOffer("driver-1", ride.ID); !open || err != nil {
+		t.Fatalf("Expected driver-1's offer to be open, got %v (%v)", open, err)
+	}
+	// driver-2 was never offered the ride.
+	if open, err := matchingService.AcknowledgeOffer("driver-2", ride.ID); open || err != nil {
+		t.Errorf("Expected no open offer for driver-2, got %v (%v)", open, err)
+	}
+
+	// Respond after the ack window has passed but inside the decision window.
+	time.Sleep(400 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-1" {
+		t.Errorf("Expected driver-1 to be matched after acknowledging, got %+v", result)
+	}
+}
+
+func TestMatchingService_DeclineDoesNotCountAsTimeout(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.DriverResponseTimeout = 300 * time.Millisecond
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411) // Declines
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.775, -122.415) // Never responds
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+
+	result := <-resultChan
+	if result.Success {
+		t.Fatal("Expected matching to fail when no driver accepts")
+	}
+
+	declined := matchingService.DriverReliability("driver-1")
+	if declined.Declines != 1 || declined.Timeouts != 0 {
+		t.Errorf("Expected 1 decline and 0 timeouts for driver-1, got %+v", declined)
+	}
+
+	timedOut := matchingService.DriverReliability("driver-2")
+	if timedOut.Timeouts != 1 || timedOut.Declines != 0 {
+		t.Errorf("Expected 1 timeout and 0 declines for driver-2, got %+v", timedOut)
+	}
+	if timedOut.Offers != 1 || timedOut.AcceptanceRate() != 0 {
+		t.Errorf("Expected 1 offer and 0 acceptance rate for driver-2, got %+v", timedOut)
+	}
+}
+
+func TestMatchingService_DeclineCountsAsTimeoutWhenConfigured(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.DeclineCountsAsTimeout = true
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+	<-resultChan
+
+	stats := matchingService.DriverReliability("driver-1")
+	if stats.Timeouts != 1 || stats.Declines != 0 {
+		t.Errorf("Expected decline to be recorded as a timeout, got %+v", stats)
+	}
+}
+
+func TestMatchingService_PickupMovedMidMatchRequeries(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	// driver-near-old sits by the original pickup; driver-near-new is ~3.3 km
+	// north, outside the geohash cells searched around the original point.
+	driverRepo.GetOrCreate(ctx, "driver-near-old")
+	driverRepo.GetOrCreate(ctx, "driver-near-new")
+	locationService.UpdateDriverLocation(ctx, "driver-near-old", 37.771, -122.411)
+	locationService.UpdateDriverLocation(ctx, "driver-near-new", 37.8001, -122.4101)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.82, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+
+	// The rider walks north while driver-near-old is considering the offer.
+	newPickup := entities.Location{Latitude: 37.80, Longitude: -122.41}
+	if _, err := rideService.UpdatePickupLocation(ctx, "rider-1", ride.ID, newPickup); err != nil {
+		t.Fatalf("UpdatePickupLocation failed: %v", err)
+	}
+	if !matchingService.UpdatePickup(ride.ID, newPickup) {
+		t.Fatal("Expected UpdatePickup to reach the in-flight matching loop")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The outstanding offer is declined; the next candidate must come from
+	// the re-query around the new point.
+	matchingService.SubmitDriverResponse("driver-near-old", ride.ID, false)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-near-new", ride.ID, true)
+
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-near-new" {
+		t.Errorf("Expected driver-near-new to be matched after pickup moved, got %+v", result)
+	}
+	if offers := matchingService.DriverReliability("driver-near-old").Offers; offers != 1 {
+		t.Errorf("Expected driver-near-old to be offered once, got %d", offers)
+	}
+}
+
+func TestMatchingService_UpdatePickupWithoutMatching(t *testing.T) {
+	matchingService, _, _, _ := setupMatchingService()
+
+	if matchingService.UpdatePickup("ride-unknown", entities.Location{Latitude: 37.77, Longitude: -122.41}) {
+		t.Error("Expected UpdatePickup to report false for a ride not being matched")
+	}
+}
+
+func TestMatchingService_DriverCancelsAndRideIsReassigned(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411) // Closest
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.775, -122.415)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+	if result := <-resultChan; !result.Success || result.DriverID != "driver-1" {
+		t.Fatalf("Expected driver-1 to be matched first, got %+v", result)
+	}
+
+	// driver-1 cancels before pickup: the ride goes back to matching.
+	reassigned, err := rideService.UpdateRideStatus(ctx, "driver-1", ride.ID, entities.RideStatusCancelled)
+	if err != nil {
+		t.Fatalf("UpdateRideStatus(cancelled) failed: %v", err)
+	}
+	if reassigned.Status != entities.RideStatusMatching || reassigned.DriverID != "" {
+		t.Fatalf("Expected ride back in matching with no driver, got %s / %q", reassigned.Status, reassigned.DriverID)
+	}
+	if driver, _ := driverRepo.GetByID(ctx, "driver-1"); !driver.IsAvailable() {
+		t.Error("Expected cancelling driver to be available again")
+	}
+
+	resultChan = matchingService.RestartMatching(ctx, reassigned, "driver-1")
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, true)
+
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-2" {
+		t.Errorf("Expected driver-2 to be matched after reassignment, got %+v", result)
+	}
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 1 {
+		t.Errorf("Expected cancelling driver not to be re-offered the ride, got %d offers", offers)
+	}
+
+	final, _ := rideService.GetRide(ctx, ride.ID)
+	if final.Status != entities.RideStatusAccepted || final.DriverID != "driver-2" {
+		t.Errorf("Expected ride accepted by driver-2, got %s / %q", final.Status, final.DriverID)
+	}
+}
+
+func TestMatchingService_RiderCancelsAsDriverAccepts(t *testing.T) {
+	// Whichever of the two lands first, the ride must end cancelled with the
+	// driver free. Repeat so both orderings get a chance to happen.
+	for i := 0; i < 5; i++ {
+		matchingService, rideService, locationService, driverRepo := setupMatchingService()
+		ctx := context.Background()
+
+		driverRepo.GetOrCreate(ctx, "driver-1")
+		locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+		estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+			Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+			Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+		})
+		ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+		resultChan := matchingService.StartMatching(ctx, ride)
+		time.Sleep(100 * time.Millisecond)
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+		}()
+		close(start)
+		if _, err := matchingService.CancelRide(ctx, "rider-1", ride.ID); err != nil {
+			t.Fatalf("CancelRide failed: %v", err)
+		}
+		wg.Wait()
+
+		select {
+		case <-resultChan:
+		case <-time.After(time.Second):
+			t.Fatal("Expected matching to stop promptly after the cancellation")
+		}
+
+		stored, _ := rideService.GetRide(ctx, ride.ID)
+		if stored.Status != entities.RideStatusCancelled {
+			t.Errorf("Expected ride cancelled, got %s", stored.Status)
+		}
+		if driver, _ := driverRepo.GetByID(ctx, "driver-1"); !driver.IsAvailable() {
+			t.Error("Expected driver-1 to be available again")
+		}
+		if locked, _ := matchingService.lockManager.IsLocked(ctx, "driver:driver-1"); locked {
+			t.Error("Expected the driver lock to be released")
+		}
+	}
+}
+
+func TestMatchingService_RiderCancelStopsOutstandingOffer(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := matchingService.CancelRide(ctx, "rider-1", ride.ID); err != nil {
+		t.Fatalf("CancelRide failed: %v", err)
+	}
+
+	// The offer's ack window is seconds long; the loop must not wait it out.
+	select {
+	case result := <-resultChan:
+		if result.Success || !errors.Is(result.Error, ErrMatchCancelled) {
+			t.Errorf("Expected ErrMatchCancelled, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to stop promptly after the cancellation")
+	}
+	if locked, _ := matchingService.lockManager.IsLocked(ctx, "driver:driver-1"); locked {
+		t.Error("Expected the driver lock to be released")
+	}
+
+	// A late accept finds nothing to accept.
+	if _, err := rideService.AcceptRide(ctx, "driver-1", ride.ID, true); err != ErrInvalidTransition {
+		t.Errorf("Expected ErrInvalidTransition for a late accept, got %v", err)
+	}
+}
+
+func TestMatchingService_CallerCancelReleasesDriverLock(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	lockManager := matchingService.lockManager
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	matchCtx, cancel := context.WithCancel(ctx)
+	resultChan := matchingService.StartMatching(matchCtx, ride)
+	time.Sleep(100 * time.Millisecond)
+
+	if locked, _ := lockManager.IsLocked(ctx, "driver:driver-1"); !locked {
+		t.Fatal("Expected driver-1 to be locked while their offer is outstanding")
+	}
+	cancel()
+
+	select {
+	case result := <-resultChan:
+		if result.Success || !errors.Is(result.Error, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to stop promptly after the cancellation")
+	}
+	if locked, _ := lockManager.IsLocked(ctx, "driver:driver-1"); locked {
+		t.Error("Expected the driver lock to be released as soon as matching stopped")
+	}
+}
+
+func TestMatchingService_PreferredZoneFiltersOffers(t *testing.T) {
+	tests := []struct {
+		name           string
+		destination    entities.Location
+		expectedDriver string
+	}{
+		{"Southbound trip skips northern-zone driver", entities.Location{Latitude: 37.74, Longitude: -122.40}, "driver-2"},
+		{"Northbound trip offered to northern-zone driver", entities.Location{Latitude: 37.85, Longitude: -122.40}, "driver-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchingService, rideService, locationService, driverRepo := setupMatchingService()
+			ctx := context.Background()
+
+			// driver-1 is closest but only wants rides ending in the 9q8z cell
+			// (north of the pickup); driver-2 has no preference.
+			driver1, _ := driverRepo.GetOrCreate(ctx, "driver-1")
+			driver1.PreferredZone = &entities.DestinationZone{GeohashPrefixes: []string{"9q8z"}}
+			driverRepo.GetOrCreate(ctx, "driver-2")
+			locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+			locationService.UpdateDriverLocation(ctx, "driver-2", 37.775, -122.415)
+
+			estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+				Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+				Destination: tt.destination,
+			})
+			ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+			resultChan := matchingService.StartMatching(ctx, ride)
+			time.Sleep(100 * time.Millisecond)
+			matchingService.SubmitDriverResponse(tt.expectedDriver, ride.ID, true)
+
+			result := <-resultChan
+			if !result.Success {
+				t.Fatalf("Expected matching to succeed, got %+v", result)
+			}
+			if result.DriverID != tt.expectedDriver {
+				t.Errorf("Expected %s, got %s", tt.expectedDriver, result.DriverID)
+			}
+
+			// The skipped driver must never have been offered the ride.
+			if tt.expectedDriver == "driver-2" {
+				if offers := matchingService.DriverReliability("driver-1").Offers; offers != 0 {
+					t.Errorf("Expected driver-1 to receive no offers, got %d", offers)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchingService_OffersOnlyToDriversOfTheRideTier(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	// driver-1 is closest but drives an economy car (no tier set); driver-2
+	// drives an XL.
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	driver2, _ := driverRepo.GetOrCreate(ctx, "driver-2")
+	driver2.VehicleTier = entities.VehicleTierXL
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.775, -122.415)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+		VehicleTier: entities.VehicleTierXL,
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, true)
+
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-2" {
+		t.Fatalf("Expected the XL driver to be matched, got %+v", result)
+	}
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 0 {
+		t.Errorf("Expected the economy driver to receive no offers for an XL ride, got %d", offers)
+	}
+}
+
+func TestMatchingService_PanicFailsRideAndServiceSurvives(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.Matching.DriverResponseTimeout = 2 * time.Second
+	cfg.Matching.TotalMatchingTimeout = 5 * time.Second
+
+	driverRepo := memory.NewDriverRepository()
+	spatialIndex := geo.NewSpatialIndex(cfg.Geo.GeohashPrecision)
+	locationService := NewLocationService(spatialIndex, driverRepo, memory.NewLocationRepository())
+	demandTracker := NewDemandTracker(spatialIndex, cfg.Geo.GeohashPrecision)
+	rideService := NewRideService(memory.NewRideRepository(), memory.NewRiderRepository(), driverRepo, memory.NewPromoCodeRepository(), locationService, demandTracker, cfg)
+
+	// A nil LocationService makes the driver search panic inside the
+	// matching goroutine.
+	matchingService := NewMatchingService(cfg, rideService, nil, NewNotificationService(), memory.NewLockManager(), driverRepo)
+	ctx := context.Background()
+
+	for _, riderID := range []string{"rider-1", "rider-2"} {
+		estimate, _ := rideService.CreateFareEstimate(ctx, riderID, FareEstimateRequest{
+			Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+			Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+		})
+		ride, _ := rideService.RequestRide(ctx, riderID, estimate.RideID)
+
+		select {
+		case result := <-matchingService.StartMatching(ctx, ride):
+			if result.Success || !errors.Is(result.Error, ErrMatchingPanicked) {
+				t.Errorf("%s: expected ErrMatchingPanicked, got %+v", riderID, result)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: matching did not finish after panicking", riderID)
+		}
+
+		stored, _ := rideService.GetRide(ctx, ride.ID)
+		if stored.Status != entities.RideStatusFailed {
+			t.Errorf("%s: expected ride to end Failed, got %s", riderID, stored.Status)
+		}
+	}
+
+	// The response router is still running: a response for an unknown ride is
+	// accepted without blocking.
+	done := make(chan struct{})
+	go func() {
+		matchingService.SubmitDriverResponse("driver-1", "no-such-ride", true)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected driver responses to still be accepted after a panic")
+	}
+}
+
+func TestMatchingService_PremiumSearchesWiderThanStandard(t *testing.T) {
+	tests := []struct {
+		category      entities.RideCategory
+		expectedMatch bool
+	}{
+		{entities.RideCategoryStandard, false},
+		{entities.RideCategoryPremium, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.category), func(t *testing.T) {
+			matchingService, rideService, locationService, driverRepo := setupMatchingService()
+			ctx := context.Background()
+
+			// Radii small enough that the driver stays within the spatial
+			// index's neighbor cells either way.
+			matchingService.config.Matching.SearchRadiusKm = 0.5
+			matchingService.config.MatchingByCategory["premium"] = config.MatchingConfig{SearchRadiusKm: 1.0}
+
+			// ~0.7 km east of the pickup: outside the standard radius but
+			// inside premium's.
+			driverRepo.GetOrCreate(ctx, "driver-1")
+			locationService.UpdateDriverLocation(ctx, "driver-1", 37.77, -122.402)
+
+			estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+				Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+				Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+				Category:    tt.category,
+			})
+			ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+			if ride.Category != tt.category {
+				t.Fatalf("Expected ride category %s, got %s", tt.category, ride.Category)
+			}
+
+			resultChan := matchingService.StartMatching(ctx, ride)
+			time.Sleep(100 * time.Millisecond)
+			matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+
+			result := <-resultChan
+			if result.Success != tt.expectedMatch {
+				t.Errorf("Expected success=%v, got %+v", tt.expectedMatch, result)
+			}
+		})
+	}
+}
+
+func TestMatchingService_PrecheckFailsFastWithoutDrivers(t *testing.T) {
+	tests := []struct {
+		name      string
+		precheck  bool
+		immediate bool
+	}{
+		{"Pre-check fails before matching starts", true, true},
+		{"Without pre-check the loop finds no drivers", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchingService, rideService, _, _ := setupMatchingService()
+			matchingService.config.Matching.PrecheckDrivers = tt.precheck
+			ctx := context.Background()
+
+			estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+				Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+				Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+			})
+			ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+			resultChan := matchingService.StartMatching(ctx, ride)
+
+			// The pre-check decides synchronously, so its result is already
+			// waiting when StartMatching returns.
+			var result MatchingResult
+			if tt.immediate {
+				select {
+				case result = <-resultChan:
+				default:
+					t.Fatal("Expected the pre-check result to be ready immediately")
+				}
+			} else {
+				result = <-resultChan
+			}
+
+			if result.Success || result.Error != ErrNoDriversNearby {
+				t.Errorf("Expected ErrNoDriversNearby, got %+v", result)
+			}
+			stored, _ := rideService.GetRide(ctx, ride.ID)
+			if stored.Status != entities.RideStatusFailed {
+				t.Errorf("Expected ride to end Failed, got %s", stored.Status)
+			}
+		})
+	}
+}
+
+func TestMatchingService_PrecheckPassesWithDriverPresent(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.PrecheckDrivers = true
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+
+	// The full loop ran and made an offer; the decline still ends in Failed.
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 1 {
+		t.Fatalf("Expected the driver to be offered the ride, got %d offers", offers)
+	}
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+
+	result := <-resultChan
+	if result.Success {
+		t.Error("Expected matching to fail after the only driver declined")
+	}
+	stored, _ := rideService.GetRide(ctx, ride.ID)
+	if stored.Status != entities.RideStatusFailed {
+		t.Errorf("Expected ride to end Failed, got %s", stored.Status)
+	}
+}
+
+func TestMatchingService_ConcurrentAcceptsOnlyOneWins(t *testing.T) {
+	matchingService, rideService, _, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+	if err := rideService.StartMatching(ctx, ride); err != nil {
+		t.Fatalf("StartMatching failed: %v", err)
+	}
+
+	const contenders = 10
+	driverIDs := make([]string, contenders)
+	for i := range driverIDs {
+		driverIDs[i] = fmt.Sprintf("driver-%d", i)
+		driverRepo.GetOrCreate(ctx, driverIDs[i])
+	}
+
+	// Each contender holds its own driver lock, as the matching loop would,
+	// and all release their accepts at once.
+	start := make(chan struct{})
+	errs := make(chan error, contenders)
+	var wg sync.WaitGroup
+	for _, driverID := range driverIDs {
+		wg.Add(1)
+		go func(driverID string) {
+			defer wg.Done()
+			driverLock := "driver:" + driverID
+			matchingService.lockManager.AcquireLock(ctx, driverLock, time.Second)
+			defer matchingService.lockManager.ReleaseLock(ctx, driverLock)
+
+			<-start
+			errs <- matchingService.acceptOffer(ctx, driverID, ride.ID)
+		}(driverID)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	wins := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			wins++
+		case ErrRideLockHeld, ErrInvalidTransition:
+		default:
+			t.Errorf("Unexpected error from losing accept: %v", err)
+		}
+	}
+	if wins != 1 {
+		t.Fatalf("Expected exactly one accept to win, got %d", wins)
+	}
+
+	stored, _ := rideService.GetRide(ctx, ride.ID)
+	if stored.Status != entities.RideStatusAccepted || stored.DriverID == "" {
+		t.Errorf("Expected ride accepted by one driver, got status %s driver %q", stored.Status, stored.DriverID)
+	}
+	if locked, _ := matchingService.lockManager.IsLocked(ctx, "ride:"+ride.ID); locked {
+		t.Error("Expected the ride lock to be released after accepting")
+	}
+}
+
+func TestMatchingService_StaleLocationRankedBelowFresh(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	// driver-1 is slightly closer, but last pinged 90 seconds ago — a minute
+	// past StaleLocationAfter, so ranked about 0.5 km farther out.
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	stale, _, _ := locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	stale.UpdatedAt = time.Now().Add(-90 * time.Second)
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.772, -122.412)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+
+	// Only the driver offered first can accept; driver-2 should be first.
+	time.Sleep(100 * time.Millisecond)
+	matchingService.AcknowledgeOffer("driver-2", ride.ID)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, true)
+
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-2" {
+		t.Errorf("Expected fresh driver-2 to be offered first, got %+v", result)
+	}
+}
+
+func TestMatchingService_LocationFreshnessRules(t *testing.T) {
+	matchingService, _, _, _ := setupMatchingService()
+
+	aged := func(driverID string, distanceKm float64, age time.Duration) geo.DriverWithDistance {
+		loc := entities.NewDriverLocation(driverID, 37.77, -122.41, "9q8yyk")
+		loc.UpdatedAt = time.Now().Add(-age)
+		return geo.DriverWithDistance{Driver: loc, Distance: distanceKm}
+	}
+
+	candidates := []geo.DriverWithDistance{
+		aged("driver-gone", 0.5, 10*time.Minute),
+		aged("driver-stale", 1.0, 2*time.Minute),
+		aged("driver-fresh", 1.2, 5*time.Second),
+	}
+
+	candidates = matchingService.dropStaleLocations(context.Background(), candidates)
+	if len(candidates) != 2 {
+		t.Fatalf("Expected the 10-minute-old location to be dropped, got %d candidates", len(candidates))
+	}
+
+	matchingService.rankCandidates(candidates)
+	if candidates[0].Driver.DriverID != "driver-fresh" {
+		t.Errorf("Expected fresh driver first, got %s", candidates[0].Driver.DriverID)
+	}
+
+	// With the penalty disabled, plain distance order returns.
+	matchingService.config.Matching.StaleLocationAfter = 0
+	matchingService.rankCandidates(candidates)
+	if candidates[0].Driver.DriverID != "driver-stale" {
+		t.Errorf("Expected nearest-first with staleness penalty off, got %s", candidates[0].Driver.DriverID)
+	}
+}
+
+func TestMatchingService_OfferIncludesPickupDemand(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	rideService.SetSurgeFunc(NewSurgeService(rideService.demand, rideService.calculator.SurgePriceMax).MultiplierAt)
+	ctx := context.Background()
+
+	// One driver and two waiting riders in the same cell: demand/supply = 2.
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.7701, -122.4101)
+	var ride *entities.Ride
+	for _, riderID := range []string{"rider-1", "rider-2"} {
+		estimate, _ := rideService.CreateFareEstimate(ctx, riderID, FareEstimateRequest{
+			Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+			Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+		})
+		ride, _ = rideService.RequestRide(ctx, riderID, estimate.RideID)
+	}
+
+	if demand := matchingService.offerDemand(ctx, ride.Source); demand != nil {
+		t.Errorf("Expected no demand context while OfferDemandContext is off, got %+v", demand)
+	}
+
+	matchingService.config.Matching.OfferDemandContext = true
+	demand := matchingService.offerDemand(ctx, ride.Source)
+	if demand == nil {
+		t.Fatal("Expected a demand context in the offer")
+	}
+	if demand.Cell != rideService.demand.CellFor(ride.Source) {
+		t.Errorf("Expected the pickup cell %s, got %s", rideService.demand.CellFor(ride.Source), demand.Cell)
+	}
+	if demand.SurgeMultiplier != 2.0 || demand.PendingRequests != 2 {
+		t.Errorf("Expected surge 2.0 and 2 pending requests, got %+v", demand)
+	}
+}
+
+func TestMatchingService_ExpandsRadiusWhenNoDriverInRange(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	// As in the premium test, radii small enough that the driver stays
+	// within the spatial index's neighbor cells.
+	matchingService.config.Matching.SearchRadiusKm = 0.5
+	matchingService.config.Matching.RadiusExpansionFactor = 2
+	matchingService.config.Matching.MaxSearchRadiusKm = 1.0
+
+	// ~0.7 km east of the pickup: just outside the initial radius.
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.77, -122.402)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-1" {
+		t.Fatalf("Expected driver-1 to be reached after one expansion, got %+v", result)
+	}
+}
+
+func TestMatchingService_ExpansionSkipsDriversAlreadyTried(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	matchingService.config.Matching.SearchRadiusKm = 0.5
+	matchingService.config.Matching.RadiusExpansionFactor = 2
+	matchingService.config.Matching.MaxSearchRadiusKm = 1.0
+
+	// driver-1 is in range and declines; driver-2 is only reached by the
+	// wider search, which must not offer the ride to driver-1 again.
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.41)
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.77, -122.402)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, true)
+
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-2" {
+		t.Fatalf("Expected driver-2 to accept after the search widened, got %+v", result)
+	}
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 1 {
+		t.Errorf("Expected driver-1 to be offered the ride once, got %d offers", offers)
+	}
+}
+
+func TestMatchingService_TotalTimeoutBoundsDriverWindow(t *testing.T) {
+	for _, strategy := range []string{config.MatchingStrategySequential, config.MatchingStrategyBroadcast} {
+		t.Run(strategy, func(t *testing.T) {
+			matchingService, rideService, locationService, driverRepo := setupMatchingService()
+			matchingService.config.Matching.MatchingStrategy = strategy
+			matchingService.config.Matching.TotalMatchingTimeout = time.Second
+			matchingService.config.Matching.DriverResponseTimeout = 5 * time.Second
+			matchingService.config.Matching.OfferAckTimeout = 0
+			ctx := context.Background()
+
+			driverRepo.GetOrCreate(ctx, "driver-1")
+			locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+			estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+				Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+				Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+			})
+			ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+			start := time.Now()
+			select {
+			case result := <-matchingService.StartMatching(ctx, ride):
+				if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 1500*time.Millisecond {
+					t.Errorf("Expected matching to end at the 1s total timeout, took %v", elapsed)
+				}
+				if !errors.Is(result.Error, ErrMatchingTimeout) {
+					t.Errorf("Expected ErrMatchingTimeout, got %+v", result)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("Expected the 1s total timeout to cut the 5s driver window short")
+			}
+
+			// The driver's window was cut short by the match, not missed.
+			if timeouts := matchingService.DriverReliability("driver-1").Timeouts; timeouts != 0 {
+				t.Errorf("Expected no driver timeout to be recorded, got %d", timeouts)
+			}
+		})
+	}
+}
+
+func TestMatchingService_ReoffersEarlierDecliner(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	matchingService.config.Matching.ReofferDecliners = true
+	matchingService.config.Matching.ReofferCooldown = 300 * time.Millisecond
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.41)
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.772, -122.41)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, false)
+
+	// Everyone has declined, but driver-1's cooldown has not passed yet.
+	time.Sleep(50 * time.Millisecond)
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 1 {
+		t.Fatalf("Expected driver-1 not to be re-offered within the cooldown, got %d offers", offers)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 2 {
+		t.Fatalf("Expected driver-1 to get a second offer after the cooldown, got %d offers", offers)
+	}
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+
+	select {
+	case result := <-resultChan:
+		if !result.Success || result.DriverID != "driver-1" {
+			t.Fatalf("Expected driver-1 to take the re-offered ride, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to finish after driver-1 accepted the re-offer")
+	}
+	if offers := matchingService.DriverReliability("driver-2").Offers; offers != 1 {
+		t.Errorf("Expected driver-2 not to be re-offered once driver-1 accepted, got %d offers", offers)
+	}
+}
+
+func TestMatchingService_ReofferIsOneSecondChance(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	matchingService.config.Matching.ReofferDecliners = true
+	matchingService.config.Matching.ReofferCooldown = 100 * time.Millisecond
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.41)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(50 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+	time.Sleep(200 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+
+	select {
+	case result := <-resultChan:
+		if result.Success {
+			t.Fatalf("Expected no match after driver-1 declined twice, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to end after the second decline")
+	}
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 2 {
+		t.Errorf("Expected exactly two offers to driver-1, got %d", offers)
+	}
+}
+
+// duplicatingStrategy hands SequentialStrategy every candidate twice.
+type duplicatingStrategy struct{}
+
+func (duplicatingStrategy) SelectAndOffer(ctx context.Context, ride *entities.Ride, candidates []geo.DriverWithDistance, offer func(driverID string) bool) (string, bool) {
+	return SequentialStrategy{}.SelectAndOffer(ctx, ride, append(candidates, candidates...), offer)
+}
+
+func TestMatchingService_DuplicateCandidateOfferedOnce(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.SetStrategy(duplicatingStrategy{})
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+
+	select {
+	case result := <-resultChan:
+		if result.Success {
+			t.Fatalf("Expected no match after the only driver declined, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to end without re-offering the declined driver")
+	}
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 1 {
+		t.Errorf("Expected driver-1 to be offered the ride once, got %d offers", offers)
+	}
+}
+
+func TestMatchingService_ResponseFromAnotherDriverIgnored(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.772, -122.412)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+
+	// driver-2 has not been offered the ride yet; their decline must not end
+	// driver-1's offer.
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, false)
+	time.Sleep(50 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-1" {
+		t.Fatalf("Expected driver-1 to keep their offer and accept, got %+v", result)
+	}
+	if offers := matchingService.DriverReliability("driver-2").Offers; offers != 0 {
+		t.Errorf("Expected driver-2 never to be offered the ride, got %d offers", offers)
+	}
+}
+
+func TestMatchingService_LateAcceptAfterTimeoutIgnored(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	matchingService.config.Matching.OfferAckTimeout = 0
+	matchingService.config.Matching.DriverResponseTimeout = 200 * time.Millisecond
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+	driverRepo.GetOrCreate(ctx, "driver-2")
+	locationService.UpdateDriverLocation(ctx, "driver-2", 37.772, -122.412)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+
+	// driver-1's window closes at 200ms and driver-2 is offered the ride;
+	// driver-1's accept only arrives after that.
+	time.Sleep(250 * time.Millisecond)
+	if offers := matchingService.DriverReliability("driver-2").Offers; offers != 1 {
+		t.Fatalf("Expected driver-2 to be offered the ride after driver-1 timed out, got %d offers", offers)
+	}
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+
+	select {
+	case result := <-resultChan:
+		t.Fatalf("Expected driver-1's late accept to be ignored, got %+v", result)
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, true)
+	result := <-resultChan
+	if !result.Success || result.DriverID != "driver-2" {
+		t.Fatalf("Expected driver-2 to be matched, got %+v", result)
+	}
+}
+
+func TestMatchingService_StaleAcceptNotTakenForReoffer(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	matchingService.config.Matching.ReofferDecliners = true
+	matchingService.config.Matching.ReofferCooldown = 300 * time.Millisecond
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.41)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(50 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+
+	// An accept sent while driver-1 has no open offer (a double tap, say)
+	// waits in the response channel through the cooldown. It must not be
+	// read as the answer to the re-offer that follows.
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+
+	time.Sleep(350 * time.Millisecond)
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 2 {
+		t.Fatalf("Expected driver-1 to be re-offered the ride, got %d offers", offers)
+	}
+	select {
+	case result := <-resultChan:
+		t.Fatalf("Expected the stale accept to be ignored, got %+v", result)
+	default:
+	}
+
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+	select {
+	case result := <-resultChan:
+		if result.Success {
+			t.Fatalf("Expected no match after driver-1 declined the re-offer, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to end after driver-1 declined the re-offer")
+	}
+}
+
+// logBuffer collects log output. Notifications are only logged, so tests
+// that need to know whether one was sent read them back from here.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *logBuffer) count(substr string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Count(l.buf.String(), substr)
+}
+
+// lines returns the logged lines containing substr.
+func (l *logBuffer) lines(substr string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []string
+	for _, line := range strings.Split(l.buf.String(), "\n") {
+		if strings.Contains(line, substr) {
+			found = append(found, line)
+		}
+	}
+	return found
+}
+
+// captureLogs sends log output to a logBuffer for the rest of the test.
+func captureLogs(t *testing.T) *logBuffer {
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return logs
+}
+
+func TestMatchingService_BroadcastLogsCarryRequestID(t *testing.T) {
+	logs := captureLogs(t)
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.MatchingStrategy = config.MatchingStrategyBroadcast
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(requestid.NewContext(ctx, "req-broadcast"), ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, false)
+	select {
+	case <-resultChan:
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to end once the only driver declined")
+	}
+
+	// Search, broadcast, decline and giving up are all logged.
+	lines := logs.lines("[MATCHING]")
+	if len(lines) < 4 {
+		t.Fatalf("Expected the match to be logged, got %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "[request_id=req-broadcast]") {
+			t.Errorf("Expected every matching log line to carry the request ID, got %q", line)
+		}
+	}
+}
+
+func TestMatchingService_DriverToldOnceWhenRiderCancelsAsTheyAccept(t *testing.T) {
+	// Whether the cancel or the accept lands first, the driver ends up
+	// without the ride and must hear about it exactly once.
+	for i := 0; i < 5; i++ {
+		logs := captureLogs(t)
+		matchingService, rideService, locationService, driverRepo := setupMatchingService()
+		ctx := context.Background()
+
+		driverRepo.GetOrCreate(ctx, "driver-1")
+		locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+		estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+			Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+			Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+		})
+		ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+		resultChan := matchingService.StartMatching(ctx, ride)
+		time.Sleep(100 * time.Millisecond)
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+		}()
+		close(start)
+		if _, err := matchingService.CancelRide(ctx, "rider-1", ride.ID); err != nil {
+			t.Fatalf("CancelRide failed: %v", err)
+		}
+		wg.Wait()
+
+		select {
+		case <-resultChan:
+		case <-time.After(time.Second):
+			t.Fatal("Expected matching to stop promptly after the cancellation")
+		}
+
+		notice := fmt.Sprintf("Driver driver-1: Ride %s was cancelled by the rider", ride.ID)
+		if n := logs.count(notice); n != 1 {
+			t.Errorf("Expected driver-1 to be told of the cancellation once, got %d", n)
+		}
+	}
+}
+
+func TestMatchingService_PendingCount(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	if n := matchingService.PendingCount(); n != 0 {
+		t.Fatalf("Expected no matches in flight at start, got %d", n)
+	}
+
+	for _, driverID := range []string{"driver-1", "driver-2"} {
+		driverRepo.GetOrCreate(ctx, driverID)
+		locationService.UpdateDriverLocation(ctx, driverID, 37.771, -122.411)
+	}
+
+	var rides []*entities.Ride
+	var results []<-chan MatchingResult
+	for _, riderID := range []string{"rider-1", "rider-2"} {
+		estimate, _ := rideService.CreateFareEstimate(ctx, riderID, FareEstimateRequest{
+			Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+			Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+		})
+		ride, _ := rideService.RequestRide(ctx, riderID, estimate.RideID)
+		rides = append(rides, ride)
+		results = append(results, matchingService.StartMatching(ctx, ride))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if n := matchingService.PendingCount(); n != 2 {
+		t.Errorf("Expected 2 matches in flight, got %d", n)
+	}
+
+	// Each ride's offer went to whichever driver it locked first.
+	for _, ride := range rides {
+		for _, driverID := range []string{"driver-1", "driver-2"} {
+			matchingService.SubmitDriverResponse(driverID, ride.ID, true)
+		}
+	}
+	// The result channel closes once the loop has deregistered the ride.
+	for _, resultChan := range results {
+		for range resultChan {
+		}
+	}
+
+	if n := matchingService.PendingCount(); n != 0 {
+		t.Errorf("Expected no matches in flight after both finished, got %d", n)
+	}
+}
+
+// goroutinesSettleTo waits up to a second for the goroutine count to drop to
+// at most want, and returns the last count seen.
+func goroutinesSettleTo(want int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMatchingService_ShutdownDrainsInFlightMatches(t *testing.T) {
+	before := runtime.NumGoroutine()
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		shutdownErr <- matchingService.Shutdown(shutdownCtx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// New matches are turned away while the running one is drained.
+	estimate2, _ := rideService.CreateFareEstimate(ctx, "rider-2", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride2, _ := rideService.RequestRide(ctx, "rider-2", estimate2.RideID)
+	if result := <-matchingService.StartMatching(ctx, ride2); !errors.Is(result.Error, ErrShuttingDown) {
+		t.Errorf("Expected a new match to be refused with ErrShuttingDown, got %+v", result)
+	}
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Expected Shutdown to wait for the match in flight, returned %v", err)
+	default:
+	}
+
+	// The in-flight match still gets its driver's answer.
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+	if result := <-resultChan; !result.Success || result.DriverID != "driver-1" {
+		t.Fatalf("Expected the in-flight match to finish normally, got %+v", result)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Expected Shutdown to return nil once drained, got %v", err)
+	}
+
+	// Responses after shutdown are dropped, not sent on a closed channel.
+	matchingService.SubmitDriverResponse("driver-1", ride.ID, true)
+
+	matchingService.lockManager.Stop()
+	if after := goroutinesSettleTo(before); after > before {
+		t.Errorf("Expected no goroutines left behind: %d before, %d after shutdown", before, after)
+	}
+}
+
+func TestMatchingService_ShutdownDeadlineStopsMatches(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	defer matchingService.lockManager.Stop()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	locationService.UpdateDriverLocation(ctx, "driver-1", 37.771, -122.411)
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := matchingService.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Shutdown to report the missed deadline, got %v", err)
+	}
+
+	select {
+	case result := <-resultChan:
+		if result.Success || !errors.Is(result.Error, ErrShuttingDown) {
+			t.Errorf("Expected the match to stop with ErrShuttingDown, got %+v", result)
+		}
+	default:
+		t.Fatal("Expected the match to have stopped by the time Shutdown returned")
+	}
+	if locked, _ := matchingService.lockManager.IsLocked(ctx, "driver:driver-1"); locked {
+		t.Error("Expected the driver lock to be released")
+	}
+}
+
+// decliningStrategy queues a decline from each candidate just before the
+// ride is offered to them, so every offer is answered at once.
+type decliningStrategy struct {
+	matchingService *MatchingService
+}
+
+func (d decliningStrategy) SelectAndOffer(ctx context.Context, ride *entities.Ride, candidates []geo.DriverWithDistance, offer func(driverID string) bool) (string, bool) {
+	for _, dwd := range candidates {
+		d.matchingService.SubmitDriverResponse(dwd.Driver.DriverID, ride.ID, false)
+		if offer(dwd.Driver.DriverID) {
+			return dwd.Driver.DriverID, true
+		}
+	}
+	return "", false
+}
+
+// BenchmarkMatching_1000Declines matches a ride against 1000 drivers who
+// each decline immediately. Run with -benchmem: each offer's ack and
+// decision windows reuse the match's timer rather than allocating their own.
+func BenchmarkMatching_1000Declines(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.SetStrategy(decliningStrategy{matchingService})
+	matchingService.config.Matching.MaxDriversContacted = 0
+	ctx := context.Background()
+
+	for i := 0; i < 1000; i++ {
+		driverID := fmt.Sprintf("driver-%d", i)
+		driverRepo.GetOrCreate(ctx, driverID)
+		locationService.UpdateDriverLocation(ctx, driverID, 37.77+float64(i%40)*0.0005, -122.41+float64(i/40)*0.0005)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		riderID := fmt.Sprintf("rider-%d", i)
+		estimate, _ := rideService.CreateFareEstimate(ctx, riderID, FareEstimateRequest{
+			Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+			Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+		})
+		ride, _ := rideService.RequestRide(ctx, riderID, estimate.RideID)
+		b.StartTimer()
+
+		if result := <-matchingService.StartMatching(ctx, ride); result.Success {
+			b.Fatalf("Expected every driver to decline, got %+v", result)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"uber/internal/domain/entities"
+)
+
+// ErrReplayDiverged is the MatchingResult error of a replay whose matching
+// loop offered the ride to a different driver than the recording did, or ran
+// past the end of it. The replay environment no longer matches the original.
+var ErrReplayDiverged = errors.New("replay diverged from recorded session")
+
+// SessionEventKind names one step of a recorded matching session.
+type SessionEventKind string
+
+const (
+	SessionEventOffer      SessionEventKind = "offer"
+	SessionEventAck        SessionEventKind = "ack"
+	SessionEventAccept     SessionEventKind = "accept"
+	SessionEventDecline    SessionEventKind = "decline"
+	SessionEventAckTimeout SessionEventKind = "ack_timeout"
+	SessionEventTimeout    SessionEventKind = "timeout"
+)
+
+// SessionEvent is one thing that happened to an offer during matching.
+type SessionEvent struct {
+	Kind     SessionEventKind `json:"kind"`
+	DriverID string           `json:"driver_id"`
+}
+
+// RecordedSession is the ordered list of offers, driver responses and
+// timeouts the matching loop observed for one ride. Feeding it to
+// ReplaySession re-runs the match with the same responses and timeouts, in
+// the same order, without waiting on real timers or real drivers — so a
+// flaky production match can be reproduced in a test.
+//
+// Only driver-side events are recorded. Pickup moves and the total matching
+// timeout are not, so a session that depended on them won't replay exactly.
+type RecordedSession struct {
+	RideID string         `json:"ride_id"`
+	Events []SessionEvent `json:"events"`
+}
+
+// sessionRecorder collects a RecordedSession per ride. A nil recorder (the
+// default, recording off) ignores every call, so the matching loop can record
+// unconditionally.
+type sessionRecorder struct {
+	mu       sync.Mutex
+	sessions map[string]*RecordedSession
+}
+
+func newSessionRecorder() *sessionRecorder {
+	return &sessionRecorder{sessions: make(map[string]*RecordedSession)}
+}
+
+func (r *sessionRecorder) record(rideID string, kind SessionEventKind, driverID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[rideID]
+	if !exists {
+		session = &RecordedSession{RideID: rideID}
+		r.sessions[rideID] = session
+	}
+	session.Events = append(session.Events, SessionEvent{Kind: kind, DriverID: driverID})
+}
+
+// get returns a copy of the ride's session so the caller can't race with
+// further recording.
+func (r *sessionRecorder) get(rideID string) (RecordedSession, bool) {
+	if r == nil {
+		return RecordedSession{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[rideID]
+	if !exists {
+		return RecordedSession{}, false
+	}
+	return RecordedSession{
+		RideID: session.RideID,
+		Events: append([]SessionEvent(nil), session.Events...),
+	}, true
+}
+
+// sessionScript walks a RecordedSession during replay, one offer at a time.
+type sessionScript struct {
+	events []SessionEvent
+	pos    int
+}
+
+// nextOffer consumes the recorded offer to driverID and returns the events
+// that followed it, up to the next offer. It returns ErrReplayDiverged if the
+// recording's next offer went to someone else or there is none.
+func (sc *sessionScript) nextOffer(driverID string) ([]SessionEvent, error) {
+	if sc.pos >= len(sc.events) {
+		return nil, fmt.Errorf("%w: unexpected offer to driver %s", ErrReplayDiverged, driverID)
+	}
+	offer := sc.events[sc.pos]
+	if offer.Kind != SessionEventOffer || offer.DriverID != driverID {
+		return nil, fmt.Errorf("%w: offered driver %s, recording has %s %s",
+			ErrReplayDiverged, driverID, offer.Kind, offer.DriverID)
+	}
+	sc.pos++
+
+	start := sc.pos
+	for sc.pos < len(sc.events) && sc.events[sc.pos].Kind != SessionEventOffer {
+		sc.pos++
+	}
+	return sc.events[start:sc.pos], nil
+}
+
+// offerTimer starts one window of an offer's wait. Live matching uses the
+// match's offerClock; a replay fires at once if the recording says this phase
+// timed out, and otherwise never (the scripted response arrives first).
+//
+// Go Learning Note — Timers That Never Fire:
+// The loop treats a nil timer as "phase not active", so a replay can't use nil
+// for "never fires". An unbuffered channel nobody sends on is non-nil yet
+// blocks forever; a buffered channel with one value already in it is the
+// opposite: a timer that has "already expired".
+func offerTimer(d time.Duration, clock *offerClock, scripted []SessionEvent, replaying bool, timeoutKind SessionEventKind) <-chan time.Time {
+	if !replaying {
+		return clock.start(d)
+	}
+	for _, event := range scripted {
+		if event.Kind == timeoutKind {
+			fired := make(chan time.Time, 1)
+			fired <- time.Now()
+			return fired
+		}
+	}
+	return make(chan time.Time)
+}
+
+// scriptedResponses converts the recorded acks, accepts and declines for an
+// offer into the DriverResponses the loop would have received, stamped with
+// the replayed offer's sequence number.
+func scriptedResponses(rideID string, seq uint64, scripted []SessionEvent) []DriverResponse {
+	var responses []DriverResponse
+	for _, event := range scripted {
+		switch event.Kind {
+		case SessionEventAck:
+			responses = append(responses, DriverResponse{DriverID: event.DriverID, RideID: rideID, Ack: true, Seq: seq})
+		case SessionEventAccept, SessionEventDecline:
+			responses = append(responses, DriverResponse{
+				DriverID: event.DriverID,
+				RideID:   rideID,
+				Accept:   event.Kind == SessionEventAccept,
+				Seq:      seq,
+			})
+		}
+	}
+	return responses
+}
+
+// EnableSessionRecording starts recording a RecordedSession for every ride
+// matched from now on. Sessions are kept in memory for the life of the
+// service, so leave this off outside debugging and tests.
+func (s *MatchingService) EnableSessionRecording() {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.recorder == nil {
+		s.recorder = newSessionRecorder()
+	}
+}
+
+// RecordedSession returns the session recorded for a ride, if recording was
+// enabled while it was matched.
+func (s *MatchingService) RecordedSession(rideID string) (RecordedSession, bool) {
+	s.pendingMu.RLock()
+	recorder := s.recorder
+	s.pendingMu.RUnlock()
+	return recorder.get(rideID)
+}
+
+// ReplaySession matches ride using the responses and timeouts in session
+// instead of waiting on drivers and timers. The service should be set up
+// like the original (same drivers, locations and availability) so that the
+// loop makes the same offers; if it offers a different driver, matching
+// fails with ErrReplayDiverged. Driver responses submitted while a replay
+// runs are ignored.
+func (s *MatchingService) ReplaySession(ctx context.Context, ride *entities.Ride, session RecordedSession) <-chan MatchingResult {
+	resultChan := make(chan MatchingResult, 1)
+	script := &sessionScript{events: session.Events}
+
+	s.launch(ctx, ride, nil, script, resultChan)
+
+	return resultChan
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+)
+
+// setupReplayScenario builds a fresh matching service with three drivers at
+// increasing distance from the pickup and a requested ride.
+func setupReplayScenario(t *testing.T, driverIDs ...string) (*MatchingService, *RideService, *entities.Ride) {
+	t.Helper()
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	matchingService.config.Matching.OfferAckTimeout = 200 * time.Millisecond
+	ctx := context.Background()
+
+	positions := map[string]entities.Location{
+		"driver-1": {Latitude: 37.771, Longitude: -122.411},
+		"driver-2": {Latitude: 37.775, Longitude: -122.415},
+		"driver-3": {Latitude: 37.776, Longitude: -122.416},
+	}
+	for _, id := range driverIDs {
+		driverRepo.GetOrCreate(ctx, id)
+		locationService.UpdateDriverLocation(ctx, id, positions[id].Latitude, positions[id].Longitude)
+	}
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, err := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+	if err != nil {
+		t.Fatalf("RequestRide failed: %v", err)
+	}
+	return matchingService, rideService, ride
+}
+
+func TestMatchingService_ReplayReproducesRecordedSession(t *testing.T) {
+	ctx := context.Background()
+
+	// Live run: driver-1 acks then declines, driver-2 never acks, driver-3
+	// acks and accepts.
+	live, _, ride := setupReplayScenario(t, "driver-1", "driver-2", "driver-3")
+	live.EnableSessionRecording()
+	resultChan := live.StartMatching(ctx, ride)
+
+	time.Sleep(50 * time.Millisecond)
+	live.AcknowledgeOffer("driver-1", ride.ID)
+	live.SubmitDriverResponse("driver-1", ride.ID, false)
+	// driver-2's 200ms ack window closes at ~250ms and driver-3's at ~450ms;
+	// ack driver-3 in the middle of theirs.
+	time.Sleep(300 * time.Millisecond)
+	live.AcknowledgeOffer("driver-3", ride.ID)
+	live.SubmitDriverResponse("driver-3", ride.ID, true)
+
+	liveResult := <-resultChan
+	if !liveResult.Success || liveResult.DriverID != "driver-3" {
+		t.Fatalf("Expected live run to match driver-3, got %+v", liveResult)
+	}
+
+	recorded, ok := live.RecordedSession(ride.ID)
+	if !ok {
+		t.Fatal("Expected a recorded session")
+	}
+	expected := []SessionEvent{
+		{SessionEventOffer, "driver-1"},
+		{SessionEventAck, "driver-1"},
+		{SessionEventDecline, "driver-1"},
+		{SessionEventOffer, "driver-2"},
+		{SessionEventAckTimeout, "driver-2"},
+		{SessionEventOffer, "driver-3"},
+		{SessionEventAck, "driver-3"},
+		{SessionEventAccept, "driver-3"},
+	}
+	if !reflect.DeepEqual(recorded.Events, expected) {
+		t.Fatalf("Unexpected recording:\n got %v\nwant %v", recorded.Events, expected)
+	}
+
+	// Replay against a fresh service set up the same way, with no driver
+	// input at all. The ack timeout is replayed without waiting for it.
+	replay, replayRides, replayRide := setupReplayScenario(t, "driver-1", "driver-2", "driver-3")
+	replay.EnableSessionRecording()
+
+	start := time.Now()
+	replayResult := <-replay.ReplaySession(ctx, replayRide, recorded)
+	elapsed := time.Since(start)
+
+	if replayResult != liveResult {
+		t.Errorf("Expected replay outcome %+v, got %+v", liveResult, replayResult)
+	}
+	if elapsed >= 200*time.Millisecond {
+		t.Errorf("Expected replay not to wait on timers, took %v", elapsed)
+	}
+	replayed, _ := replay.RecordedSession(replayRide.ID)
+	if !reflect.DeepEqual(replayed.Events, expected) {
+		t.Errorf("Expected replay to retrace the recording, got %v", replayed.Events)
+	}
+	stored, _ := replayRides.GetRide(ctx, replayRide.ID)
+	if stored.Status != entities.RideStatusAccepted || stored.DriverID != "driver-3" {
+		t.Errorf("Expected replayed ride accepted by driver-3, got %s / %q", stored.Status, stored.DriverID)
+	}
+}
+
+func TestMatchingService_ReplayDivergesWhenDriversDiffer(t *testing.T) {
+	ctx := context.Background()
+	session := RecordedSession{Events: []SessionEvent{
+		{SessionEventOffer, "driver-1"},
+		{SessionEventAccept, "driver-1"},
+	}}
+
+	// driver-1 is missing, so the replay's first offer goes to driver-2.
+	replay, rideService, ride := setupReplayScenario(t, "driver-2")
+	result := <-replay.ReplaySession(ctx, ride, session)
+
+	if result.Success || !errors.Is(result.Error, ErrReplayDiverged) {
+		t.Errorf("Expected ErrReplayDiverged, got %+v", result)
+	}
+	stored, _ := rideService.GetRide(ctx, ride.ID)
+	if stored.Status != entities.RideStatusFailed {
+		t.Errorf("Expected diverged replay to fail the ride, got %s", stored.Status)
+	}
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+)
+
+// MatchingStrategy decides which of a ride's candidate drivers are offered
+// the ride, and in what order. The matching loop finds and ranks the
+// candidates; the strategy only chooses among them and calls offer for each
+// driver it wants to try.
+//
+// offer does everything involved in offering the ride to one driver: it
+// reserves the driver, notifies them, and blocks until they accept, decline
+// or time out. It returns true only if the driver accepted and was assigned
+// the ride. It returns false without contacting anyone if the driver is not
+// eligible (already offered, or reserved by another ride), and it keeps
+// returning false once the match is over.
+//
+// SelectAndOffer returns the driver who accepted, or ok=false if none did.
+// ctx is cancelled when the match ends for any other reason (the rider
+// cancelled, the total timeout, a pickup change that re-ranks the
+// candidates), and a strategy should stop offering as soon as it is.
+//
+// Go Learning Note — Callbacks Instead of Wider Interfaces:
+// The strategy is handed a func rather than the MatchingService itself. That
+// keeps the interface to a single method, keeps locking, notifications and
+// timers out of the strategy's reach, and lets a test drive it with a plain
+// closure. Any type with a SelectAndOffer method satisfies the interface
+// implicitly; there is no "implements" declaration to keep in sync.
+type MatchingStrategy interface {
+	SelectAndOffer(ctx context.Context, ride *entities.Ride, candidates []geo.DriverWithDistance, offer func(driverID string) (accepted bool)) (driverID string, ok bool)
+}
+
+// SequentialStrategy offers the ride to one candidate at a time, in the order
+// the matching loop ranked them (nearest first), until one accepts. It is the
+// default strategy.
+type SequentialStrategy struct{}
+
+// SelectAndOffer implements MatchingStrategy.
+func (SequentialStrategy) SelectAndOffer(ctx context.Context, ride *entities.Ride, candidates []geo.DriverWithDistance, offer func(driverID string) bool) (string, bool) {
+	for _, dwd := range candidates {
+		if ctx.Err() != nil {
+			return "", false
+		}
+		if offer(dwd.Driver.DriverID) {
+			return dwd.Driver.DriverID, true
+		}
+	}
+	return "", false
+}
+
+// SetStrategy replaces the strategy used to offer rides to candidates. Like
+// EnableSessionRecording, it should be called before matching starts.
+//
+// MatchingStrategyBroadcast in the config still takes precedence: it offers
+// several drivers at once, which doesn't fit a callback that blocks on one
+// driver's answer, so it stays a separate path (see broadcastMatch).
+func (s *MatchingService) SetStrategy(strategy MatchingStrategy) {
+	s.strategy = strategy
+}
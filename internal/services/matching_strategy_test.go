@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+)
+
+// pickSecondStrategy records the candidates it is given and offers the ride
+// only to the second one.
+type pickSecondStrategy struct {
+	candidates []string
+}
+
+func (p *pickSecondStrategy) SelectAndOffer(ctx context.Context, ride *entities.Ride, candidates []geo.DriverWithDistance, offer func(driverID string) bool) (string, bool) {
+	for _, dwd := range candidates {
+		p.candidates = append(p.candidates, dwd.Driver.DriverID)
+	}
+	if len(candidates) < 2 {
+		return "", false
+	}
+	driverID := candidates[1].Driver.DriverID
+	return driverID, offer(driverID)
+}
+
+func TestMatchingService_DelegatesToStrategy(t *testing.T) {
+	matchingService, rideService, locationService, driverRepo := setupMatchingService()
+	strategy := &pickSecondStrategy{}
+	matchingService.SetStrategy(strategy)
+	ctx := context.Background()
+
+	for i, driverID := range []string{"driver-1", "driver-2"} {
+		driverRepo.GetOrCreate(ctx, driverID)
+		locationService.UpdateDriverLocation(ctx, driverID, 37.771+0.001*float64(i), -122.41)
+	}
+
+	estimate, _ := rideService.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideService.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	resultChan := matchingService.StartMatching(ctx, ride)
+	time.Sleep(100 * time.Millisecond)
+	matchingService.SubmitDriverResponse("driver-2", ride.ID, true)
+
+	select {
+	case result := <-resultChan:
+		if !result.Success || result.DriverID != "driver-2" {
+			t.Fatalf("Expected the strategy's pick driver-2 to be matched, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected matching to finish after driver-2 accepted")
+	}
+
+	if len(strategy.candidates) != 2 || strategy.candidates[0] != "driver-1" || strategy.candidates[1] != "driver-2" {
+		t.Errorf("Expected the strategy to get [driver-1 driver-2] nearest first, got %v", strategy.candidates)
+	}
+	if offers := matchingService.DriverReliability("driver-1").Offers; offers != 0 {
+		t.Errorf("Expected driver-1 not to be offered the ride, got %d offers", offers)
+	}
+	if offers := matchingService.DriverReliability("driver-2").Offers; offers != 1 {
+		t.Errorf("Expected driver-2 to be offered the ride once, got %d offers", offers)
+	}
+}
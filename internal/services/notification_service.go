@@ -1,8 +1,13 @@
 package services
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"sync"
 	"uber/internal/domain/entities"
+	"uber/internal/i18n"
+	"uber/pkg/utils"
 )
 
 // NotificationService is a mock implementation that logs notifications.
@@ -16,60 +21,186 @@ import (
 // so the rest of the codebase works without conditional logic. To swap in a
 // real implementation, you'd define an interface, have both the mock and real
 // implementations satisfy it, and inject the desired one at startup.
+//
+// Message text comes from the i18n catalog, in each recipient's preferred
+// language (see SetLanguage).
 type NotificationService struct {
 	// In a real implementation, this would have push notification clients
 	// (e.g., *fcm.Client, *apns.Client).
+
+	// currency is the ISO 4217 code fares are quoted in, used to format
+	// amounts in messages.
+	currency string
+
+	// languages maps user ID → preferred message language. Users with no
+	// entry get i18n.DefaultLanguage.
+	languages   map[string]string
+	languagesMu sync.RWMutex
 }
 
-// NewNotificationService creates a mock notification service.
+// NewNotificationService creates a mock notification service that formats
+// amounts in utils.DefaultCurrencyCode and writes in i18n.DefaultLanguage
+// until users set a preference.
 func NewNotificationService() *NotificationService {
-	return &NotificationService{}
+	return &NotificationService{
+		currency:  utils.DefaultCurrencyCode,
+		languages: make(map[string]string),
+	}
+}
+
+// SetCurrency sets the ISO 4217 currency fares in notifications are shown in.
+func (s *NotificationService) SetCurrency(currencyCode string) {
+	s.currency = currencyCode
+}
+
+// SetLanguage records the language a user wants their notifications in.
+// Unsupported languages are ignored, leaving the previous preference.
+func (s *NotificationService) SetLanguage(userID, lang string) {
+	if !i18n.Supported(lang) {
+		return
+	}
+	s.languagesMu.Lock()
+	defer s.languagesMu.Unlock()
+	s.languages[userID] = lang
+}
+
+// Language returns the user's preferred notification language.
+func (s *NotificationService) Language(userID string) string {
+	s.languagesMu.RLock()
+	defer s.languagesMu.RUnlock()
+	if lang, ok := s.languages[userID]; ok {
+		return lang
+	}
+	return i18n.DefaultLanguage
+}
+
+// message renders catalog message id in the user's language.
+func (s *NotificationService) message(userID, id string, data map[string]any) string {
+	return i18n.Render(s.Language(userID), id, data)
+}
+
+// formatFare renders a fare amount for a notification message.
+func (s *NotificationService) formatFare(amount utils.Money) string {
+	return amount.Format(s.currency)
 }
 
 // NotifyDriverOfRideRequest sends a push notification to a driver about a new
 // ride request. The driver's app would display this with an accept/decline UI.
-func (s *NotificationService) NotifyDriverOfRideRequest(driverID string, ride *entities.Ride) {
-	log.Printf("[NOTIFICATION] Driver %s: New ride request %s from (%.4f, %.4f) to (%.4f, %.4f). Estimated fare: $%.2f",
-		driverID,
-		ride.ID,
-		ride.Source.Latitude, ride.Source.Longitude,
-		ride.Destination.Latitude, ride.Destination.Longitude,
-		ride.EstimatedFare,
-	)
+// pickup is the pickup point the matching loop is offering the ride for, which
+// the rider may have moved since the ride was read. demand, when not nil, adds how busy the pickup area is (see
+// config.MatchingConfig.OfferDemandContext).
+func (s *NotificationService) NotifyDriverOfRideRequest(driverID string, ride *entities.Ride, pickup entities.Location, demand *DemandContext) {
+	log.Printf("[NOTIFICATION] Driver %s: %s", driverID, s.rideRequestMessage(driverID, ride, pickup, demand))
+}
+
+// rideRequestMessage renders the text of a ride offer.
+func (s *NotificationService) rideRequestMessage(driverID string, ride *entities.Ride, pickup entities.Location, demand *DemandContext) string {
+	msg := s.message(driverID, "notify.ride_request", map[string]any{
+		"Ride":     ride.ID,
+		"FromLat":  fmt.Sprintf("%.4f", pickup.Latitude),
+		"FromLong": fmt.Sprintf("%.4f", pickup.Longitude),
+		"ToLat":    fmt.Sprintf("%.4f", ride.Destination.Latitude),
+		"ToLong":   fmt.Sprintf("%.4f", ride.Destination.Longitude),
+		"Fare":     s.formatFare(ride.CurrentFare()),
+	})
+	if demand == nil {
+		return msg
+	}
+	return msg + ". " + s.message(driverID, "notify.offer_demand", map[string]any{
+		"Surge":   fmt.Sprintf("%.1f", demand.SurgeMultiplier),
+		"Pending": demand.PendingRequests,
+	})
 }
 
-// NotifyRiderOfDriverAccepted sends notification to rider that driver accepted
-func (s *NotificationService) NotifyRiderOfDriverAccepted(riderID, driverID, rideID string) {
-	log.Printf("[NOTIFICATION] Rider %s: Driver %s has accepted your ride %s",
-		riderID, driverID, rideID)
+// NotifyRiderOfDriverAccepted sends notification to rider that driver accepted.
+// pickupMins, when not nil, adds how many minutes away the driver is (see
+// LocationService.EstimatePickupETA).
+func (s *NotificationService) NotifyRiderOfDriverAccepted(riderID, driverID, rideID string, pickupMins *float64) {
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.driverAcceptedMessage(riderID, driverID, rideID, pickupMins))
+}
+
+// driverAcceptedMessage renders the text of a driver-accepted notification.
+// The ETA is rounded up to whole minutes, never below one: "0 minutes" would
+// tell the rider the car is already outside.
+func (s *NotificationService) driverAcceptedMessage(riderID, driverID, rideID string, pickupMins *float64) string {
+	msg := s.message(riderID, "notify.driver_accepted", map[string]any{"Driver": driverID, "Ride": rideID})
+	if pickupMins == nil {
+		return msg
+	}
+	return msg + ". " + s.message(riderID, "notify.pickup_eta", map[string]any{
+		"Minutes": max(int(math.Ceil(*pickupMins)), 1),
+	})
 }
 
 // NotifyRiderOfDriverArriving sends notification that driver is arriving
 func (s *NotificationService) NotifyRiderOfDriverArriving(riderID, driverID, rideID string) {
-	log.Printf("[NOTIFICATION] Rider %s: Driver %s is arriving for ride %s",
-		riderID, driverID, rideID)
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.message(riderID, "notify.driver_arriving",
+		map[string]any{"Driver": driverID, "Ride": rideID}))
+}
+
+// NotifyRiderOfDriverArrivingSoon tells the rider their driver is a couple of
+// minutes from the pickup, so they can head out to meet them.
+func (s *NotificationService) NotifyRiderOfDriverArrivingSoon(riderID, driverID, rideID string) {
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.message(riderID, "notify.driver_arriving_soon",
+		map[string]any{"Driver": driverID, "Ride": rideID}))
+}
+
+// NotifyRiderOfContactlessDropoff is the contactless counterpart of
+// NotifyRiderOfDriverArriving: nobody meets the driver, so the rider is told
+// the order is on its way and will be left at the door.
+func (s *NotificationService) NotifyRiderOfContactlessDropoff(riderID, driverID, rideID string) {
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.message(riderID, "notify.contactless_dropoff",
+		map[string]any{"Driver": driverID, "Ride": rideID}))
 }
 
 // NotifyRiderOfTripStarted sends notification that trip has started
 func (s *NotificationService) NotifyRiderOfTripStarted(riderID, rideID string) {
-	log.Printf("[NOTIFICATION] Rider %s: Your trip %s has started",
-		riderID, rideID)
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.message(riderID, "notify.trip_started",
+		map[string]any{"Ride": rideID}))
 }
 
 // NotifyRiderOfTripCompleted sends notification that trip is complete
-func (s *NotificationService) NotifyRiderOfTripCompleted(riderID, rideID string, fare float64) {
-	log.Printf("[NOTIFICATION] Rider %s: Your trip %s has been completed. Fare: $%.2f",
-		riderID, rideID, fare)
+func (s *NotificationService) NotifyRiderOfTripCompleted(riderID, rideID string, fare utils.Money) {
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.message(riderID, "notify.trip_completed",
+		map[string]any{"Ride": rideID, "Fare": s.formatFare(fare)}))
+}
+
+// NotifyRiderToConfirmCompletion asks the rider to confirm a ride the driver
+// has marked completed (see RideService.ConfirmCompletion).
+func (s *NotificationService) NotifyRiderToConfirmCompletion(riderID, rideID string) {
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.message(riderID, "notify.confirm_completion",
+		map[string]any{"Ride": rideID}))
+}
+
+// NotifyRiderOfDriverReassignment tells the rider their driver cancelled and
+// a new one is being found; the ride stays active while they wait.
+func (s *NotificationService) NotifyRiderOfDriverReassignment(riderID, rideID string) {
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.message(riderID, "notify.driver_reassignment",
+		map[string]any{"Ride": rideID}))
 }
 
 // NotifyRiderOfNoDriversAvailable sends notification that no drivers were found
 func (s *NotificationService) NotifyRiderOfNoDriversAvailable(riderID, rideID string) {
-	log.Printf("[NOTIFICATION] Rider %s: No drivers available for ride %s. Please try again later.",
-		riderID, rideID)
+	log.Printf("[NOTIFICATION] Rider %s: %s", riderID, s.message(riderID, "notify.no_drivers_available",
+		map[string]any{"Ride": rideID}))
+}
+
+// NotifyDriverOfRideTaken tells a driver that a ride offered to them went to
+// another driver, so their app can withdraw the offer.
+func (s *NotificationService) NotifyDriverOfRideTaken(driverID, rideID string) {
+	log.Printf("[NOTIFICATION] Driver %s: %s", driverID, s.message(driverID, "notify.ride_taken",
+		map[string]any{"Ride": rideID}))
+}
+
+// NotifyDriverOfRideCancelled tells a driver the rider cancelled a ride they
+// were assigned, or had just accepted.
+func (s *NotificationService) NotifyDriverOfRideCancelled(driverID, rideID string) {
+	log.Printf("[NOTIFICATION] Driver %s: %s", driverID, s.message(driverID, "notify.ride_cancelled",
+		map[string]any{"Ride": rideID}))
 }
 
 // NotifyDriverOfRideTimeout sends notification to driver that response timed out
 func (s *NotificationService) NotifyDriverOfRideTimeout(driverID, rideID string) {
-	log.Printf("[NOTIFICATION] Driver %s: Your response time for ride %s has expired",
-		driverID, rideID)
+	log.Printf("[NOTIFICATION] Driver %s: %s", driverID, s.message(driverID, "notify.ride_timeout",
+		map[string]any{"Ride": rideID}))
 }
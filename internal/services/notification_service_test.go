@@ -0,0 +1,77 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"uber/internal/domain/entities"
+	"uber/internal/i18n"
+	"uber/pkg/utils"
+)
+
+func TestNotificationService_Language(t *testing.T) {
+	service := NewNotificationService()
+
+	if lang := service.Language("rider-1"); lang != i18n.DefaultLanguage {
+		t.Errorf("Expected default language, got %s", lang)
+	}
+
+	service.SetLanguage("rider-1", "es")
+	service.SetLanguage("rider-1", "tlh") // Unsupported; keeps Spanish.
+
+	got := service.message("rider-1", "notify.trip_started", map[string]any{"Ride": "ride-1"})
+	if got != "Tu viaje ride-1 ha comenzado" {
+		t.Errorf("Expected Spanish notification, got %q", got)
+	}
+
+	// Missing translations fall back to English for the same user.
+	got = service.message("rider-1", "notify.ride_timeout", map[string]any{"Ride": "ride-1"})
+	if got != "Your response time for ride ride-1 has expired" {
+		t.Errorf("Expected English fallback, got %q", got)
+	}
+
+	// Other users are unaffected.
+	got = service.message("rider-2", "notify.trip_started", map[string]any{"Ride": "ride-2"})
+	if got != "Your trip ride-2 has started" {
+		t.Errorf("Expected English notification for rider-2, got %q", got)
+	}
+}
+
+func TestNotificationService_RideRequestDemandContext(t *testing.T) {
+	service := NewNotificationService()
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+
+	plain := service.rideRequestMessage("driver-1", ride, ride.Source, nil)
+	if strings.Contains(plain, "surge") {
+		t.Errorf("Expected no demand context without one, got %q", plain)
+	}
+
+	got := service.rideRequestMessage("driver-1", ride, ride.Source, &DemandContext{Cell: "9q8yy", SurgeMultiplier: 1.5, PendingRequests: 3})
+	if !strings.HasPrefix(got, plain) || !strings.HasSuffix(got, "Nearby: surge 1.5x, 3 pending requests") {
+		t.Errorf("Expected the offer followed by its demand context, got %q", got)
+	}
+}
+
+func TestNotificationService_DriverAcceptedPickupETA(t *testing.T) {
+	service := NewNotificationService()
+
+	got := service.driverAcceptedMessage("rider-1", "driver-1", "ride-1", nil)
+	if got != "Driver driver-1 has accepted your ride ride-1" {
+		t.Errorf("Expected no ETA without one, got %q", got)
+	}
+
+	mins := 3.2
+	got = service.driverAcceptedMessage("rider-1", "driver-1", "ride-1", &mins)
+	if got != "Driver driver-1 has accepted your ride ride-1. Arriving in about 4 min" {
+		t.Errorf("Expected the ETA rounded up, got %q", got)
+	}
+
+	// A driver already at the pickup still reads as one minute away.
+	mins = 0
+	got = service.driverAcceptedMessage("rider-1", "driver-1", "ride-1", &mins)
+	if !strings.HasSuffix(got, "Arriving in about 1 min") {
+		t.Errorf("Expected at least one minute, got %q", got)
+	}
+}
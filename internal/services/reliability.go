@@ -0,0 +1,133 @@
+package services
+
+import "sync"
+
+// DriverReliability is a snapshot of one driver's offer history. Declines and
+// timeouts are tracked separately because markets treat them differently: a
+// decline is an explicit choice, while a timeout usually means the driver was
+// not paying attention (or unreachable) and wasted the rider's time.
+//
+// CancelsAfterAccept counts rides the driver accepted and then cancelled
+// before pickup. These are the costliest outcome for the rider — they waited
+// on a driver who never came — so Score penalizes them harder than a decline.
+type DriverReliability struct {
+	DriverID           string `json:"driver_id"`
+	Offers             int    `json:"offers"`
+	Accepts            int    `json:"accepts"`
+	Declines           int    `json:"declines"`
+	Timeouts           int    `json:"timeouts"`
+	CancelsAfterAccept int    `json:"cancels_after_accept"`
+}
+
+// cancelAfterAcceptPenalty is how many accepts one accept-then-cancel erases
+// in Score: the accept it undoes, plus one more for the rider's wasted wait.
+const cancelAfterAcceptPenalty = 2
+
+// AcceptanceRate returns the fraction of offers the driver accepted, or 1.0
+// for a driver who has not been offered anything yet (no evidence against them).
+func (r DriverReliability) AcceptanceRate() float64 {
+	if r.Offers == 0 {
+		return 1.0
+	}
+	return float64(r.Accepts) / float64(r.Offers)
+}
+
+// Score rates the driver from 0 (unreliable) to 1 (reliable) for ranking
+// candidates. It is the acceptance rate with accept-then-cancels subtracted
+// at cancelAfterAcceptPenalty each, smoothed by one assumed accepted offer so
+// that a single early miss doesn't zero out a new driver. With no history the
+// score is 1.0; one decline gives 0.5; one accept-then-cancel gives 0.
+func (r DriverReliability) Score() float64 {
+	score := float64(r.Accepts-cancelAfterAcceptPenalty*r.CancelsAfterAccept+1) / float64(r.Offers+1)
+	if score < 0 {
+		return 0
+	}
+	if score > 1 {
+		return 1
+	}
+	return score
+}
+
+// ReliabilityTracker records per-driver offer outcomes. It is safe for
+// concurrent use by multiple matching goroutines.
+type ReliabilityTracker struct {
+	mu      sync.RWMutex
+	drivers map[string]*DriverReliability
+}
+
+// NewReliabilityTracker creates an empty tracker.
+func NewReliabilityTracker() *ReliabilityTracker {
+	return &ReliabilityTracker{
+		drivers: make(map[string]*DriverReliability),
+	}
+}
+
+// entry returns the mutable record for a driver, creating it if needed.
+// Callers must hold the write lock.
+func (t *ReliabilityTracker) entry(driverID string) *DriverReliability {
+	r, exists := t.drivers[driverID]
+	if !exists {
+		r = &DriverReliability{DriverID: driverID}
+		t.drivers[driverID] = r
+	}
+	return r
+}
+
+// RecordOffer counts a ride offer sent to the driver.
+func (t *ReliabilityTracker) RecordOffer(driverID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(driverID).Offers++
+}
+
+// RecordWithdrawn takes back an offer that was withdrawn before the driver
+// answered, because another driver in the same broadcast accepted first. The
+// driver had no chance to respond, so it shouldn't lower their acceptance
+// rate.
+func (t *ReliabilityTracker) RecordWithdrawn(driverID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if r := t.entry(driverID); r.Offers > 0 {
+		r.Offers--
+	}
+}
+
+// RecordAccept counts an accepted offer.
+func (t *ReliabilityTracker) RecordAccept(driverID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(driverID).Accepts++
+}
+
+// RecordDecline counts an explicit decline.
+func (t *ReliabilityTracker) RecordDecline(driverID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(driverID).Declines++
+}
+
+// RecordTimeout counts an offer the driver let expire.
+func (t *ReliabilityTracker) RecordTimeout(driverID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(driverID).Timeouts++
+}
+
+// RecordCancelAfterAccept counts a ride the driver accepted and then
+// cancelled before picking up the rider.
+func (t *ReliabilityTracker) RecordCancelAfterAccept(driverID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(driverID).CancelsAfterAccept++
+}
+
+// Get returns a copy of the driver's record (zero counts if never offered).
+func (t *ReliabilityTracker) Get(driverID string) DriverReliability {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if r, exists := t.drivers[driverID]; exists {
+		return *r
+	}
+	return DriverReliability{DriverID: driverID}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"testing"
+	"uber/internal/domain/entities"
+	"uber/internal/geo"
+)
+
+func TestDriverReliability_Score(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   DriverReliability
+		expected float64
+	}{
+		{"No history", DriverReliability{}, 1.0},
+		{"Accepted", DriverReliability{Offers: 1, Accepts: 1}, 1.0},
+		{"Declined", DriverReliability{Offers: 1, Declines: 1}, 0.5},
+		{"Timed out", DriverReliability{Offers: 1, Timeouts: 1}, 0.5},
+		{"Accepted then cancelled", DriverReliability{Offers: 1, Accepts: 1, CancelsAfterAccept: 1}, 0},
+		{"Mostly reliable with one cancel", DriverReliability{Offers: 5, Accepts: 5, CancelsAfterAccept: 1}, 4.0 / 6.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.record.Score(); got != tt.expected {
+				t.Errorf("Score() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func candidate(driverID string, distanceKm float64) geo.DriverWithDistance {
+	return geo.DriverWithDistance{
+		Driver:   entities.NewDriverLocation(driverID, 37.77, -122.41, "9q8yyk"),
+		Distance: distanceKm,
+	}
+}
+
+func TestMatchingService_CancelAfterAcceptRanksBelowDecline(t *testing.T) {
+	matchingService, _, _, _ := setupMatchingService()
+
+	// Same offer count for both; one declined, the other accepted and then
+	// cancelled before pickup.
+	matchingService.reliability.RecordOffer("driver-decliner")
+	matchingService.reliability.RecordDecline("driver-decliner")
+	matchingService.reliability.RecordOffer("driver-canceller")
+	matchingService.reliability.RecordAccept("driver-canceller")
+	matchingService.RecordCancelAfterAccept("driver-canceller")
+
+	decliner := matchingService.DriverReliability("driver-decliner")
+	canceller := matchingService.DriverReliability("driver-canceller")
+	if canceller.CancelsAfterAccept != 1 {
+		t.Fatalf("Expected 1 cancel after accept, got %d", canceller.CancelsAfterAccept)
+	}
+	if canceller.Score() >= decliner.Score() {
+		t.Errorf("Expected accept-then-cancel score %v below decline score %v", canceller.Score(), decliner.Score())
+	}
+
+	// The canceller is slightly closer but should now be tried after the
+	// decliner, and both after a driver with a clean record.
+	candidates := []geo.DriverWithDistance{
+		candidate("driver-canceller", 1.0),
+		candidate("driver-decliner", 1.1),
+		candidate("driver-new", 1.5),
+	}
+	matchingService.rankCandidates(candidates)
+
+	expected := []string{"driver-new", "driver-decliner", "driver-canceller"}
+	for i, id := range expected {
+		if candidates[i].Driver.DriverID != id {
+			t.Errorf("Position %d: expected %s, got %s", i, id, candidates[i].Driver.DriverID)
+		}
+	}
+}
+
+func TestMatchingService_ZeroReliabilityWeightKeepsNearestFirst(t *testing.T) {
+	matchingService, _, _, _ := setupMatchingService()
+	matchingService.config.Matching.ReliabilityWeight = 0
+
+	matchingService.reliability.RecordOffer("driver-canceller")
+	matchingService.reliability.RecordAccept("driver-canceller")
+	matchingService.RecordCancelAfterAccept("driver-canceller")
+
+	candidates := []geo.DriverWithDistance{
+		candidate("driver-canceller", 1.0),
+		candidate("driver-new", 1.5),
+	}
+	matchingService.rankCandidates(candidates)
+
+	if candidates[0].Driver.DriverID != "driver-canceller" {
+		t.Errorf("Expected nearest-first order with weight 0, got %s first", candidates[0].Driver.DriverID)
+	}
+}
@@ -14,6 +14,8 @@ package services
 import (
 	"context"
 	"errors"
+	"sync"
+	"time"
 	"uber/internal/config"
 	"uber/internal/domain/entities"
 	"uber/internal/repository/memory"
@@ -39,63 +41,294 @@ var (
 	ErrInvalidTransition = errors.New("invalid status transition")
 	ErrNotAuthorized     = errors.New("not authorized to perform this action")
 	ErrActiveRideExists  = errors.New("rider already has an active ride")
+	ErrSameLocation      = errors.New("source and destination are the same location")
+	ErrPickupLocked      = errors.New("pickup location can no longer be changed")
+	ErrFareExpired       = errors.New("fare lock expired and the fare has changed; please confirm the new fare")
+	ErrNoTripInProgress  = errors.New("driver has no ride in progress")
+	ErrRideTerminal      = errors.New("ride is already finished")
+	ErrInvalidLocation   = errors.New("coordinates are out of range")
+	ErrRouteTooLong      = errors.New("route exceeds the maximum trip distance")
+	ErrTooManyWaypoints  = errors.New("route has more stops than allowed")
+	ErrInvalidTripLength = errors.New("actual distance and duration must not be negative")
+	ErrEstimateExpired   = errors.New("fare estimate has expired; please request a new estimate")
+	ErrInvalidPromoCode  = errors.New("promo code is not valid")
+	ErrPromoCodeExpired  = errors.New("promo code has expired")
+	ErrInvalidPagination = errors.New("limit must be between 1 and 100 and offset must not be negative")
+	ErrNoDriverEnRoute   = errors.New("no driver is on the way to the pickup")
 )
 
+// ShortTripWarning is attached to fare estimates whose distance is below
+// PricingConfig.ShortTripWarningKm.
+const ShortTripWarning = "trip distance is very short; please check the pickup and destination"
+
+// SurgeFunc returns the surge multiplier currently in effect at a pickup
+// point (1.0 = no surge).
+type SurgeFunc func(ctx context.Context, pickup entities.Location) float64
+
+// noSurge is the default SurgeFunc until a surge source is wired in.
+func noSurge(ctx context.Context, pickup entities.Location) float64 {
+	return 1.0
+}
+
+// CompletionFunc is told about a ride that reached Completed through rider
+// confirmation, whether the rider confirmed or the confirmation timed out.
+type CompletionFunc func(ride *entities.Ride)
+
+// ArrivingSoonFunc is told, once per ride, that the driver picking the rider
+// up is within RideConfig.ArrivingSoonThreshold of the pickup.
+type ArrivingSoonFunc func(ride *entities.Ride)
+
 // RideService manages the ride lifecycle: fare estimation, requesting, status
 // transitions, and driver assignment. It coordinates between ride, rider, and
 // driver repositories.
+//
+// statuses pushes each ride's status changes to its subscribers (see
+// SubscribeStatus).
+//
+// confirmMu serializes completing a PendingConfirmation ride, so the rider's
+// confirmation and the timeout can't both complete it. confirmTimers holds the
+// pending timeouts by ride ID, so a confirmation can stop its timer.
 type RideService struct {
-	rideRepo   *memory.RideRepository
-	riderRepo  *memory.RiderRepository
-	driverRepo *memory.DriverRepository
-	config     *config.Config
-	calculator *utils.PricingCalculator
+	rideRepo        *memory.RideRepository
+	riderRepo       *memory.RiderRepository
+	driverRepo      *memory.DriverRepository
+	promoRepo       *memory.PromoCodeRepository
+	locationService *LocationService
+	demand          *DemandTracker
+	config          *config.Config
+	calculator      *utils.PricingCalculator
+	tierCalculators map[entities.VehicleTier]*utils.PricingCalculator
+	surge           SurgeFunc
+	completed       CompletionFunc
+	arrivingSoon    ArrivingSoonFunc
+	statuses        *statusHub
+
+	confirmMu     sync.Mutex
+	confirmTimers map[string]*time.Timer
+
+	// arrivingMu makes checking and setting a ride's ArrivingSoonAt one
+	// step, so two pings landing together can't both notify.
+	arrivingMu sync.Mutex
 }
 
 // NewRideService creates a RideService. The PricingCalculator is initialized
 // from the config's pricing parameters — this keeps pricing configuration in
-// one place rather than scattered through service methods.
+// one place rather than scattered through service methods. Promo codes riders
+// apply to estimates are looked up in the PromoCodeRepository. The LocationService
+// is used to look up nearby drivers when quoting pickup times, and the
+// DemandTracker is kept up to date as rides are requested and resolved.
 func NewRideService(
 	rideRepo *memory.RideRepository,
 	riderRepo *memory.RiderRepository,
 	driverRepo *memory.DriverRepository,
+	promoRepo *memory.PromoCodeRepository,
+	locationService *LocationService,
+	demand *DemandTracker,
 	cfg *config.Config,
 ) *RideService {
+	tierCalculators := make(map[entities.VehicleTier]*utils.PricingCalculator, len(cfg.Pricing.Tiers))
+	for tier := range cfg.Pricing.Tiers {
+		tierCalculators[entities.VehicleTier(tier)] = newPricingCalculator(cfg.Pricing.ForTier(tier))
+	}
+
 	return &RideService{
-		rideRepo:   rideRepo,
-		riderRepo:  riderRepo,
-		driverRepo: driverRepo,
-		config:     cfg,
-		calculator: utils.NewPricingCalculator(
-			cfg.Pricing.BaseFare,
-			cfg.Pricing.PerKmRate,
-			cfg.Pricing.PerMinuteRate,
-			cfg.Pricing.MinimumFare,
-		),
+		rideRepo:        rideRepo,
+		riderRepo:       riderRepo,
+		driverRepo:      driverRepo,
+		promoRepo:       promoRepo,
+		locationService: locationService,
+		demand:          demand,
+		config:          cfg,
+		calculator:      newPricingCalculator(cfg.Pricing),
+		tierCalculators: tierCalculators,
+		surge:           noSurge,
+		completed:       func(*entities.Ride) {},
+		statuses:        newStatusHub(),
+		confirmTimers:   make(map[string]*time.Timer),
+	}
+}
+
+// SetSurgeFunc sets where fares get their surge multiplier from. It is a
+// setter rather than a constructor argument because the surge source usually
+// depends on services built after the RideService.
+func (s *RideService) SetSurgeFunc(surge SurgeFunc) {
+	s.surge = surge
+}
+
+// SetCompletionFunc sets who is told when a ride awaiting rider confirmation
+// completes. Like SetSurgeFunc it is a setter so the RideService doesn't need
+// the notifier at construction time.
+func (s *RideService) SetCompletionFunc(completed CompletionFunc) {
+	s.completed = completed
+}
+
+// SetArrivingSoonFunc sets who is told when a driver is about to reach the
+// pickup. Until it is set, ObserveDriverLocation does nothing.
+func (s *RideService) SetArrivingSoonFunc(arrivingSoon ArrivingSoonFunc) {
+	s.arrivingSoon = arrivingSoon
+}
+
+// routeTooLong reports whether a trip of distanceKm exceeds the configured
+// RideConfig.MaxRouteDistanceKm.
+func (s *RideService) routeTooLong(distanceKm float64) bool {
+	return s.config.Ride.MaxRouteDistanceKm > 0 && distanceKm > s.config.Ride.MaxRouteDistanceKm
+}
+
+// routeDistanceKm is the length of a trip from source through each of
+// waypoints in order to destination, summing the straight-line legs.
+func routeDistanceKm(source entities.Location, waypoints []entities.Location, destination entities.Location) float64 {
+	distanceKm, from := 0.0, source
+	for _, stop := range waypoints {
+		distanceKm += utils.HaversineDistance(from.Latitude, from.Longitude, stop.Latitude, stop.Longitude)
+		from = stop
+	}
+	return distanceKm + utils.HaversineDistance(from.Latitude, from.Longitude, destination.Latitude, destination.Longitude)
+}
+
+// newPricingCalculator builds a PricingCalculator from one set of pricing
+// parameters: the top-level PricingConfig or a tier's (PricingConfig.ForTier).
+func newPricingCalculator(pricing config.PricingConfig) *utils.PricingCalculator {
+	calculator := utils.NewPricingCalculator(
+		pricing.BaseFare,
+		pricing.PerKmRate,
+		pricing.PerMinuteRate,
+		pricing.MinimumFare,
+	)
+	if pricing.CurrencyCode != "" {
+		calculator.CurrencyCode = pricing.CurrencyCode
+	}
+	calculator.SurgePriceMax = pricing.SurgePriceMax
+	calculator.SurgeDisplayMax = pricing.SurgeDisplayMax
+	calculator.PerWaitMinuteRate = utils.NewMoney(pricing.PerWaitMinuteRate)
+	calculator.FreeWaitMins = pricing.FreeWaitTime.Minutes()
+	return calculator
+}
+
+// calculatorFor returns the calculator for a vehicle tier. Tiers without
+// their own pricing, economy included, use the top-level rates.
+func (s *RideService) calculatorFor(tier entities.VehicleTier) *utils.PricingCalculator {
+	if calculator, ok := s.tierCalculators[tier]; ok {
+		return calculator
+	}
+	return s.calculator
+}
+
+// tripFare prices a ride's trip of the given length as driven: the quote for
+// that distance and time, plus the driver's wait at the pickup, minus the
+// ride's promo. It is the fare for a trip in progress or just completed, as
+// opposed to quoteFare's estimate for a trip not yet taken.
+func (s *RideService) tripFare(ctx context.Context, ride *entities.Ride, distanceKm, durationMins float64) utils.FareEstimate {
+	fare := s.quoteFare(ctx, ride.VehicleTier, ride.Source, distanceKm, durationMins)
+	fare = s.calculatorFor(ride.VehicleTier).AddWaitCharge(fare, ride.WaitTime().Minutes())
+	return discounted(fare, ride.Promo)
+}
+
+// quoteFare prices a trip of the given length in a tier's car from pickup at
+// the current surge.
+func (s *RideService) quoteFare(ctx context.Context, tier entities.VehicleTier, pickup entities.Location, distanceKm, durationMins float64) utils.FareEstimate {
+	return s.calculatorFor(tier).CalculateFare(distanceKm, durationMins, s.surge(ctx, pickup))
+}
+
+// lookupPromo finds the promo code a rider entered and checks it can still be
+// applied.
+func (s *RideService) lookupPromo(ctx context.Context, code string) (*entities.PromoCode, error) {
+	promo, err := s.promoRepo.GetByCode(ctx, code)
+	if err != nil {
+		return nil, ErrInvalidPromoCode
+	}
+	if promo.Expired(time.Now()) {
+		return nil, ErrPromoCodeExpired
 	}
+	return promo, nil
 }
 
-// FareEstimateRequest contains the pickup and dropoff locations for a fare estimate.
+// discounted takes a ride's promo code, if it has one, off a fare.
+func discounted(fare utils.FareEstimate, promo *entities.PromoCode) utils.FareEstimate {
+	if promo == nil {
+		return fare
+	}
+	return fare.WithDiscount(promo.DiscountOn(fare.TotalFare))
+}
+
+// lockFare guarantees the ride's current EstimatedFare for FareLockWindow.
+func (s *RideService) lockFare(ride *entities.Ride, now time.Time) {
+	ride.FareLockExpiresAt = now.Add(s.config.Pricing.FareLockWindow)
+}
+
+// FareEstimateRequest contains the pickup and dropoff locations for a fare
+// estimate, and any Waypoints to stop at in between, in order. Contactless requests a delivery-style ride with the shortened
+// lifecycle (no InProgress phase). VehicleTier picks the class of car the
+// trip is priced and matched for; empty means economy. PromoCode, if set, must
+// name a valid, unexpired promo code, which is then applied to the fare.
 type FareEstimateRequest struct {
-	Source      entities.Location `json:"source"`
-	Destination entities.Location `json:"destination"`
+	Source      entities.Location     `json:"source"`
+	Destination entities.Location     `json:"destination"`
+	Waypoints   []entities.Location   `json:"waypoints"`
+	Contactless bool                  `json:"contactless"`
+	Category    entities.RideCategory `json:"category"`
+	VehicleTier entities.VehicleTier  `json:"vehicle_tier"`
+	PromoCode   string                `json:"promo_code"`
 }
 
 // FareEstimateResponse contains the computed fare breakdown, distance, and
-// duration. The RideID can be used to later request this ride.
+// duration. The RideID can be used to later request this ride. Warning is
+// set for trips short enough to suggest an input mistake.
+//
+// FareLockExpiresAt is when the quoted fare stops being guaranteed.
+//
+// EstimatedPickupMins is how long the nearest available driver would take to
+// reach the pickup point. It is a pointer so that "no drivers nearby"
+// serializes as JSON null rather than a misleading 0.
 type FareEstimateResponse struct {
-	RideID       string             `json:"ride_id"`
-	Source       entities.Location  `json:"source"`
-	Destination  entities.Location  `json:"destination"`
-	DistanceKm   float64            `json:"distance_km"`
-	DurationMins float64            `json:"duration_mins"`
-	Fare         utils.FareEstimate `json:"fare"`
+	RideID              string                `json:"ride_id"`
+	Category            entities.RideCategory `json:"category"`
+	VehicleTier         entities.VehicleTier  `json:"vehicle_tier"`
+	Contactless         bool                  `json:"contactless,omitempty"`
+	Source              entities.Location     `json:"source"`
+	Destination         entities.Location     `json:"destination"`
+	Waypoints           []entities.Location   `json:"waypoints,omitempty"`
+	DistanceKm          float64               `json:"distance_km"`
+	DurationMins        float64               `json:"duration_mins"`
+	EstimatedPickupMins *float64              `json:"estimated_pickup_mins"`
+	Fare                utils.FareEstimate    `json:"fare"`
+	FareLockExpiresAt   time.Time             `json:"fare_lock_expires_at"`
+	ExpiresAt           time.Time             `json:"expires_at,omitempty"`
+	Warning             string                `json:"warning,omitempty"`
 }
 
 // CreateFareEstimate calculates the fare for a trip and creates a Ride entity
 // in the Estimate state. The rider can later confirm this estimate to request
 // an actual ride.
+//
+// Identical source and destination are rejected with ErrSameLocation rather
+// than silently priced at the minimum fare. Very short (but non-zero) trips
+// are allowed and flagged with a warning.
+//
+// A promo code is checked up front: an unknown one fails with
+// ErrInvalidPromoCode and an expired one with ErrPromoCodeExpired, rather
+// than quoting the full fare as if none had been entered. A valid code is
+// copied onto the ride, so every later re-quote of it — including the final
+// fare — gets the same discount even if the code expires in the meantime.
+//
+// A multi-stop trip is priced on its distance through every waypoint. More
+// waypoints than RideConfig.MaxWaypoints fail with ErrTooManyWaypoints, and
+// any of them off the globe with ErrInvalidLocation.
 func (s *RideService) CreateFareEstimate(ctx context.Context, riderID string, req FareEstimateRequest) (*FareEstimateResponse, error) {
+	if !req.Source.Valid() || !req.Destination.Valid() {
+		return nil, ErrInvalidLocation
+	}
+	if limit := s.config.Ride.MaxWaypoints; limit > 0 && len(req.Waypoints) > limit {
+		return nil, ErrTooManyWaypoints
+	}
+	for _, stop := range req.Waypoints {
+		if !stop.Valid() {
+			return nil, ErrInvalidLocation
+		}
+	}
+	if req.Source == req.Destination {
+		return nil, ErrSameLocation
+	}
+
 	// Ensure rider exists
 	_, err := s.riderRepo.GetOrCreate(ctx, riderID)
 	if err != nil {
@@ -103,14 +336,29 @@ func (s *RideService) CreateFareEstimate(ctx context.Context, riderID string, re
 	}
 
 	// Calculate distance and duration
-	distanceKm := utils.HaversineDistance(
-		req.Source.Latitude, req.Source.Longitude,
-		req.Destination.Latitude, req.Destination.Longitude,
-	)
+	distanceKm := routeDistanceKm(req.Source, req.Waypoints, req.Destination)
+	if s.routeTooLong(distanceKm) {
+		return nil, ErrRouteTooLong
+	}
 	durationMins := utils.EstimateDuration(distanceKm)
 
-	// Calculate fare (no surge for MVP)
-	fare := s.calculator.CalculateFare(distanceKm, durationMins, 1.0)
+	var promo *entities.PromoCode
+	if req.PromoCode != "" {
+		found, err := s.lookupPromo(ctx, req.PromoCode)
+		if err != nil {
+			return nil, err
+		}
+		applied := *found
+		promo = &applied
+	}
+
+	// Calculate fare for the tier at the surge currently in effect at the
+	// pickup point.
+	tier := req.VehicleTier
+	if tier == "" {
+		tier = entities.VehicleTierEconomy
+	}
+	fare := discounted(s.quoteFare(ctx, tier, req.Source, distanceKm, durationMins), promo)
 
 	// Create ride entity
 	rideID := utils.GenerateID()
@@ -123,25 +371,95 @@ func (s *RideService) CreateFareEstimate(ctx context.Context, riderID string, re
 		distanceKm,
 		durationMins,
 	)
+	ride.Contactless = req.Contactless
+	ride.Waypoints = req.Waypoints
+	if req.Category != "" {
+		ride.Category = req.Category
+	}
+	ride.VehicleTier = tier
+	ride.Promo = promo
+	s.lockFare(ride, ride.CreatedAt)
+	if ttl := s.config.Pricing.EstimateTTL; ttl > 0 {
+		ride.ExpiresAt = ride.CreatedAt.Add(ttl)
+	}
+
+	// Look up the pickup ETA before saving, so an estimate that fails here
+	// doesn't leave a ride behind that nobody was given the ID of.
+	pickupMins, found, err := s.locationService.EstimateNearestDriverETA(
+		ctx,
+		req.Source.Latitude, req.Source.Longitude,
+		s.config.MatchingFor(string(ride.Category)).SearchRadiusKm,
+	)
+	if err != nil {
+		return nil, err
+	}
 
 	// Save ride
 	if err := s.rideRepo.Create(ctx, ride); err != nil {
 		return nil, err
 	}
 
-	return &FareEstimateResponse{
-		RideID:       rideID,
-		Source:       req.Source,
-		Destination:  req.Destination,
-		DistanceKm:   distanceKm,
-		DurationMins: durationMins,
-		Fare:         fare,
-	}, nil
+	response := &FareEstimateResponse{
+		RideID:            rideID,
+		Category:          ride.Category,
+		VehicleTier:       ride.VehicleTier,
+		Contactless:       req.Contactless,
+		Source:            req.Source,
+		Destination:       req.Destination,
+		Waypoints:         req.Waypoints,
+		DistanceKm:        distanceKm,
+		DurationMins:      durationMins,
+		Fare:              fare,
+		FareLockExpiresAt: ride.FareLockExpiresAt,
+		ExpiresAt:         ride.ExpiresAt,
+	}
+	if distanceKm < s.config.Pricing.ShortTripWarningKm {
+		response.Warning = ShortTripWarning
+	}
+
+	if found {
+		response.EstimatedPickupMins = &pickupMins
+	}
+
+	return response, nil
+}
+
+// RepeatRide creates a fresh estimate for the same trip as one of the rider's
+// earlier rides — same source, stops, destination, category, vehicle tier
+// and contactless choice — priced at current rates and surge. The original ride
+// is left untouched, and the new estimate is requested like any other. Riders
+// can only repeat their own rides.
+func (s *RideService) RepeatRide(ctx context.Context, riderID, rideID string) (*FareEstimateResponse, error) {
+	ride, err := s.rideRepo.GetByID(ctx, rideID)
+	if err != nil {
+		return nil, ErrRideNotFound
+	}
+
+	if ride.RiderID != riderID {
+		return nil, ErrNotAuthorized
+	}
+
+	return s.CreateFareEstimate(ctx, riderID, FareEstimateRequest{
+		Source:      ride.Source,
+		Destination: ride.Destination,
+		Waypoints:   ride.Waypoints,
+		Contactless: ride.Contactless,
+		Category:    ride.Category,
+		VehicleTier: ride.VehicleTier,
+	})
 }
 
 // RequestRide transitions a ride from Estimate to Requested. This is the
 // rider confirming they want the ride. It checks authorization (is this the
 // rider's ride?) and idempotency (does the rider already have an active ride?).
+//
+// Within the fare lock window the quoted fare is honored whatever the surge
+// is doing now. After it, the ride is re-priced: if the fare is unchanged the
+// request goes ahead, otherwise the new fare is stored with a fresh lock and
+// the re-priced ride is returned with ErrFareExpired, asking the rider to
+// confirm it by requesting again. An estimate
+// past its ExpiresAt (PricingConfig.EstimateTTL) can't be requested at all
+// and returns ErrEstimateExpired.
 func (s *RideService) RequestRide(ctx context.Context, riderID, rideID string) (*entities.Ride, error) {
 	// Check for existing active ride
 	activeRide, _ := s.rideRepo.GetActiveRideByRiderID(ctx, riderID)
@@ -158,38 +476,407 @@ func (s *RideService) RequestRide(ctx context.Context, riderID, rideID string) (
 		return nil, ErrNotAuthorized
 	}
 
+	// Estimates aren't swept; an expired one is found when the rider tries
+	// to request it and cancelled then, so it reads as over everywhere else
+	// too. Later attempts get the same error.
+	now := time.Now()
+	if ride.EstimateExpired(now) {
+		if ride.CurrentStatus() == entities.RideStatusEstimate {
+			if err := ride.Cancel(); err == nil {
+				s.saveRide(ctx, ride)
+			}
+		}
+		return nil, ErrEstimateExpired
+	}
+
+	if ride.CurrentStatus() == entities.RideStatusEstimate && !ride.FareLocked(now) {
+		fare := discounted(s.quoteFare(ctx, ride.VehicleTier, ride.Source, ride.DistanceKm, ride.DurationMins), ride.Promo)
+		if fare.TotalFare != ride.EstimatedFare {
+			ride.Update(func() {
+				ride.EstimatedFare = fare.TotalFare
+				ride.UpdatedAt = now
+				s.lockFare(ride, now)
+			})
+			if err := s.saveRide(ctx, ride); err != nil {
+				return nil, err
+			}
+			return ride, ErrFareExpired
+		}
+	}
+
 	if err := ride.Request(); err != nil {
 		return nil, ErrInvalidTransition
 	}
+	ride.Update(func() { ride.ExpiresAt = time.Time{} })
 
-	if err := s.rideRepo.Update(ctx, ride); err != nil {
+	if err := s.saveRide(ctx, ride); err != nil {
 		return nil, err
 	}
 
+	s.demand.RecordRequest(ride.ID, ride.Source)
+
 	return ride, nil
 }
 
+// UpdatePickupLocation moves the pickup point of a ride that has not been
+// accepted yet (Requested or Matching) — e.g. the rider walked to a corner.
+// Distance, duration and the estimated fare are recomputed for the new point.
+// Once a driver has accepted, the pickup is locked and ErrPickupLocked is
+// returned. The caller is responsible for telling an in-flight matching loop
+// about the move (MatchingService.UpdatePickup).
+func (s *RideService) UpdatePickupLocation(ctx context.Context, riderID, rideID string, pickup entities.Location) (*entities.Ride, error) {
+	ride, err := s.rideRepo.GetByID(ctx, rideID)
+	if err != nil {
+		return nil, ErrRideNotFound
+	}
+
+	if ride.RiderID != riderID {
+		return nil, ErrNotAuthorized
+	}
+
+	if status := ride.CurrentStatus(); status != entities.RideStatusRequested && status != entities.RideStatusMatching {
+		return nil, ErrPickupLocked
+	}
+
+	if !pickup.Valid() {
+		return nil, ErrInvalidLocation
+	}
+	if pickup == ride.Destination {
+		return nil, ErrSameLocation
+	}
+
+	distanceKm := routeDistanceKm(pickup, ride.Waypoints, ride.Destination)
+	if s.routeTooLong(distanceKm) {
+		return nil, ErrRouteTooLong
+	}
+	durationMins := utils.EstimateDuration(distanceKm)
+	fare := discounted(s.quoteFare(ctx, ride.VehicleTier, pickup, distanceKm, durationMins), ride.Promo)
+
+	// A driver may have accepted while the new fare was being quoted.
+	if !ride.MovePickup(pickup, distanceKm, durationMins, fare.TotalFare) {
+		return nil, ErrPickupLocked
+	}
+
+	if err := s.saveRide(ctx, ride); err != nil {
+		return nil, err
+	}
+
+	return ride, nil
+}
+
+// AvailabilityPreview summarizes driver supply around a pickup point before
+// the rider asks for an estimate ("3 cars nearby, closest 2 min"). Count is
+// every available driver in range; ETAsMins holds the nearest few, ascending,
+// capped at MatchingConfig.AvailabilityPreviewMax. ClosestMins is null when no
+// driver is in range.
+type AvailabilityPreview struct {
+	Count       int       `json:"count"`
+	ETAsMins    []float64 `json:"etas_mins"`
+	ClosestMins *float64  `json:"closest_mins"`
+}
+
+// PreviewAvailability reports nearby driver supply for a pickup point, using
+// the search radius of the given ride category.
+func (s *RideService) PreviewAvailability(ctx context.Context, pickup entities.Location, category entities.RideCategory) (*AvailabilityPreview, error) {
+	count, etas, err := s.locationService.EstimateNearbyDriverETAs(
+		ctx,
+		pickup.Latitude, pickup.Longitude,
+		s.config.MatchingFor(string(category)).SearchRadiusKm,
+		s.config.Matching.AvailabilityPreviewMax,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &AvailabilityPreview{Count: count, ETAsMins: etas}
+	if len(etas) > 0 {
+		preview.ClosestMins = &etas[0]
+	}
+	return preview, nil
+}
+
+// DemandContext describes how busy the area around a pickup is, for a driver
+// weighing a ride offer. SurgeMultiplier is the billed multiplier at the
+// pickup; PendingRequests counts the rides waiting for a driver in the
+// pickup's demand cell, including the one being offered.
+type DemandContext struct {
+	Cell            string  `json:"cell"`
+	SurgeMultiplier float64 `json:"surge_multiplier"`
+	PendingRequests int     `json:"pending_requests"`
+}
+
+// DemandContextAt reports current surge and pending demand at a pickup point.
+func (s *RideService) DemandContextAt(ctx context.Context, pickup entities.Location) DemandContext {
+	snapshot := s.demand.Snapshot(pickup)
+	return DemandContext{
+		Cell:            snapshot.Cell,
+		SurgeMultiplier: utils.ClampSurge(s.surge(ctx, pickup), s.calculator.SurgePriceMax),
+		PendingRequests: snapshot.Demand,
+	}
+}
+
 // GetRide retrieves a ride by ID
 func (s *RideService) GetRide(ctx context.Context, rideID string) (*entities.Ride, error) {
 	return s.rideRepo.GetByID(ctx, rideID)
 }
 
+// PickupETA is how long the driver assigned to a ride needs to reach its
+// pickup, as of their last location ping.
+type PickupETA struct {
+	RideID   string  `json:"ride_id"`
+	DriverID string  `json:"driver_id"`
+	ETAMins  float64 `json:"eta_mins"`
+}
+
+// GetPickupETA estimates when the driver assigned to the rider's ride will
+// reach the pickup. It only applies between a driver accepting and the trip
+// starting; before or after that it fails with ErrNoDriverEnRoute, and with
+// ErrDriverLocationUnknown while the driver has no stored location.
+func (s *RideService) GetPickupETA(ctx context.Context, riderID, rideID string) (*PickupETA, error) {
+	ride, err := s.rideRepo.GetByID(ctx, rideID)
+	if err != nil {
+		return nil, ErrRideNotFound
+	}
+	if ride.RiderID != riderID {
+		return nil, ErrNotAuthorized
+	}
+	driverID := ride.AssignedDriver()
+	if status := ride.CurrentStatus(); driverID == "" ||
+		(status != entities.RideStatusAccepted && status != entities.RideStatusPickingUp) {
+		return nil, ErrNoDriverEnRoute
+	}
+
+	pickup := ride.Pickup()
+	etaMins, err := s.locationService.EstimatePickupETA(ctx, driverID, pickup.Latitude, pickup.Longitude)
+	if err != nil {
+		return nil, err
+	}
+	return &PickupETA{RideID: ride.ID, DriverID: driverID, ETAMins: etaMins}, nil
+}
+
+// ObserveDriverLocation looks at a location ping from a driver on a ride. If
+// the driver is picking the rider up and their estimated time to the pickup
+// has dropped below RideConfig.ArrivingSoonThreshold, the ArrivingSoonFunc is
+// called for the ride. That happens once per ride: GPS jitter can carry the
+// estimate back and forth across the threshold, and the rider only needs to
+// hear it the first time. Contactless rides are skipped, since nobody waits
+// at their pickup.
+func (s *RideService) ObserveDriverLocation(ctx context.Context, location *entities.DriverLocation) {
+	threshold := s.config.Ride.ArrivingSoonThreshold
+	if threshold <= 0 || s.arrivingSoon == nil {
+		return
+	}
+
+	ride, err := s.GetActiveRideForDriver(ctx, location.DriverID)
+	if err != nil || ride == nil || ride.CurrentStatus() != entities.RideStatusPickingUp || ride.Contactless {
+		return
+	}
+
+	distanceKm := utils.HaversineDistance(
+		location.Location.Latitude, location.Location.Longitude,
+		ride.Source.Latitude, ride.Source.Longitude,
+	)
+	if utils.EstimateDuration(distanceKm) > threshold.Minutes() {
+		return
+	}
+
+	s.arrivingMu.Lock()
+	if !ride.ArrivingSoonAt.IsZero() {
+		s.arrivingMu.Unlock()
+		return
+	}
+	ride.Update(func() { ride.ArrivingSoonAt = time.Now() })
+	err = s.saveRide(ctx, ride)
+	s.arrivingMu.Unlock()
+	if err != nil {
+		return
+	}
+
+	s.arrivingSoon(ride)
+}
+
+// GetActiveRide returns the rider's ride that is requested, being matched,
+// under way or waiting for them to confirm its completion, or nil if they have
+// none. A rider app polls this after
+// requesting a ride to follow it through matching without having to keep the
+// ride ID around.
+func (s *RideService) GetActiveRide(ctx context.Context, riderID string) (*entities.Ride, error) {
+	return s.rideRepo.GetActiveRideByRiderID(ctx, riderID)
+}
+
+// DefaultHistoryLimit is the page size of a rider's ride history when none
+// is asked for; MaxHistoryLimit is the largest page RideHistory will return.
+const (
+	DefaultHistoryLimit = 20
+	MaxHistoryLimit     = 100
+)
+
+// RideHistory is one page of a rider's rides, newest first. Total counts all
+// of the rider's rides, so a client knows there are more while
+// Offset+len(Rides) < Total.
+type RideHistory struct {
+	Rides  []*entities.Ride `json:"rides"`
+	Total  int              `json:"total"`
+	Limit  int              `json:"limit"`
+	Offset int              `json:"offset"`
+}
+
+// RideHistory returns the page of the rider's rides starting offset rides in
+// from the newest, at most limit of them. Every ride is included, open
+// estimates and the active ride as well as finished trips. A limit outside 1
+// to MaxHistoryLimit, or a negative offset, returns ErrInvalidPagination; an
+// offset past the last ride returns an empty page.
+func (s *RideService) RideHistory(ctx context.Context, riderID string, limit, offset int) (*RideHistory, error) {
+	if limit < 1 || limit > MaxHistoryLimit || offset < 0 {
+		return nil, ErrInvalidPagination
+	}
+	rides, total, err := s.rideRepo.GetByRiderIDPaged(ctx, riderID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &RideHistory{Rides: rides, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+// GetActiveRideForDriver returns the driver's current non-terminal assigned
+// ride, or nil if they have none. A driver app calls this on reopen to resume
+// whatever ride it was handling. A ride waiting for the rider to confirm
+// completion is already over for the driver, so it doesn't count.
+func (s *RideService) GetActiveRideForDriver(ctx context.Context, driverID string) (*entities.Ride, error) {
+	rides, err := s.rideRepo.GetByDriverID(ctx, driverID)
+	if err != nil {
+		return nil, err
+	}
+	for _, ride := range rides {
+		if status := ride.CurrentStatus(); !status.IsTerminal() && status != entities.RideStatusPendingConfirmation {
+			return ride, nil
+		}
+	}
+	return nil, nil
+}
+
+// FareProjection is the running fare of a trip in progress: what the ride
+// would cost if it ended now, from the distance driven and time elapsed so far.
+type FareProjection struct {
+	RideID       string             `json:"ride_id"`
+	DistanceKm   float64            `json:"distance_km"`
+	DurationMins float64            `json:"duration_mins"`
+	Fare         utils.FareEstimate `json:"fare"`
+}
+
+// ProjectTripFare prices the driver's in-progress ride on the distance their
+// location pings have covered since the trip started (see
+// LocationService.TripDistanceKm) and the time elapsed, plus any wait charge
+// already incurred at the pickup. Surge is taken at the pickup point as it
+// stands now. Returns ErrNoTripInProgress if the driver's
+// active ride hasn't started yet, or they have none.
+func (s *RideService) ProjectTripFare(ctx context.Context, driverID string) (*FareProjection, error) {
+	ride, err := s.GetActiveRideForDriver(ctx, driverID)
+	if err != nil {
+		return nil, err
+	}
+	if ride == nil || ride.CurrentStatus() != entities.RideStatusInProgress {
+		return nil, ErrNoTripInProgress
+	}
+
+	distanceKm, err := s.locationService.TripDistanceKm(ctx, driverID, ride.Source, ride.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	durationMins := time.Since(ride.StartedAt).Minutes()
+
+	return &FareProjection{
+		RideID:       ride.ID,
+		DistanceKm:   distanceKm,
+		DurationMins: durationMins,
+		Fare:         s.tripFare(ctx, ride, distanceKm, durationMins),
+	}, nil
+}
+
 // UpdateRideStatus advances a ride through its lifecycle (driver-side).
 // It also keeps the driver's status in sync — when a ride starts, the driver
 // is marked as InRide; when it completes or is cancelled, the driver becomes
 // Available again. This dual-update is a business rule: ride state and driver
 // state must always be consistent.
+//
+// A driver cancelling before pickup (Accepted or PickingUp) does not cancel
+// the ride: it goes back to Matching with no driver assigned, and the returned
+// ride's Matching status tells the caller to restart matching without them.
+//
+// With RideConfig.RiderConfirmsCompletion, a driver completing the ride moves
+// it to PendingConfirmation instead (see ConfirmCompletion). The driver is
+// still freed straight away; only the ride waits.
 func (s *RideService) UpdateRideStatus(ctx context.Context, driverID, rideID string, newStatus entities.RideStatus) (*entities.Ride, error) {
+	return s.updateRideStatus(ctx, driverID, rideID, newStatus, nil)
+}
+
+// tripLength is the distance and duration a trip actually took.
+type tripLength struct {
+	distanceKm   float64
+	durationMins float64
+}
+
+// CompleteRide is UpdateRideStatus to Completed for a driver app that
+// measured the trip: instead of charging the quoted EstimatedFare, the fare is
+// recomputed from the actual distance and duration, with the minimum fare
+// still applied. Time the driver spent waiting at the pickup beyond
+// PricingConfig.FreeWaitTime is added as a wait charge (Ride.WaitFare). As in
+// ProjectTripFare, surge is taken at the pickup point as it stands now. With RideConfig.RiderConfirmsCompletion the fare is set as
+// the ride enters PendingConfirmation, so the rider confirms the amount they
+// will pay. Negative measurements return ErrInvalidTripLength.
+func (s *RideService) CompleteRide(ctx context.Context, driverID, rideID string, actualDistanceKm, actualDurationMins float64) (*entities.Ride, error) {
+	if actualDistanceKm < 0 || actualDurationMins < 0 {
+		return nil, ErrInvalidTripLength
+	}
+	return s.updateRideStatus(ctx, driverID, rideID, entities.RideStatusCompleted,
+		&tripLength{distanceKm: actualDistanceKm, durationMins: actualDurationMins})
+}
+
+// updateRideStatus implements UpdateRideStatus and CompleteRide. trip, if
+// not nil, prices a completing ride.
+func (s *RideService) updateRideStatus(ctx context.Context, driverID, rideID string, newStatus entities.RideStatus, trip *tripLength) (*entities.Ride, error) {
 	ride, err := s.rideRepo.GetByID(ctx, rideID)
 	if err != nil {
 		return nil, ErrRideNotFound
 	}
 
-	if ride.DriverID != driverID {
+	if ride.AssignedDriver() != driverID {
 		return nil, ErrNotAuthorized
 	}
 
-	if err := ride.TransitionTo(newStatus); err != nil {
+	// A finished ride can't move at all, whatever the target. Reporting that
+	// separately from ErrInvalidTransition lets a client tell "you're too late"
+	// (e.g. a retried completion) apart from "that step isn't allowed yet".
+	status := ride.CurrentStatus()
+	if status.IsTerminal() {
+		return nil, ErrRideTerminal
+	}
+
+	if newStatus == entities.RideStatusCancelled &&
+		(status == entities.RideStatusAccepted || status == entities.RideStatusPickingUp) {
+		return s.reassignRide(ctx, driverID, ride)
+	}
+
+	target := newStatus
+	if newStatus == entities.RideStatusCompleted && s.config.Ride.RiderConfirmsCompletion {
+		target = entities.RideStatusPendingConfirmation
+	}
+	// The measured fare and the confirmation deadline are set in the same
+	// step as the status, so nobody sees a completed ride charging the
+	// quote or one awaiting confirmation without a deadline.
+	var fare *utils.FareEstimate
+	if trip != nil {
+		priced := s.tripFare(ctx, ride, trip.distanceKm, trip.durationMins)
+		fare = &priced
+	}
+	if err := ride.TransitionToWith(target, func() {
+		if target == entities.RideStatusPendingConfirmation {
+			ride.ConfirmBy = ride.UpdatedAt.Add(s.config.Ride.ConfirmationTimeout)
+		}
+		if fare != nil {
+			ride.ActualFare = fare.TotalFare
+			ride.WaitFare = fare.WaitFare
+		}
+	}); err != nil {
 		return nil, ErrInvalidTransition
 	}
 
@@ -205,10 +892,185 @@ func (s *RideService) UpdateRideStatus(ctx context.Context, driverID, rideID str
 		s.driverRepo.Update(ctx, driver)
 	}
 
-	if err := s.rideRepo.Update(ctx, ride); err != nil {
+	if err := s.saveRide(ctx, ride); err != nil {
+		return nil, err
+	}
+
+	if target == entities.RideStatusPendingConfirmation {
+		s.scheduleAutoConfirm(ride.ID, s.config.Ride.ConfirmationTimeout)
+	}
+
+	return ride, nil
+}
+
+// ConfirmCompletion is the rider agreeing that a PendingConfirmation ride is
+// over, which completes it and finalizes the fare. A ride that isn't waiting
+// for confirmation returns ErrInvalidTransition, or ErrRideTerminal once it
+// has already completed (including by timing out).
+func (s *RideService) ConfirmCompletion(ctx context.Context, riderID, rideID string) (*entities.Ride, error) {
+	s.confirmMu.Lock()
+	defer s.confirmMu.Unlock()
+
+	ride, err := s.rideRepo.GetByID(ctx, rideID)
+	if err != nil {
+		return nil, ErrRideNotFound
+	}
+
+	if ride.RiderID != riderID {
+		return nil, ErrNotAuthorized
+	}
+
+	if ride.CurrentStatus().IsTerminal() {
+		return nil, ErrRideTerminal
+	}
+
+	if err := s.completeLocked(ctx, ride); err != nil {
+		return nil, err
+	}
+	return ride, nil
+}
+
+// scheduleAutoConfirm completes rideID after timeout unless the rider
+// confirms first.
+//
+// Go Learning Note — time.AfterFunc:
+// AfterFunc runs a function on its own goroutine once the duration elapses
+// and returns a *time.Timer whose Stop cancels it if it hasn't fired yet.
+// Stop can lose the race with a timer that is just firing, so the callback
+// re-checks the ride's status under confirmMu rather than trusting that it
+// was never stopped.
+func (s *RideService) scheduleAutoConfirm(rideID string, timeout time.Duration) {
+	s.confirmMu.Lock()
+	defer s.confirmMu.Unlock()
+
+	s.confirmTimers[rideID] = time.AfterFunc(timeout, func() {
+		s.confirmMu.Lock()
+		defer s.confirmMu.Unlock()
+		delete(s.confirmTimers, rideID)
+
+		ride, err := s.rideRepo.GetByID(context.Background(), rideID)
+		if err != nil || ride.CurrentStatus() != entities.RideStatusPendingConfirmation {
+			return
+		}
+		s.completeLocked(context.Background(), ride)
+	})
+}
+
+// completeLocked moves a PendingConfirmation ride to Completed, stops its
+// timeout, and reports it to the CompletionFunc. The caller holds confirmMu.
+func (s *RideService) completeLocked(ctx context.Context, ride *entities.Ride) error {
+	if ride.CurrentStatus() != entities.RideStatusPendingConfirmation {
+		return ErrInvalidTransition
+	}
+	if err := ride.Complete(); err != nil {
+		return ErrInvalidTransition
+	}
+	if timer, ok := s.confirmTimers[ride.ID]; ok {
+		timer.Stop()
+		delete(s.confirmTimers, ride.ID)
+	}
+	if err := s.saveRide(ctx, ride); err != nil {
+		return err
+	}
+	s.completed(ride)
+	return nil
+}
+
+// CancelRide cancels a ride on the rider's behalf, any time before the trip
+// starts. A driver already assigned is made available again. Cancelling while
+// the ride is still Requested or Matching needs RideConfig.RiderCancelWhileMatching;
+// without it, as once the trip is in progress, ErrInvalidTransition is
+// returned. The caller is responsible for stopping an in-flight matching loop
+// (MatchingService.CancelRide does both).
+//
+// A rider who cancels late is charged: see cancellationFee. The fee is
+// recorded on the ride as CancellationFee, priced in the same step as the
+// cancellation so it is for the driver the ride had at that moment.
+func (s *RideService) CancelRide(ctx context.Context, riderID, rideID string) (*entities.Ride, error) {
+	ride, err := s.rideRepo.GetByID(ctx, rideID)
+	if err != nil {
+		return nil, ErrRideNotFound
+	}
+
+	if ride.RiderID != riderID {
+		return nil, ErrNotAuthorized
+	}
+
+	status := ride.CurrentStatus()
+	if status.IsTerminal() {
+		return nil, ErrRideTerminal
+	}
+
+	switch status {
+	case entities.RideStatusRequested, entities.RideStatusMatching:
+		if !s.config.Ride.RiderCancelWhileMatching {
+			return nil, ErrInvalidTransition
+		}
+	case entities.RideStatusInProgress:
+		return nil, ErrInvalidTransition
+	}
+
+	now := time.Now()
+	if err := ride.CancelWithFee(func(driverID string, acceptedAt time.Time) utils.Money {
+		return s.cancellationFee(driverID, acceptedAt, now)
+	}); err != nil {
+		return nil, ErrInvalidTransition
+	}
+	s.demand.Resolve(ride.ID)
+
+	if driverID := ride.AssignedDriver(); driverID != "" {
+		driver, err := s.driverRepo.GetByID(ctx, driverID)
+		if err == nil {
+			driver.EndRide()
+			s.driverRepo.Update(ctx, driver)
+		}
+	}
+
+	if err := s.saveRide(ctx, ride); err != nil {
+		return nil, err
+	}
+
+	return ride, nil
+}
+
+// cancellationFee is what the rider owes for cancelling at now a ride that
+// driverID accepted at acceptedAt (see Ride.CancelWithFee). It is
+// free before a driver has accepted and within
+// CancellationConfig.FreeCancelWindow of the acceptance; after that it is the
+// flat CancellationConfig.Fee, rounded for the fare currency.
+//
+// AcceptedAt is cleared when a driver backs out and the ride is reassigned, so
+// the window restarts with whichever driver accepts next: the rider isn't
+// charged for a wait the new driver hasn't made them sit through.
+func (s *RideService) cancellationFee(driverID string, acceptedAt, now time.Time) utils.Money {
+	if driverID == "" || acceptedAt.IsZero() {
+		return 0
+	}
+	if now.Sub(acceptedAt) < s.config.Cancellation.FreeCancelWindow {
+		return 0
+	}
+	return utils.NewMoney(s.config.Cancellation.Fee).Round(s.config.Pricing.CurrencyCode)
+}
+
+// reassignRide releases the cancelling driver and puts the ride back into
+// Matching. The ride counts as pending demand again until it is re-matched.
+func (s *RideService) reassignRide(ctx context.Context, driverID string, ride *entities.Ride) (*entities.Ride, error) {
+	if err := ride.Reassign(); err != nil {
+		return nil, ErrInvalidTransition
+	}
+
+	driver, err := s.driverRepo.GetByID(ctx, driverID)
+	if err == nil {
+		driver.EndRide()
+		s.driverRepo.Update(ctx, driver)
+	}
+
+	if err := s.saveRide(ctx, ride); err != nil {
 		return nil, err
 	}
 
+	s.demand.RecordRequest(ride.ID, ride.Source)
+
 	return ride, nil
 }
 
@@ -229,6 +1091,7 @@ func (s *RideService) AcceptRide(ctx context.Context, driverID, rideID string, a
 	if err := ride.Accept(driverID); err != nil {
 		return nil, ErrInvalidTransition
 	}
+	s.demand.Resolve(ride.ID)
 
 	// Update driver status
 	driver, err := s.driverRepo.GetByID(ctx, driverID)
@@ -237,7 +1100,7 @@ func (s *RideService) AcceptRide(ctx context.Context, driverID, rideID string, a
 		s.driverRepo.Update(ctx, driver)
 	}
 
-	if err := s.rideRepo.Update(ctx, ride); err != nil {
+	if err := s.saveRide(ctx, ride); err != nil {
 		return nil, err
 	}
 
@@ -249,7 +1112,7 @@ func (s *RideService) StartMatching(ctx context.Context, ride *entities.Ride) er
 	if err := ride.StartMatching(); err != nil {
 		return err
 	}
-	return s.rideRepo.Update(ctx, ride)
+	return s.saveRide(ctx, ride)
 }
 
 // FailMatching marks a ride as failed to find a driver
@@ -261,5 +1124,6 @@ func (s *RideService) FailMatching(ctx context.Context, rideID string) error {
 	if err := ride.Fail(); err != nil {
 		return err
 	}
-	return s.rideRepo.Update(ctx, ride)
+	s.demand.Resolve(ride.ID)
+	return s.saveRide(ctx, ride)
 }
@@ -2,19 +2,27 @@ package services
 
 import (
 	"context"
+	"math"
 	"testing"
+	"time"
 	"uber/internal/config"
 	"uber/internal/domain/entities"
+	"uber/internal/geo"
 	"uber/internal/repository/memory"
+	"uber/pkg/utils"
 )
 
 func setupRideService() (*RideService, *memory.RideRepository, *memory.RiderRepository, *memory.DriverRepository) {
 	rideRepo := memory.NewRideRepository()
+	promoRepo := memory.NewPromoCodeRepository()
 	riderRepo := memory.NewRiderRepository()
 	driverRepo := memory.NewDriverRepository()
 	cfg := config.NewDefaultConfig()
 
-	service := NewRideService(rideRepo, riderRepo, driverRepo, cfg)
+	spatialIndex := geo.NewSpatialIndex(cfg.Geo.GeohashPrecision)
+	locationService := NewLocationService(spatialIndex, driverRepo, memory.NewLocationRepository())
+	demandTracker := NewDemandTracker(spatialIndex, cfg.Geo.GeohashPrecision)
+	service := NewRideService(rideRepo, riderRepo, driverRepo, promoRepo, locationService, demandTracker, cfg)
 	return service, rideRepo, riderRepo, driverRepo
 }
 
@@ -52,6 +60,374 @@ func TestRideService_CreateFareEstimate(t *testing.T) {
 	}
 }
 
+func TestRideService_CreateFareEstimate_VehicleTiers(t *testing.T) {
+	service, rideRepo, _, _ := setupRideService()
+	ctx := context.Background()
+
+	fares := make(map[entities.VehicleTier]utils.Money)
+	for _, tier := range []entities.VehicleTier{"", entities.VehicleTierEconomy, entities.VehicleTierComfort, entities.VehicleTierXL} {
+		estimate, err := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+			Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+			Destination: entities.Location{Latitude: 37.80, Longitude: -122.38},
+			VehicleTier: tier,
+		})
+		if err != nil {
+			t.Fatalf("CreateFareEstimate(%q) failed: %v", tier, err)
+		}
+
+		want := tier
+		if want == "" {
+			want = entities.VehicleTierEconomy
+		}
+		ride, _ := rideRepo.GetByID(ctx, estimate.RideID)
+		if estimate.VehicleTier != want || ride.VehicleTier != want {
+			t.Errorf("Expected tier %s on the estimate and ride, got %s and %s", want, estimate.VehicleTier, ride.VehicleTier)
+		}
+		if ride.EstimatedFare != estimate.Fare.TotalFare {
+			t.Errorf("Expected the %s ride to store its quoted fare %v, got %v", want, estimate.Fare.TotalFare, ride.EstimatedFare)
+		}
+		fares[tier] = estimate.Fare.TotalFare
+	}
+
+	if fares[""] != fares[entities.VehicleTierEconomy] {
+		t.Errorf("Expected the default tier to be priced as economy, got %v and %v", fares[""], fares[entities.VehicleTierEconomy])
+	}
+	if !(fares[entities.VehicleTierEconomy] < fares[entities.VehicleTierComfort] && fares[entities.VehicleTierComfort] < fares[entities.VehicleTierXL]) {
+		t.Errorf("Expected economy < comfort < xl, got %+v", fares)
+	}
+
+	// The comfort fare is the comfort rates applied to the same trip.
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.38},
+		VehicleTier: entities.VehicleTierComfort,
+	})
+	comfort := service.config.Pricing.ForTier("comfort")
+	want := comfort.BaseFare + estimate.DistanceKm*comfort.PerKmRate + estimate.DurationMins*comfort.PerMinuteRate
+	if math.Abs(estimate.Fare.TotalFare.Float64()-want) > 0.01 {
+		t.Errorf("Expected comfort fare %.2f, got %v", want, estimate.Fare.TotalFare)
+	}
+}
+
+func TestRideService_CreateFareEstimate_PromoCodes(t *testing.T) {
+	service, rideRepo, _, _ := setupRideService()
+	ctx := context.Background()
+
+	service.promoRepo.Create(ctx, &entities.PromoCode{Code: "TENPCT", Type: entities.PromoTypePercentage, Value: 10})
+	service.promoRepo.Create(ctx, &entities.PromoCode{Code: "HALFCAP", Type: entities.PromoTypePercentage, Value: 50, MaxDiscount: utils.NewMoney(2)})
+	service.promoRepo.Create(ctx, &entities.PromoCode{Code: "FREERIDE", Type: entities.PromoTypeFlat, Value: 500})
+
+	trip := FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.38},
+	}
+	full, _ := service.CreateFareEstimate(ctx, "rider-1", trip)
+	fullFare := full.Fare.TotalFare
+
+	tests := []struct {
+		name         string
+		code         string
+		wantDiscount utils.Money
+	}{
+		{"Percentage", "tenpct", fullFare.Mul(0.1)},
+		{"Percentage capped at MaxDiscount", "HALFCAP", utils.NewMoney(2)},
+		{"Flat discount floors at zero", "FREERIDE", fullFare},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := trip
+			req.PromoCode = tt.code
+			estimate, err := service.CreateFareEstimate(ctx, "rider-1", req)
+			if err != nil {
+				t.Fatalf("CreateFareEstimate failed: %v", err)
+			}
+
+			if estimate.Fare.Discount != tt.wantDiscount {
+				t.Errorf("Expected discount %v, got %v", tt.wantDiscount, estimate.Fare.Discount)
+			}
+			if want := fullFare - tt.wantDiscount; estimate.Fare.TotalFare != want || estimate.Fare.TotalFare < 0 {
+				t.Errorf("Expected discounted total %v, got %v", want, estimate.Fare.TotalFare)
+			}
+
+			ride, _ := rideRepo.GetByID(ctx, estimate.RideID)
+			if ride.EstimatedFare != estimate.Fare.TotalFare || ride.Promo == nil {
+				t.Errorf("Expected the ride to keep the discounted fare and its promo, got %v and %+v", ride.EstimatedFare, ride.Promo)
+			}
+		})
+	}
+}
+
+func TestRideService_CreateFareEstimate_RejectsBadPromoCodes(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	service.promoRepo.Create(ctx, &entities.PromoCode{
+		Code:      "SUMMER",
+		Type:      entities.PromoTypeFlat,
+		Value:     5,
+		ExpiresAt: time.Now().Add(-time.Hour),
+	})
+
+	for code, wantErr := range map[string]error{
+		"SUMMER":  ErrPromoCodeExpired,
+		"NOSUCH1": ErrInvalidPromoCode,
+	} {
+		_, err := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+			Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+			Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+			PromoCode:   code,
+		})
+		if err != wantErr {
+			t.Errorf("Expected %v for code %s, got %v", wantErr, code, err)
+		}
+	}
+}
+
+func TestRideService_CreateFareEstimate_PickupETA(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	// A driver ~0.5 km north of the pickup point.
+	service.locationService.UpdateDriverLocation(ctx, "driver-1", 37.7745, -122.41)
+
+	estimate, err := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	if err != nil {
+		t.Fatalf("CreateFareEstimate failed: %v", err)
+	}
+
+	if estimate.EstimatedPickupMins == nil {
+		t.Fatal("Expected estimated pickup time with a nearby driver")
+	}
+	// 0.5 km at 30 km/h ≈ 1 minute.
+	if *estimate.EstimatedPickupMins < 0.8 || *estimate.EstimatedPickupMins > 1.2 {
+		t.Errorf("Expected ~1 min pickup, got %v", *estimate.EstimatedPickupMins)
+	}
+}
+
+func TestRideService_CreateFareEstimate_NoPickupETAWithoutDrivers(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	estimate, err := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	if err != nil {
+		t.Fatalf("CreateFareEstimate failed: %v", err)
+	}
+
+	if estimate.EstimatedPickupMins != nil {
+		t.Errorf("Expected nil pickup time with no drivers, got %v", *estimate.EstimatedPickupMins)
+	}
+}
+
+func TestRideService_CreateFareEstimate_VeryShortTrip(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	// ~5 m north of the source: a legitimate but suspicious trip.
+	req := FareEstimateRequest{
+		Source: entities.Location{
+			Latitude:  37.77,
+			Longitude: -122.41,
+		},
+		Destination: entities.Location{
+			Latitude:  37.770045,
+			Longitude: -122.41,
+		},
+	}
+
+	estimate, err := service.CreateFareEstimate(ctx, "rider-1", req)
+	if err != nil {
+		t.Fatalf("CreateFareEstimate failed: %v", err)
+	}
+
+	if estimate.Warning == "" {
+		t.Error("Expected a warning for a very short trip")
+	}
+	if estimate.Fare.TotalFare != utils.NewMoney(5.00) {
+		t.Errorf("Expected minimum fare 5.00, got %v", estimate.Fare.TotalFare)
+	}
+}
+
+func TestRideService_CreateFareEstimate_NoWarningForNormalTrip(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	req := FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	}
+
+	estimate, err := service.CreateFareEstimate(ctx, "rider-1", req)
+	if err != nil {
+		t.Fatalf("CreateFareEstimate failed: %v", err)
+	}
+	if estimate.Warning != "" {
+		t.Errorf("Expected no warning, got %q", estimate.Warning)
+	}
+}
+
+func TestRideService_CreateFareEstimate_SameLocation(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	point := entities.Location{Latitude: 37.77, Longitude: -122.41}
+	_, err := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      point,
+		Destination: point,
+	})
+	if err != ErrSameLocation {
+		t.Errorf("Expected ErrSameLocation, got %v", err)
+	}
+}
+
+func TestRideService_CreateFareEstimate_RouteLimits(t *testing.T) {
+	sf := entities.Location{Latitude: 37.77, Longitude: -122.41}
+	tests := []struct {
+		name        string
+		destination entities.Location
+		wantErr     error
+	}{
+		{"within cap", entities.Location{Latitude: 37.80, Longitude: -122.27}, nil},
+		{"over distance cap", entities.Location{Latitude: 34.05, Longitude: -118.24}, ErrRouteTooLong},
+		{"latitude out of range", entities.Location{Latitude: 91, Longitude: -122.41}, ErrInvalidLocation},
+		{"longitude out of range", entities.Location{Latitude: 37.77, Longitude: -181}, ErrInvalidLocation},
+		{"not finite", entities.Location{Latitude: math.NaN(), Longitude: -122.41}, ErrInvalidLocation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _, _, _ := setupRideService()
+			service.config.Ride.MaxRouteDistanceKm = 100
+
+			_, err := service.CreateFareEstimate(context.Background(), "rider-1", FareEstimateRequest{
+				Source:      sf,
+				Destination: tt.destination,
+			})
+			if err != tt.wantErr {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRideService_CreateFareEstimate_Waypoints(t *testing.T) {
+	sf := entities.Location{Latitude: 37.77, Longitude: -122.41}
+	oakland := entities.Location{Latitude: 37.80, Longitude: -122.27}
+	nearby := entities.Location{Latitude: 37.78, Longitude: -122.40}
+	sanJose := entities.Location{Latitude: 37.34, Longitude: -121.89}
+	tests := []struct {
+		name      string
+		waypoints []entities.Location
+		wantErr   error
+	}{
+		{"one stop", []entities.Location{nearby}, nil},
+		{"at the stop limit", []entities.Location{nearby, nearby}, nil},
+		{"over the stop limit", []entities.Location{nearby, nearby, nearby}, ErrTooManyWaypoints},
+		// San Francisco to Oakland is short; by way of San Jose it isn't.
+		{"detour over distance cap", []entities.Location{sanJose}, ErrRouteTooLong},
+		{"stop out of range", []entities.Location{{Latitude: 37.78, Longitude: 190}}, ErrInvalidLocation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, rideRepo, _, _ := setupRideService()
+			service.config.Ride.MaxRouteDistanceKm = 100
+			service.config.Ride.MaxWaypoints = 2
+
+			estimate, err := service.CreateFareEstimate(context.Background(), "rider-1", FareEstimateRequest{
+				Source:      sf,
+				Waypoints:   tt.waypoints,
+				Destination: oakland,
+			})
+			if err != tt.wantErr {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			// The trip is priced through every stop, not as the crow flies.
+			direct := utils.HaversineDistance(sf.Latitude, sf.Longitude, oakland.Latitude, oakland.Longitude)
+			if want := routeDistanceKm(sf, tt.waypoints, oakland); estimate.DistanceKm != want || want <= direct {
+				t.Errorf("Expected distance %v through the stops (direct is %v), got %v", want, direct, estimate.DistanceKm)
+			}
+			ride, _ := rideRepo.GetByID(context.Background(), estimate.RideID)
+			if len(ride.Waypoints) != len(tt.waypoints) {
+				t.Errorf("Expected the ride to keep %d stops, got %v", len(tt.waypoints), ride.Waypoints)
+			}
+		})
+	}
+}
+
+func TestRideService_RepeatRide(t *testing.T) {
+	service, rideRepo, riderRepo, _ := setupRideService()
+	ctx := context.Background()
+
+	riderRepo.GetOrCreate(ctx, "rider-1")
+	original := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	original.Category = entities.RideCategoryPremium
+	original.Request()
+	original.StartMatching()
+	original.Accept("driver-1")
+	original.TransitionTo(entities.RideStatusPickingUp)
+	original.TransitionTo(entities.RideStatusInProgress)
+	original.TransitionTo(entities.RideStatusCompleted)
+	rideRepo.Create(ctx, original)
+
+	// Surge has kicked in since the original trip.
+	service.SetSurgeFunc(func(ctx context.Context, pickup entities.Location) float64 { return 2.0 })
+
+	estimate, err := service.RepeatRide(ctx, "rider-1", "ride-1")
+	if err != nil {
+		t.Fatalf("RepeatRide failed: %v", err)
+	}
+	if estimate.RideID == "ride-1" {
+		t.Error("Expected a new ride, got the original ID")
+	}
+	if estimate.Source != original.Source || estimate.Destination != original.Destination {
+		t.Errorf("Expected the original route, got %+v -> %+v", estimate.Source, estimate.Destination)
+	}
+	if estimate.Category != entities.RideCategoryPremium {
+		t.Errorf("Expected category to carry over, got %s", estimate.Category)
+	}
+	if estimate.Fare.SurgeMultiple != 2.0 {
+		t.Errorf("Expected the repeat to be priced at current surge, got %v", estimate.Fare.SurgeMultiple)
+	}
+
+	repeated, _ := rideRepo.GetByID(ctx, estimate.RideID)
+	if repeated == nil || repeated.RiderID != "rider-1" || repeated.Status != entities.RideStatusEstimate {
+		t.Errorf("Expected a new estimate owned by rider-1, got %+v", repeated)
+	}
+	if original.Status != entities.RideStatusCompleted {
+		t.Errorf("Expected the original ride to stay completed, got %s", original.Status)
+	}
+}
+
+func TestRideService_RepeatRide_OtherRider(t *testing.T) {
+	service, rideRepo, _, _ := setupRideService()
+	ctx := context.Background()
+
+	rideRepo.Create(ctx, entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0))
+
+	if _, err := service.RepeatRide(ctx, "rider-2", "ride-1"); err != ErrNotAuthorized {
+		t.Errorf("Expected ErrNotAuthorized, got %v", err)
+	}
+	if _, err := service.RepeatRide(ctx, "rider-1", "missing"); err != ErrRideNotFound {
+		t.Errorf("Expected ErrRideNotFound, got %v", err)
+	}
+}
+
 func TestRideService_RequestRide(t *testing.T) {
 	service, _, _, _ := setupRideService()
 	ctx := context.Background()
@@ -80,6 +456,164 @@ func TestRideService_RequestRide(t *testing.T) {
 	}
 }
 
+func TestRideService_RequestRide_FareLockHonored(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.40},
+	})
+	if !estimate.FareLockExpiresAt.After(time.Now()) {
+		t.Fatalf("Expected fare lock in the future, got %v", estimate.FareLockExpiresAt)
+	}
+
+	// Surge kicks in after the quote, but the lock is still valid.
+	service.SetSurgeFunc(func(ctx context.Context, pickup entities.Location) float64 { return 2.0 })
+
+	ride, err := service.RequestRide(ctx, "rider-1", estimate.RideID)
+	if err != nil {
+		t.Fatalf("Expected request within the fare lock to succeed, got %v", err)
+	}
+	if ride.EstimatedFare != estimate.Fare.TotalFare {
+		t.Errorf("Expected locked fare %v, got %v", estimate.Fare.TotalFare, ride.EstimatedFare)
+	}
+}
+
+func TestRideService_RequestRide_FareLockExpired(t *testing.T) {
+	service, rideRepo, _, _ := setupRideService()
+	ctx := context.Background()
+
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.40},
+	})
+
+	service.SetSurgeFunc(func(ctx context.Context, pickup entities.Location) float64 { return 2.0 })
+
+	stored, _ := rideRepo.GetByID(ctx, estimate.RideID)
+	stored.FareLockExpiresAt = time.Now().Add(-time.Second)
+
+	returned, err := service.RequestRide(ctx, "rider-1", estimate.RideID)
+	if err != ErrFareExpired {
+		t.Fatalf("Expected ErrFareExpired after the lock window, got %v", err)
+	}
+
+	repriced, _ := rideRepo.GetByID(ctx, estimate.RideID)
+	if returned != repriced {
+		t.Error("Expected the re-priced ride to be returned with ErrFareExpired")
+	}
+	if repriced.Status != entities.RideStatusEstimate {
+		t.Errorf("Expected ride to stay in estimate until re-confirmed, got %s", repriced.Status)
+	}
+	if repriced.EstimatedFare <= estimate.Fare.TotalFare {
+		t.Errorf("Expected re-quoted fare above %v, got %v", estimate.Fare.TotalFare, repriced.EstimatedFare)
+	}
+	if !repriced.FareLocked(time.Now()) {
+		t.Error("Expected the re-quoted fare to be locked")
+	}
+
+	// Requesting again confirms the new fare.
+	ride, err := service.RequestRide(ctx, "rider-1", estimate.RideID)
+	if err != nil {
+		t.Fatalf("Expected re-confirmed request to succeed, got %v", err)
+	}
+	if ride.EstimatedFare != repriced.EstimatedFare {
+		t.Errorf("Expected re-quoted fare %v, got %v", repriced.EstimatedFare, ride.EstimatedFare)
+	}
+}
+
+func TestRideService_RequestRide_FareLockExpiredUnchanged(t *testing.T) {
+	service, rideRepo, _, _ := setupRideService()
+	ctx := context.Background()
+
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.40},
+	})
+
+	stored, _ := rideRepo.GetByID(ctx, estimate.RideID)
+	stored.FareLockExpiresAt = time.Now().Add(-time.Second)
+
+	// No surge change, so the re-quote matches and no confirmation is needed.
+	if _, err := service.RequestRide(ctx, "rider-1", estimate.RideID); err != nil {
+		t.Errorf("Expected request with unchanged fare to succeed, got %v", err)
+	}
+}
+
+func TestRideService_RequestRide_FreshEstimate(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.40},
+	})
+	if ttl := service.config.Pricing.EstimateTTL; estimate.ExpiresAt.Sub(time.Now()) > ttl || !estimate.ExpiresAt.After(estimate.FareLockExpiresAt) {
+		t.Fatalf("Expected the estimate to expire within %v and after its fare lock, got %v", ttl, estimate.ExpiresAt)
+	}
+
+	ride, err := service.RequestRide(ctx, "rider-1", estimate.RideID)
+	if err != nil {
+		t.Fatalf("Expected a fresh estimate to be requestable, got %v", err)
+	}
+	if !ride.ExpiresAt.IsZero() {
+		t.Errorf("Expected a requested ride to carry no expiry, got %v", ride.ExpiresAt)
+	}
+}
+
+func TestRideService_RequestRide_EstimateExpired(t *testing.T) {
+	service, rideRepo, _, _ := setupRideService()
+	ctx := context.Background()
+
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.40},
+	})
+
+	stored, _ := rideRepo.GetByID(ctx, estimate.RideID)
+	stored.FareLockExpiresAt = time.Now().Add(-time.Hour)
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+
+	if _, err := service.RequestRide(ctx, "rider-1", estimate.RideID); err != ErrEstimateExpired {
+		t.Fatalf("Expected ErrEstimateExpired, got %v", err)
+	}
+	expired, _ := rideRepo.GetByID(ctx, estimate.RideID)
+	if expired.Status != entities.RideStatusCancelled {
+		t.Errorf("Expected the expired estimate to be cancelled, got %s", expired.Status)
+	}
+
+	// It stays expired rather than turning into a generic transition error.
+	if _, err := service.RequestRide(ctx, "rider-1", estimate.RideID); err != ErrEstimateExpired {
+		t.Errorf("Expected ErrEstimateExpired on a second attempt, got %v", err)
+	}
+}
+
+func TestRideService_RequestRide_TracksDemand(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+	source := entities.Location{Latitude: 37.77, Longitude: -122.41}
+
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      source,
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.40},
+	})
+	if demand := service.demand.Snapshot(source).Demand; demand != 0 {
+		t.Errorf("Expected an estimate not to count as demand, got %d", demand)
+	}
+
+	ride, _ := service.RequestRide(ctx, "rider-1", estimate.RideID)
+	if demand := service.demand.Snapshot(source).Demand; demand != 1 {
+		t.Errorf("Expected demand 1 after request, got %d", demand)
+	}
+
+	service.StartMatching(ctx, ride)
+	service.FailMatching(ctx, ride.ID)
+	if demand := service.demand.Snapshot(source).Demand; demand != 0 {
+		t.Errorf("Expected demand 0 after matching failed, got %d", demand)
+	}
+}
+
 func TestRideService_RequestRide_NotAuthorized(t *testing.T) {
 	service, _, _, _ := setupRideService()
 	ctx := context.Background()
@@ -135,6 +669,58 @@ func TestRideService_RequestRide_ActiveRideExists(t *testing.T) {
 	}
 }
 
+func TestRideService_UpdatePickupLocation(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.40},
+	})
+
+	// Still an estimate — not yet requested.
+	newPickup := entities.Location{Latitude: 37.78, Longitude: -122.41}
+	if _, err := service.UpdatePickupLocation(ctx, "rider-1", estimate.RideID, newPickup); err != ErrPickupLocked {
+		t.Errorf("Expected ErrPickupLocked before the ride is requested, got %v", err)
+	}
+
+	service.RequestRide(ctx, "rider-1", estimate.RideID)
+
+	if _, err := service.UpdatePickupLocation(ctx, "rider-2", estimate.RideID, newPickup); err != ErrNotAuthorized {
+		t.Errorf("Expected ErrNotAuthorized for another rider, got %v", err)
+	}
+
+	ride, err := service.UpdatePickupLocation(ctx, "rider-1", estimate.RideID, newPickup)
+	if err != nil {
+		t.Fatalf("UpdatePickupLocation failed: %v", err)
+	}
+	if ride.Source != newPickup {
+		t.Errorf("Expected source %+v, got %+v", newPickup, ride.Source)
+	}
+	if ride.DistanceKm >= estimate.DistanceKm {
+		t.Errorf("Expected shorter trip after moving pickup closer, got %.2f km (was %.2f)", ride.DistanceKm, estimate.DistanceKm)
+	}
+}
+
+func TestRideService_UpdatePickupLocation_LockedAfterAccept(t *testing.T) {
+	service, _, _, driverRepo := setupRideService()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.80, Longitude: -122.40},
+	})
+	ride, _ := service.RequestRide(ctx, "rider-1", estimate.RideID)
+	service.StartMatching(ctx, ride)
+	service.AcceptRide(ctx, "driver-1", ride.ID, true)
+
+	_, err := service.UpdatePickupLocation(ctx, "rider-1", ride.ID, entities.Location{Latitude: 37.78, Longitude: -122.41})
+	if err != ErrPickupLocked {
+		t.Errorf("Expected ErrPickupLocked after acceptance, got %v", err)
+	}
+}
+
 func TestRideService_UpdateRideStatus(t *testing.T) {
 	service, rideRepo, riderRepo, driverRepo := setupRideService()
 	ctx := context.Background()
@@ -147,7 +733,7 @@ func TestRideService_UpdateRideStatus(t *testing.T) {
 	ride := entities.NewRide("ride-1", "rider-1",
 		entities.Location{Latitude: 37.77, Longitude: -122.41},
 		entities.Location{Latitude: 37.78, Longitude: -122.40},
-		10.00, 1.5, 5.0)
+		utils.NewMoney(10.00), 1.5, 5.0)
 	ride.Request()
 	ride.StartMatching()
 	ride.Accept("driver-1")
@@ -175,7 +761,7 @@ func TestRideService_UpdateRideStatus_InvalidTransition(t *testing.T) {
 	ride := entities.NewRide("ride-1", "rider-1",
 		entities.Location{Latitude: 37.77, Longitude: -122.41},
 		entities.Location{Latitude: 37.78, Longitude: -122.40},
-		10.00, 1.5, 5.0)
+		utils.NewMoney(10.00), 1.5, 5.0)
 	ride.Request()
 	ride.StartMatching()
 	ride.Accept("driver-1")
@@ -188,6 +774,456 @@ func TestRideService_UpdateRideStatus_InvalidTransition(t *testing.T) {
 	}
 }
 
+func TestRideService_UpdateRideStatus_Terminal(t *testing.T) {
+	service, rideRepo, riderRepo, driverRepo := setupRideService()
+	ctx := context.Background()
+
+	riderRepo.GetOrCreate(ctx, "rider-1")
+	driverRepo.GetOrCreate(ctx, "driver-1")
+
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	ride.TransitionTo(entities.RideStatusPickingUp)
+	ride.TransitionTo(entities.RideStatusInProgress)
+	ride.TransitionTo(entities.RideStatusCompleted)
+	rideRepo.Create(ctx, ride)
+
+	// Every target is refused the same way once the ride is finished.
+	for _, status := range []entities.RideStatus{entities.RideStatusCompleted, entities.RideStatusCancelled} {
+		_, err := service.UpdateRideStatus(ctx, "driver-1", "ride-1", status)
+		if err != ErrRideTerminal {
+			t.Errorf("%s: expected ErrRideTerminal, got %v", status, err)
+		}
+	}
+}
+
+// newInProgressRide stores a ride quoted for 1.5 km / 5 min that driver-1
+// has started.
+func newInProgressRide(t *testing.T, rideRepo *memory.RideRepository, driverRepo *memory.DriverRepository) *entities.Ride {
+	t.Helper()
+	ctx := context.Background()
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	ride.StartPickup()
+	ride.StartTrip()
+	rideRepo.Create(ctx, ride)
+	return ride
+}
+
+func TestRideService_CompleteRide_ChargesMeasuredTrip(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	ctx := context.Background()
+	newInProgressRide(t, rideRepo, driverRepo)
+
+	// A detour: four times the quoted distance, and slower.
+	completed, err := service.CompleteRide(ctx, "driver-1", "ride-1", 6.0, 25.0)
+	if err != nil {
+		t.Fatalf("CompleteRide failed: %v", err)
+	}
+	if completed.Status != entities.RideStatusCompleted {
+		t.Fatalf("Expected completed, got %s", completed.Status)
+	}
+
+	expected := service.calculator.CalculateFare(6.0, 25.0, 1.0).TotalFare
+	quoted := service.calculator.CalculateFare(1.5, 5.0, 1.0).TotalFare
+	if completed.ActualFare != expected {
+		t.Errorf("Expected the fare for 6 km / 25 min, %v, got %v", expected, completed.ActualFare)
+	}
+	if completed.ActualFare <= quoted {
+		t.Errorf("Expected the measured fare %v to exceed the quote for the planned trip, %v", completed.ActualFare, quoted)
+	}
+
+	if driver, _ := driverRepo.GetByID(ctx, "driver-1"); !driver.IsAvailable() {
+		t.Error("Expected the driver to be freed on completion")
+	}
+}
+
+func TestRideService_CompleteRide_WaitCharge(t *testing.T) {
+	tests := []struct {
+		name         string
+		wait         time.Duration
+		wantWaitFare utils.Money
+	}{
+		{"within free wait", 1 * time.Minute, 0},
+		{"exactly the free wait", 2 * time.Minute, 0},
+		// 5 billable minutes at $0.30
+		{"past the free wait", 7 * time.Minute, utils.NewMoney(1.50)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, rideRepo, _, driverRepo := setupRideService()
+			ctx := context.Background()
+			ride := newInProgressRide(t, rideRepo, driverRepo)
+			ride.PickedUpAt = ride.StartedAt.Add(-tt.wait)
+
+			completed, err := service.CompleteRide(ctx, "driver-1", "ride-1", 6.0, 25.0)
+			if err != nil {
+				t.Fatalf("CompleteRide failed: %v", err)
+			}
+			if completed.WaitFare != tt.wantWaitFare {
+				t.Errorf("Expected wait fare %v, got %v", tt.wantWaitFare, completed.WaitFare)
+			}
+			trip := service.quoteFare(ctx, ride.VehicleTier, ride.Source, 6.0, 25.0)
+			if want := trip.TotalFare + tt.wantWaitFare; completed.ActualFare != want {
+				t.Errorf("Expected actual fare %v (trip plus wait), got %v", want, completed.ActualFare)
+			}
+		})
+	}
+}
+
+func TestRideService_CompleteRide_MinimumFareAndValidation(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	ctx := context.Background()
+	newInProgressRide(t, rideRepo, driverRepo)
+
+	if _, err := service.CompleteRide(ctx, "driver-1", "ride-1", -1, 5); err != ErrInvalidTripLength {
+		t.Errorf("Expected ErrInvalidTripLength for a negative distance, got %v", err)
+	}
+	if _, err := service.CompleteRide(ctx, "driver-2", "ride-1", 1, 5); err != ErrNotAuthorized {
+		t.Errorf("Expected ErrNotAuthorized for another driver, got %v", err)
+	}
+
+	// The rider cancelled at the curb: hardly any trip at all.
+	completed, err := service.CompleteRide(ctx, "driver-1", "ride-1", 0.1, 1)
+	if err != nil {
+		t.Fatalf("CompleteRide failed: %v", err)
+	}
+	if completed.ActualFare != utils.NewMoney(service.config.Pricing.MinimumFare) {
+		t.Errorf("Expected the minimum fare %.2f, got %v", service.config.Pricing.MinimumFare, completed.ActualFare)
+	}
+}
+
+func TestRideService_CompleteRide_FareSurvivesRiderConfirmation(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	service.config.Ride.RiderConfirmsCompletion = true
+	service.config.Ride.ConfirmationTimeout = time.Hour
+	ctx := context.Background()
+	newInProgressRide(t, rideRepo, driverRepo)
+
+	pending, err := service.CompleteRide(ctx, "driver-1", "ride-1", 6.0, 25.0)
+	if err != nil {
+		t.Fatalf("CompleteRide failed: %v", err)
+	}
+	expected := service.calculator.CalculateFare(6.0, 25.0, 1.0).TotalFare
+	if pending.Status != entities.RideStatusPendingConfirmation || pending.ActualFare != expected {
+		t.Fatalf("Expected pending_confirmation at %v, got %s at %v", expected, pending.Status, pending.ActualFare)
+	}
+
+	confirmed, err := service.ConfirmCompletion(ctx, "rider-1", "ride-1")
+	if err != nil {
+		t.Fatalf("ConfirmCompletion failed: %v", err)
+	}
+	if confirmed.ActualFare != expected {
+		t.Errorf("Expected confirmation to keep the measured fare %v, got %v", expected, confirmed.ActualFare)
+	}
+}
+
+func TestRideService_GetActiveRide_PendingConfirmation(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	service.config.Ride.RiderConfirmsCompletion = true
+	service.config.Ride.ConfirmationTimeout = time.Hour
+	ctx := context.Background()
+	newInProgressRide(t, rideRepo, driverRepo)
+
+	if _, err := service.UpdateRideStatus(ctx, "driver-1", "ride-1", entities.RideStatusCompleted); err != nil {
+		t.Fatalf("UpdateRideStatus failed: %v", err)
+	}
+
+	// The rider still has to confirm, so the app polling for the active
+	// ride must keep finding it.
+	active, err := service.GetActiveRide(ctx, "rider-1")
+	if err != nil || active == nil || active.ID != "ride-1" {
+		t.Fatalf("Expected ride-1 awaiting confirmation to be active, got %v (%v)", active, err)
+	}
+
+	if _, err := service.ConfirmCompletion(ctx, "rider-1", "ride-1"); err != nil {
+		t.Fatalf("ConfirmCompletion failed: %v", err)
+	}
+	if active, _ := service.GetActiveRide(ctx, "rider-1"); active != nil {
+		t.Errorf("Expected no active ride once confirmed, got %s", active.ID)
+	}
+}
+
+func TestRideService_CancelRide(t *testing.T) {
+	tests := []struct {
+		name          string
+		advance       func(ride *entities.Ride)
+		cancelInMatch bool
+		wantErr       error
+	}{
+		{"matching", func(r *entities.Ride) { r.Request(); r.StartMatching() }, true, nil},
+		{"matching, disabled", func(r *entities.Ride) { r.Request(); r.StartMatching() }, false, ErrInvalidTransition},
+		{"accepted, disabled", func(r *entities.Ride) { r.Request(); r.StartMatching(); r.Accept("driver-1") }, false, nil},
+		{"in progress", func(r *entities.Ride) {
+			r.Request()
+			r.StartMatching()
+			r.Accept("driver-1")
+			r.TransitionTo(entities.RideStatusPickingUp)
+			r.TransitionTo(entities.RideStatusInProgress)
+		}, true, ErrInvalidTransition},
+		{"failed", func(r *entities.Ride) { r.Request(); r.StartMatching(); r.Fail() }, true, ErrRideTerminal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, rideRepo, _, driverRepo := setupRideService()
+			service.config.Ride.RiderCancelWhileMatching = tt.cancelInMatch
+			ctx := context.Background()
+
+			driver, _ := driverRepo.GetOrCreate(ctx, "driver-1")
+			ride := entities.NewRide("ride-1", "rider-1",
+				entities.Location{Latitude: 37.77, Longitude: -122.41},
+				entities.Location{Latitude: 37.78, Longitude: -122.40},
+				utils.NewMoney(10.00), 1.5, 5.0)
+			tt.advance(ride)
+			if ride.DriverID != "" {
+				driver.StartRide()
+			}
+			rideRepo.Create(ctx, ride)
+
+			if _, err := service.CancelRide(ctx, "rider-2", "ride-1"); err != ErrNotAuthorized {
+				t.Errorf("Expected ErrNotAuthorized for another rider, got %v", err)
+			}
+
+			cancelled, err := service.CancelRide(ctx, "rider-1", "ride-1")
+			if err != tt.wantErr {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if cancelled.Status != entities.RideStatusCancelled {
+				t.Errorf("Expected cancelled, got %s", cancelled.Status)
+			}
+			if !driver.IsAvailable() {
+				t.Error("Expected the assigned driver to be available again")
+			}
+		})
+	}
+}
+
+func TestRideService_CancelRide_Fee(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance func(ride *entities.Ride)
+		wantFee utils.Money
+	}{
+		{"before accept", func(r *entities.Ride) { r.Request(); r.StartMatching() }, 0},
+		{"within free window", func(r *entities.Ride) {
+			r.Request()
+			r.StartMatching()
+			r.Accept("driver-1")
+			r.AcceptedAt = time.Now().Add(-1 * time.Minute)
+		}, 0},
+		{"after free window", func(r *entities.Ride) {
+			r.Request()
+			r.StartMatching()
+			r.Accept("driver-1")
+			r.AcceptedAt = time.Now().Add(-3 * time.Minute)
+		}, utils.NewMoney(5.00)},
+		{"driver arrived", func(r *entities.Ride) {
+			r.Request()
+			r.StartMatching()
+			r.Accept("driver-1")
+			r.StartPickup()
+			r.AcceptedAt = time.Now().Add(-10 * time.Minute)
+		}, utils.NewMoney(5.00)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, rideRepo, _, driverRepo := setupRideService()
+			ctx := context.Background()
+
+			driverRepo.GetOrCreate(ctx, "driver-1")
+			ride := entities.NewRide("ride-1", "rider-1",
+				entities.Location{Latitude: 37.77, Longitude: -122.41},
+				entities.Location{Latitude: 37.78, Longitude: -122.40},
+				utils.NewMoney(10.00), 1.5, 5.0)
+			tt.advance(ride)
+			rideRepo.Create(ctx, ride)
+
+			cancelled, err := service.CancelRide(ctx, "rider-1", "ride-1")
+			if err != nil {
+				t.Fatalf("CancelRide failed: %v", err)
+			}
+			if cancelled.CancellationFee != tt.wantFee {
+				t.Errorf("Expected cancellation fee %v, got %v", tt.wantFee, cancelled.CancellationFee)
+			}
+		})
+	}
+}
+
+func TestRideService_CancelRide_FeeDisabled(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	service.config.Cancellation.Fee = 0
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	ride.AcceptedAt = time.Now().Add(-time.Hour)
+	rideRepo.Create(ctx, ride)
+
+	cancelled, err := service.CancelRide(ctx, "rider-1", "ride-1")
+	if err != nil {
+		t.Fatalf("CancelRide failed: %v", err)
+	}
+	if cancelled.CancellationFee != 0 {
+		t.Errorf("Expected no fee with fees turned off, got %v", cancelled.CancellationFee)
+	}
+}
+
+func TestRideService_UpdateRideStatus_ContactlessShortPath(t *testing.T) {
+	service, _, _, driverRepo := setupRideService()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+
+	estimate, err := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+		Contactless: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateFareEstimate failed: %v", err)
+	}
+	if !estimate.Contactless {
+		t.Error("Expected estimate to be marked contactless")
+	}
+
+	ride, _ := service.RequestRide(ctx, "rider-1", estimate.RideID)
+	service.StartMatching(ctx, ride)
+	service.AcceptRide(ctx, "driver-1", ride.ID, true)
+
+	if _, err := service.UpdateRideStatus(ctx, "driver-1", ride.ID, entities.RideStatusPickingUp); err != nil {
+		t.Fatalf("UpdateRideStatus(picking_up) failed: %v", err)
+	}
+	completed, err := service.UpdateRideStatus(ctx, "driver-1", ride.ID, entities.RideStatusCompleted)
+	if err != nil {
+		t.Fatalf("Expected contactless ride to complete from picking_up, got %v", err)
+	}
+	if completed.Status != entities.RideStatusCompleted {
+		t.Errorf("Expected status completed, got %s", completed.Status)
+	}
+}
+
+// setupPendingConfirmation returns a service requiring rider confirmation
+// within timeout, and the ride-1 (rider-1, driver-1) it returned when the
+// driver completed the trip. completed is installed before the timeout starts.
+func setupPendingConfirmation(t *testing.T, timeout time.Duration, completed CompletionFunc) (*RideService, *memory.DriverRepository, *entities.Ride) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	service.config.Ride.RiderConfirmsCompletion = true
+	service.config.Ride.ConfirmationTimeout = timeout
+	service.SetCompletionFunc(completed)
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	ride.StartPickup()
+	ride.StartTrip()
+	rideRepo.Create(ctx, ride)
+
+	pending, err := service.UpdateRideStatus(ctx, "driver-1", "ride-1", entities.RideStatusCompleted)
+	if err != nil {
+		t.Fatalf("UpdateRideStatus(completed) failed: %v", err)
+	}
+	return service, driverRepo, pending
+}
+
+func TestRideService_ConfirmCompletion(t *testing.T) {
+	var notified []string
+	service, driverRepo, ride := setupPendingConfirmation(t, time.Hour, func(ride *entities.Ride) {
+		notified = append(notified, ride.ID)
+	})
+	ctx := context.Background()
+
+	if ride.Status != entities.RideStatusPendingConfirmation {
+		t.Fatalf("Expected pending_confirmation, got %s", ride.Status)
+	}
+	if ride.ActualFare != 0 || ride.ConfirmBy.IsZero() {
+		t.Errorf("Expected no final fare and a confirmation deadline, got fare %v, deadline %v", ride.ActualFare, ride.ConfirmBy)
+	}
+
+	driver, _ := driverRepo.GetByID(ctx, "driver-1")
+	if !driver.IsAvailable() {
+		t.Error("Expected the driver to be free while the rider confirms")
+	}
+	if active, _ := service.GetActiveRideForDriver(ctx, "driver-1"); active != nil {
+		t.Errorf("Expected no active ride for the driver, got %s", active.ID)
+	}
+
+	if _, err := service.ConfirmCompletion(ctx, "rider-2", ride.ID); err != ErrNotAuthorized {
+		t.Errorf("Expected ErrNotAuthorized for another rider, got %v", err)
+	}
+
+	confirmed, err := service.ConfirmCompletion(ctx, "rider-1", ride.ID)
+	if err != nil {
+		t.Fatalf("ConfirmCompletion failed: %v", err)
+	}
+	if confirmed.Status != entities.RideStatusCompleted {
+		t.Errorf("Expected completed, got %s", confirmed.Status)
+	}
+	if confirmed.ActualFare != confirmed.EstimatedFare {
+		t.Errorf("Expected the fare to be finalized at %v, got %v", confirmed.EstimatedFare, confirmed.ActualFare)
+	}
+	if len(notified) != 1 {
+		t.Errorf("Expected one completion notification, got %v", notified)
+	}
+
+	if _, err := service.ConfirmCompletion(ctx, "rider-1", ride.ID); err != ErrRideTerminal {
+		t.Errorf("Expected ErrRideTerminal confirming twice, got %v", err)
+	}
+}
+
+func TestRideService_ConfirmCompletion_AutoConfirmsAfterTimeout(t *testing.T) {
+	completed := make(chan *entities.Ride, 1)
+	service, _, _ := setupPendingConfirmation(t, 20*time.Millisecond, func(ride *entities.Ride) {
+		completed <- ride
+	})
+
+	// The ride is only read once the timeout has handed it over: the timer
+	// goroutine is still free to write it until then.
+	select {
+	case done := <-completed:
+		if done.ID != "ride-1" || done.Status != entities.RideStatusCompleted {
+			t.Errorf("Expected ride-1 completed, got %s %s", done.ID, done.Status)
+		}
+		if done.ActualFare != done.EstimatedFare {
+			t.Errorf("Expected the fare to be finalized at %v, got %v", done.EstimatedFare, done.ActualFare)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the ride to auto-confirm after the timeout")
+	}
+
+	if _, err := service.ConfirmCompletion(context.Background(), "rider-1", "ride-1"); err != ErrRideTerminal {
+		t.Errorf("Expected ErrRideTerminal confirming after the timeout, got %v", err)
+	}
+}
+
 func TestRideService_AcceptRide(t *testing.T) {
 	service, rideRepo, riderRepo, driverRepo := setupRideService()
 	ctx := context.Background()
@@ -199,7 +1235,7 @@ func TestRideService_AcceptRide(t *testing.T) {
 	ride := entities.NewRide("ride-1", "rider-1",
 		entities.Location{Latitude: 37.77, Longitude: -122.41},
 		entities.Location{Latitude: 37.78, Longitude: -122.40},
-		10.00, 1.5, 5.0)
+		utils.NewMoney(10.00), 1.5, 5.0)
 	ride.Request()
 	ride.StartMatching()
 	rideRepo.Create(ctx, ride)
@@ -217,3 +1253,219 @@ func TestRideService_AcceptRide(t *testing.T) {
 		t.Errorf("Expected driver-1, got %s", acceptedRide.DriverID)
 	}
 }
+
+func TestRideService_RideHistory_ValidatesPage(t *testing.T) {
+	service, _, _, _ := setupRideService()
+	ctx := context.Background()
+
+	for _, page := range []struct{ limit, offset int }{{0, 0}, {MaxHistoryLimit + 1, 0}, {10, -1}} {
+		if _, err := service.RideHistory(ctx, "rider-1", page.limit, page.offset); err != ErrInvalidPagination {
+			t.Errorf("limit %d, offset %d: expected ErrInvalidPagination, got %v", page.limit, page.offset, err)
+		}
+	}
+
+	history, err := service.RideHistory(ctx, "rider-1", MaxHistoryLimit, 0)
+	if err != nil {
+		t.Fatalf("RideHistory failed: %v", err)
+	}
+	if history.Total != 0 || len(history.Rides) != 0 || history.Limit != MaxHistoryLimit {
+		t.Errorf("Expected an empty page of %d, got %+v", MaxHistoryLimit, history)
+	}
+}
+
+func TestRideService_GetActiveRideForDriver(t *testing.T) {
+	service, rideRepo, riderRepo, driverRepo := setupRideService()
+	ctx := context.Background()
+
+	riderRepo.GetOrCreate(ctx, "rider-1")
+	driverRepo.GetOrCreate(ctx, "driver-1")
+
+	// A completed ride from earlier in the shift should be ignored.
+	done := entities.NewRide("ride-0", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	done.Request()
+	done.StartMatching()
+	done.Accept("driver-1")
+	done.StartPickup()
+	done.StartTrip()
+	done.Complete()
+	rideRepo.Create(ctx, done)
+
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	ride.StartPickup()
+	ride.StartTrip()
+	rideRepo.Create(ctx, ride)
+
+	active, err := service.GetActiveRideForDriver(ctx, "driver-1")
+	if err != nil {
+		t.Fatalf("GetActiveRideForDriver failed: %v", err)
+	}
+	if active == nil || active.ID != "ride-1" {
+		t.Errorf("Expected in-progress ride-1, got %v", active)
+	}
+}
+
+func TestRideService_GetActiveRideForDriver_None(t *testing.T) {
+	service, _, _, driverRepo := setupRideService()
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+
+	active, err := service.GetActiveRideForDriver(ctx, "driver-1")
+	if err != nil {
+		t.Fatalf("GetActiveRideForDriver failed: %v", err)
+	}
+	if active != nil {
+		t.Errorf("Expected no active ride, got %s", active.ID)
+	}
+}
+
+func TestRideService_ProjectTripFare_GrowsWithPings(t *testing.T) {
+	service, rideRepo, riderRepo, driverRepo := setupRideService()
+	ctx := context.Background()
+
+	riderRepo.GetOrCreate(ctx, "rider-1")
+	driverRepo.GetOrCreate(ctx, "driver-1")
+
+	// Before the trip starts there is nothing to project.
+	if _, err := service.ProjectTripFare(ctx, "driver-1"); err != ErrNoTripInProgress {
+		t.Errorf("Expected ErrNoTripInProgress without a ride, got %v", err)
+	}
+
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.80, Longitude: -122.41},
+		utils.NewMoney(10.00), 3.3, 8.0)
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	ride.StartPickup()
+	rideRepo.Create(ctx, ride)
+
+	if _, err := service.ProjectTripFare(ctx, "driver-1"); err != ErrNoTripInProgress {
+		t.Errorf("Expected ErrNoTripInProgress while picking up, got %v", err)
+	}
+
+	ride.StartTrip()
+	// Backdate the start so the time component alone clears the minimum fare.
+	ride.StartedAt = ride.StartedAt.Add(-10 * time.Minute)
+
+	var previous utils.Money
+	for i, lat := range []float64{37.78, 37.79, 37.80} {
+		service.locationService.UpdateDriverLocation(ctx, "driver-1", lat, -122.41)
+
+		projection, err := service.ProjectTripFare(ctx, "driver-1")
+		if err != nil {
+			t.Fatalf("ProjectTripFare failed: %v", err)
+		}
+		if projection.RideID != "ride-1" {
+			t.Errorf("Expected projection for ride-1, got %s", projection.RideID)
+		}
+		if projection.Fare.TotalFare <= previous {
+			t.Errorf("Ping %d: expected fare to grow past %v, got %v", i+1, previous, projection.Fare.TotalFare)
+		}
+		previous = projection.Fare.TotalFare
+	}
+
+	projection, _ := service.ProjectTripFare(ctx, "driver-1")
+	if math.Abs(projection.DistanceKm-3.3) > 0.1 {
+		t.Errorf("Expected about 3.3 km driven, got %.2f", projection.DistanceKm)
+	}
+}
+
+func TestRideService_ArrivingSoonNotifiesOnce(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	var notified []string
+	service.SetArrivingSoonFunc(func(ride *entities.Ride) {
+		notified = append(notified, ride.ID)
+	})
+	service.locationService.SetRidePingFunc(service.ObserveDriverLocation)
+	ctx := context.Background()
+
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	driverRepo.SetStatus(ctx, "driver-1", entities.DriverStatusInRide)
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	ride.Request()
+	ride.StartMatching()
+	ride.Accept("driver-1")
+	ride.StartPickup()
+	rideRepo.Create(ctx, ride)
+
+	// At 30 km/h the default 2 minute threshold is 1 km out. The driver
+	// approaches from ~3.3 km north, comes within it, drifts back out on a
+	// jittery fix and comes in again.
+	pings := []struct {
+		lat          float64
+		wantNotified int
+	}{
+		{37.80, 0},
+		{37.785, 0},
+		{37.775, 1},
+		{37.772, 1},
+		{37.782, 1},
+		{37.771, 1},
+	}
+	for _, p := range pings {
+		service.locationService.UpdateDriverLocation(ctx, "driver-1", p.lat, -122.41)
+		if len(notified) != p.wantNotified {
+			t.Fatalf("After a ping at %.3f: expected %d notifications, got %d", p.lat, p.wantNotified, len(notified))
+		}
+	}
+
+	stored, _ := rideRepo.GetByID(ctx, "ride-1")
+	if stored.ArrivingSoonAt.IsZero() {
+		t.Error("Expected ArrivingSoonAt to record when the rider was told")
+	}
+}
+
+func TestRideService_GetPickupETA(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	ctx := context.Background()
+	driverRepo.GetOrCreate(ctx, "driver-1")
+	ride := entities.NewRide("ride-1", "rider-1",
+		entities.Location{Latitude: 37.77, Longitude: -122.41},
+		entities.Location{Latitude: 37.78, Longitude: -122.40},
+		utils.NewMoney(10.00), 1.5, 5.0)
+	ride.Request()
+	ride.StartMatching()
+	rideRepo.Create(ctx, ride)
+
+	if _, err := service.GetPickupETA(ctx, "rider-1", "ride-1"); err != ErrNoDriverEnRoute {
+		t.Errorf("Expected ErrNoDriverEnRoute while matching, got %v", err)
+	}
+
+	ride.Accept("driver-1")
+	if _, err := service.GetPickupETA(ctx, "rider-1", "ride-1"); err != ErrDriverLocationUnknown {
+		t.Errorf("Expected ErrDriverLocationUnknown before the driver pings, got %v", err)
+	}
+
+	service.locationService.UpdateDriverLocation(ctx, "driver-1", 37.78, -122.41)
+	eta, err := service.GetPickupETA(ctx, "rider-1", "ride-1")
+	if err != nil {
+		t.Fatalf("GetPickupETA failed: %v", err)
+	}
+	if eta.RideID != "ride-1" || eta.DriverID != "driver-1" || eta.ETAMins <= 0 {
+		t.Errorf("Expected a positive ETA for driver-1 on ride-1, got %+v", eta)
+	}
+
+	if _, err := service.GetPickupETA(ctx, "rider-2", "ride-1"); err != ErrNotAuthorized {
+		t.Errorf("Expected ErrNotAuthorized for another rider, got %v", err)
+	}
+
+	ride.StartPickup()
+	ride.StartTrip()
+	if _, err := service.GetPickupETA(ctx, "rider-1", "ride-1"); err != ErrNoDriverEnRoute {
+		t.Errorf("Expected ErrNoDriverEnRoute once the trip started, got %v", err)
+	}
+}
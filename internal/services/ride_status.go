@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+	"uber/internal/domain/entities"
+)
+
+// RideStatusEvent is one status change of a ride, pushed to the ride's
+// subscribers as it is saved. From is empty on the snapshot a subscriber is
+// sent when it connects (see the /ride/:id/ws handler).
+type RideStatusEvent struct {
+	RideID string              `json:"ride_id"`
+	From   entities.RideStatus `json:"from,omitempty"`
+	Status entities.RideStatus `json:"status"`
+	At     time.Time           `json:"at"`
+}
+
+// CurrentStatusEvent describes ride's current status as an event, for the
+// first message of a status stream: the status and when the ride entered it.
+func CurrentStatusEvent(ride *entities.Ride) RideStatusEvent {
+	last := ride.LastStatusChange()
+	return RideStatusEvent{RideID: ride.ID, Status: last.To, At: last.At}
+}
+
+// statusBufferSize is how many status changes a subscriber can fall behind by
+// before further ones to it are dropped. A ride rarely makes more than ten
+// transitions in its life, so in practice nothing is.
+const statusBufferSize = 16
+
+// rideSubscribers are the subscriptions to one ride. published is how many of
+// the ride's StatusHistory entries they have already been sent.
+type rideSubscribers struct {
+	channels  map[chan RideStatusEvent]bool
+	published int
+}
+
+// statusHub fans ride status changes out to the subscribers of each ride.
+// Like the matching eventHub, publishing never blocks, and rides nobody is
+// watching cost nothing: the hub only tracks rides with subscribers.
+//
+// Go Learning Note — Publishing From the History:
+// Rides change status in many places, but every one of them ends by saving
+// the ride, and each transition appends to StatusHistory. So rather than
+// publishing at each transition, the hub publishes on save whatever part of
+// the history its subscribers haven't seen. A save that changed nothing
+// publishes nothing, and a ride that moved twice before being saved (Accepted
+// then PickingUp, say) still produces both events, in order.
+type statusHub struct {
+	mu    sync.Mutex
+	rides map[string]*rideSubscribers
+}
+
+func newStatusHub() *statusHub {
+	return &statusHub{rides: make(map[string]*rideSubscribers)}
+}
+
+// subscribe starts a subscription to ride's status changes from its current
+// status on. A ride that has already finished gets a closed channel.
+func (h *statusHub) subscribe(ride *entities.Ride) chan RideStatusEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan RideStatusEvent, statusBufferSize)
+	status, history := ride.StatusSnapshot()
+	if status.IsTerminal() {
+		close(ch)
+		return ch
+	}
+	subs, ok := h.rides[ride.ID]
+	if !ok {
+		subs = &rideSubscribers{
+			channels:  make(map[chan RideStatusEvent]bool),
+			published: len(history),
+		}
+		h.rides[ride.ID] = subs
+	}
+	subs.channels[ch] = true
+	return ch
+}
+
+func (h *statusHub) unsubscribe(rideID string, events <-chan RideStatusEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs, ok := h.rides[rideID]
+	if !ok {
+		return
+	}
+	for ch := range subs.channels {
+		if ch == events {
+			delete(subs.channels, ch)
+			close(ch)
+		}
+	}
+	if len(subs.channels) == 0 {
+		delete(h.rides, rideID)
+	}
+}
+
+// publish sends the ride's status changes since the last publish to its
+// subscribers. Once the ride reaches a terminal status, every subscription to
+// it ends: its channel is closed after the final event.
+func (h *statusHub) publish(ride *entities.Ride) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs, ok := h.rides[ride.ID]
+	if !ok {
+		return
+	}
+	status, history := ride.StatusSnapshot()
+	for _, change := range history[min(subs.published, len(history)):] {
+		event := RideStatusEvent{RideID: ride.ID, From: change.From, Status: change.To, At: change.At}
+		for ch := range subs.channels {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+	subs.published = len(history)
+
+	if status.IsTerminal() {
+		for ch := range subs.channels {
+			close(ch)
+		}
+		delete(h.rides, ride.ID)
+	}
+}
+
+// SubscribeStatus subscribes to the status changes of ride from now on, for
+// pushing them to a client as they happen. The channel is closed after the
+// ride reaches a terminal status — straight away if it already has — or by
+// UnsubscribeStatus. Every change made through RideService is published,
+// including those the matching loop makes through it.
+func (s *RideService) SubscribeStatus(ride *entities.Ride) <-chan RideStatusEvent {
+	return s.statuses.subscribe(ride)
+}
+
+// UnsubscribeStatus ends a subscription returned by SubscribeStatus, for
+// instance when the client disconnects, and closes its channel.
+func (s *RideService) UnsubscribeStatus(rideID string, events <-chan RideStatusEvent) {
+	s.statuses.unsubscribe(rideID, events)
+}
+
+// saveRide stores ride and publishes the status changes it has made since it
+// was last saved. Every ride update in RideService goes through here.
+func (s *RideService) saveRide(ctx context.Context, ride *entities.Ride) error {
+	if err := s.rideRepo.Update(ctx, ride); err != nil {
+		return err
+	}
+	s.statuses.publish(ride)
+	return nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"uber/internal/domain/entities"
+)
+
+// drainStatuses reads a status subscription until it is closed or empty.
+func drainStatuses(events <-chan RideStatusEvent) (changes []string, closed bool) {
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return changes, true
+			}
+			changes = append(changes, string(event.From)+"->"+string(event.Status))
+		default:
+			return changes, false
+		}
+	}
+}
+
+func TestRideService_StatusSubscriptionFollowsTheRide(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	ctx := context.Background()
+	ride := newInProgressRide(t, rideRepo, driverRepo)
+
+	events := service.SubscribeStatus(ride)
+	other := service.SubscribeStatus(ride)
+	service.UnsubscribeStatus(ride.ID, other)
+
+	// A save that changes nothing publishes nothing.
+	service.saveRide(ctx, ride)
+	if changes, closed := drainStatuses(events); len(changes) != 0 || closed {
+		t.Fatalf("Expected nothing yet, got %v (closed %v)", changes, closed)
+	}
+
+	if _, err := service.CompleteRide(ctx, "driver-1", ride.ID, 1.5, 5.0); err != nil {
+		t.Fatalf("CompleteRide failed: %v", err)
+	}
+	changes, closed := drainStatuses(events)
+	if got := fmt.Sprint(changes); got != "[in_progress->completed]" || !closed {
+		t.Errorf("Expected the completion and then a closed channel, got %s (closed %v)", got, closed)
+	}
+	if _, closed := drainStatuses(other); !closed {
+		t.Error("Expected the unsubscribed channel to be closed")
+	}
+
+	// Watching a finished ride ends straight away.
+	if _, closed := drainStatuses(service.SubscribeStatus(ride)); !closed {
+		t.Error("Expected a closed channel for a completed ride")
+	}
+	if len(service.statuses.rides) != 0 {
+		t.Errorf("Expected the hub to forget finished rides, still tracking %d", len(service.statuses.rides))
+	}
+}
+
+func TestRideService_StatusSubscriptionSendsEveryTransitionOfASave(t *testing.T) {
+	service, rideRepo, _, driverRepo := setupRideService()
+	ctx := context.Background()
+	driverRepo.GetOrCreate(ctx, "driver-1")
+
+	estimate, _ := service.CreateFareEstimate(ctx, "rider-1", FareEstimateRequest{
+		Source:      entities.Location{Latitude: 37.77, Longitude: -122.41},
+		Destination: entities.Location{Latitude: 37.78, Longitude: -122.40},
+	})
+	ride, _ := rideRepo.GetByID(ctx, estimate.RideID)
+	events := service.SubscribeStatus(ride)
+	defer service.UnsubscribeStatus(ride.ID, events)
+
+	// Two transitions, one save.
+	ride.Request()
+	ride.StartMatching()
+	service.saveRide(ctx, ride)
+
+	changes, _ := drainStatuses(events)
+	if got := fmt.Sprint(changes); got != "[estimate->requested requested->matching]" {
+		t.Errorf("Expected both transitions in order, got %s", got)
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"math"
+	"uber/internal/domain/entities"
+	"uber/pkg/utils"
+)
+
+// SurgeService turns the supply/demand ratio from the DemandTracker into a
+// surge multiplier. Its MultiplierAt method is a SurgeFunc, so it plugs
+// straight into RideService.SetSurgeFunc.
+//
+// Demand and supply are read over the pickup's cell and the eight cells
+// around it (DemandTracker.AreaSnapshot), so a pickup near a cell edge sees
+// the drivers just across it. The ratio is mapped to a multiplier by
+// surgeMultiplier and clamped to maxMultiplier; the PricingCalculator still
+// applies SurgePriceMax/SurgeDisplayMax on top, so the two caps agree when
+// maxMultiplier comes from the same config.
+type SurgeService struct {
+	demand        *DemandTracker
+	maxMultiplier float64 // 0 means uncapped
+}
+
+// NewSurgeService creates a SurgeService reading from the given tracker.
+// maxMultiplier is normally PricingConfig.SurgePriceMax; 0 leaves the
+// multiplier uncapped.
+func NewSurgeService(demand *DemandTracker, maxMultiplier float64) *SurgeService {
+	return &SurgeService{demand: demand, maxMultiplier: maxMultiplier}
+}
+
+// MultiplierAt returns the surge multiplier for a pickup point.
+func (s *SurgeService) MultiplierAt(ctx context.Context, pickup entities.Location) float64 {
+	snapshot := s.demand.AreaSnapshot(pickup)
+	return surgeMultiplier(snapshot.Demand, snapshot.Supply, s.maxMultiplier)
+}
+
+// surgeMultiplier maps pending requests and available drivers to a surge
+// multiplier:
+//
+//   - no demand: 1.0, however few drivers there are
+//   - demand with no drivers at all: limit, since the ratio is unbounded
+//     (with limit 0, the demand itself, as if one driver were present)
+//   - drivers outnumber or match requests: 1.0
+//   - otherwise: demand/supply rounded to one decimal, clamped to limit
+func surgeMultiplier(demand, supply int, limit float64) float64 {
+	if demand <= 0 {
+		return 1.0
+	}
+	if supply <= 0 {
+		if limit > 0 {
+			return limit
+		}
+		supply = 1
+	}
+	ratio := float64(demand) / float64(supply)
+	if ratio <= 1.0 {
+		return 1.0
+	}
+	return utils.ClampSurge(math.Round(ratio*10)/10, limit)
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount in hundredths of a currency's major unit — cents
+// for USD, so Money(1250) is $12.50. Every fare amount is a Money: sums,
+// comparisons and the minimum-fare clamp are plain integer arithmetic, and
+// floats only come in at the edges (a measured distance, a configured rate),
+// converted once with NewMoney.
+//
+// The scale is fixed at two decimals whatever the currency. Zero-decimal
+// currencies such as JPY simply hold multiples of 100 (see Round); the few
+// three-decimal currencies (KWD, BHD, ...) are kept to two.
+//
+// In JSON a Money is a string with exactly two decimals ("12.50"), so clients
+// never see 12.499999999 and never parse a fare into a lossy float by default.
+//
+// Go Learning Note — Named Types over Primitives:
+// Money is an int64 underneath, but as a distinct named type the compiler
+// won't let a float64 (or a distance) be added to it by accident, and it can
+// carry its own methods, including the json.Marshaler below.
+type Money int64
+
+// moneyScale is the number of Money units per major currency unit.
+const moneyScale = 100
+
+// NewMoney converts an amount in major units (dollars, euros) to Money,
+// rounding half away from zero to the nearest hundredth.
+func NewMoney(amount float64) Money {
+	return Money(math.Round(amount * moneyScale))
+}
+
+// Float64 returns the amount in major units, for display or APIs that need a
+// float. Don't do arithmetic on the result.
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// Mul multiplies the amount by factor (a surge multiplier, a percentage as a
+// fraction), rounding half away from zero to the nearest hundredth. The
+// factor is taken to six decimal places and the product is computed in
+// integers, so 1.7 × $10.00 is exactly $17.00 rather than 16.999999999.
+func (m Money) Mul(factor float64) Money {
+	const factorScale = 1_000_000
+	return Money(divRound(int64(m)*int64(math.Round(factor*factorScale)), factorScale))
+}
+
+// divRound divides n by d (d > 0), rounding half away from zero.
+func divRound(n, d int64) int64 {
+	if n < 0 {
+		return -((-n + d/2) / d)
+	}
+	return (n + d/2) / d
+}
+
+// Round rounds the amount to the minor unit of the given currency: whole
+// yen for JPY, cents (a no-op) for two- and three-decimal currencies.
+func (m Money) Round(currencyCode string) Money {
+	decimals := CurrencyDecimals(currencyCode)
+	if decimals >= 2 {
+		return m
+	}
+	step := int64(math.Pow10(2 - decimals))
+	return Money(divRound(int64(m), step) * step)
+}
+
+// MinorUnits converts the amount to the currency's smallest unit, as
+// FormatMoney expects: cents for USD, yen for JPY, fils for KWD.
+func (m Money) MinorUnits(currencyCode string) int64 {
+	decimals := CurrencyDecimals(currencyCode)
+	if decimals >= 2 {
+		return int64(m) * int64(math.Pow10(decimals-2))
+	}
+	return divRound(int64(m), int64(math.Pow10(2-decimals)))
+}
+
+// Format renders the amount for display in a currency (see FormatMoney).
+func (m Money) Format(currencyCode string) string {
+	return FormatMoney(m.MinorUnits(currencyCode), currencyCode)
+}
+
+// String renders the amount with exactly two decimals and no currency:
+// "12.50", "-0.05".
+func (m Money) String() string {
+	sign := ""
+	magnitude := uint64(m)
+	if m < 0 {
+		sign = "-"
+		magnitude = uint64(-m)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, magnitude/moneyScale, magnitude%moneyScale)
+}
+
+// MarshalJSON encodes the amount as a two-decimal string.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts the two-decimal string MarshalJSON writes, or a bare
+// JSON number from older clients.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var number float64
+		if err := json.Unmarshal(data, &number); err != nil {
+			return fmt.Errorf("money must be a decimal string or number: %w", err)
+		}
+		*m = NewMoney(number)
+		return nil
+	}
+	amount, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("invalid money amount %q: %w", text, err)
+	}
+	*m = NewMoney(amount)
+	return nil
+}
+
+// currencySymbol says how an amount in a currency is labelled: the symbol
+// and whether it follows the number ("12.50 €") instead of leading it ("$12.50").
+type currencySymbol struct {
+	symbol string
+	suffix bool
+}
+
+// currencySymbols covers the currencies this service is likely to price in.
+// Anything missing falls back to its ISO code after the number ("12.50 CHF").
+var currencySymbols = map[string]currencySymbol{
+	"USD": {symbol: "$"},
+	"CAD": {symbol: "CA$"},
+	"AUD": {symbol: "A$"},
+	"GBP": {symbol: "£"},
+	"EUR": {symbol: "€", suffix: true},
+	"JPY": {symbol: "¥"},
+	"KRW": {symbol: "₩"},
+	"INR": {symbol: "₹"},
+}
+
+// FormatMoney renders an amount given in minor units for display, using the
+// currency's decimal places, symbol and symbol placement, with thousands
+// grouped by commas. Negative amounts (refunds) get a leading minus sign:
+// FormatMoney(-1250, "USD") is "-$12.50", FormatMoney(1250, "EUR") is
+// "12.50 €" and FormatMoney(1250, "JPY") is "¥1,250". An empty currency code
+// means DefaultCurrencyCode.
+//
+// Go Learning Note — Integer Money:
+// Working in int64 minor units keeps formatting exact: splitting 1250 cents
+// into 12 and 50 with / and % never produces a stray 12.4999999 the way
+// float64 arithmetic can. Convert a Money at the edge with its MinorUnits.
+func FormatMoney(minorUnits int64, currencyCode string) string {
+	if currencyCode == "" {
+		currencyCode = DefaultCurrencyCode
+	}
+	decimals := CurrencyDecimals(currencyCode)
+
+	// Negating math.MinInt64 overflows back to itself, but its uint64
+	// conversion is still the correct magnitude.
+	negative := minorUnits < 0
+	magnitude := uint64(minorUnits)
+	if negative {
+		magnitude = uint64(-minorUnits)
+	}
+
+	scale := uint64(math.Pow10(decimals))
+	number := groupThousands(strconv.FormatUint(magnitude/scale, 10))
+	if decimals > 0 {
+		number += fmt.Sprintf(".%0*d", decimals, magnitude%scale)
+	}
+
+	symbol, ok := currencySymbols[currencyCode]
+	if !ok {
+		symbol = currencySymbol{symbol: currencyCode, suffix: true}
+	}
+
+	sign := ""
+	if negative {
+		sign = "-"
+	}
+	if symbol.suffix {
+		return sign + number + " " + symbol.symbol
+	}
+	return sign + symbol.symbol + number
+}
+
+// groupThousands inserts a comma every three digits from the right.
+func groupThousands(digits string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	lead := len(digits) % 3
+	if lead > 0 {
+		b.WriteString(digits[:lead])
+	}
+	for i := lead; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		name       string
+		minorUnits int64
+		currency   string
+		expected   string
+	}{
+		{"USD", 1250, "USD", "$12.50"},
+		{"USD cents only", 5, "USD", "$0.05"},
+		{"USD thousands", 123456789, "USD", "$1,234,567.89"},
+		{"USD refund", -1250, "USD", "-$12.50"},
+		{"EUR suffix", 1250, "EUR", "12.50 €"},
+		{"EUR refund", -99, "EUR", "-0.99 €"},
+		{"JPY no decimals", 1250, "JPY", "¥1,250"},
+		{"JPY refund", -2803, "JPY", "-¥2,803"},
+		{"KWD three decimals", 12345, "KWD", "12.345 KWD"},
+		{"Unknown currency falls back to code", 1250, "CHF", "12.50 CHF"},
+		{"Empty currency is USD", 1250, "", "$12.50"},
+		{"Zero", 0, "USD", "$0.00"},
+		{"Most negative", math.MinInt64, "JPY", "-¥9,223,372,036,854,775,808"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatMoney(tt.minorUnits, tt.currency); got != tt.expected {
+				t.Errorf("FormatMoney(%d, %q) = %q, expected %q", tt.minorUnits, tt.currency, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	tests := []struct {
+		amount   Money
+		expected string
+	}{
+		{1250, "12.50"},
+		{5, "0.05"},
+		{0, "0.00"},
+		{-1250, "-12.50"},
+		{-5, "-0.05"},
+		{280300, "2803.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.expected {
+			t.Errorf("Money(%d).String() = %q, expected %q", int64(tt.amount), got, tt.expected)
+		}
+	}
+}
+
+func TestNewMoney_RoundsFloatInputs(t *testing.T) {
+	// Each of these is a hair off in binary floating point (1.15*100 is
+	// 114.99999999999999); NewMoney still lands on the intended cent.
+	tests := []struct {
+		amount   float64
+		expected Money
+	}{
+		{1.15, 115},
+		{0.1 + 0.2, 30},
+		{2.675, 268},
+		{-12.5, -1250},
+	}
+	for _, tt := range tests {
+		if got := NewMoney(tt.amount); got != tt.expected {
+			t.Errorf("NewMoney(%v) = %d, expected %d", tt.amount, got, tt.expected)
+		}
+	}
+}
+
+func TestMoney_SumsExactly(t *testing.T) {
+	var floatTotal float64
+	var total Money
+	for i := 0; i < 10; i++ {
+		floatTotal += 0.10
+		total += NewMoney(0.10)
+	}
+	if floatTotal == 1.0 {
+		t.Fatal("Expected the float sum to drift, which is what this test guards against")
+	}
+	if total != NewMoney(1.00) {
+		t.Errorf("Expected ten 0.10 amounts to sum to exactly 1.00, got %v", total)
+	}
+}
+
+func TestMoney_Mul(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Money
+		factor   float64
+		expected Money
+	}{
+		// As floats, 6.05*1.5 is 9.0749999..., which math.Round(x*100)/100
+		// takes down to 9.07.
+		{"Half cent rounds up", NewMoney(6.05), 1.5, NewMoney(9.08)},
+		{"Half cent rounds up again", NewMoney(4.35), 1.5, NewMoney(6.53)},
+		{"Surge", NewMoney(10.00), 1.7, NewMoney(17.00)},
+		{"Percentage", NewMoney(10.10), 0.125, NewMoney(1.26)},
+		{"Identity", NewMoney(12.34), 1.0, NewMoney(12.34)},
+		{"Negative rounds away from zero", -NewMoney(6.05), 1.5, -NewMoney(9.08)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.amount.Mul(tt.factor); got != tt.expected {
+				t.Errorf("%v.Mul(%v) = %v, expected %v", tt.amount, tt.factor, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMoney_RoundAndMinorUnits(t *testing.T) {
+	tests := []struct {
+		amount     Money
+		currency   string
+		rounded    Money
+		minorUnits int64
+		formatted  string
+	}{
+		{NewMoney(14.02), "USD", NewMoney(14.02), 1402, "$14.02"},
+		{NewMoney(2803.40), "JPY", NewMoney(2803), 2803, "¥2,803"},
+		{NewMoney(2803.50), "JPY", NewMoney(2804), 2804, "¥2,804"},
+		{NewMoney(12.34), "KWD", NewMoney(12.34), 12340, "12.340 KWD"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Round(tt.currency); got != tt.rounded {
+			t.Errorf("%v.Round(%s) = %v, expected %v", tt.amount, tt.currency, got, tt.rounded)
+		}
+		if got := tt.rounded.MinorUnits(tt.currency); got != tt.minorUnits {
+			t.Errorf("%v.MinorUnits(%s) = %d, expected %d", tt.rounded, tt.currency, got, tt.minorUnits)
+		}
+		if got := tt.rounded.Format(tt.currency); got != tt.formatted {
+			t.Errorf("%v.Format(%s) = %q, expected %q", tt.rounded, tt.currency, got, tt.formatted)
+		}
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Fare Money `json:"fare"`
+	}{NewMoney(5)})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `{"fare":"5.00"}` {
+		t.Errorf("Expected a two-decimal string, got %s", data)
+	}
+
+	for input, expected := range map[string]Money{
+		`"12.50"`: 1250,
+		`"0.05"`:  5,
+		`12.5`:    1250,
+	} {
+		var m Money
+		if err := json.Unmarshal([]byte(input), &m); err != nil {
+			t.Errorf("Unmarshal(%s) failed: %v", input, err)
+			continue
+		}
+		if m != expected {
+			t.Errorf("Unmarshal(%s) = %v, expected %v", input, m, expected)
+		}
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`"twelve"`), &m); err == nil {
+		t.Error("Expected an error for a non-numeric amount")
+	}
+}
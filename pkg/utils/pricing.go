@@ -10,36 +10,94 @@ const (
 	EarthRadiusKm = 6371.0
 )
 
+// DefaultCurrencyCode is used when no currency is configured.
+const DefaultCurrencyCode = "USD"
+
+// currencyDecimals lists ISO 4217 currencies whose minor unit is not the
+// usual 2 decimal places. Anything not listed here rounds to cents.
+var currencyDecimals = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"VND": 0,
+	"CLP": 0,
+	"ISK": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"OMR": 3,
+	"JOD": 3,
+	"TND": 3,
+}
+
+// CurrencyDecimals returns the number of decimal places amounts in the given
+// ISO 4217 currency are rounded to (e.g., 2 for USD, 0 for JPY).
+func CurrencyDecimals(currencyCode string) int {
+	if decimals, ok := currencyDecimals[currencyCode]; ok {
+		return decimals
+	}
+	return 2
+}
+
 // FareEstimate is a detailed fare breakdown returned to the rider. It shows
 // each component of the fare separately so the UI can display a transparent
-// breakdown.
+// breakdown. Monetary fields are Money, rounded to the minor unit of Currency.
+//
+// SurgeMultiple is the value shown to the rider, clamped to the display cap.
+// BilledSurgeMultiple is the value TotalFare was actually computed with
+// (clamped to the billing cap); it is internal and never serialized.
+// FormattedTotal is TotalFare ready for display (see FormatMoney). Discount is
+// the amount a promo code took off, already subtracted from TotalFare.
+// WaitMins and WaitFare are the billable minutes the driver waited at the
+// pickup and their charge, already included in TotalFare (see AddWaitCharge).
 type FareEstimate struct {
-	DistanceKm    float64 `json:"distance_km"`
-	DurationMins  float64 `json:"duration_mins"`
-	BaseFare      float64 `json:"base_fare"`
-	DistanceFare  float64 `json:"distance_fare"`
-	TimeFare      float64 `json:"time_fare"`
-	TotalFare     float64 `json:"total_fare"`
-	SurgeMultiple float64 `json:"surge_multiple"`
+	DistanceKm          float64 `json:"distance_km"`
+	DurationMins        float64 `json:"duration_mins"`
+	BaseFare            Money   `json:"base_fare"`
+	DistanceFare        Money   `json:"distance_fare"`
+	TimeFare            Money   `json:"time_fare"`
+	TotalFare           Money   `json:"total_fare"`
+	WaitMins            float64 `json:"wait_mins,omitempty"`
+	WaitFare            Money   `json:"wait_fare,omitempty"`
+	Discount            Money   `json:"discount,omitempty"`
+	SurgeMultiple       float64 `json:"surge_multiple"`
+	BilledSurgeMultiple float64 `json:"-"`
+	Currency            string  `json:"currency"`
+	FormattedTotal      string  `json:"formatted_total"`
 }
 
 // PricingCalculator computes ride fares using a standard formula:
 // Total = (BaseFare + Distance*PerKmRate + Duration*PerMinuteRate) * SurgeMultiplier
 // If the result is below MinimumFare, MinimumFare is charged instead.
+// Rates are expressed in CurrencyCode (ISO 4217); PerKmRate and PerMinuteRate
+// are the Money charged per km and per minute.
+//
+// SurgePriceMax caps the multiplier used for billing; SurgeDisplayMax caps the
+// multiplier shown to riders. They are independent so operators can soften
+// how surge looks without changing what is charged. A cap of 0 means uncapped.
+//
+// PerWaitMinuteRate is charged for each minute a driver waits at the pickup
+// beyond FreeWaitMins (see AddWaitCharge).
 type PricingCalculator struct {
-	BaseFare      float64
-	PerKmRate     float64
-	PerMinuteRate float64
-	MinimumFare   float64
+	BaseFare          Money
+	PerKmRate         Money
+	PerMinuteRate     Money
+	MinimumFare       Money
+	CurrencyCode      string
+	SurgePriceMax     float64
+	SurgeDisplayMax   float64
+	PerWaitMinuteRate Money
+	FreeWaitMins      float64
 }
 
-// NewPricingCalculator creates a calculator with the given rate parameters.
+// NewPricingCalculator creates a calculator with the given rate parameters in
+// major units (as configured, e.g. 2.50), priced in DefaultCurrencyCode. Set
+// CurrencyCode to price in another currency.
 func NewPricingCalculator(baseFare, perKmRate, perMinuteRate, minimumFare float64) *PricingCalculator {
 	return &PricingCalculator{
-		BaseFare:      baseFare,
-		PerKmRate:     perKmRate,
-		PerMinuteRate: perMinuteRate,
-		MinimumFare:   minimumFare,
+		BaseFare:      NewMoney(baseFare),
+		PerKmRate:     NewMoney(perKmRate),
+		PerMinuteRate: NewMoney(perMinuteRate),
+		MinimumFare:   NewMoney(minimumFare),
+		CurrencyCode:  DefaultCurrencyCode,
 	}
 }
 
@@ -47,33 +105,94 @@ func NewPricingCalculator(baseFare, perKmRate, perMinuteRate, minimumFare float6
 // surgeMultiple parameter allows dynamic pricing during high-demand periods
 // (1.0 = no surge, 2.0 = double price).
 //
+// Monetary amounts are rounded per currency (see Money.Round), so a JPY fare
+// has no decimals while a USD fare is rounded to cents.
+//
+// The distance and time charges are the only float step: the trip's length is
+// a measurement, so they are summed as floats and converted to Money once.
+// From there the base fare, the surge multiplication (Money.Mul) and the
+// minimum-fare clamp are exact integer arithmetic.
+//
 // Go Learning Note — Rounding with math.Round:
 // math.Round(x*100)/100 is the standard trick to round to 2 decimal places
-// in Go. Go doesn't have a built-in "round to N decimals" function. Multiply
-// by 10^N, round to nearest integer, then divide by 10^N. For financial
-// calculations in production, use a decimal library like "shopspring/decimal"
-// to avoid floating-point precision issues.
+// in Go, and the distances and durations here still use it. Money amounts
+// don't need it: they are integers of cents, which is the usual fix for
+// floating-point money (the other being a decimal library such as
+// "shopspring/decimal").
 func (p *PricingCalculator) CalculateFare(distanceKm, durationMins, surgeMultiple float64) FareEstimate {
-	distanceFare := distanceKm * p.PerKmRate
-	timeFare := durationMins * p.PerMinuteRate
+	currency := p.CurrencyCode
+	if currency == "" {
+		currency = DefaultCurrencyCode
+	}
 
-	subtotal := p.BaseFare + distanceFare + timeFare
-	total := subtotal * surgeMultiple
+	// The display value is derived from the already-capped billing value, so
+	// the multiplier shown to riders never exceeds what is charged.
+	billedSurge := ClampSurge(surgeMultiple, p.SurgePriceMax)
+	displaySurge := ClampSurge(billedSurge, p.SurgeDisplayMax)
+
+	distanceFare := p.PerKmRate.Float64() * distanceKm
+	timeFare := p.PerMinuteRate.Float64() * durationMins
+
+	subtotal := p.BaseFare + NewMoney(distanceFare+timeFare)
+	total := subtotal.Mul(billedSurge)
 
 	// Enforce minimum fare — short rides still cost at least MinimumFare.
 	if total < p.MinimumFare {
 		total = p.MinimumFare
 	}
 
+	totalFare := total.Round(currency)
+
 	return FareEstimate{
-		DistanceKm:    math.Round(distanceKm*100) / 100,
-		DurationMins:  math.Round(durationMins*100) / 100,
-		BaseFare:      p.BaseFare,
-		DistanceFare:  math.Round(distanceFare*100) / 100,
-		TimeFare:      math.Round(timeFare*100) / 100,
-		TotalFare:     math.Round(total*100) / 100,
-		SurgeMultiple: surgeMultiple,
+		DistanceKm:          math.Round(distanceKm*100) / 100,
+		DurationMins:        math.Round(durationMins*100) / 100,
+		BaseFare:            p.BaseFare,
+		DistanceFare:        NewMoney(distanceFare).Round(currency),
+		TimeFare:            NewMoney(timeFare).Round(currency),
+		TotalFare:           totalFare,
+		SurgeMultiple:       displaySurge,
+		BilledSurgeMultiple: billedSurge,
+		Currency:            currency,
+		FormattedTotal:      totalFare.Format(currency),
+	}
+}
+
+// AddWaitCharge adds the charge for a driver waiting waitMins at the pickup to
+// a fare: PerWaitMinuteRate for every minute past FreeWaitMins. The charge is
+// a separate line (WaitMins, WaitFare) added to TotalFare after surge and the
+// minimum fare, so waiting is never surged and a short trip with a long wait
+// pays both. A wait within the free period leaves the fare unchanged.
+func (p *PricingCalculator) AddWaitCharge(f FareEstimate, waitMins float64) FareEstimate {
+	billable := waitMins - p.FreeWaitMins
+	if billable <= 0 || p.PerWaitMinuteRate <= 0 {
+		return f
+	}
+	f.WaitMins = math.Round(billable*100) / 100
+	f.WaitFare = NewMoney(p.PerWaitMinuteRate.Float64() * billable).Round(f.Currency)
+	f.TotalFare += f.WaitFare
+	f.FormattedTotal = f.TotalFare.Format(f.Currency)
+	return f
+}
+
+// WithDiscount returns the fare with amount taken off TotalFare. The discount
+// is rounded to the fare's currency and never exceeds the total, so the
+// result is at least zero. The component fares are left as they were, for
+// the breakdown.
+func (f FareEstimate) WithDiscount(amount Money) FareEstimate {
+	discount := min(max(amount, 0), f.TotalFare).Round(f.Currency)
+	f.Discount = discount
+	f.TotalFare -= discount
+	f.FormattedTotal = f.TotalFare.Format(f.Currency)
+	return f
+}
+
+// ClampSurge limits a surge multiplier to limit. A limit of 0 (or less) means
+// no cap.
+func ClampSurge(multiple, limit float64) float64 {
+	if limit > 0 && multiple > limit {
+		return limit
 	}
+	return multiple
 }
 
 // HaversineDistance calculates the great-circle distance between two points on
@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"encoding/json"
 	"math"
+	"strings"
 	"testing"
 )
 
@@ -132,7 +134,7 @@ func TestPricingCalculator_CalculateFare(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			result := calc.CalculateFare(tt.distanceKm, tt.durationMins, tt.surgeMultiple)
-			if result.TotalFare < tt.minFare || result.TotalFare > tt.maxFare {
+			if fare := result.TotalFare.Float64(); fare < tt.minFare || fare > tt.maxFare {
 				t.Errorf("CalculateFare() = %v, expected between %v and %v",
 					result.TotalFare, tt.minFare, tt.maxFare)
 			}
@@ -146,7 +148,7 @@ func TestPricingCalculator_MinimumFare(t *testing.T) {
 	// Very short trip that would normally be less than minimum
 	result := calc.CalculateFare(0.1, 1.0, 1.0)
 
-	if result.TotalFare < 5.00 {
+	if result.TotalFare < NewMoney(5.00) {
 		t.Errorf("Expected minimum fare of 5.00, got %v", result.TotalFare)
 	}
 }
@@ -161,7 +163,7 @@ func TestFareEstimate_Fields(t *testing.T) {
 	if result.DurationMins != 15.0 {
 		t.Errorf("Expected DurationMins 15.0, got %v", result.DurationMins)
 	}
-	if result.BaseFare != 2.50 {
+	if result.BaseFare != NewMoney(2.50) {
 		t.Errorf("Expected BaseFare 2.50, got %v", result.BaseFare)
 	}
 	if result.SurgeMultiple != 1.5 {
@@ -169,6 +171,169 @@ func TestFareEstimate_Fields(t *testing.T) {
 	}
 }
 
+func TestPricingCalculator_CurrencyRounding(t *testing.T) {
+	tests := []struct {
+		name         string
+		calc         *PricingCalculator
+		currency     string
+		expectedFare float64
+	}{
+		{
+			name:         "USD rounds to cents",
+			calc:         NewPricingCalculator(2.50, 1.50, 0.25, 5.00),
+			currency:     "USD",
+			expectedFare: 14.02, // 2.50 + 5.123*1.50 + 15.33*0.25 = 14.017
+		},
+		{
+			name:         "JPY has no decimals",
+			calc:         NewPricingCalculator(500, 300, 50, 700),
+			currency:     "JPY",
+			expectedFare: 2803, // 500 + 5.123*300 + 15.33*50 = 2803.4
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.calc.CurrencyCode = tt.currency
+			result := tt.calc.CalculateFare(5.123, 15.33, 1.0)
+
+			if result.Currency != tt.currency {
+				t.Errorf("Expected currency %s, got %s", tt.currency, result.Currency)
+			}
+			if result.TotalFare != NewMoney(tt.expectedFare) {
+				t.Errorf("Expected total fare %v, got %v", tt.expectedFare, result.TotalFare)
+			}
+		})
+	}
+}
+
+func TestPricingCalculator_DefaultCurrency(t *testing.T) {
+	calc := NewPricingCalculator(2.50, 1.50, 0.25, 5.00)
+	result := calc.CalculateFare(5.0, 15.0, 1.0)
+
+	if result.Currency != "USD" {
+		t.Errorf("Expected default currency USD, got %s", result.Currency)
+	}
+}
+
+func TestPricingCalculator_FormattedTotal(t *testing.T) {
+	calc := NewPricingCalculator(500, 300, 50, 700)
+	calc.CurrencyCode = "JPY"
+	result := calc.CalculateFare(5.123, 15.33, 1.0)
+
+	if result.FormattedTotal != "¥2,803" {
+		t.Errorf("Expected formatted total ¥2,803, got %q", result.FormattedTotal)
+	}
+}
+
+func TestPricingCalculator_SurgeCaps(t *testing.T) {
+	tests := []struct {
+		name            string
+		surge           float64
+		priceMax        float64
+		displayMax      float64
+		expectedBilled  float64
+		expectedDisplay float64
+	}{
+		{"Below both caps", 1.3, 3.0, 1.5, 1.3, 1.3},
+		{"Display capped, billing not", 2.5, 3.0, 1.5, 2.5, 1.5},
+		{"Both capped", 4.0, 3.0, 1.5, 3.0, 1.5},
+		{"No display cap follows billing cap", 4.0, 3.0, 0, 3.0, 3.0},
+		{"Uncapped", 4.0, 0, 0, 4.0, 4.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calc := NewPricingCalculator(2.50, 1.50, 0.25, 5.00)
+			calc.SurgePriceMax = tt.priceMax
+			calc.SurgeDisplayMax = tt.displayMax
+
+			result := calc.CalculateFare(5.0, 15.0, tt.surge)
+
+			if result.BilledSurgeMultiple != tt.expectedBilled {
+				t.Errorf("Expected billed surge %v, got %v", tt.expectedBilled, result.BilledSurgeMultiple)
+			}
+			if result.SurgeMultiple != tt.expectedDisplay {
+				t.Errorf("Expected display surge %v, got %v", tt.expectedDisplay, result.SurgeMultiple)
+			}
+			if tt.priceMax > 0 && result.BilledSurgeMultiple > tt.priceMax {
+				t.Errorf("Billed surge %v exceeds SurgePriceMax %v", result.BilledSurgeMultiple, tt.priceMax)
+			}
+			if tt.displayMax > 0 && result.SurgeMultiple > tt.displayMax {
+				t.Errorf("Display surge %v exceeds SurgeDisplayMax %v", result.SurgeMultiple, tt.displayMax)
+			}
+
+			// The fare is always billed at the billing multiplier.
+			expectedFare := NewMoney(2.50 + 5.0*1.50 + 15.0*0.25).Mul(tt.expectedBilled)
+			if result.TotalFare != expectedFare {
+				t.Errorf("Expected total fare %v, got %v", expectedFare, result.TotalFare)
+			}
+		})
+	}
+}
+
+func TestPricingCalculator_ExactMoney(t *testing.T) {
+	// In float64, 6.05 * 1.5 is 9.0749999999999993, so the old float
+	// pipeline rounded this surged fare down to 9.07.
+	calc := NewPricingCalculator(6.05, 0, 0, 0)
+	if got := calc.CalculateFare(0, 0, 1.5).TotalFare; got != NewMoney(9.08) {
+		t.Errorf("Expected 6.05 at 1.5x surge to be exactly 9.08, got %v", got)
+	}
+
+	// The minimum-fare clamp compares whole cents: a fare one cent over the
+	// minimum is kept, one exactly at it is not clamped up or down.
+	calc = NewPricingCalculator(5.00, 0.01, 0, 5.00)
+	if got := calc.CalculateFare(1, 0, 1.0).TotalFare; got != NewMoney(5.01) {
+		t.Errorf("Expected 5.01 just above the minimum, got %v", got)
+	}
+	if got := calc.CalculateFare(0, 0, 1.0).TotalFare; got != NewMoney(5.00) {
+		t.Errorf("Expected exactly the minimum 5.00, got %v", got)
+	}
+
+	// Components and total serialize as two-decimal strings.
+	data, _ := json.Marshal(NewPricingCalculator(2.50, 1.50, 0.25, 5.00).CalculateFare(5.0, 15.0, 1.0))
+	if !strings.Contains(string(data), `"total_fare":"13.75"`) || !strings.Contains(string(data), `"base_fare":"2.50"`) {
+		t.Errorf("Expected fares as two-decimal strings, got %s", data)
+	}
+}
+
+func TestPricingCalculator_AddWaitCharge(t *testing.T) {
+	calc := NewPricingCalculator(2.50, 1.50, 0.25, 5.00)
+	calc.PerWaitMinuteRate = NewMoney(0.30)
+	calc.FreeWaitMins = 2
+
+	fare := calc.CalculateFare(10, 20, 2.0)
+	if got := calc.AddWaitCharge(fare, 1.5); got != fare {
+		t.Errorf("Expected a wait within the free period to leave the fare unchanged, got %+v", got)
+	}
+
+	// 4.5 billable minutes at $0.30 = $1.35, added after surge.
+	got := calc.AddWaitCharge(fare, 6.5)
+	if got.WaitMins != 4.5 || got.WaitFare != NewMoney(1.35) {
+		t.Errorf("Expected 4.5 billable minutes for 1.35, got %v minutes for %v", got.WaitMins, got.WaitFare)
+	}
+	if got.TotalFare != fare.TotalFare+NewMoney(1.35) {
+		t.Errorf("Expected total %v, got %v", fare.TotalFare+NewMoney(1.35), got.TotalFare)
+	}
+	if got.FormattedTotal != got.TotalFare.Format(got.Currency) {
+		t.Errorf("Expected formatted total to follow the new total, got %q", got.FormattedTotal)
+	}
+}
+
+func TestCurrencyDecimals(t *testing.T) {
+	tests := map[string]int{
+		"USD": 2,
+		"EUR": 2,
+		"JPY": 0,
+		"KWD": 3,
+	}
+	for code, expected := range tests {
+		if got := CurrencyDecimals(code); got != expected {
+			t.Errorf("CurrencyDecimals(%s) = %d, expected %d", code, got, expected)
+		}
+	}
+}
+
 func BenchmarkHaversineDistance(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		HaversineDistance(37.7749, -122.4194, 37.8044, -122.2712)